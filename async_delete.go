@@ -0,0 +1,122 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// asyncDeletionThreshold is the number of versions above which the versions
+// of a key are deleted by a background job instead of in the request.
+const asyncDeletionThreshold = 100
+
+// backgroundDeletion returns the ID of the job deleting key in the
+// background, or an empty string if the key is not being deleted by a job.
+func (b *versionedKVBackend) backgroundDeletion(key string) string {
+	b.deletionsLock.Lock()
+	defer b.deletionsLock.Unlock()
+
+	return b.deletions[key]
+}
+
+// startKeyDeletion marks the key described by meta as being deleted, deletes
+// its versions in a background job and then deletes its metadata. The
+// Deleting marker acts as a write-ahead log: if the job is interrupted the
+// deletion is resumed by the periodic maintenance. The caller must hold the
+// write lock of the key.
+func (b *versionedKVBackend) startKeyDeletion(ctx context.Context, s logical.Storage, meta *KeyMetadata) (*Job, error) {
+	key := meta.Key
+
+	if !meta.Deleting {
+		meta.Deleting = true
+		if err := b.writeKeyMetadata(ctx, s, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]uint64, 0, len(meta.Versions))
+	for id := range meta.Versions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	versions := make([]string, 0, len(ids))
+	for _, id := range ids {
+		versions = append(versions, strconv.FormatUint(id, 10))
+	}
+
+	b.deletionsLock.Lock()
+	defer b.deletionsLock.Unlock()
+
+	job, err := b.startJob(ctx, s, "delete-metadata", key, versions, func(ctx context.Context, version string) (bool, error) {
+		id, err := strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			return false, err
+		}
+
+		versionKey, err := b.getVersionKey(ctx, key, id, s)
+		if err != nil {
+			return false, err
+		}
+
+		return true, s.Delete(ctx, versionKey)
+	}, func(ctx context.Context, job *Job) error {
+		defer func() {
+			b.deletionsLock.Lock()
+			delete(b.deletions, key)
+			b.deletionsLock.Unlock()
+		}()
+
+		// The versions that could not be deleted are retried by the
+		// periodic maintenance
+		if job.Failed > 0 {
+			return fmt.Errorf("%d versions could not be deleted, the deletion will be retried", job.Failed)
+		}
+
+		lock := locksutil.LockForKey(b.locks, key)
+		lock.Lock()
+		defer lock.Unlock()
+
+		meta, err := b.getKeyMetadata(ctx, s, key)
+		if err != nil {
+			return err
+		}
+		if meta == nil || !meta.Deleting {
+			return nil
+		}
+
+		wrapper, err := b.getKeyEncryptor(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		err = wrapper.Wrap(s).Delete(ctx, key)
+		b.invalidateKeyMetadata(s, key)
+		if err != nil {
+			return err
+		}
+		b.trackKeyBytes(s, key, nil)
+		b.trackKeyCount(s, key, nil)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.deletions[key] = job.Id
+	return job, nil
+}
+
+// deletionJobResponse returns the response of a deletion performed by a
+// background job.
+func deletionJobResponse(job *Job) *logical.Response {
+	resp := &logical.Response{
+		Data: jobResponseData(job),
+	}
+	resp.AddWarning(fmt.Sprintf("The versions of the secret are deleted in the background, the progress can be read at jobs/%s", job.Id))
+	return resp
+}
@@ -0,0 +1,98 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Metadata_Delete_Async(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	ctx := context.Background()
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_versions": asyncDeletionThreshold + 10,
+		},
+	}
+	resp, err := b.HandleRequest(ctx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for i := 0; i < asyncDeletionThreshold+1; i++ {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": i,
+				},
+			},
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(ctx, req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("expected a warning, got %#v", resp.Warnings)
+	}
+
+	job := waitForJob(t, b, storage, resp.Data["id"].(string))
+	if job["operation"] != "delete-metadata" || job["processed"] != uint64(asyncDeletionThreshold+1) || job["failed"] != uint64(0) {
+		t.Fatalf("unexpected job: %#v", job)
+	}
+
+	meta, err := kv.getKeyMetadata(ctx, storage, "foo")
+	if err != nil || meta != nil {
+		t.Fatalf("expected the metadata to be deleted, err:%s meta:%#v", err, meta)
+	}
+	for i := 1; i <= asyncDeletionThreshold+1; i++ {
+		versionKey, err := kv.getVersionKey(ctx, "foo", uint64(i), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw, err := storage.Get(ctx, versionKey); err != nil || raw != nil {
+			t.Fatalf("expected version %d to be deleted, err:%s", i, err)
+		}
+	}
+	if id := kv.backgroundDeletion("foo"); id != "" {
+		t.Fatalf("unexpected background deletion %q", id)
+	}
+
+	// The key can be written again
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(ctx, req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["version"] != uint64(1) {
+		t.Fatalf("unexpected version %v", resp.Data["version"])
+	}
+}
@@ -6,11 +6,16 @@ import (
 	"fmt"
 	"net/http"
 	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/keysutil"
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
@@ -37,13 +42,20 @@ const (
 type versionedKVBackend struct {
 	*framework.Backend
 
-	// keyEncryptedWrapper is a cached version of the EncryptedKeyStorageWrapper
-	keyEncryptedWrapper *keysutil.EncryptedKeyStorageWrapper
+	// keyEncryptedWrapper is a cached version of the keyEncryptor
+	keyEncryptedWrapper *keyEncryptor
 
 	// salt is the cached version of the salt used to create paths for version
 	// data storage paths.
 	salt *salt.Salt
 
+	// layout is the cached storage layout, versionShards is the number of
+	// version shards requested by the mount options and used when the layout
+	// is first written at mount time, as is deterministicPaths.
+	layout             *StorageLayout
+	versionShards      uint32
+	deterministicPaths bool
+
 	// l locks the keyPolicy and salt caches.
 	l sync.RWMutex
 
@@ -51,6 +63,10 @@ type versionedKVBackend struct {
 	// updates.
 	locks []*locksutil.LockEntry
 
+	// writeGates bounds the time request handlers wait for the locks, see
+	// lockKeyForWrite.
+	writeGates []chan struct{}
+
 	// storagePrefix is the prefix given to all the data for a versioned KV
 	// store. We prefix this data so that upgrading from a passthrough backend
 	// to a versioned backend is easier. This value is passed from Vault core
@@ -61,13 +77,105 @@ type versionedKVBackend struct {
 	// upgrading its data.
 	upgrading *uint32
 
+	// rewrapping is an atomic value holding the state of the rewrap of the
+	// key metadata, see rewrapIdle.
+	rewrapping *uint32
+
 	// globalConfig is a cached value for fast lookup
 	globalConfig     *Configuration
 	globalConfigLock *sync.RWMutex
 
+	// configWriteLock serializes the changes to the configuration, which are
+	// made by reading it, modifying the copy and storing it back.
+	configWriteLock sync.Mutex
+
 	// upgradeCancelFunc is used to be able to shut down the upgrade checking
 	// goroutine from cleanup
 	upgradeCancelFunc context.CancelFunc
+
+	// jobsCtx is the context background jobs run with, jobsCancelFunc is
+	// used to stop them from cleanup.
+	jobsCtx        context.Context
+	jobsCancelFunc context.CancelFunc
+
+	// jobsWG tracks the running background jobs so that cleanup can wait for
+	// them to return.
+	jobsWG sync.WaitGroup
+
+	// enrichmentLock serializes the updates of the enrichment integration
+	// config.
+	enrichmentLock sync.Mutex
+
+	// enrichmentSyncing is true while a synchronization of the annotations
+	// is running. It is protected by enrichmentLock.
+	enrichmentSyncing bool
+
+	// maintenanceLock serializes the runs of the periodic maintenance.
+	maintenanceLock sync.Mutex
+
+	// deletions maps the keys being deleted in the background to the ID of
+	// the job deleting them. It is protected by deletionsLock.
+	deletions     map[string]string
+	deletionsLock sync.Mutex
+
+	// reads maps the keys to their data reads not yet written to their
+	// metadata. It is protected by readsLock.
+	reads     map[string]*pendingRead
+	readsLock sync.Mutex
+
+	// rateLimiters maps the prefixes with a read_rate_limit or a
+	// write_rate_limit to their limiters. It is protected by
+	// rateLimitersLock.
+	rateLimiters     map[string]*rateLimiter
+	rateLimitersLock sync.Mutex
+
+	// byteUsage tracks the bytes stored under the prefixes with a max_bytes.
+	byteUsage *byteUsage
+
+	// keyCounts tracks the keys under the prefixes with a key quota.
+	keyCounts *keyCounts
+
+	// upgradeOptions are the settings of the upgrade from non-versioned to
+	// versioned data requested by the mount options.
+	upgradeOptions upgradeOptions
+
+	// metadataCache caches the decrypted key metadata, metadataCacheSize is
+	// its size requested by the mount options.
+	metadataCache     *metadataCache
+	metadataCacheSize int
+
+	// policyCacheDisabled disables the caching of keyEncryptedWrapper. It
+	// is protected by l.
+	policyCacheDisabled bool
+
+	// cacheConfig is a cached value of the cache settings, it is applied to
+	// the caches when loaded.
+	cacheConfig     *CacheConfig
+	cacheConfigLock sync.RWMutex
+
+	// webhookConfig is a cached value of the webhooks notified of the
+	// changes of the secrets.
+	webhookConfig *WebhookConfig
+	webhooksLock  sync.RWMutex
+
+	// webhookClient is the client the webhook notifications are posted
+	// with.
+	webhookClient *http.Client
+
+	// webhookQueue holds the deliveries waiting for one of the workers
+	// started by webhookWorkersOnce. webhookPending holds the IDs of the
+	// deliveries queued or waiting for a retry so that they are not queued
+	// twice, it is protected by webhookPendingLock.
+	webhookQueue       chan webhookTask
+	webhookWorkersOnce sync.Once
+	webhookPending     map[string]struct{}
+	webhookPendingLock sync.Mutex
+
+	// encryptionConfig is a cached value of the encryption config and
+	// transitClient the client of the Transit mount it refers to.
+	encryptionConfig *EncryptionConfig
+	transitClient    *api.Client
+	encryptionLock   sync.RWMutex
 }
 
 // Factory will return a logical backend of type versionedKVBackend or
@@ -94,21 +202,71 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 func VersionedKVFactory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
 	upgradeCtx, upgradeCancelFunc := context.WithCancel(ctx)
 
+	// Jobs outlive the request that started them so they don't derive from
+	// the factory context.
+	jobsCtx, jobsCancelFunc := context.WithCancel(context.Background())
+
 	b := &versionedKVBackend{
 		upgrading:         new(uint32),
+		rewrapping:        new(uint32),
 		globalConfigLock:  new(sync.RWMutex),
 		upgradeCancelFunc: upgradeCancelFunc,
+		jobsCtx:           jobsCtx,
+		jobsCancelFunc:    jobsCancelFunc,
+		deletions:         make(map[string]string),
+		reads:             make(map[string]*pendingRead),
+		rateLimiters:      make(map[string]*rateLimiter),
+		byteUsage:         newByteUsage(),
+		keyCounts:         newKeyCounts(),
+		webhookQueue:      make(chan webhookTask, webhookQueueSize),
+		webhookPending:    make(map[string]struct{}),
 	}
 	if conf.BackendUUID == "" {
 		return nil, errors.New("could not initialize versioned K/V Store, no UUID was provided")
 	}
 	b.storagePrefix = conf.BackendUUID
 
+	versionShards, err := parseVersionShards(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+	b.versionShards = versionShards
+
+	b.webhookClient, err = newHTTPClient(webhookTimeout, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	b.deterministicPaths, err = parseDeterministicPaths(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+	if b.deterministicPaths && b.versionShards > 0 {
+		return nil, errors.New("version_shards cannot be used with deterministic_paths")
+	}
+
+	b.upgradeOptions, err = parseUpgradeOptions(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	b.metadataCacheSize, err = parseMetadataCacheSize(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+	b.metadataCache, err = newMetadataCache(b.metadataCacheSize)
+	if err != nil {
+		return nil, err
+	}
+
 	b.Backend = &framework.Backend{
 		BackendType: logical.TypeLogical,
 		Help:        backendHelp,
 		Invalidate:  b.Invalidate,
 
+		InitializeFunc: b.initialize,
+		PeriodicFunc:   b.periodicFunc,
+
 		PathsSpecial: &logical.Paths{
 			SealWrapStorage: []string{
 				// Seal wrap the versioned data
@@ -119,17 +277,65 @@ func VersionedKVFactory(ctx context.Context, conf *logical.BackendConfig) (logic
 
 				// Seal wrap the archived key policy
 				path.Join(b.storagePrefix, "archive") + "/",
+
+				// Seal wrap the snapshots holding versioned data
+				path.Join(b.storagePrefix, snapshotsPrefix) + "/",
+
+				// Seal wrap the key policies of the prefixes with a key
+				// policy of their own
+				path.Join(b.storagePrefix, prefixesPrefix) + "/",
+
+				// Seal wrap the versioned data of the trashed keys
+				path.Join(b.storagePrefix, trashVersionsPrefix) + "/",
 			},
 		},
 
 		Paths: framework.PathAppend(
 			[]*framework.Path{
 				pathConfig(b),
+				pathCacheConfig(b),
+				pathCacheClear(b),
+				pathConfigAudit(b),
+				pathConfigComposite(b),
+				pathConfigEncryption(b),
+				pathConfigEnrichment(b),
+				pathConfigPath(b),
+				pathConfigWebhooks(b),
 				pathData(b),
+				pathMetadataEffective(b),
+				pathMetadataCustomMetadata(b),
 				pathMetadata(b),
+				pathDetailedMetadata(b),
+				pathComposite(b),
 				pathDestroy(b),
+				pathRenameKey(b),
+				pathRepair(b),
+				pathInjectField(b),
+				pathConformance(b),
+				pathInfo(b),
+				pathQuotas(b),
+				pathCheckpoints(b),
+				pathCount(b),
+				pathExists(b),
+				pathExport(b),
+				pathImport(b),
+				pathRotationDue(b),
+				pathUsage(b),
+				pathVerify(b),
+				pathReadonlyMirror(b),
+				pathSearch(b),
+				pathTemplates(b),
+				pathTidy(b),
+				pathMigrateCustomMetadata(b),
+				pathMigrateV1(b),
+				pathUpgradeStatus(b),
 			},
+			pathsJobs(b),
 			pathsDelete(b),
+			pathsSnapshot(b),
+			pathsRewrap(b),
+			pathsPrefixKeys(b),
+			pathsTrash(b),
 
 			// Make sure this stays at the end so that the valid paths are
 			// processed first.
@@ -138,6 +344,7 @@ func VersionedKVFactory(ctx context.Context, conf *logical.BackendConfig) (logic
 	}
 
 	b.locks = locksutil.CreateLocks()
+	b.writeGates = createWriteGates()
 
 	if err := b.Setup(ctx, conf); err != nil {
 		return nil, err
@@ -154,13 +361,26 @@ func VersionedKVFactory(ctx context.Context, conf *logical.BackendConfig) (logic
 		}
 	}
 
+	if err := b.resumeRewrap(ctx, conf.StorageView); err != nil {
+		return nil, err
+	}
+
+	if err := b.failInterruptedJobs(ctx, conf.StorageView); err != nil {
+		return nil, err
+	}
+
 	return b, nil
 }
 
+// initialize prepares the storage of the mount once it is mounted.
+func (b *versionedKVBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	return b.initializeStorageLayout(ctx, req.Storage)
+}
+
 func pathInvalid(b *versionedKVBackend) []*framework.Path {
 	handler := func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		switch req.Path {
-		case "metadata", "data", "delete", "undelete", "destroy":
+		case "metadata", "detailed-metadata", "composite", "count", "data", "delete", "undelete", "destroy", "exists", "export", "import", "rename-key", "repair", "inject-field", "migrate-custom-metadata", "migrate-v1", "checkpoints", "prefix-keys", "readonly-mirror", "rotation-due", "templates", "trash", "usage", "verify":
 			resp := &logical.Response{}
 			resp.AddWarning("Non-listing operations on the root of a K/V v2 mount are not supported.")
 			return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
@@ -189,7 +409,6 @@ func pathInvalid(b *versionedKVBackend) []*framework.Path {
 			Pattern: ".*",
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.UpdateOperation: &framework.PathOperation{Callback: handler, Unpublished: true},
-				logical.CreateOperation: &framework.PathOperation{Callback: handler, Unpublished: true},
 				logical.PatchOperation:  &framework.PathOperation{Callback: handler, Unpublished: true},
 				logical.ReadOperation:   &framework.PathOperation{Callback: handler, Unpublished: true},
 				logical.DeleteOperation: &framework.PathOperation{Callback: handler, Unpublished: true},
@@ -201,28 +420,134 @@ func pathInvalid(b *versionedKVBackend) []*framework.Path {
 	}
 }
 
+// periodicFunc is invoked by Vault on a regular basis to run the background
+// maintenance of the backend.
+func (b *versionedKVBackend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if atomic.LoadUint32(b.upgrading) == 1 || atomic.LoadUint32(b.rewrapping) != rewrapIdle || b.perfSecondaryCheck() {
+		return nil
+	}
+
+	if err := b.flushReads(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	// Only a batch of keys is processed on each run so that the
+	// maintenance of large mounts is spread across runs
+	keys, err := b.maintenanceBatch(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+
+	// An error on a key does not prevent the maintenance of the others
+	for _, key := range keys {
+		if err := b.maintainKey(ctx, req.Storage, key); err != nil {
+			b.Logger().Error("periodic maintenance of key failed", "key", key, "error", err)
+		}
+	}
+
+	var errs *multierror.Error
+	if err := b.loadByteUsage(ctx, req.Storage); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("computing the byte usage: %w", err))
+	}
+	if err := b.purgeTrash(ctx, req.Storage, time.Now()); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("purging the trash: %w", err))
+	}
+	if err := b.syncEnrichment(ctx, req.Storage); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("synchronizing the annotations: %w", err))
+	}
+	if err := b.renewTransitToken(ctx, req.Storage); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := b.retryWebhookDeliveries(ctx, req.Storage); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("retrying the webhook deliveries: %w", err))
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// maintainKey runs the periodic maintenance of a key.
+func (b *versionedKVBackend) maintainKey(ctx context.Context, s logical.Storage, key string) error {
+	for _, fn := range []func(context.Context, logical.Storage, string) error{
+		b.resumeInterruptedDeletion,
+		b.expireKey,
+		b.applyMinVersions,
+		b.deleteExpiredVersions,
+		b.destroyDeletedVersions,
+		b.pruneOldVersions,
+		b.pruneHistoryBytes,
+		b.recordCheckpoint,
+	} {
+		if err := fn(ctx, s, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (b *versionedKVBackend) Cleanup(ctx context.Context) {
 	if b.upgradeCancelFunc != nil {
 		b.upgradeCancelFunc()
 	}
+	if b.jobsCancelFunc != nil {
+		b.jobsCancelFunc()
+	}
+	b.jobsWG.Wait()
 }
 
-// Invalidate invalidates the salt and the policy so replication secondaries can
-// cache these values.
+// Invalidate invalidates the salt, the storage layout, the policy and the key
+// metadata so replication secondaries and standbys can cache these values.
 func (b *versionedKVBackend) Invalidate(ctx context.Context, key string) {
+	// The metadata is stored under obfuscated keys so the whole cache is
+	// invalidated
+	if strings.HasPrefix(key, path.Join(b.storagePrefix, metadataPrefix)+"/") {
+		b.metadataCache.purge()
+		return
+	}
+
+	// The key policies and the metadata of the prefixes with a key policy
+	// of their own are stored under their salted name
+	if strings.HasPrefix(key, path.Join(b.storagePrefix, prefixesPrefix)+"/") {
+		if !strings.Contains(key, "/"+metadataPrefix) {
+			b.l.Lock()
+			b.keyEncryptedWrapper = nil
+			b.l.Unlock()
+		}
+		b.metadataCache.purge()
+		return
+	}
+
 	switch key {
 	case path.Join(b.storagePrefix, salt.DefaultLocation):
 		b.l.Lock()
 		b.salt = nil
 		b.l.Unlock()
-	case path.Join(b.storagePrefix, "policy/metadata"):
+	case path.Join(b.storagePrefix, storageLayoutPath):
+		b.l.Lock()
+		b.layout = nil
+		b.l.Unlock()
+	case path.Join(b.storagePrefix, "policy/metadata"), path.Join(b.storagePrefix, prefixKeysPath):
 		b.l.Lock()
 		b.keyEncryptedWrapper = nil
 		b.l.Unlock()
+		b.metadataCache.purge()
 	case path.Join(b.storagePrefix, configPath):
 		b.globalConfigLock.Lock()
 		b.globalConfig = nil
 		b.globalConfigLock.Unlock()
+	case path.Join(b.storagePrefix, cacheConfigPath):
+		b.cacheConfigLock.Lock()
+		b.cacheConfig = nil
+		b.cacheConfigLock.Unlock()
+	case path.Join(b.storagePrefix, webhooksConfigPath):
+		b.webhooksLock.Lock()
+		b.webhookConfig = nil
+		b.webhooksLock.Unlock()
+	case path.Join(b.storagePrefix, encryptionConfigPath):
+		b.encryptionLock.Lock()
+		b.encryptionConfig = nil
+		b.transitClient = nil
+		b.encryptionLock.Unlock()
 	}
 }
 
@@ -282,13 +607,30 @@ func (b *versionedKVBackend) policy(ctx context.Context, s logical.Storage) (*ke
 	return policy, nil
 }
 
-func (b *versionedKVBackend) getKeyEncryptor(ctx context.Context, s logical.Storage) (*keysutil.EncryptedKeyStorageWrapper, error) {
+// getKeyEncryptor returns the encryptor of the paths of the key metadata.
+func (b *versionedKVBackend) getKeyEncryptor(ctx context.Context, s logical.Storage) (*keyEncryptor, error) {
+	if _, err := b.cacheSettings(ctx, s); err != nil {
+		return nil, err
+	}
+
 	b.l.RLock()
 	if b.keyEncryptedWrapper != nil {
 		defer b.l.RUnlock()
 		return b.keyEncryptedWrapper, nil
 	}
 	b.l.RUnlock()
+
+	// The salt and the storage layout are loaded before taking the lock as
+	// they lock the backend
+	salt, err := b.Salt(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := b.storageLayout(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
 	b.l.Lock()
 	defer b.l.Unlock()
 
@@ -296,23 +638,62 @@ func (b *versionedKVBackend) getKeyEncryptor(ctx context.Context, s logical.Stor
 		return b.keyEncryptedWrapper, nil
 	}
 
-	policy, err := b.policy(ctx, s)
+	// The progress of the rewrap is read under the lock as the rewrap
+	// updates it along with the key policy
+	rewrapInfo, err := b.getRewrapInfo(ctx, s)
 	if err != nil {
 		return nil, err
 	}
 
-	e, err := keysutil.NewEncryptedKeyStorageWrapper(keysutil.EncryptedKeyStorageConfig{
-		Policy: policy,
-		Prefix: path.Join(b.storagePrefix, metadataPrefix),
-	})
+	policy, err := b.policy(ctx, s)
 	if err != nil {
 		return nil, err
 	}
 
+	e := &keyEncryptor{
+		rootPolicy: policy,
+	}
+	if layout.DeterministicPaths {
+		e.root = &plainKeyStorageWrapper{
+			prefix: path.Join(b.storagePrefix, metadataPrefix) + "/",
+		}
+	} else {
+		e.root, err = keysutil.NewEncryptedKeyStorageWrapper(keysutil.EncryptedKeyStorageConfig{
+			Policy: policy,
+			Prefix: path.Join(b.storagePrefix, metadataPrefix),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The metadata is served while it is rewrapped
+		if rewrapInfo != nil && !rewrapInfo.Done {
+			e.archived, err = b.archivedKeyWrapper(ctx, s, rewrapInfo.FromVersion)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := b.loadPrefixWrappers(ctx, s, salt, e); err != nil {
+		return nil, err
+	}
+
 	// Cache the value
-	b.keyEncryptedWrapper = e
+	if !b.policyCacheDisabled {
+		b.keyEncryptedWrapper = e
+	}
 
-	return b.keyEncryptedWrapper, nil
+	return e, nil
+}
+
+// getKeyPolicy returns the key policy protecting the key metadata of key.
+func (b *versionedKVBackend) getKeyPolicy(ctx context.Context, s logical.Storage, key string) (*keysutil.Policy, error) {
+	e, err := b.getKeyEncryptor(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.policy(key), nil
 }
 
 // config takes a storage object and returns a configuration object
@@ -320,11 +701,7 @@ func (b *versionedKVBackend) config(ctx context.Context, s logical.Storage) (*Co
 	b.globalConfigLock.RLock()
 	if b.globalConfig != nil {
 		defer b.globalConfigLock.RUnlock()
-		return &Configuration{
-			CasRequired:        b.globalConfig.CasRequired,
-			MaxVersions:        b.globalConfig.MaxVersions,
-			DeleteVersionAfter: b.globalConfig.DeleteVersionAfter,
-		}, nil
+		return proto.Clone(b.globalConfig).(*Configuration), nil
 	}
 
 	b.globalConfigLock.RUnlock()
@@ -333,11 +710,7 @@ func (b *versionedKVBackend) config(ctx context.Context, s logical.Storage) (*Co
 
 	// Verify this hasn't already changed
 	if b.globalConfig != nil {
-		return &Configuration{
-			CasRequired:        b.globalConfig.CasRequired,
-			MaxVersions:        b.globalConfig.MaxVersions,
-			DeleteVersionAfter: b.globalConfig.DeleteVersionAfter,
-		}, nil
+		return proto.Clone(b.globalConfig).(*Configuration), nil
 	}
 
 	raw, err := s.Get(ctx, path.Join(b.storagePrefix, configPath))
@@ -354,25 +727,53 @@ func (b *versionedKVBackend) config(ctx context.Context, s logical.Storage) (*Co
 
 	b.globalConfig = conf
 
-	return conf, nil
+	return proto.Clone(conf).(*Configuration), nil
 }
 
 // getVersionKey uses the salt to generate the version key for a specific
-// version of a key.
+// version of a key. If the storage layout has version shards the version is
+// stored under the shard its salted ID hashes to, if it has deterministic
+// paths the version is stored under the escaped key.
 func (b *versionedKVBackend) getVersionKey(ctx context.Context, key string, version uint64, s logical.Storage) (string, error) {
+	layout, err := b.storageLayout(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	if layout.DeterministicPaths {
+		return b.deterministicVersionKey(key, version), nil
+	}
+
 	salt, err := b.Salt(ctx, s)
 	if err != nil {
 		return "", err
 	}
 
 	salted := salt.SaltID(fmt.Sprintf("%s|%d", key, version))
+	if layout.VersionShards > 0 {
+		shard, err := versionShard(salted, layout.VersionShards, layout.NestedShards)
+		if err != nil {
+			return "", err
+		}
+		if layout.NestedShards {
+			return path.Join(b.storagePrefix, versionPrefix, salted[0:3], shard, salted[3:]), nil
+		}
+		return path.Join(b.storagePrefix, versionPrefix, shard, salted), nil
+	}
 
 	return path.Join(b.storagePrefix, versionPrefix, salted[0:3], salted[3:]), nil
 }
 
 // getKeyMetadata returns the metadata object for the provided key, if no object
-// exits it will return nil.
+// exits it will return nil. The metadata is served from the cache.
 func (b *versionedKVBackend) getKeyMetadata(ctx context.Context, s logical.Storage, key string) (*KeyMetadata, error) {
+	if _, err := b.cacheSettings(ctx, s); err != nil {
+		return nil, err
+	}
+
+	if meta := b.metadataCache.get(key); meta != nil {
+		return meta, nil
+	}
+	gen := b.metadataCache.generation()
 
 	wrapper, err := b.getKeyEncryptor(ctx, s)
 	if err != nil {
@@ -395,6 +796,18 @@ func (b *versionedKVBackend) getKeyMetadata(ctx context.Context, s logical.Stora
 		return nil, fmt.Errorf("failed to decode key metadata from storage: %v", err)
 	}
 
+	if meta.EncryptedCustomMetadata != "" {
+		policy, err := b.getKeyPolicy(ctx, s, meta.Key)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.decryptCustomMetadata(policy, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	b.metadataCache.add(meta, gen)
+
 	return meta, nil
 }
 
@@ -407,6 +820,23 @@ func (b *versionedKVBackend) writeKeyMetadata(ctx context.Context, s logical.Sto
 
 	es := wrapper.Wrap(s)
 
+	config, err := b.config(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config.EncryptCustomMetadata && len(meta.CustomMetadata) > 0 {
+		policy, err := b.getKeyPolicy(ctx, s, meta.Key)
+		if err != nil {
+			return err
+		}
+
+		// The metadata of the caller is left untouched
+		meta = proto.Clone(meta).(*KeyMetadata)
+		if err := b.encryptCustomMetadata(policy, meta); err != nil {
+			return err
+		}
+	}
+
 	bytes, err := proto.Marshal(meta)
 	if err != nil {
 		return err
@@ -416,13 +846,80 @@ func (b *versionedKVBackend) writeKeyMetadata(ctx context.Context, s logical.Sto
 		Key:   meta.Key,
 		Value: bytes,
 	})
+	b.invalidateKeyMetadata(s, meta.Key)
 	if err != nil {
 		return err
 	}
+	b.trackKeyBytes(s, meta.Key, meta)
+	b.trackKeyCount(s, meta.Key, meta)
 
 	return nil
 }
 
+// invalidateKeyMetadata removes the metadata of key from the cache once its
+// new value has been persisted to s.
+func (b *versionedKVBackend) invalidateKeyMetadata(s logical.Storage, key string) {
+	b.metadataCache.remove(key)
+}
+
+// collectKeys returns the keys of every secret under the provided prefix,
+// descending into all the sub-folders.
+func (b *versionedKVBackend) collectKeys(ctx context.Context, s logical.Storage, prefix string) ([]string, error) {
+	return b.walkKeys(ctx, s, prefix, 0)
+}
+
+// walkKeys returns the keys of every secret under the provided prefix,
+// descending into the sub-folders up to depth levels below the prefix. The
+// folders at the depth limit are returned with a trailing slash. There is no
+// limit if depth is zero.
+func (b *versionedKVBackend) walkKeys(ctx context.Context, s logical.Storage, prefix string, depth int) ([]string, error) {
+	wrapper, err := b.getKeyEncryptor(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	es := wrapper.Wrap(s)
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	type folder struct {
+		path  string
+		level int
+	}
+
+	var keys []string
+	folders := []folder{{path: prefix, level: 1}}
+	for len(folders) > 0 {
+		f := folders[0]
+		folders = folders[1:]
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		entries, err := es.List(ctx, f.path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry, "/") {
+				keys = append(keys, f.path+entry)
+				continue
+			}
+			if depth > 0 && f.level >= depth {
+				keys = append(keys, f.path+entry)
+				continue
+			}
+			folders = append(folders, folder{path: f.path + entry, level: f.level + 1})
+		}
+	}
+
+	return keys, nil
+}
+
 func ptypesTimestampToString(t *timestamp.Timestamp) string {
 	if t == nil {
 		return ""
@@ -438,6 +935,29 @@ encrypted/decrypted by Vault: they are never stored unencrypted in the backend
 and the backend never has an opportunity to see the unencrypted value. Each key
 can have a configured number of versions, and versions can be retrieved based on
 their version numbers.
+
+The "version_shards" mount option further spreads the versions across that
+number of hashed sub-prefixes nested under each of the 4096 folders of the
+default layout, for storage backends that perform poorly with large
+directories. It must be greater than 1, only applies to mounts created with it
+and cannot be changed once versions have been written.
+
+The "deterministic_paths" mount option stores the key metadata under the
+plaintext path of the key and the versions under the key with its slashes
+escaped and the version number, instead of encrypted and salted paths, so that
+operators restoring from raw storage backups can locate the records of a
+secret. The records are still encrypted by the barrier. It only applies to
+mounts created with it, cannot be combined with "version_shards" and disables
+the rewrap of the key metadata and the key policies of prefixes.
+
+The "metadata_cache_size" mount option is the number of key metadata objects
+kept decrypted in memory, it defaults to 1024 and zero disables the cache.
+
+The "upgrade_workers", "upgrade_batch_size" and "upgrade_rate_limit" mount
+options control the upgrade from non-versioned to versioned data: the keys are
+upgraded by that number of workers, 8 by default, in batches of 100 keys by
+default, and at most "upgrade_rate_limit" keys are upgraded per second to
+throttle the load on the storage backend, zero, the default, meaning no limit.
 `
 
 var pathInvalidHelp string = backendHelp + `
@@ -449,9 +969,45 @@ any of the paths below, use the help command with any route matching
 the path pattern. Note that depending on the policy of your auth token,
 you may or may not be able to access certain paths.
 
+    ^checkpoints/.*$
+        Reads the daily checkpoints of a secret.
+
+    ^cache-clear$
+        Clears the in-memory caches of the KV store.
+
+    ^cache-config$
+        Configures the in-memory caches of the KV store.
+
     ^config$
         Configures settings for the KV store
 
+    ^composite/.*$
+        Reads a secret merged from the data of other secrets.
+
+    ^config/audit$
+        Configures the fields logged without HMAC by the audit devices.
+
+    ^config/composite/.*$
+        Configures the sources of a composite secret.
+
+    ^config/encryption$
+        Configures how the version data is encrypted.
+
+    ^config/enrichment$
+        Synchronizes custom_metadata annotations from an external source.
+
+    ^config/path/.*$
+        Configures settings for the keys under a prefix.
+
+    ^config/webhooks/.*$
+        Configures the webhooks notified of the changes of the secrets.
+
+    ^conformance$
+        Checks the secrets against the required_paths manifest.
+
+    ^count/.*$
+        Counts the secrets under a prefix.
+
     ^data/.*$
         Write, Read, and Delete data in the Key-Value Store.
 
@@ -461,9 +1017,91 @@ you may or may not be able to access certain paths.
     ^destroy/.*$
         Permanently removes one or more versions in the KV store
 
+    ^detailed-metadata/.*$
+        Lists secrets along with a summary of their metadata.
+
     ^metadata/.*$
         Configures settings for the KV store
 
+    ^metadata/.*/custom-metadata/.*$
+        Deletes a single custom_metadata key of a secret.
+
+    ^metadata/.*/effective$
+        Reports the resolved settings of a key.
+
+    ^exists/.*$
+        Checks whether a secret exists without reading it.
+
+    ^export/.*$
+        Exports the secrets under a prefix as a portable bundle.
+
+    ^import/.*$
+        Imports a bundle of secrets produced by export.
+
+    ^info$
+        Reports the optional subsystems and limits of the KV store.
+
+    ^inject-field/.*$
+        Sets a field in the data of every secret under a prefix.
+
+    ^jobs/.*$
+        Reports the progress of background jobs.
+
+    ^migrate-custom-metadata/.*$
+        Promotes pseudo-metadata data fields to custom_metadata.
+
+    ^migrate-v1/.*$
+        Migrates KV v1 entries to versioned secrets.
+
+    ^prefix-keys/.*$
+        Manages the key policies of the prefixes with a key of their own.
+
+    ^quotas$
+        Reports the usage of the quotas of the KV store.
+
+    ^readonly-mirror/.*$
+        Reads secrets through a read-only mirror of another prefix.
+
+    ^rename-key/.*$
+        Renames a field in the data of a secret.
+
+    ^repair/.*$
+        Rebuilds the metadata of a secret from its versions.
+
+    ^rewrap$
+        Rotates the key protecting the key metadata and rewraps the metadata.
+
+    ^rewrap/status$
+        Reports the progress of the rewrap of the key metadata.
+
+    ^rotation-due/.*$
+        Lists the secrets due for rotation.
+
+    ^search$
+        Searches the secrets by name.
+
+    ^snapshot/(create|delete|list|restore)$
+        Creates, deletes, lists and restores snapshots of the secrets under a
+        prefix.
+
+    ^templates/.*$
+        Configures the settings new keys under a prefix start with.
+
+    ^tidy$
+        Finds and removes the inconsistencies between the metadata and the versions.
+
+    ^trash/(list|restore/.*)$
+        Lists and restores the secrets moved to the trash.
+
     ^undelete/.*$
         Undeletes one or more versions from the KV store.
+
+    ^upgrade/status$
+        Reports the progress of the upgrade to versioned data.
+
+    ^usage/.*$
+        Reports the storage used by the secrets under a prefix.
+
+    ^verify/.*$
+        Checks the integrity of the secrets under a prefix.
 `
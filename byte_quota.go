@@ -0,0 +1,376 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// errByteQuotaExceeded is returned when writing a version would take the
+// versions stored under a prefix over its max_bytes.
+var errByteQuotaExceeded = errors.New("storage quota exceeded")
+
+// byteQuotas returns the max_bytes of the mount, under the empty prefix, and
+// of every prefix matching key.
+func byteQuotas(config *Configuration, key string) map[string]uint64 {
+	quotas := make(map[string]uint64)
+	if config.MaxBytes > 0 {
+		quotas[""] = config.MaxBytes
+	}
+	for prefix, pc := range config.PathConfigs {
+		if pc.MaxBytes > 0 && strings.HasPrefix(key, prefix) {
+			quotas[prefix] = pc.MaxBytes
+		}
+	}
+	return quotas
+}
+
+// hasByteQuotas returns true if the mount or a prefix has a max_bytes.
+func hasByteQuotas(config *Configuration) bool {
+	if config.MaxBytes > 0 {
+		return true
+	}
+	for _, pc := range config.PathConfigs {
+		if pc.MaxBytes > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// versionBytes returns the recorded size of the versions of the key that are
+// not destroyed.
+func versionBytes(meta *KeyMetadata) uint64 {
+	var size uint64
+	for _, vm := range meta.Versions {
+		if !vm.Destroyed {
+			size += vm.Size
+		}
+	}
+	return size
+}
+
+// byteUsage tracks the bytes of the versions stored under the prefixes with a
+// max_bytes. The usage of a prefix is computed from the metadata of its keys
+// by the periodic function, and then kept up to date as the metadata of the
+// keys is written and deleted. The bytes of the versions being written are
+// reserved until their write completes so that concurrent writes cannot
+// exceed a quota together.
+type byteUsage struct {
+	l        sync.Mutex
+	prefixes map[string]*prefixBytes
+}
+
+// prefixBytes is the usage of a prefix, with the bytes of each of its keys.
+// Its fields are guarded by the lock of the byteUsage.
+type prefixBytes struct {
+	loaded  bool
+	loading bool
+	total   uint64
+	keys    map[string]uint64
+
+	// pending are the updates received while the usage is computed, they
+	// are applied once it completes.
+	pending []bytesUpdate
+
+	// reserved are the bytes of the versions being written.
+	reserved uint64
+}
+
+// bytesUpdate is the new size of a key, or its deletion.
+type bytesUpdate struct {
+	key     string
+	size    uint64
+	deleted bool
+}
+
+func newByteUsage() *byteUsage {
+	return &byteUsage{
+		prefixes: make(map[string]*prefixBytes),
+	}
+}
+
+// prefix returns the usage of prefix, creating it if needed. The lock of the
+// byteUsage must be held.
+func (u *byteUsage) prefix(prefix string) *prefixBytes {
+	p, ok := u.prefixes[prefix]
+	if !ok {
+		p = &prefixBytes{
+			keys: make(map[string]uint64),
+		}
+		u.prefixes[prefix] = p
+	}
+	return p
+}
+
+// update records size as the bytes of key in the tracked prefixes matching
+// it, or removes the key if deleted is true.
+func (u *byteUsage) update(key string, size uint64, deleted bool) {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	for prefix, p := range u.prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		update := bytesUpdate{key: key, size: size, deleted: deleted}
+		switch {
+		case p.loading:
+			p.pending = append(p.pending, update)
+		case p.loaded:
+			p.apply(update)
+		}
+	}
+}
+
+func (p *prefixBytes) apply(u bytesUpdate) {
+	p.total -= p.keys[u.key]
+	if u.deleted {
+		delete(p.keys, u.key)
+		return
+	}
+	p.total += u.size
+	p.keys[u.key] = u.size
+}
+
+// usedBytes returns the bytes of the versions stored under prefix, and false
+// if they have not been computed yet.
+func (b *versionedKVBackend) usedBytes(prefix string) (uint64, bool) {
+	u := b.byteUsage
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	p := u.prefix(prefix)
+	return p.total, p.loaded
+}
+
+// loadByteUsage computes the usage of the prefixes with a max_bytes whose
+// usage is not tracked yet. It is called by the periodic function so that the
+// writes never wait for the metadata of the keys to be scanned.
+func (b *versionedKVBackend) loadByteUsage(ctx context.Context, s logical.Storage) error {
+	config, err := b.config(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var prefixes []string
+	if config.MaxBytes > 0 {
+		prefixes = append(prefixes, "")
+	}
+	for prefix, pc := range config.PathConfigs {
+		if pc.MaxBytes > 0 {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		if err := b.loadPrefixBytes(ctx, s, prefix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadPrefixBytes computes the usage of prefix if it is not tracked yet. The
+// scan is made without holding the lock of the byteUsage, the updates made
+// meanwhile are applied once it completes.
+func (b *versionedKVBackend) loadPrefixBytes(ctx context.Context, s logical.Storage, prefix string) error {
+	u := b.byteUsage
+	u.l.Lock()
+	p := u.prefix(prefix)
+	if p.loaded || p.loading {
+		u.l.Unlock()
+		return nil
+	}
+	p.loading = true
+	u.l.Unlock()
+
+	keys, sizes, err := b.scanBytes(ctx, s, prefix)
+
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	p.loading = false
+	if err != nil {
+		p.pending = nil
+		return err
+	}
+
+	// The updates made during the scan are more recent than the metadata
+	// it may have read
+	for i, key := range keys {
+		p.keys[key] = sizes[i]
+		p.total += sizes[i]
+	}
+	for _, update := range p.pending {
+		p.apply(update)
+	}
+	p.pending = nil
+	p.loaded = true
+
+	return nil
+}
+
+// scanBytes returns the keys under prefix along with the bytes of their
+// versions. The sizes of the versions written before they were recorded are
+// read from their entries and saved to the metadata of their key.
+func (b *versionedKVBackend) scanBytes(ctx context.Context, s logical.Storage, prefix string) ([]string, []uint64, error) {
+	// The prefix does not have to end at a folder, so the keys of the
+	// folder it is in are filtered
+	all, err := b.collectKeys(ctx, s, prefix[:strings.LastIndex(prefix, "/")+1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var keys []string
+	var sizes []uint64
+	for _, key := range all {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		meta, err := b.getKeyMetadata(ctx, s, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if meta != nil && hasUnsizedVersions(meta) {
+			meta, err = b.recordKeyVersionSizes(ctx, s, key)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if meta == nil {
+			continue
+		}
+		keys = append(keys, key)
+		sizes = append(sizes, versionBytes(meta))
+	}
+
+	return keys, sizes, nil
+}
+
+// hasUnsizedVersions returns true if a version of the key that is not
+// destroyed was written before the sizes of the versions were recorded.
+func hasUnsizedVersions(meta *KeyMetadata) bool {
+	for _, vm := range meta.Versions {
+		if !vm.Destroyed && vm.Size == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// recordKeyVersionSizes records the sizes of the versions of key written
+// before they were recorded, and returns its updated metadata.
+func (b *versionedKVBackend) recordKeyVersionSizes(ctx context.Context, s logical.Storage, key string) (*KeyMetadata, error) {
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil || meta == nil || meta.Deleting {
+		return meta, err
+	}
+
+	modified, err := b.recordVersionSizes(ctx, s, meta)
+	if err != nil || !modified {
+		return meta, err
+	}
+
+	return meta, b.writeKeyMetadata(ctx, s, meta)
+}
+
+// trackKeyBytes updates the usage of the prefixes matching the key once the
+// change of its metadata has been persisted to s. A nil meta means that the
+// key was deleted.
+func (b *versionedKVBackend) trackKeyBytes(s logical.Storage, key string, meta *KeyMetadata) {
+	var size uint64
+	if meta != nil {
+		size = versionBytes(meta)
+	}
+
+	b.byteUsage.update(key, size, meta == nil)
+}
+
+// checkByteQuotas reserves the bytes of a version of size bytes written to
+// key, as reserveBytes does.
+func (b *versionedKVBackend) checkByteQuotas(config *Configuration, key string, size uint64) (func(), error) {
+	return b.reserveBytes(config, map[string]uint64{key: size}, "the version")
+}
+
+// checkBatchByteQuotas reserves the bytes of versions of the provided sizes,
+// by key, as reserveBytes does. The sizes of the keys under a prefix are
+// added up so that a batch of writes is checked before any of them is made.
+func (b *versionedKVBackend) checkBatchByteQuotas(config *Configuration, sizes map[string]uint64) (func(), error) {
+	return b.reserveBytes(config, sizes, "the versions")
+}
+
+// reserveBytes checks the writes of versions of the provided sizes, by key,
+// against the max_bytes of every prefix matching them. It returns an error
+// wrapping errByteQuotaExceeded, describing the versions as what, if they
+// would take a prefix over its max_bytes. Unless an error is returned, the
+// bytes are reserved until the returned func is called, which the caller must
+// do once the versions are written or their writes failed. The quotas of the
+// prefixes whose usage has not been computed yet are not enforced.
+func (b *versionedKVBackend) reserveBytes(config *Configuration, sizes map[string]uint64, what string) (func(), error) {
+	quotas := make(map[string]uint64)
+	totals := make(map[string]uint64)
+	for key, size := range sizes {
+		for prefix, quota := range byteQuotas(config, key) {
+			quotas[prefix] = quota
+			totals[prefix] += size
+		}
+	}
+	prefixes := make([]string, 0, len(quotas))
+	for prefix := range quotas {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	u := b.byteUsage
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	// The bytes are only reserved once all the prefixes have been checked
+	reserved := make(map[*prefixBytes]uint64, len(prefixes))
+	for _, prefix := range prefixes {
+		p := u.prefix(prefix)
+		if !p.loaded {
+			continue
+		}
+
+		used := p.total + p.reserved
+		if used+totals[prefix] > quotas[prefix] {
+			if prefix == "" {
+				return nil, fmt.Errorf("%w: %s of %d bytes would take the mount over its max_bytes of %d, %d bytes are used", errByteQuotaExceeded, what, totals[prefix], quotas[prefix], used)
+			}
+			return nil, fmt.Errorf("%w: %s of %d bytes would take %q over its max_bytes of %d, %d bytes are used", errByteQuotaExceeded, what, totals[prefix], prefix, quotas[prefix], used)
+		}
+		reserved[p] = totals[prefix]
+	}
+	for p, size := range reserved {
+		p.reserved += size
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			u.l.Lock()
+			defer u.l.Unlock()
+
+			for p, size := range reserved {
+				p.reserved -= size
+			}
+		})
+	}
+
+	return release, nil
+}
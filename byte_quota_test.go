@@ -0,0 +1,374 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// computeByteUsage runs the periodic function, which computes the usage of the
+// byte quotas.
+func computeByteUsage(t *testing.T, b logical.Backend, storage logical.Storage) {
+	t.Helper()
+
+	req := &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionedKV_ByteQuotas(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/team-a/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_bytes": 300,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	computeByteUsage(t, b, storage)
+
+	write := func(path string) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": strings.Repeat("a", 100),
+				},
+			},
+		})
+	}
+	usedBytes := func() uint64 {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "quotas",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		quota := resp.Data["prefixes"].(map[string]interface{})["team-a/"].(map[string]interface{})
+		return quota["used_bytes"].(uint64)
+	}
+
+	for _, path := range []string{"data/team-a/foo", "data/team-a/bar"} {
+		resp, err := write(path)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	used := usedBytes()
+	if used < 200 || used > 300 {
+		t.Fatalf("expected between 200 and 300 bytes to be used, got %d", used)
+	}
+
+	resp, err = write("data/team-a/foo")
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Error().Error(), "max_bytes") {
+		t.Fatalf("expected the write to be rejected by the quota, err:%s resp:%#v\n", err, resp)
+	}
+
+	// The other prefixes are not limited
+	resp, err = write("data/team-b/foo")
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Destroying a version frees its bytes
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "destroy/team-a/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"versions": "1",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if freed := usedBytes(); freed >= used {
+		t.Fatalf("expected the destroy to free bytes, used %d then %d", used, freed)
+	}
+
+	resp, err = write("data/team-a/foo")
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// So does deleting a key
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/team-a/bar",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if deleted := usedBytes(); deleted >= used {
+		t.Fatalf("expected the delete to free bytes, used %d then %d", used, deleted)
+	}
+}
+
+func TestVersionedKV_ByteQuotas_Mount(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_bytes": 10,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	computeByteUsage(t, b, storage)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": strings.Repeat("a", 100),
+			},
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Error().Error(), "the mount") {
+		t.Fatalf("expected the write to be rejected by the quota, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_ByteQuotas_ConcurrentScan(t *testing.T) {
+	b, storage := getBackend(t)
+
+	write := func(key string) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": key,
+				},
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Errorf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		write(fmt.Sprintf("app/%d", i))
+	}
+
+	// The writes made while the usage is computed are reconciled with it
+	kvb := b.(*versionedKVBackend)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 50; i < 100; i++ {
+			write(fmt.Sprintf("app/%d", i))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := kvb.loadPrefixBytes(context.Background(), storage, "app/"); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	used, ok := kvb.usedBytes("app/")
+	if !ok {
+		t.Fatal("expected the usage to be computed")
+	}
+	_, sizes, err := kvb.scanBytes(context.Background(), storage, "app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected uint64
+	for _, size := range sizes {
+		expected += size
+	}
+	if used != expected || len(sizes) != 100 {
+		t.Fatalf("expected %d bytes for %d keys, got %d", expected, len(sizes), used)
+	}
+}
+
+func TestVersionedKV_ByteQuotas_RenameKeyAndImport(t *testing.T) {
+	b, storage := getBackend(t)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	for prefix, maxBytes := range map[string]int{"team-a/": 300, "team-c/": 150} {
+		resp, err := handle(logical.UpdateOperation, "config/path/"+prefix, map[string]interface{}{"max_bytes": maxBytes})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	for _, path := range []string{"data/team-a/foo", "data/team-a/bar", "data/team-b/foo", "data/team-b/bar"} {
+		resp, err := handle(logical.CreateOperation, path, map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": strings.Repeat("a", 100),
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	computeByteUsage(t, b, storage)
+
+	// The version written by the renaming is rejected like a write
+	resp, err := handle(logical.UpdateOperation, "rename-key/team-a/foo", map[string]interface{}{
+		"from": "bar",
+		"to":   "baz",
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Error().Error(), "max_bytes") {
+		t.Fatalf("expected the renaming to be rejected by the quota, err:%s resp:%#v\n", err, resp)
+	}
+
+	// The bundle is rejected as a whole when only some of its keys fit
+	resp, err = handle(logical.ReadOperation, "export/team-b", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	keys := map[string]interface{}{}
+	for k, v := range resp.Data["keys"].(map[string]*exportedKey) {
+		keys[k] = v
+	}
+
+	resp, err = handle(logical.UpdateOperation, "import/team-c", map[string]interface{}{"keys": keys})
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Error().Error(), "max_bytes") {
+		t.Fatalf("expected the import to be rejected by the quota, err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = handle(logical.ListOperation, "metadata/team-c/", nil)
+	if err != nil || (resp != nil && resp.Data["keys"] != nil) {
+		t.Fatalf("expected no key to be imported, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_ByteQuotas_UnsizedVersions(t *testing.T) {
+	b, storage := getBackend(t)
+	kvb := b.(*versionedKVBackend)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/app/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": strings.Repeat("a", 100),
+			},
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Forget the size of the version, as for a version written before the
+	// sizes were recorded
+	meta, err := kvb.getKeyMetadata(context.Background(), storage, "app/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := meta.Versions[1].Size
+	meta.Versions[1].Size = 0
+	if err := kvb.writeKeyMetadata(context.Background(), storage, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/app/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_bytes": 300,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	computeByteUsage(t, b, storage)
+
+	used, ok := kvb.usedBytes("app/")
+	if !ok || used != size {
+		t.Fatalf("expected %d bytes to be used, got %d (computed: %t)", size, used, ok)
+	}
+	meta, err = kvb.getKeyMetadata(context.Background(), storage, "app/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Versions[1].Size != size {
+		t.Fatalf("expected the size of the version to be saved, got %d", meta.Versions[1].Size)
+	}
+}
+
+func TestVersionedKV_ByteQuotas_Reservations(t *testing.T) {
+	b, storage := getBackend(t)
+	kvb := b.(*versionedKVBackend)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/app/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_bytes": 300,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	computeByteUsage(t, b, storage)
+
+	config, err := kvb.config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bytes reserved by a write in progress count against the quota
+	release, err := kvb.checkByteQuotas(config, "app/foo", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvb.checkByteQuotas(config, "app/bar", 200); !errors.Is(err, errByteQuotaExceeded) {
+		t.Fatalf("expected the reservation to be rejected, got %v", err)
+	}
+
+	// Until they are released
+	release()
+	release()
+	releaseBar, err := kvb.checkByteQuotas(config, "app/bar", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseBar()
+}
@@ -0,0 +1,63 @@
+package kv
+
+import (
+	"fmt"
+)
+
+// classificationRank returns the position of classification in the
+// classifications of the mount, or -1 if it is not one of them.
+func classificationRank(config *Configuration, classification string) int {
+	for i, c := range config.Classifications {
+		if c == classification {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateClassifications returns an error if the classifications of the
+// mount contain duplicates or if the warning threshold is not one of them.
+func validateClassifications(config *Configuration) error {
+	seen := make(map[string]bool, len(config.Classifications))
+	for _, c := range config.Classifications {
+		if c == "" {
+			return fmt.Errorf("classifications cannot be empty")
+		}
+		if seen[c] {
+			return fmt.Errorf("duplicate classification %q", c)
+		}
+		seen[c] = true
+	}
+
+	if threshold := config.ClassificationWarningThreshold; threshold != "" && !seen[threshold] {
+		return fmt.Errorf("classification_warning_threshold %q is not one of the classifications", threshold)
+	}
+
+	return nil
+}
+
+// validateClassification returns an error if classification cannot be set on
+// a key. An empty classification clears the label and is always valid.
+func validateClassification(config *Configuration, classification string) error {
+	if classification == "" || classificationRank(config, classification) >= 0 {
+		return nil
+	}
+	return fmt.Errorf("classification %q is not one of the classifications %v configured on the backend", classification, config.Classifications)
+}
+
+// classificationWarning returns the warning to add to the data reads of the
+// key, or an empty string if its classification is below the warning
+// threshold of the mount. Keys whose classification was removed from the
+// mount do not get a warning.
+func classificationWarning(config *Configuration, meta *KeyMetadata) string {
+	if meta.Classification == "" || config.ClassificationWarningThreshold == "" {
+		return ""
+	}
+
+	rank := classificationRank(config, meta.Classification)
+	if rank < 0 || rank < classificationRank(config, config.ClassificationWarningThreshold) {
+		return ""
+	}
+
+	return fmt.Sprintf("The secret is classified as %q", meta.Classification)
+}
@@ -0,0 +1,141 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestClassificationWarning(t *testing.T) {
+	config := &Configuration{
+		Classifications:                []string{"public", "internal", "confidential", "secret"},
+		ClassificationWarningThreshold: "confidential",
+	}
+
+	for classification, warns := range map[string]bool{
+		"":             false,
+		"public":       false,
+		"internal":     false,
+		"confidential": true,
+		"secret":       true,
+		"removed":      false,
+	} {
+		warning := classificationWarning(config, &KeyMetadata{Classification: classification})
+		if (warning != "") != warns {
+			t.Fatalf("unexpected warning for %q: %q", classification, warning)
+		}
+	}
+}
+
+func TestValidateClassifications(t *testing.T) {
+	for _, tc := range []struct {
+		config *Configuration
+		valid  bool
+	}{
+		{&Configuration{}, true},
+		{&Configuration{Classifications: []string{"public", "secret"}, ClassificationWarningThreshold: "secret"}, true},
+		{&Configuration{Classifications: []string{"public", "public"}}, false},
+		{&Configuration{Classifications: []string{"public", ""}}, false},
+		{&Configuration{Classifications: []string{"public"}, ClassificationWarningThreshold: "secret"}, false},
+	} {
+		if err := validateClassifications(tc.config); (err == nil) != tc.valid {
+			t.Fatalf("unexpected result for %v: %v", tc.config, err)
+		}
+	}
+}
+
+func TestVersionedKV_Classification(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"classifications":                  "public,internal,confidential,secret",
+			"classification_warning_threshold": "confidential",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"classification": "top-secret",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	for key, classification := range map[string]string{
+		"foo": "internal",
+		"bar": "secret",
+	} {
+		req = &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"classification": classification,
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		req = &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"foo": "bar",
+				},
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for key, warnings := range map[string]int{
+		"foo": 0,
+		"bar": 1,
+	} {
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if len(resp.Warnings) != warnings {
+			t.Fatalf("%s: expected %d warnings, got %v", key, warnings, resp.Warnings)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/bar",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["classification"] != "secret" {
+		t.Fatalf("unexpected classification: %v", resp.Data["classification"])
+	}
+}
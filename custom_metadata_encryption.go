@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+)
+
+// customMetadataContext returns the key derivation context used to encrypt
+// the custom_metadata of key.
+func (b *versionedKVBackend) customMetadataContext(key string) []byte {
+	return []byte(path.Join(b.storagePrefix, "custom_metadata", key))
+}
+
+// encryptCustomMetadata moves the custom_metadata of meta to its
+// EncryptedCustomMetadata field, encrypted with policy. meta must not be
+// shared as it is modified.
+func (b *versionedKVBackend) encryptCustomMetadata(policy *keysutil.Policy, meta *KeyMetadata) error {
+	if len(meta.CustomMetadata) == 0 {
+		meta.EncryptedCustomMetadata = ""
+		return nil
+	}
+
+	buf, err := json.Marshal(meta.CustomMetadata)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := policy.Encrypt(0, b.customMetadataContext(meta.Key), nil, base64.StdEncoding.EncodeToString(buf))
+	if err != nil {
+		return fmt.Errorf("encrypting custom_metadata: %w", err)
+	}
+
+	meta.EncryptedCustomMetadata = ciphertext
+	meta.CustomMetadata = nil
+	return nil
+}
+
+// decryptCustomMetadata restores the custom_metadata of meta from its
+// EncryptedCustomMetadata field, if set.
+func (b *versionedKVBackend) decryptCustomMetadata(policy *keysutil.Policy, meta *KeyMetadata) error {
+	if meta.EncryptedCustomMetadata == "" {
+		return nil
+	}
+
+	encoded, err := policy.Decrypt(b.customMetadataContext(meta.Key), nil, meta.EncryptedCustomMetadata)
+	if err != nil {
+		return fmt.Errorf("decrypting custom_metadata: %w", err)
+	}
+	buf, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("decrypting custom_metadata: %w", err)
+	}
+
+	customMetadata := map[string]string{}
+	if err := json.Unmarshal(buf, &customMetadata); err != nil {
+		return fmt.Errorf("decoding custom_metadata: %w", err)
+	}
+
+	meta.CustomMetadata = customMetadata
+	meta.EncryptedCustomMetadata = ""
+	return nil
+}
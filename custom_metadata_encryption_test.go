@@ -0,0 +1,103 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_EncryptCustomMetadata(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"encrypt_custom_metadata": true,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	customMetadata := map[string]string{"owner": "team-a", "env": "prod"}
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{"owner": "team-a", "env": "prod"},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	assertEncrypted := func() {
+		t.Helper()
+
+		wrapper, err := b.(*versionedKVBackend).getKeyEncryptor(context.Background(), storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := wrapper.Wrap(storage).Get(context.Background(), "foo")
+		if err != nil || entry == nil {
+			t.Fatalf("err:%s entry:%#v\n", err, entry)
+		}
+		meta := &KeyMetadata{}
+		if err := proto.Unmarshal(entry.Value, meta); err != nil {
+			t.Fatal(err)
+		}
+		if len(meta.CustomMetadata) != 0 || meta.EncryptedCustomMetadata == "" {
+			t.Fatalf("expected the custom_metadata to be encrypted, got %#v", meta)
+		}
+	}
+
+	assertRead := func() {
+		t.Helper()
+
+		// Bypass the metadata cache to decrypt the stored entry
+		b.(*versionedKVBackend).metadataCache.purge()
+
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metadata/foo",
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if !reflect.DeepEqual(resp.Data["custom_metadata"], customMetadata) {
+			t.Fatalf("unexpected custom_metadata: %#v", resp.Data["custom_metadata"])
+		}
+	}
+
+	assertEncrypted()
+	assertRead()
+
+	// The custom_metadata is re-encrypted with the rotated key policy
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rewrap",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	for atomic.LoadUint32(b.(*versionedKVBackend).rewrapping) != rewrapIdle {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assertEncrypted()
+	assertRead()
+}
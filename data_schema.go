@@ -0,0 +1,120 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const dataSchemaValidationErrorPrefix = "data schema validation failed"
+
+// dataSchemaURL is the resource name under which schemas are compiled. It is
+// only used for error reporting as schemas are never fetched from it.
+const dataSchemaURL = "kv-data-schema.json"
+
+// compileDataSchema parses and compiles the provided JSON Schema document.
+// References to external documents are rejected so that a schema can never
+// cause the backend to read from the filesystem or the network.
+func compileDataSchema(schema string) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("loading external schema %q is not allowed", s)
+	}
+
+	if err := compiler.AddResource(dataSchemaURL, strings.NewReader(schema)); err != nil {
+		return nil, fmt.Errorf("invalid data schema: %w", err)
+	}
+
+	compiled, err := compiler.Compile(dataSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data schema: %w", err)
+	}
+
+	return compiled, nil
+}
+
+// dataSchema returns the JSON Schema document that applies to the provided
+// key. A schema set in the key metadata takes precedence over the schemas
+// configured on the mount, of which the one with the longest matching prefix
+// is used. An empty string is returned if no schema applies.
+func dataSchema(config *Configuration, meta *KeyMetadata) string {
+	if meta.DataSchema != "" {
+		return meta.DataSchema
+	}
+
+	var schema, longest string
+	for prefix, s := range config.DataSchemas {
+		if strings.HasPrefix(meta.Key, prefix) && (schema == "" || len(prefix) > len(longest)) {
+			schema, longest = s, prefix
+		}
+	}
+
+	return schema
+}
+
+// validateDataSchema validates the JSON encoded data against the provided
+// schema. Every failing leaf of the validation is reported so that a client
+// can fix all the problems of a payload at once.
+func validateDataSchema(schema string, data []byte) error {
+	if schema == "" {
+		return nil
+	}
+
+	compiled, err := compileDataSchema(schema)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dataSchemaValidationErrorPrefix, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+
+	err = compiled.Validate(v)
+	if err == nil {
+		return nil
+	}
+
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return fmt.Errorf("%s: %w", dataSchemaValidationErrorPrefix, err)
+	}
+
+	var errs *multierror.Error
+	for _, leaf := range validationErrorLeaves(verr) {
+		location := leaf.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		errs = multierror.Append(errs, fmt.Errorf("%s: %s: %s",
+			dataSchemaValidationErrorPrefix,
+			location,
+			leaf.Message))
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// validationErrorLeaves flattens the tree of validation errors returned by the
+// schema validator into the errors that have no further causes.
+func validationErrorLeaves(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range err.Causes {
+		leaves = append(leaves, validationErrorLeaves(cause)...)
+	}
+
+	return leaves
+}
@@ -0,0 +1,181 @@
+package kv
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const testDataSchema = `{
+	"type": "object",
+	"properties": {
+		"username": {"type": "string", "minLength": 3},
+		"port": {"type": "integer"}
+	},
+	"required": ["username", "port"]
+}`
+
+func TestDataSchema(t *testing.T) {
+	config := &Configuration{
+		DataSchemas: map[string]string{
+			"app/":     "app",
+			"app/db/":  "db",
+			"unused/":  "unused",
+			"app/dba/": "dba",
+		},
+	}
+
+	tests := map[string]struct {
+		meta *KeyMetadata
+		want string
+	}{
+		"no match":       {&KeyMetadata{Key: "other/foo"}, ""},
+		"prefix match":   {&KeyMetadata{Key: "app/foo"}, "app"},
+		"longest prefix": {&KeyMetadata{Key: "app/db/foo"}, "db"},
+		"key override":   {&KeyMetadata{Key: "app/db/foo", DataSchema: "key"}, "key"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := dataSchema(config, tc.meta); got != tc.want {
+				t.Fatalf("expected schema %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVersionedKV_Data_Put_DataSchema(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data_schemas": map[string]interface{}{
+				"app/": testDataSchema,
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/app/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"username": "ab",
+				"port":     "5432",
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected validation error, err:%s resp:%#v\n", err, resp)
+	}
+
+	respError := resp.Error().Error()
+	if !strings.Contains(respError, "2 errors occurred") {
+		t.Fatalf("expected 2 validation errors, resp: %#v", resp)
+	}
+	if !strings.Contains(respError, "/username") || !strings.Contains(respError, "/port") {
+		t.Fatalf("expected errors for username and port, resp: %#v", resp)
+	}
+
+	req.Data = map[string]interface{}{
+		"data": map[string]interface{}{
+			"username": "abc",
+			"port":     5432,
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Keys outside of the prefix are not validated
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/other",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"foo": "bar",
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Metadata_Put_DataSchema(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data_schema": `{"type": "object"`,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected invalid schema error, err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data = map[string]interface{}{
+		"data_schema": testDataSchema,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data_schema"] != testDataSchema {
+		t.Fatalf("unexpected data_schema, resp: %#v", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"username": "abc",
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected validation error, err:%s resp:%#v\n", err, resp)
+	}
+	if !strings.Contains(resp.Error().Error(), "missing properties") {
+		t.Fatalf("expected missing property error, resp: %#v", resp)
+	}
+}
@@ -0,0 +1,145 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// contentHash returns the salted HMAC of the data of a version.
+func (b *versionedKVBackend) contentHash(ctx context.Context, s logical.Storage, data []byte) (string, error) {
+	salt, err := b.Salt(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	return salt.GetHMAC(string(data)), nil
+}
+
+// sharedDataVersion returns the newest kept version of the key described by
+// meta whose entry holds data with the content hash, or 0 if there is none.
+// The newest version is used as it is the last one to be pruned.
+func sharedDataVersion(meta *KeyMetadata, hash string) uint64 {
+	var shared uint64
+	for id, vm := range meta.Versions {
+		if vm.ContentHash != hash || vm.DataVersion != 0 || vm.Destroyed || vm.Reference != "" {
+			continue
+		}
+		if id > shared {
+			shared = id
+		}
+	}
+	return shared
+}
+
+// resolveVersionData returns the version whose entry holds the data of
+// version, which is version itself unless it is deduplicated.
+func (b *versionedKVBackend) resolveVersionData(ctx context.Context, s logical.Storage, key string, version *Version) (*Version, error) {
+	if version.DataVersion == 0 {
+		return version, nil
+	}
+
+	versionKey, err := b.getVersionKey(ctx, key, version.DataVersion, s)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := s.Get(ctx, versionKey)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("could not find the data of version %d", version.DataVersion)
+	}
+
+	shared := &Version{}
+	if err := proto.Unmarshal(raw.Value, shared); err != nil {
+		return nil, err
+	}
+	return shared, nil
+}
+
+// rehomeSharedData moves the data shared by deduplicated versions of the key
+// described by meta out of the entries of the versions that are no longer
+// kept, so that these entries can be deleted. The data is moved to the oldest
+// of the versions sharing it, which the others then point to. It must be
+// called before the entries are deleted, meta is updated but not written.
+// The caller must hold the lock for the key.
+func (b *versionedKVBackend) rehomeSharedData(ctx context.Context, s logical.Storage, meta *KeyMetadata) error {
+	orphaned := map[uint64][]uint64{}
+	for id, vm := range meta.Versions {
+		if vm.DataVersion == 0 || vm.Destroyed {
+			continue
+		}
+		if shared := meta.Versions[vm.DataVersion]; shared != nil && !shared.Destroyed {
+			continue
+		}
+		orphaned[vm.DataVersion] = append(orphaned[vm.DataVersion], id)
+	}
+
+	for dataVersion, ids := range orphaned {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		dataKey, err := b.getVersionKey(ctx, meta.Key, dataVersion, s)
+		if err != nil {
+			return err
+		}
+		raw, err := s.Get(ctx, dataKey)
+		if err != nil {
+			return err
+		}
+		if raw == nil {
+			return fmt.Errorf("could not find the data of version %d shared by versions %v", dataVersion, ids)
+		}
+		shared := &Version{}
+		if err := proto.Unmarshal(raw.Value, shared); err != nil {
+			return err
+		}
+
+		heir := ids[0]
+		for _, id := range ids {
+			versionKey, err := b.getVersionKey(ctx, meta.Key, id, s)
+			if err != nil {
+				return err
+			}
+			entry, err := s.Get(ctx, versionKey)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				return fmt.Errorf("could not find version %d", id)
+			}
+			version := &Version{}
+			if err := proto.Unmarshal(entry.Value, version); err != nil {
+				return err
+			}
+
+			if id == heir {
+				version.Data = shared.Data
+				version.TransitCiphertext = shared.TransitCiphertext
+				version.TransitKey = shared.TransitKey
+				version.DataVersion = 0
+			} else {
+				version.DataVersion = heir
+			}
+
+			buf, err := proto.Marshal(version)
+			if err != nil {
+				return err
+			}
+			if err := s.Put(ctx, &logical.StorageEntry{
+				Key:      versionKey,
+				Value:    buf,
+				SealWrap: entry.SealWrap || raw.SealWrap,
+			}); err != nil {
+				return err
+			}
+
+			meta.Versions[id].DataVersion = version.DataVersion
+			meta.Versions[id].Size = uint64(len(buf))
+		}
+	}
+
+	return nil
+}
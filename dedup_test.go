@@ -0,0 +1,159 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_DeduplicateVersions(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"deduplicate_versions": true,
+			"max_versions":         3,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	write := func(value string) {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": value,
+				},
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	read := func(version int) string {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"version": version,
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp.Data["data"].(map[string]interface{})["bar"].(string)
+	}
+
+	// dataVersion returns the version whose entry holds the data of the
+	// version, checking that the metadata agrees with the stored entry
+	dataVersion := func(verNum uint64) uint64 {
+		t.Helper()
+		meta, err := kv.getKeyMetadata(context.Background(), storage, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		versionKey, err := kv.getVersionKey(context.Background(), "foo", verNum, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := storage.Get(context.Background(), versionKey)
+		if err != nil || raw == nil {
+			t.Fatalf("err:%s entry:%#v", err, raw)
+		}
+		version := &Version{}
+		if err := proto.Unmarshal(raw.Value, version); err != nil {
+			t.Fatal(err)
+		}
+		vm := meta.Versions[verNum]
+		if vm.ContentHash == "" || vm.DataVersion != version.DataVersion || (version.DataVersion > 0) != (version.Data == nil) {
+			t.Fatalf("inconsistent version %d: %#v %#v", verNum, vm, version)
+		}
+		return version.DataVersion
+	}
+
+	write("a")
+	write("b")
+	write("a")
+	if v := dataVersion(3); v != 1 {
+		t.Fatalf("expected version 3 to share the data of version 1, got %d", v)
+	}
+	if v := dataVersion(2); v != 0 {
+		t.Fatalf("expected version 2 to hold its data, got %d", v)
+	}
+
+	// Once version 1 is pruned its data is moved to version 3, which the
+	// other versions sharing it point to
+	write("a")
+	if v := dataVersion(3); v != 0 {
+		t.Fatalf("expected version 3 to hold the data, got %d", v)
+	}
+	if v := dataVersion(4); v != 3 {
+		t.Fatalf("expected version 4 to share the data of version 3, got %d", v)
+	}
+	for _, version := range []int{3, 4} {
+		if value := read(version); value != "a" {
+			t.Fatalf("unexpected data of version %d: %q", version, value)
+		}
+	}
+
+	// and the same happens when version 3 is destroyed
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "destroy/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"versions": []int{3},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if v := dataVersion(4); v != 0 {
+		t.Fatalf("expected version 4 to hold the data, got %d", v)
+	}
+	if value := read(4); value != "a" {
+		t.Fatalf("unexpected data of version 4: %q", value)
+	}
+}
+
+func TestVersionedKV_DeduplicateVersions_Disabled(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The data is not hashed when deduplication is disabled
+	meta, err := kv.getKeyMetadata(context.Background(), storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := meta.Versions[1].ContentHash; hash != "" {
+		t.Fatalf("expected no content hash, got %q", hash)
+	}
+}
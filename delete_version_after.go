@@ -1,10 +1,13 @@
 package kv
 
 import (
+	"context"
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
 )
 
 // deletionTime returns the time of creation plus the duration of the
@@ -39,6 +42,19 @@ func deleteVersionAfter(v deleteVersionAfterGetter) time.Duration {
 	return dva
 }
 
+// minDeleteVersionAfter returns the shortest delete_version_after a key is
+// allowed to set, or zero if no floor is configured.
+func minDeleteVersionAfter(c *Configuration) time.Duration {
+	if c.GetMinDeleteVersionAfter() == nil {
+		return time.Duration(0)
+	}
+	min, err := ptypes.Duration(c.GetMinDeleteVersionAfter())
+	if err != nil {
+		return time.Duration(0)
+	}
+	return min
+}
+
 const (
 	disabled time.Duration = -1 * time.Second
 )
@@ -62,3 +78,84 @@ func (c *Configuration) DisableDeleteVersionAfter() {
 func (c *Configuration) ResetDeleteVersionAfter() {
 	c.DeleteVersionAfter = nil
 }
+
+// deleteExpiredVersions deletes the versions of key that have no
+// deletion_time, such as the versions written before delete_version_after was
+// configured, once the delete_version_after of the mount or of the key has
+// elapsed since their creation.
+func (b *versionedKVBackend) deleteExpiredVersions(ctx context.Context, s logical.Storage, key string) error {
+	config, err := b.keyConfig(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if config.IsDeleteVersionAfterDisabled() {
+		return nil
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil || meta.Deleting {
+		return nil
+	}
+
+	// The deletion of the most recent retrievable versions is deferred so
+	// that min_versions is honored, as ApplyMinVersions does
+	protected := make(map[uint64]bool)
+	n := minVersions(config, meta)
+	for v := meta.CurrentVersion; v > 0 && v >= meta.OldestVersion && uint32(len(protected)) < n; v-- {
+		vm, ok := meta.Versions[v]
+		if !ok {
+			continue
+		}
+		retrievable, err := isRetrievable(vm)
+		if err != nil {
+			return err
+		}
+		if retrievable {
+			protected[v] = true
+		}
+	}
+
+	now := time.Now()
+	var modified bool
+	for id, vm := range meta.Versions {
+		if vm.Destroyed || vm.DeletionTime != nil || vm.DeferredDeletionTime != nil || vm.CreatedTime == nil {
+			continue
+		}
+
+		created, err := ptypes.Timestamp(vm.CreatedTime)
+		if err != nil {
+			return err
+		}
+		dtime, ok := deletionTime(created, deleteVersionAfter(config), deleteVersionAfter(meta))
+		if !ok || dtime.After(now) {
+			continue
+		}
+
+		dt, err := ptypes.TimestampProto(dtime)
+		if err != nil {
+			return err
+		}
+		vm.DeletionTime = dt
+		vm.DeletionDeferred = protected[id]
+		modified = true
+	}
+
+	if !modified {
+		return nil
+	}
+
+	return b.writeKeyMetadata(ctx, s, meta)
+}
+
+// expiresIn returns the number of whole seconds left at now before a version
+// is deleted at deletionTime.
+func expiresIn(deletionTime, now time.Time) int64 {
+	return int64(deletionTime.Sub(now) / time.Second)
+}
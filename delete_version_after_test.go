@@ -116,7 +116,7 @@ func TestDeleteVersionAfter(t *testing.T) {
 				"delete_version_after": tt.mount.String(),
 			}
 			req := &logical.Request{
-				Operation: logical.CreateOperation,
+				Operation: logical.UpdateOperation,
 				Path:      "config",
 				Storage:   storage,
 				Data:      data,
@@ -210,7 +210,7 @@ func TestDeleteVersionAfter(t *testing.T) {
 				"versions": "1",
 			}
 			req = &logical.Request{
-				Operation: logical.CreateOperation,
+				Operation: logical.UpdateOperation,
 				Path:      "delete/foo",
 				Storage:   storage,
 				Data:      data,
@@ -222,7 +222,7 @@ func TestDeleteVersionAfter(t *testing.T) {
 				"versions": "1",
 			}
 			req = &logical.Request{
-				Operation: logical.CreateOperation,
+				Operation: logical.UpdateOperation,
 				Path:      "undelete/foo",
 				Storage:   storage,
 				Data:      data,
@@ -256,3 +256,129 @@ func TestDeleteVersionAfter(t *testing.T) {
 		})
 	}
 }
+
+func TestMinDeleteVersionAfter(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"min_delete_version_after": "168h",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	wantNoResponse(t, resp, err)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	wantResponse(t, resp, err)
+	if want, got := (168 * time.Hour).String(), resp.Data["min_delete_version_after"]; want != got {
+		t.Fatalf("want min_delete_version_after: %v, got %v", want, got)
+	}
+
+	// A mount level delete_version_after below the floor is rejected
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"delete_version_after": "1h",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected error, err: %v resp: %#v", err, resp)
+	}
+
+	var tests = []struct {
+		dva     string
+		wantErr bool
+	}{
+		{"1h", true},
+		{"167h59m", true},
+		{"168h", false},
+		{"720h", false},
+		{"0s", false},
+	}
+	for _, tt := range tests {
+		req = &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"delete_version_after": tt.dva,
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("dva=%s: unexpected err: %v", tt.dva, err)
+		}
+		if gotErr := resp != nil && resp.IsError(); gotErr != tt.wantErr {
+			t.Fatalf("dva=%s: want error %t, resp: %#v", tt.dva, tt.wantErr, resp)
+		}
+	}
+}
+
+func TestDeleteVersionAfter_Periodic(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for i := 0; i < 2; i++ {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	// The versions written before delete_version_after is set have no
+	// deletion_time
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"delete_version_after": "1s",
+			"min_versions":         1,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	req = &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Versions[1].DeletionTime == nil {
+		t.Fatal("expected version 1 to be deleted")
+	}
+	if vm := meta.Versions[2]; vm.DeletionTime == nil || !vm.DeletionDeferred {
+		t.Fatal("expected version 2 to be kept by min_versions")
+	}
+}
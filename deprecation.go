@@ -0,0 +1,13 @@
+package kv
+
+// deprecationWarning returns the warning to add to the data reads of the key,
+// or an empty string if the key is not deprecated.
+func deprecationWarning(meta *KeyMetadata) string {
+	if !meta.Deprecated {
+		return ""
+	}
+	if meta.DeprecationMessage == "" {
+		return "The secret is deprecated"
+	}
+	return "The secret is deprecated: " + meta.DeprecationMessage
+}
@@ -0,0 +1,111 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestDeprecationWarning(t *testing.T) {
+	tests := map[string]struct {
+		meta *KeyMetadata
+		want string
+	}{
+		"not deprecated": {&KeyMetadata{DeprecationMessage: "use bar"}, ""},
+		"deprecated":     {&KeyMetadata{Deprecated: true}, "The secret is deprecated"},
+		"message":        {&KeyMetadata{Deprecated: true, DeprecationMessage: "use bar"}, "The secret is deprecated: use bar"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := deprecationWarning(tc.meta); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVersionedKV_Deprecation(t *testing.T) {
+	b, storage := getBackend(t)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "metadata/foo",
+			Data: map[string]interface{}{
+				"deprecated":          true,
+				"deprecation_message": "use secret/bar instead",
+			},
+		},
+	}
+	for _, req := range requests {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	readData := func() *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	resp := readData()
+	if resp.Data["data"] == nil {
+		t.Fatal("expected the data of the deprecated secret")
+	}
+	expected := []string{"The secret is deprecated: use secret/bar instead"}
+	if !reflect.DeepEqual(resp.Warnings, expected) {
+		t.Fatalf("expected warnings %v, got %v", expected, resp.Warnings)
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["deprecated"] != true || resp.Data["deprecation_message"] != "use secret/bar instead" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"deprecated": false,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if resp := readData(); len(resp.Warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", resp.Warnings)
+	}
+}
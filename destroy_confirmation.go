@@ -0,0 +1,198 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// errDestroyNoEntity is returned when a destroy requiring confirmation is
+// made with a token that is not tied to an entity.
+var errDestroyNoEntity = errors.New("destroys must be confirmed by another entity but the request has no entity")
+
+// errDestroySameEntity is returned when the entity that requested a destroy
+// tries to confirm it.
+var errDestroySameEntity = errors.New("the destroy must be confirmed by a different entity than the one that requested it")
+
+// errDestroyPending is returned when a destroy is requested while another
+// entity is waiting for the confirmation of a different destroy of the key.
+var errDestroyPending = errors.New("another destroy of the key requested by a different entity is waiting for its confirmation")
+
+// errDualControlOverwrite is returned when an operation would overwrite keys
+// whose destroys must be confirmed.
+var errDualControlOverwrite = errors.New("keys whose destroys must be confirmed by another entity cannot be overwritten")
+
+// destroyConfirmationWindow returns the time a requested destroy can be
+// confirmed within, or zero if destroys do not need to be confirmed.
+func destroyConfirmationWindow(c *Configuration) time.Duration {
+	if c.GetDestroyConfirmationWindow() == nil {
+		return time.Duration(0)
+	}
+	window, err := ptypes.Duration(c.GetDestroyConfirmationWindow())
+	if err != nil {
+		return time.Duration(0)
+	}
+	return window
+}
+
+// erasingSetting returns the name of the first of the provided settings that
+// erases versions without them being destroyed through the API, or an empty
+// string if none of them does. max_versions erases versions when it is lower
+// than previousMaxVersions, the value it replaces.
+func erasingSetting(maxVersions, previousMaxVersions uint32, destroyVersionAfter, maxVersionAge *duration.Duration, maxHistoryBytes bool) string {
+	switch {
+	case loweredMaxVersions(maxVersions, previousMaxVersions):
+		return "max_versions"
+	case destroyVersionAfter != nil:
+		return "destroy_version_after"
+	case maxVersionAge != nil:
+		return "max_version_age"
+	case maxHistoryBytes:
+		return "max_history_bytes"
+	default:
+		return ""
+	}
+}
+
+// loweredMaxVersions returns true if maxVersions keeps fewer versions than
+// previousMaxVersions. Zero stands for the default number of versions.
+func loweredMaxVersions(maxVersions, previousMaxVersions uint32) bool {
+	if previousMaxVersions == 0 {
+		previousMaxVersions = defaultMaxVersions
+	}
+	return maxVersions > 0 && maxVersions < previousMaxVersions
+}
+
+// maxVersionsIf returns maxVersions if it is set by the request, or zero.
+func maxVersionsIf(ok bool, maxVersions uint32) uint32 {
+	if !ok {
+		return 0
+	}
+	return maxVersions
+}
+
+// durationIf returns d if it is set by the request, or nil.
+func durationIf(ok bool, d *duration.Duration) *duration.Duration {
+	if !ok {
+		return nil
+	}
+	return d
+}
+
+// checkDualControlSetting returns an error if setting, as returned by
+// erasingSetting, is set while the destroys must be confirmed, as it would
+// erase versions without a confirmation.
+func checkDualControlSetting(config *Configuration, setting string) error {
+	if setting == "" || destroyConfirmationWindow(config) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s cannot be set while destroys must be confirmed by another entity, as it erases versions without a confirmation", setting)
+}
+
+// confirmDestroy checks the destroy of versions requested by entityID against
+// the pending destroy of the key. If deleteMetadata is true the request is
+// for the deletion of the whole key. It returns true if the request confirms
+// the pending destroy, in which case the pending destroy is cleared.
+// Otherwise the request is recorded as the new pending destroy of the key. A
+// pending destroy of other versions can only be replaced by its requester
+// until it expires.
+func confirmDestroy(meta *KeyMetadata, versions []uint64, deleteMetadata bool, entityID string, window time.Duration, now time.Time) (bool, error) {
+	if entityID == "" {
+		return false, errDestroyNoEntity
+	}
+
+	versions = sortedVersions(versions)
+	if pending := meta.PendingDestroy; pending != nil {
+		requestedTime, err := ptypes.Timestamp(pending.RequestedTime)
+		if err != nil {
+			return false, err
+		}
+
+		if now.Before(requestedTime.Add(window)) {
+			same := pending.DeleteMetadata == deleteMetadata && equalVersions(pending.Versions, versions)
+			switch {
+			case same && pending.RequesterEntityId == entityID:
+				return false, errDestroySameEntity
+			case same:
+				meta.PendingDestroy = nil
+				return true, nil
+			case pending.RequesterEntityId != entityID:
+				return false, errDestroyPending
+			}
+		}
+	}
+
+	requestedTime, err := ptypes.TimestampProto(now)
+	if err != nil {
+		return false, err
+	}
+	meta.PendingDestroy = &PendingDestroy{
+		Versions:          versions,
+		RequesterEntityId: entityID,
+		RequestedTime:     requestedTime,
+		DeleteMetadata:    deleteMetadata,
+	}
+	return false, nil
+}
+
+// pendingDestroyResponse returns the response to a destroy recorded as the
+// pending destroy of the key, waiting for its confirmation.
+func pendingDestroyResponse(meta *KeyMetadata, window time.Duration) *logical.Response {
+	resp := &logical.Response{
+		Data: pendingDestroyData(meta),
+	}
+	resp.Data["confirmation_window"] = window.String()
+	resp.AddWarning("The destroy must be confirmed by another entity within " + window.String())
+	return resp
+}
+
+// pendingDestroyData returns the description of the pending destroy of the
+// key, or nil if there is none.
+func pendingDestroyData(meta *KeyMetadata) map[string]interface{} {
+	pending := meta.PendingDestroy
+	if pending == nil {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"versions":        pending.Versions,
+		"requested_by":    pending.RequesterEntityId,
+		"requested_time":  ptypesTimestampToString(pending.RequestedTime),
+		"delete_metadata": pending.DeleteMetadata,
+	}
+}
+
+// sortedVersions returns the distinct version numbers of versions in
+// ascending order.
+func sortedVersions(versions []uint64) []uint64 {
+	sorted := make([]uint64, 0, len(versions))
+	seen := make(map[uint64]struct{}, len(versions))
+	for _, v := range versions {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// equalVersions returns true if a and b hold the same version numbers in the
+// same order.
+func equalVersions(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,375 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Destroy_DualControl(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"destroy_confirmation_window": "1h",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{"bar": "baz"},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	destroy := func(entityID string) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "destroy/foo",
+			Storage:   storage,
+			EntityID:  entityID,
+			Data: map[string]interface{}{
+				"versions": "1",
+			},
+		})
+	}
+	isDestroyed := func() bool {
+		meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return meta.Versions[1].Destroyed
+	}
+
+	// Tokens without an entity cannot take part
+	resp, err = destroy("")
+	if err != logical.ErrPermissionDenied || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = destroy("alice")
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["requested_by"] != "alice" || resp.Data["confirmation_window"] != "1h0m0s" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+	if isDestroyed() {
+		t.Fatal("expected the version not to be destroyed before confirmation")
+	}
+
+	// The requester cannot confirm its own destroy
+	resp, err = destroy("alice")
+	if err != logical.ErrPermissionDenied || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if pending, ok := resp.Data["pending_destroy"].(map[string]interface{}); !ok || pending["requested_by"] != "alice" {
+		t.Fatalf("unexpected pending_destroy: %#v", resp.Data["pending_destroy"])
+	}
+
+	resp, err = destroy("bob")
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !isDestroyed() {
+		t.Fatal("expected the version to be destroyed once confirmed")
+	}
+}
+
+func TestConfirmDestroy(t *testing.T) {
+	now := time.Now()
+	meta := &KeyMetadata{}
+
+	confirmed, err := confirmDestroy(meta, []uint64{2, 1}, false, "alice", time.Minute, now)
+	if err != nil || confirmed {
+		t.Fatalf("expected a pending destroy, confirmed:%t err:%s", confirmed, err)
+	}
+
+	// Other entities cannot replace the pending destroy while it can be
+	// confirmed
+	confirmed, err = confirmDestroy(meta, []uint64{1}, false, "bob", time.Minute, now)
+	if !errors.Is(err, errDestroyPending) || confirmed || meta.PendingDestroy.RequesterEntityId != "alice" {
+		t.Fatalf("expected the pending destroy to be kept, confirmed:%t err:%s", confirmed, err)
+	}
+
+	// Its requester can
+	confirmed, err = confirmDestroy(meta, []uint64{1}, false, "alice", time.Minute, now)
+	if err != nil || confirmed || !equalVersions(meta.PendingDestroy.Versions, []uint64{1}) {
+		t.Fatalf("expected a new pending destroy, confirmed:%t err:%s", confirmed, err)
+	}
+
+	// An expired pending destroy is replaced
+	meta.PendingDestroy.RequestedTime, _ = ptypes.TimestampProto(now.Add(-time.Hour))
+	confirmed, err = confirmDestroy(meta, []uint64{1, 2}, false, "carol", time.Minute, now)
+	if err != nil || confirmed || meta.PendingDestroy.RequesterEntityId != "carol" {
+		t.Fatalf("expected a new pending destroy, confirmed:%t err:%s", confirmed, err)
+	}
+
+	// An expired pending destroy cannot be confirmed
+	meta.PendingDestroy.RequestedTime, _ = ptypes.TimestampProto(now.Add(-time.Hour))
+	confirmed, err = confirmDestroy(meta, []uint64{1, 2}, false, "alice", time.Minute, now)
+	if err != nil || confirmed || meta.PendingDestroy.RequesterEntityId != "alice" {
+		t.Fatalf("expected a new pending destroy, confirmed:%t err:%s", confirmed, err)
+	}
+
+	confirmed, err = confirmDestroy(meta, []uint64{2, 1, 2}, false, "carol", time.Minute, now)
+	if err != nil || !confirmed || meta.PendingDestroy != nil {
+		t.Fatalf("expected the destroy to be confirmed, confirmed:%t err:%s", confirmed, err)
+	}
+}
+
+func TestVersionedKV_DualControl_Erasures(t *testing.T) {
+	b, storage := getBackend(t)
+
+	handle := func(op logical.Operation, path, entityID string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      data,
+		})
+	}
+
+	resp, err := handle(logical.CreateOperation, "data/app/foo", "", map[string]interface{}{
+		"data": map[string]interface{}{"bar": "baz"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = handle(logical.ReadOperation, "export/app", "", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	keys := map[string]interface{}{}
+	for k, v := range resp.Data["keys"].(map[string]*exportedKey) {
+		keys[k] = v
+	}
+
+	resp, err = handle(logical.UpdateOperation, "config", "", map[string]interface{}{
+		"destroy_confirmation_window": "1h",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The settings erasing versions on their own are rejected
+	for field, value := range map[string]interface{}{
+		"max_versions":          2,
+		"destroy_version_after": "1h",
+		"max_version_age":       "1h",
+		"max_history_bytes":     1024,
+	} {
+		resp, err = handle(logical.UpdateOperation, "metadata/app/foo", "", map[string]interface{}{field: value})
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected an error, err:%s resp:%#v\n", field, err, resp)
+		}
+	}
+	resp, err = handle(logical.UpdateOperation, "config", "", map[string]interface{}{"max_versions": 2})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = handle(logical.UpdateOperation, "templates/app/", "", map[string]interface{}{"max_versions": 1})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	// Raising max_versions does not erase versions
+	resp, err = handle(logical.UpdateOperation, "metadata/app/foo", "", map[string]interface{}{"max_versions": 20})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = handle(logical.UpdateOperation, "templates/app/", "", map[string]interface{}{"max_versions": 20})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = handle(logical.UpdateOperation, "metadata/app/foo", "", map[string]interface{}{"max_versions": 15})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	// The existing keys cannot be overwritten
+	resp, err = handle(logical.UpdateOperation, "import/app", "", map[string]interface{}{
+		"keys":     keys,
+		"conflict": "overwrite",
+	})
+	if err != logical.ErrPermissionDenied || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	// The deletion of the metadata must be confirmed
+	resp, err = handle(logical.DeleteOperation, "metadata/app/foo", "alice", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["delete_metadata"] != true {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+	meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "app/foo")
+	if err != nil || meta == nil {
+		t.Fatalf("expected the key to exist, err:%s meta:%#v", err, meta)
+	}
+
+	// A destroy of the versions neither confirms nor replaces the deletion
+	// of the key
+	resp, err = handle(logical.UpdateOperation, "destroy/app/foo", "bob", map[string]interface{}{"versions": "1"})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = handle(logical.DeleteOperation, "metadata/app/foo", "bob", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	meta, err = b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "app/foo")
+	if err != nil || meta != nil {
+		t.Fatalf("expected the key to be deleted, err:%s meta:%#v", err, meta)
+	}
+}
+
+func TestVersionedKV_Destroy_DualControlPathSettings(t *testing.T) {
+	b, storage := getBackend(t)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	resp, err := handle(logical.UpdateOperation, "config/path/app/", map[string]interface{}{
+		"destroy_version_after": "1h",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The window cannot be set while a prefix erases versions on its own
+	resp, err = handle(logical.UpdateOperation, "config", map[string]interface{}{
+		"destroy_confirmation_window": "1h",
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = handle(logical.DeleteOperation, "config/path/app/", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = handle(logical.UpdateOperation, "config", map[string]interface{}{
+		"destroy_confirmation_window": "1h",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The settings of a prefix erasing versions on their own are rejected
+	for field, value := range map[string]interface{}{
+		"max_versions":          2,
+		"destroy_version_after": "1h",
+		"max_version_age":       "1h",
+	} {
+		resp, err = handle(logical.UpdateOperation, "config/path/app/", map[string]interface{}{field: value})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected an error, err:%s resp:%#v\n", field, err, resp)
+		}
+	}
+	resp, err = handle(logical.UpdateOperation, "config/path/app/", map[string]interface{}{"max_versions": 20})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// A version cannot be scheduled for destruction when written
+	resp, err = handle(logical.UpdateOperation, "data/app/foo", map[string]interface{}{
+		"data": map[string]interface{}{"bar": "baz"},
+		"options": map[string]interface{}{
+			"destroy_version_after": "1h",
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+	meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "app/foo")
+	if err != nil || meta != nil {
+		t.Fatalf("expected no key, err:%s meta:%#v", err, meta)
+	}
+}
+
+func TestVersionedKV_Destroy_DualControlTrash(t *testing.T) {
+	b, storage := getBackend(t)
+
+	handle := func(op logical.Operation, path, entityID string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      data,
+		})
+	}
+
+	resp, err := handle(logical.UpdateOperation, "config", "", map[string]interface{}{
+		"destroy_confirmation_window": "1h",
+		"trash_retention":             "1h",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = handle(logical.CreateOperation, "data/foo", "", map[string]interface{}{
+		"data": map[string]interface{}{"bar": "baz"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The key is not trashed before the deletion is confirmed, as the trash
+	// is purged without a confirmation
+	resp, err = handle(logical.DeleteOperation, "metadata/foo", "alice", nil)
+	if err != nil || resp == nil || resp.IsError() || resp.Data["delete_metadata"] != true {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	trash, err := b.(*versionedKVBackend).listTrash(context.Background(), storage)
+	if err != nil || len(trash) != 0 {
+		t.Fatalf("expected an empty trash, err:%s trash:%#v", err, trash)
+	}
+
+	resp, err = handle(logical.DeleteOperation, "metadata/foo", "bob", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	trash, err = b.(*versionedKVBackend).listTrash(context.Background(), storage)
+	if err != nil || len(trash) != 1 {
+		t.Fatalf("expected the key to be trashed, err:%s trash:%#v", err, trash)
+	}
+}
@@ -0,0 +1,130 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+type destroyVersionAfterGetter interface {
+	GetDestroyVersionAfter() *duration.Duration
+}
+
+func destroyVersionAfter(v destroyVersionAfterGetter) time.Duration {
+	if v.GetDestroyVersionAfter() == nil {
+		return time.Duration(0)
+	}
+	dva, err := ptypes.Duration(v.GetDestroyVersionAfter())
+	if err != nil {
+		return time.Duration(0)
+	}
+	return dva
+}
+
+// destroyTime returns the time the version should be destroyed at, based on
+// its deletion time and on the destroy_version_after of the version, or
+// else on the minimum non-zero value of the mount and the key. If the
+// version is not deleted or no destroy_version_after applies, false is
+// returned.
+func destroyTime(config *Configuration, meta *KeyMetadata, vm *VersionMetadata) (time.Time, bool, error) {
+	if vm.DeletionTime == nil {
+		return time.Time{}, false, nil
+	}
+
+	deletion, err := ptypes.Timestamp(vm.DeletionTime)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if dva := destroyVersionAfter(vm); dva > 0 {
+		return deletion.Add(dva), true, nil
+	}
+
+	dtime, ok := deletionTime(deletion, destroyVersionAfter(config), destroyVersionAfter(meta))
+	return dtime, ok, nil
+}
+
+// destroyDeletedVersions destroys the versions of key whose
+// destroy_version_after has elapsed since their deletion.
+func (b *versionedKVBackend) destroyDeletedVersions(ctx context.Context, s logical.Storage, key string) error {
+	config, err := b.keyConfig(ctx, s, key)
+	if err != nil {
+		return err
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+
+	versions, err := versionsDueForDestroy(config, meta, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	for _, verNum := range versions {
+		meta.Versions[verNum].Destroyed = true
+	}
+
+	if deleteMetadataWhenDestroyed(config, meta) && allVersionsDestroyed(meta) {
+		return b.deleteKey(ctx, s, meta)
+	}
+
+	// Write the metadata key before deleting the versions
+	if err := b.rehomeSharedData(ctx, s, meta); err != nil {
+		return err
+	}
+	if err := b.writeKeyMetadata(ctx, s, meta); err != nil {
+		return err
+	}
+
+	return b.deleteVersions(ctx, s, key, versions)
+}
+
+// versionsDueForDestroy returns the versions of the key whose
+// destroy_version_after has elapsed at now and that are not destroyed yet.
+func versionsDueForDestroy(config *Configuration, meta *KeyMetadata, now time.Time) ([]uint64, error) {
+	var versions []uint64
+	for verNum, vm := range meta.Versions {
+		if vm.Destroyed {
+			continue
+		}
+
+		dtime, ok, err := destroyTime(config, meta, vm)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || dtime.After(now) {
+			continue
+		}
+
+		versions = append(versions, verNum)
+	}
+
+	return versions, nil
+}
+
+// purgeDueVersions destroys the versions of key whose destroy_version_after
+// has elapsed without waiting for the periodic function to reach the key. It
+// is called after reads, so failures are logged rather than returned.
+func (b *versionedKVBackend) purgeDueVersions(ctx context.Context, s logical.Storage, key string) {
+	if b.perfSecondaryCheck() {
+		return
+	}
+	if err := b.destroyDeletedVersions(ctx, s, key); err != nil {
+		b.Logger().Warn("failed to destroy the deleted versions", "key", key, "error", err)
+	}
+}
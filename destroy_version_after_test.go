@@ -0,0 +1,215 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestDestroyTime(t *testing.T) {
+	deletion := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	deletionProto, err := ptypes.TimestampProto(deletion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		mount, key, version time.Duration
+		deleted             bool
+		want                time.Duration
+		ok                  bool
+	}{
+		"not deleted":      {time.Hour, 0, 0, false, 0, false},
+		"nothing set":      {0, 0, 0, true, 0, false},
+		"mount":            {time.Hour, 0, 0, true, time.Hour, true},
+		"key":              {0, time.Minute, 0, true, time.Minute, true},
+		"min of mount/key": {time.Hour, time.Minute, 0, true, time.Minute, true},
+		"version override": {time.Minute, time.Minute, time.Hour, true, time.Hour, true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := &Configuration{}
+			meta := &KeyMetadata{}
+			vm := &VersionMetadata{}
+			if tc.mount != 0 {
+				config.DestroyVersionAfter = ptypes.DurationProto(tc.mount)
+			}
+			if tc.key != 0 {
+				meta.DestroyVersionAfter = ptypes.DurationProto(tc.key)
+			}
+			if tc.version != 0 {
+				vm.DestroyVersionAfter = ptypes.DurationProto(tc.version)
+			}
+			if tc.deleted {
+				vm.DeletionTime = deletionProto
+			}
+
+			got, ok, err := destroyTime(config, meta, vm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.ok {
+				t.Fatalf("expected ok to be %t", tc.ok)
+			}
+			if ok && !got.Equal(deletion.Add(tc.want)) {
+				t.Fatalf("expected %s, got %s", deletion.Add(tc.want), got)
+			}
+		})
+	}
+}
+
+func TestVersionedKV_DestroyVersionAfter(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"destroy_version_after": "1h",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Version 1 overrides the destroy_version_after of the key, version 2
+	// uses it
+	for _, options := range []map[string]interface{}{{"destroy_version_after": "1s"}, {}} {
+		req = &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"options": options,
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "delete/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"versions": "1,2",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	req = &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["destroy_version_after"] != "1h0m0s" {
+		t.Fatalf("unexpected destroy_version_after, resp: %#v", resp)
+	}
+
+	versions := resp.Data["versions"].(map[string]interface{})
+	if !versions["1"].(map[string]interface{})["destroyed"].(bool) {
+		t.Fatalf("expected version 1 to be destroyed, resp: %#v", resp)
+	}
+	if versions["2"].(map[string]interface{})["destroyed"].(bool) {
+		t.Fatalf("expected version 2 not to be destroyed, resp: %#v", resp)
+	}
+
+	versionKey, err := b.(*versionedKVBackend).getVersionKey(context.Background(), "foo", 1, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := storage.Get(context.Background(), versionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Fatal("expected the data of version 1 to be removed")
+	}
+}
+
+func TestVersionedKV_DestroyVersionAfter_ReadWrite(t *testing.T) {
+	b, storage := getBackend(t)
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		req := &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+	destroyed := func(verNum uint64) bool {
+		meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		versionKey, err := b.(*versionedKVBackend).getVersionKey(context.Background(), "foo", verNum, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := storage.Get(context.Background(), versionKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return meta.Versions[verNum].Destroyed && entry == nil
+	}
+
+	for i := 0; i < 2; i++ {
+		request(logical.CreateOperation, "data/foo", map[string]interface{}{
+			"options": map[string]interface{}{"destroy_version_after": "1s"},
+			"data":    map[string]interface{}{"bar": "baz"},
+		})
+	}
+
+	// The versions due are destroyed when the secret is read, without
+	// waiting for the periodic function
+	request(logical.UpdateOperation, "delete/foo", map[string]interface{}{"versions": "1"})
+	time.Sleep(1100 * time.Millisecond)
+	request(logical.ReadOperation, "data/foo", nil)
+	if !destroyed(1) || destroyed(2) {
+		t.Fatal("expected only version 1 to be destroyed after the read")
+	}
+
+	// and when it is written
+	request(logical.UpdateOperation, "delete/foo", map[string]interface{}{"versions": "2"})
+	time.Sleep(1100 * time.Millisecond)
+	request(logical.CreateOperation, "data/foo", map[string]interface{}{
+		"data": map[string]interface{}{"bar": "baz"},
+	})
+	if !destroyed(2) {
+		t.Fatal("expected version 2 to be destroyed after the write")
+	}
+}
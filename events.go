@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The types of the events published on the changes of the secrets.
+const (
+	eventDataWrite      = "kv-v2/data-write"
+	eventDataDelete     = "kv-v2/data-delete"
+	eventDataUndelete   = "kv-v2/data-undelete"
+	eventMetadataDelete = "kv-v2/metadata-delete"
+	eventDestroy        = "kv-v2/destroy"
+)
+
+// sendEvent publishes an event of eventType about the provided versions of
+// key to the Vault event system and the webhooks. The metadata of an event
+// holds the mount and the path of the secret, and the versions affected if
+// any. Failing to publish an event does not fail the request, the error is
+// logged instead.
+func (b *versionedKVBackend) sendEvent(ctx context.Context, req *logical.Request, eventType, key string, versions []uint64) {
+	b.sendEventWithMetadata(ctx, req, eventType, key, versions, nil)
+}
+
+// sendEventWithMetadata publishes an event like sendEvent, with extra added
+// to its metadata.
+func (b *versionedKVBackend) sendEventWithMetadata(ctx context.Context, req *logical.Request, eventType, key string, versions []uint64, extra map[string]string) {
+	metadata := map[string]string{
+		"mount": req.MountPoint,
+		"path":  key,
+	}
+	for k, v := range extra {
+		metadata[k] = v
+	}
+	if len(versions) > 0 {
+		vs := make([]string, 0, len(versions))
+		for _, v := range versions {
+			vs = append(vs, strconv.FormatUint(v, 10))
+		}
+		metadata["versions"] = strings.Join(vs, ",")
+	}
+
+	if err := b.publishEvent(ctx, eventType, metadata); err != nil {
+		b.Logger().Warn("failed to send event", "type", eventType, "path", key, "error", err)
+	}
+
+	b.notifyWebhooks(ctx, req.Storage, eventType, key, metadata)
+}
+
+// publishEvent sends an event to the Vault event system. Nothing is sent when
+// the events are not enabled on the server.
+func (b *versionedKVBackend) publishEvent(ctx context.Context, eventType string, metadata map[string]string) error {
+	event, err := logical.NewEvent()
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		fields[k] = v
+	}
+	event.Metadata, err = structpb.NewStruct(fields)
+	if err != nil {
+		return err
+	}
+
+	err = b.SendEvent(ctx, logical.EventType(eventType), event)
+	if errors.Is(err, framework.ErrNoEvents) {
+		return nil
+	}
+	return err
+}
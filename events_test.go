@@ -0,0 +1,259 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+type event struct {
+	eventType string
+	metadata  map[string]string
+}
+
+// eventsRecorder records the events sent by the backend.
+type eventsRecorder struct {
+	l      sync.Mutex
+	events []event
+}
+
+func (r *eventsRecorder) Send(ctx context.Context, eventType logical.EventType, data *logical.EventData) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	metadata := map[string]string{}
+	for k, v := range data.Metadata.AsMap() {
+		metadata[k] = v.(string)
+	}
+	r.events = append(r.events, event{string(eventType), metadata})
+	return nil
+}
+
+func TestVersionedKV_Events(t *testing.T) {
+	recorder := &eventsRecorder{}
+	config := &logical.BackendConfig{
+		Logger:       logging.NewVaultLogger(log.Trace),
+		System:       &logical.StaticSystemView{},
+		EventsSender: recorder,
+		StorageView:  &logical.InmemStorage{},
+		BackendUUID:  "test",
+	}
+	b, err := VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatalf("unable to create backend: %v", err)
+	}
+	time.Sleep(time.Second)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "qux",
+				},
+			},
+		},
+		{
+			Operation: logical.DeleteOperation,
+			Path:      "data/foo",
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "undelete/foo",
+			Data: map[string]interface{}{
+				"versions": "2",
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "destroy/foo",
+			Data: map[string]interface{}{
+				"versions": "1",
+			},
+		},
+		{
+			Operation: logical.DeleteOperation,
+			Path:      "metadata/foo",
+		},
+	}
+	for _, req := range requests {
+		req.Storage = config.StorageView
+		req.MountPoint = "secret/"
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	expected := []event{
+		{eventDataWrite, map[string]string{"mount": "secret/", "path": "foo", "versions": "1"}},
+		{eventDataWrite, map[string]string{"mount": "secret/", "path": "foo", "versions": "2"}},
+		{eventDataDelete, map[string]string{"mount": "secret/", "path": "foo", "versions": "2"}},
+		{eventDataUndelete, map[string]string{"mount": "secret/", "path": "foo", "versions": "2"}},
+		{eventDestroy, map[string]string{"mount": "secret/", "path": "foo", "versions": "1"}},
+		{eventMetadataDelete, map[string]string{"mount": "secret/", "path": "foo"}},
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, recorder.events)
+	}
+}
+
+func TestVersionedKV_Events_ChangedKeys(t *testing.T) {
+	recorder := &eventsRecorder{}
+	config := &logical.BackendConfig{
+		Logger:       logging.NewVaultLogger(log.Trace),
+		System:       &logical.StaticSystemView{},
+		EventsSender: recorder,
+		StorageView:  &logical.InmemStorage{},
+		BackendUUID:  "test",
+	}
+	b, err := VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatalf("unable to create backend: %v", err)
+	}
+	time.Sleep(time.Second)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"event_changed_keys": true,
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"a": "1",
+					"b": "2",
+				},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"b": "3",
+					"c": "4",
+				},
+			},
+		},
+		{
+			Operation: logical.PatchOperation,
+			Path:      "data/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"c": nil,
+				},
+			},
+		},
+	}
+	for _, req := range requests {
+		req.Storage = config.StorageView
+		req.MountPoint = "secret/"
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	// The values are never included
+	expected := []event{
+		{eventDataWrite, map[string]string{"mount": "secret/", "path": "foo", "versions": "1", "added_keys": "a,b", "removed_keys": "", "modified_keys": ""}},
+		{eventDataWrite, map[string]string{"mount": "secret/", "path": "foo", "versions": "2", "added_keys": "c", "removed_keys": "a", "modified_keys": "b"}},
+		{eventDataWrite, map[string]string{"mount": "secret/", "path": "foo", "versions": "3", "added_keys": "", "removed_keys": "c", "modified_keys": ""}},
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, recorder.events)
+	}
+}
+
+func TestVersionedKV_Events_NewVersions(t *testing.T) {
+	recorder := &eventsRecorder{}
+	config := &logical.BackendConfig{
+		Logger:       logging.NewVaultLogger(log.Trace),
+		System:       &logical.StaticSystemView{},
+		EventsSender: recorder,
+		StorageView:  &logical.InmemStorage{},
+		BackendUUID:  "test",
+	}
+	b, err := VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatalf("unable to create backend: %v", err)
+	}
+	time.Sleep(time.Second)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"trash_retention": "1h",
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "rename-key/foo",
+			Data: map[string]interface{}{
+				"from": "bar",
+				"to":   "qux",
+			},
+		},
+		{
+			Operation: logical.DeleteOperation,
+			Path:      "metadata/foo",
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "trash/restore/foo",
+		},
+	}
+	for _, req := range requests {
+		req.Storage = config.StorageView
+		req.MountPoint = "secret/"
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	// The renamed key is a new version and the restored versions are written
+	// back, both publishing a data write
+	expected := []event{
+		{eventDataWrite, map[string]string{"mount": "secret/", "path": "foo", "versions": "1"}},
+		{eventDataWrite, map[string]string{"mount": "secret/", "path": "foo", "versions": "2"}},
+		{eventMetadataDelete, map[string]string{"mount": "secret/", "path": "foo"}},
+		{eventDataWrite, map[string]string{"mount": "secret/", "path": "foo", "versions": "1,2"}},
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, recorder.events)
+	}
+}
@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// expireAt returns the absolute expiration time of the key, or the zero time
+// if the key does not expire.
+func expireAt(meta *KeyMetadata) (time.Time, error) {
+	if meta.ExpireAt == nil {
+		return time.Time{}, nil
+	}
+
+	return ptypes.Timestamp(meta.ExpireAt)
+}
+
+// isExpired returns true if the expiration time of the key has passed.
+func isExpired(meta *KeyMetadata) (bool, error) {
+	t, err := expireAt(meta)
+	if err != nil {
+		return false, err
+	}
+
+	return !t.IsZero() && t.Before(time.Now()), nil
+}
+
+// expireKey soft-deletes the versions of key if it is expired. Versions that
+// are already deleted or destroyed are left untouched.
+func (b *versionedKVBackend) expireKey(ctx context.Context, s logical.Storage, key string) error {
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+
+	expired, err := isExpired(meta)
+	if err != nil || !expired {
+		return err
+	}
+
+	var modified bool
+	for _, vm := range meta.Versions {
+		if vm.Destroyed {
+			continue
+		}
+
+		deleted, err := isDeleted(vm)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			continue
+		}
+
+		vm.DeletionTime = &timestamp.Timestamp{
+			Seconds: meta.ExpireAt.Seconds,
+			Nanos:   meta.ExpireAt.Nanos,
+		}
+		modified = true
+	}
+
+	if !modified {
+		return nil
+	}
+
+	return b.writeKeyMetadata(ctx, s, meta)
+}
@@ -0,0 +1,151 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_ExpireAt(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for i := 0; i < 2; i++ {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"expire_at": "not a timestamp",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected invalid expire_at error, err:%s resp:%#v\n", err, resp)
+	}
+
+	expiration := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	req.Data = map[string]interface{}{
+		"expire_at": expiration.Format(time.RFC3339),
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["expire_at"] != expiration.Format(time.RFC3339Nano) {
+		t.Fatalf("unexpected expire_at, resp: %#v", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data[logical.HTTPStatusCode] != http.StatusNotFound {
+		t.Fatalf("expected a 404, resp: %#v", resp)
+	}
+
+	var httpResp logical.HTTPResponse
+	if err := json.Unmarshal([]byte(resp.Data[logical.HTTPRawBody].(string)), &httpResp); err != nil {
+		t.Fatal(err)
+	}
+	if httpResp.Data["data"] != nil || len(httpResp.Warnings) != 1 {
+		t.Fatalf("expected no data and an expiration warning, resp: %#v", httpResp)
+	}
+
+	// The periodic function soft-deletes the versions
+	req = &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version, vm := range meta.Versions {
+		deleted, err := isDeleted(vm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deleted {
+			t.Fatalf("expected version %d to be deleted", version)
+		}
+	}
+
+	// Clearing expire_at makes the key readable again once undeleted
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"expire_at": "",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "undelete/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"versions": "2",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data"].(map[string]interface{})["bar"] != "baz" {
+		t.Fatalf("unexpected data, resp: %#v", resp)
+	}
+}
@@ -0,0 +1,91 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// validateGlob returns an error if pattern is not a valid glob for
+// globKeys.
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == "" {
+			return errors.New("glob cannot contain empty segments")
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// globKeys returns the fully qualified keys and folders under prefix whose
+// path relative to prefix matches pattern. The pattern is matched segment by
+// segment with path.Match, so a "*" never matches across a "/". Only the
+// folders whose segment contains a wildcard are listed.
+func (b *versionedKVBackend) globKeys(ctx context.Context, s logical.Storage, prefix, pattern string) ([]string, error) {
+	wrapper, err := b.getKeyEncryptor(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	es := wrapper.Wrap(s)
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	segments := strings.Split(pattern, "/")
+	folders := []string{prefix}
+	var keys []string
+	for i, segment := range segments {
+		last := i == len(segments)-1
+
+		var next []string
+		for _, folder := range folders {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			// Folders do not have to be listed to descend into a literal
+			// segment
+			if !last && !strings.ContainsAny(segment, `*?[\`) {
+				next = append(next, folder+segment+"/")
+				continue
+			}
+
+			entries, err := es.List(ctx, folder)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				isFolder := strings.HasSuffix(entry, "/")
+				if !last && !isFolder {
+					continue
+				}
+
+				matched, err := path.Match(segment, strings.TrimSuffix(entry, "/"))
+				if err != nil {
+					return nil, err
+				}
+				if !matched {
+					continue
+				}
+
+				if last {
+					keys = append(keys, folder+entry)
+				} else {
+					next = append(next, folder+entry)
+				}
+			}
+		}
+		folders = next
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,84 @@
+package kv
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestValidateGlob(t *testing.T) {
+	for pattern, valid := range map[string]bool{
+		"apps/*/database": true,
+		"apps/db-?":       true,
+		"apps//database":  false,
+		"apps/*/":         false,
+		"apps/[":          false,
+	} {
+		if err := validateGlob(pattern); (err == nil) != valid {
+			t.Fatalf("unexpected result for %q: %v", pattern, err)
+		}
+	}
+}
+
+func TestVersionedKV_Metadata_List_Glob(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, key := range []string{
+		"apps/foo/database",
+		"apps/foo/cache",
+		"apps/bar/database",
+		"apps/bar/database/replica",
+		"apps/baz/nested/database",
+		"other/foo/database",
+	} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"max_versions": 5,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, tc := range []struct {
+		path     string
+		glob     string
+		expected []string
+	}{
+		{"", "apps/*/database", []string{"apps/bar/database", "apps/bar/database/", "apps/foo/database"}},
+		{"apps/", "*/database", []string{"apps/bar/database", "apps/bar/database/", "apps/foo/database"}},
+		{"apps/", "f*/*", []string{"apps/foo/cache", "apps/foo/database"}},
+		{"", "*/foo/database", []string{"apps/foo/database", "other/foo/database"}},
+		{"", "apps/*/missing", nil},
+	} {
+		req := &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "metadata/" + tc.path,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"glob": tc.glob,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		var keys []string
+		if raw, ok := resp.Data["keys"]; ok {
+			keys = raw.([]string)
+			sort.Strings(keys)
+		}
+		if diff := deep.Equal(keys, tc.expected); diff != nil {
+			t.Fatalf("%s: %v", tc.glob, diff)
+		}
+	}
+}
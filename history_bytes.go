@@ -0,0 +1,190 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxHistoryBytes returns the largest cumulative size of the stored versions
+// of the key. The value set on the key takes precedence over the one
+// configured for the longest matching prefix of the mount. Zero is returned
+// if the size is not limited.
+func maxHistoryBytes(config *Configuration, meta *KeyMetadata) uint64 {
+	if meta.MaxHistoryBytes > 0 {
+		return meta.MaxHistoryBytes
+	}
+
+	_, limit := prefixHistoryBytes(config, meta.Key)
+	return limit
+}
+
+// prefixHistoryBytes returns the max_history_bytes configured on the mount for
+// the longest prefix matching key, along with the prefix. Zero is returned if
+// no prefix matches.
+func prefixHistoryBytes(config *Configuration, key string) (string, uint64) {
+	var limit uint64
+	var longest string
+	var found bool
+	for prefix, l := range config.MaxHistoryBytes {
+		if strings.HasPrefix(key, prefix) && (!found || len(prefix) > len(longest)) {
+			limit, longest, found = l, prefix, true
+		}
+	}
+
+	return longest, limit
+}
+
+// parseMaxHistoryBytes parses the max_history_bytes configuration of the
+// mount.
+func parseMaxHistoryBytes(raw map[string]string) (map[string]uint64, error) {
+	limits := make(map[string]uint64, len(raw))
+	for prefix, l := range raw {
+		limit, err := strconv.ParseUint(l, 10, 64)
+		if err != nil || limit == 0 {
+			return nil, fmt.Errorf("invalid max_history_bytes %q for prefix %q, expected a positive number of bytes", l, prefix)
+		}
+		limits[prefix] = limit
+	}
+	return limits, nil
+}
+
+// PruneVersionsExceedingBytes removes the oldest versions from the key
+// metadata until the cumulative size of the stored versions is at most limit,
+// and moves the oldest version accordingly. The current version is never
+// removed, nor are versions that would leave fewer than minVersions
+// retrievable versions. It returns the most recent version removed, or zero
+// if none was.
+func (k *KeyMetadata) PruneVersionsExceedingBytes(limit uint64, minVersions uint32) (uint64, error) {
+	var total uint64
+	for _, vm := range k.Versions {
+		if !vm.Destroyed {
+			total += vm.Size
+		}
+	}
+
+	remaining, err := retrievableVersions(k)
+	if err != nil {
+		return 0, err
+	}
+
+	var versionToDelete uint64
+	for i := k.OldestVersion; i < k.CurrentVersion && total > limit; i++ {
+		vm, ok := k.Versions[i]
+		if !ok {
+			continue
+		}
+
+		retrievable, err := isRetrievable(vm)
+		if err != nil {
+			return 0, err
+		}
+		if retrievable {
+			if remaining <= minVersions {
+				break
+			}
+			remaining--
+		}
+
+		if !vm.Destroyed {
+			total -= vm.Size
+		}
+		versionToDelete = i
+	}
+
+	if versionToDelete == 0 {
+		return 0, nil
+	}
+
+	for i := k.OldestVersion; i < versionToDelete+1; i++ {
+		delete(k.Versions, i)
+	}
+	k.OldestVersion = versionToDelete + 1
+
+	return versionToDelete, nil
+}
+
+// recordVersionSizes sets the size of the stored versions of the key that
+// were written before sizes were recorded. It returns true if the metadata
+// was modified.
+func (b *versionedKVBackend) recordVersionSizes(ctx context.Context, s logical.Storage, meta *KeyMetadata) (bool, error) {
+	var modified bool
+	for v, vm := range meta.Versions {
+		if vm.Destroyed || vm.Size > 0 {
+			continue
+		}
+
+		versionKey, err := b.getVersionKey(ctx, meta.Key, v, s)
+		if err != nil {
+			return false, err
+		}
+		entry, err := s.Get(ctx, versionKey)
+		if err != nil {
+			return false, err
+		}
+		if entry == nil {
+			continue
+		}
+
+		vm.Size = uint64(len(entry.Value))
+		modified = true
+	}
+
+	return modified, nil
+}
+
+// pruneHistoryBytes removes the oldest versions of key while their cumulative
+// size exceeds the max_history_bytes of the key or of the mount.
+func (b *versionedKVBackend) pruneHistoryBytes(ctx context.Context, s logical.Storage, key string) error {
+	config, err := b.keyConfig(ctx, s, key)
+	if err != nil {
+		return err
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil || meta.Deleting {
+		return nil
+	}
+
+	limit := maxHistoryBytes(config, meta)
+	if limit == 0 {
+		return nil
+	}
+
+	modified, err := b.recordVersionSizes(ctx, s, meta)
+	if err != nil {
+		return err
+	}
+
+	versionToDelete, err := meta.PruneVersionsExceedingBytes(limit, minVersions(config, meta))
+	if err != nil {
+		return err
+	}
+	if versionToDelete == 0 && !modified {
+		return nil
+	}
+
+	if err := b.rehomeSharedData(ctx, s, meta); err != nil {
+		return err
+	}
+	if err := b.writeKeyMetadata(ctx, s, meta); err != nil {
+		return err
+	}
+
+	if warning := b.cleanupOldVersions(ctx, s, key, versionToDelete); warning != "" {
+		b.Logger().Warn(warning, "key", key)
+	}
+
+	return nil
+}
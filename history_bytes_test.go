@@ -0,0 +1,162 @@
+package kv
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMaxHistoryBytes(t *testing.T) {
+	config := &Configuration{
+		MaxHistoryBytes: map[string]uint64{
+			"foo/":     100,
+			"foo/bar/": 200,
+		},
+	}
+
+	tests := map[string]struct {
+		meta *KeyMetadata
+		want uint64
+	}{
+		"no match":       {&KeyMetadata{Key: "baz"}, 0},
+		"prefix":         {&KeyMetadata{Key: "foo/baz"}, 100},
+		"longest prefix": {&KeyMetadata{Key: "foo/bar/baz"}, 200},
+		"key":            {&KeyMetadata{Key: "foo/bar/baz", MaxHistoryBytes: 50}, 50},
+	}
+	for name, tc := range tests {
+		if got := maxHistoryBytes(config, tc.meta); got != tc.want {
+			t.Fatalf("%s: expected %d, got %d", name, tc.want, got)
+		}
+	}
+}
+
+func TestKeyMetadata_PruneVersionsExceedingBytes(t *testing.T) {
+	meta := &KeyMetadata{
+		Versions: map[uint64]*VersionMetadata{
+			1: {Size: 10},
+			2: {Size: 10, Destroyed: true},
+			3: {Size: 10},
+			4: {Size: 10},
+			5: {Size: 10},
+		},
+		CurrentVersion: 5,
+		OldestVersion:  1,
+	}
+
+	// min_versions stops the pruning
+	v, err := meta.PruneVersionsExceedingBytes(15, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 || meta.OldestVersion != 3 || len(meta.Versions) != 3 {
+		t.Fatalf("unexpected result %d, meta: %#v", v, meta)
+	}
+
+	// The current version is always kept
+	v, err = meta.PruneVersionsExceedingBytes(5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 4 || meta.OldestVersion != 5 || len(meta.Versions) != 1 {
+		t.Fatalf("unexpected result %d, meta: %#v", v, meta)
+	}
+}
+
+func TestVersionedKV_MaxHistoryBytes(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_history_bytes": map[string]interface{}{
+				"big/": "zero",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data = map[string]interface{}{
+		"max_history_bytes": map[string]interface{}{
+			"big/": "3000",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	versions := func(key string) int {
+		t.Helper()
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return len(resp.Data["versions"].(map[string]interface{}))
+	}
+
+	for _, key := range []string{"big/foo", "small/foo"} {
+		for i := 0; i < 5; i++ {
+			req := &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "data/" + key,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"data": map[string]interface{}{
+						"blob": strings.Repeat(strconv.Itoa(i), 1000),
+					},
+				},
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+		}
+	}
+
+	// Each version is a little larger than 1000 bytes
+	if n := versions("big/foo"); n != 2 {
+		t.Fatalf("expected 2 versions, got %d", n)
+	}
+	if n := versions("small/foo"); n != 5 {
+		t.Fatalf("expected 5 versions, got %d", n)
+	}
+
+	// Lowering the limit of the key prunes its versions in the periodic
+	// sweep
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/small/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_history_bytes": 2500,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if n := versions("small/foo"); n != 2 {
+		t.Fatalf("expected 2 versions, got %d", n)
+	}
+}
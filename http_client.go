@@ -0,0 +1,84 @@
+package kv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// httpDialTimeout bounds the time spent establishing a connection to an
+	// external service.
+	httpDialTimeout = 10 * time.Second
+
+	// httpTLSHandshakeTimeout bounds the time spent in the TLS handshake
+	// with an external service.
+	httpTLSHandshakeTimeout = 10 * time.Second
+)
+
+// validateServiceURL returns an error if rawURL is not an absolute http or
+// https URL.
+func validateServiceURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid url %q: the scheme must be http or https", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid url %q: missing host", rawURL)
+	}
+	if u.User != nil {
+		return fmt.Errorf("invalid url %q: credentials cannot be set in the url", rawURL)
+	}
+	return nil
+}
+
+// parseCACert returns the pool of the PEM encoded certificates of caCert, or
+// nil if it is empty so that the system roots are used.
+func parseCACert(caCert string) (*x509.CertPool, error) {
+	if caCert == "" {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caCert)) {
+		return nil, errors.New("ca_cert does not hold a PEM encoded certificate")
+	}
+	return pool, nil
+}
+
+// newHTTPClient returns the client of the requests made to an external
+// service. Each request is bounded by timeout, the server certificate is
+// verified against caCert if set, or else the system roots, and the name it
+// is verified against is serverName if set, or else the host of the request.
+func newHTTPClient(timeout time.Duration, caCert, serverName string) (*http.Client, error) {
+	pool, err := parseCACert(caCert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout: httpDialTimeout,
+			}).DialContext,
+			TLSHandshakeTimeout: httpTLSHandshakeTimeout,
+			TLSClientConfig: &tls.Config{
+				RootCAs:    pool,
+				ServerName: serverName,
+				MinVersion: tls.VersionTLS12,
+			},
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}, nil
+}
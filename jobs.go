@@ -0,0 +1,270 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// jobsPrefix is the prefix where the background jobs are stored.
+	jobsPrefix string = "jobs/"
+
+	// maxJobErrors is the number of errors recorded in a job. Further errors
+	// are only counted.
+	maxJobErrors = 100
+
+	// jobProgressInterval is the number of keys processed between two
+	// writes of the job progress to storage.
+	jobProgressInterval = 100
+)
+
+// jobFunc processes a single key of a job. It returns true if the key was
+// modified.
+type jobFunc func(ctx context.Context, key string) (bool, error)
+
+// jobCompleteFunc is called once a job has processed every key, before the
+// job is marked as done.
+type jobCompleteFunc func(ctx context.Context, job *Job) error
+
+// pathsJobs returns the path configuration for the endpoints reporting the
+// progress of background jobs.
+func pathsJobs(b *versionedKVBackend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "jobs/?$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.upgradeCheck(b.pathJobsList()),
+			},
+
+			HelpSynopsis:    jobsHelpSyn,
+			HelpDescription: jobsHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "jobs/" + framework.GenericNameRegex("id"),
+			Fields: map[string]*framework.FieldSchema{
+				"id": {
+					Type:        framework.TypeString,
+					Description: "The ID of the job.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.upgradeCheck(b.pathJobsRead()),
+			},
+
+			HelpSynopsis:    jobsHelpSyn,
+			HelpDescription: jobsHelpDesc,
+		},
+	}
+}
+
+func (b *versionedKVBackend) pathJobsList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		ids, err := req.Storage.List(ctx, path.Join(b.storagePrefix, jobsPrefix)+"/")
+		if err != nil {
+			return nil, err
+		}
+
+		return logical.ListResponse(ids), nil
+	}
+}
+
+func (b *versionedKVBackend) pathJobsRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		job, err := b.getJob(ctx, req.Storage, data.Get("id").(string))
+		if err != nil {
+			return nil, err
+		}
+		if job == nil {
+			return nil, nil
+		}
+
+		return &logical.Response{
+			Data: jobResponseData(job),
+		}, nil
+	}
+}
+
+func jobResponseData(job *Job) map[string]interface{} {
+	errs := job.Errors
+	if errs == nil {
+		errs = []string{}
+	}
+
+	return map[string]interface{}{
+		"id":             job.Id,
+		"operation":      job.Operation,
+		"path":           job.Path,
+		"started_time":   ptypesTimestampToString(job.StartedTime),
+		"completed_time": ptypesTimestampToString(job.CompletedTime),
+		"total":          job.Total,
+		"processed":      job.Processed,
+		"updated":        job.Updated,
+		"failed":         job.Failed,
+		"errors":         errs,
+		"done":           job.Done,
+	}
+}
+
+// getJob returns the job with the provided ID, if no job exists it will
+// return nil.
+func (b *versionedKVBackend) getJob(ctx context.Context, s logical.Storage, id string) (*Job, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, jobsPrefix, id))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	job := &Job{}
+	if err := proto.Unmarshal(raw.Value, job); err != nil {
+		return nil, fmt.Errorf("failed to decode job from storage: %v", err)
+	}
+
+	return job, nil
+}
+
+// writeJob writes a job to storage.
+func (b *versionedKVBackend) writeJob(ctx context.Context, s logical.Storage, job *Job) error {
+	buf, err := proto.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, jobsPrefix, job.Id),
+		Value: buf,
+	})
+}
+
+// startJob records a new job for the provided keys and runs fn on each of them
+// in a background goroutine so that the client is not blocked on a
+// potentially long process. The progress is regularly written to storage and
+// can be followed through the jobs endpoint. If complete is not nil, it is
+// called once every key has been processed.
+func (b *versionedKVBackend) startJob(ctx context.Context, s logical.Storage, operation, p string, keys []string, fn jobFunc, complete jobCompleteFunc) (*Job, error) {
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		Id:          id,
+		Operation:   operation,
+		Path:        p,
+		StartedTime: ptypes.TimestampNow(),
+		Total:       uint64(len(keys)),
+	}
+
+	if err := b.writeJob(ctx, s, job); err != nil {
+		return nil, err
+	}
+
+	// The returned job must not be shared with the goroutine updating it.
+	started := proto.Clone(job).(*Job)
+
+	b.jobsWG.Add(1)
+	go func() {
+		defer b.jobsWG.Done()
+
+		ctx := b.jobsCtx
+		logger := b.Logger().With("job_id", job.Id, "operation", job.Operation)
+
+		for i, key := range keys {
+			if ctx.Err() != nil {
+				logger.Info("job canceled", "progress", fmt.Sprintf("%d/%d", i, len(keys)))
+				return
+			}
+
+			updated, err := fn(ctx, key)
+			job.Processed++
+			switch {
+			case err != nil:
+				job.Failed++
+				if len(job.Errors) < maxJobErrors {
+					job.Errors = append(job.Errors, fmt.Sprintf("%s: %s", key, err))
+				}
+			case updated:
+				job.Updated++
+			}
+
+			if job.Processed%jobProgressInterval == 0 {
+				if err := b.writeJob(ctx, s, job); err != nil {
+					logger.Error("writing job progress resulted in an error", "error", err)
+				}
+			}
+		}
+
+		if complete != nil {
+			if err := complete(ctx, job); err != nil {
+				if len(job.Errors) < maxJobErrors {
+					job.Errors = append(job.Errors, err.Error())
+				}
+			}
+		}
+
+		job.Done = true
+		job.CompletedTime = ptypes.TimestampNow()
+		if err := b.writeJob(ctx, s, job); err != nil {
+			logger.Error("writing job completion resulted in an error", "error", err)
+		}
+	}()
+
+	return started, nil
+}
+
+// failInterruptedJobs marks the jobs that were still running when the backend
+// was last stopped as done. Their goroutine did not survive the restart or the
+// leader change, so the keys they had not processed are counted as failed.
+func (b *versionedKVBackend) failInterruptedJobs(ctx context.Context, s logical.Storage) error {
+	if b.perfSecondaryCheck() {
+		return nil
+	}
+
+	ids, err := s.List(ctx, path.Join(b.storagePrefix, jobsPrefix)+"/")
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		job, err := b.getJob(ctx, s, id)
+		if err != nil {
+			return err
+		}
+		if job == nil || job.Done {
+			continue
+		}
+
+		job.Failed += job.Total - job.Processed
+		if len(job.Errors) < maxJobErrors {
+			job.Errors = append(job.Errors, fmt.Sprintf("the job was interrupted after processing %d of %d keys", job.Processed, job.Total))
+		}
+		job.Processed = job.Total
+		job.Done = true
+		job.CompletedTime = ptypes.TimestampNow()
+		if err := b.writeJob(ctx, s, job); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const jobsHelpSyn = `Reports the progress of background jobs.`
+const jobsHelpDesc = `
+Long running operations, such as injecting a field under a prefix or deleting
+a key with many versions, are performed by background jobs. This endpoint lists the jobs and reads the
+progress of a job: the number of keys to process, processed, updated and
+failed, the first errors encountered and whether the job is done.
+
+The jobs are run by the active node. A job interrupted by a restart or a leader
+change is not resumed: it is marked as done when the backend starts, with the
+keys it had not processed counted as failed, and can be started again.
+`
@@ -0,0 +1,82 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// waitForJob polls the job with the provided ID until it is done and returns
+// its last state.
+func waitForJob(t *testing.T, b logical.Backend, storage logical.Storage, id string) map[string]interface{} {
+	t.Helper()
+
+	var job map[string]interface{}
+	for i := 0; i < 50; i++ {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "jobs/" + id,
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		job = resp.Data
+		if job["done"].(bool) {
+			return job
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("job did not finish: %#v", job)
+	return nil
+}
+
+func TestVersionedKV_Jobs_NotFound(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "jobs/missing",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("expected no job, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Jobs_Interrupted(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+
+	job := &Job{
+		Id:        "interrupted",
+		Operation: "inject-field",
+		Total:     10,
+		Processed: 4,
+		Updated:   4,
+	}
+	if err := kv.writeJob(context.Background(), storage, job); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kv.failInterruptedJobs(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "jobs/interrupted",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["done"] != true || resp.Data["failed"] != uint64(6) || len(resp.Data["errors"].([]string)) != 1 {
+		t.Fatalf("unexpected job %#v", resp.Data)
+	}
+}
@@ -0,0 +1,125 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// createWriteGates returns one gate per lock of locksutil.CreateLocks. A
+// request handler holds the gate of a key while it waits for and holds the
+// write lock of the key. Unlike a sync.RWMutex, waiting on a channel can be
+// abandoned, which lets handlers bound the time spent waiting for a contended
+// key.
+func createWriteGates() []chan struct{} {
+	gates := make([]chan struct{}, 256)
+	for i := range gates {
+		gates[i] = make(chan struct{}, 1)
+	}
+	return gates
+}
+
+// lockWaitTimeout returns the longest time a request waits for the lock of a
+// key, or zero if the wait is unbounded.
+func lockWaitTimeout(c *Configuration) time.Duration {
+	if c.GetLockWaitTimeout() == nil {
+		return time.Duration(0)
+	}
+	timeout, err := ptypes.Duration(c.GetLockWaitTimeout())
+	if err != nil {
+		return time.Duration(0)
+	}
+	return timeout
+}
+
+// lockKeyForWrite acquires the write lock of key and returns the function
+// releasing it. If the lock_wait_timeout of the config elapses first, a 429
+// error is returned so that clients back off instead of piling up in the
+// plugin. The timeout covers the wait for the lock itself as well, which the
+// background operations take without going through the gate.
+func (b *versionedKVBackend) lockKeyForWrite(ctx context.Context, s logical.Storage, key string) (func(), error) {
+	config, err := b.config(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	gate := b.writeGates[locksutil.LockIndexForKey(key)]
+
+	d := lockWaitTimeout(config)
+	var timeout <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case gate <- struct{}{}:
+	case <-timeout:
+		return nil, lockTimeoutError(key)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	if d == 0 {
+		lock.Lock()
+	} else if err := tryLock(ctx, lock, timeout); err != nil {
+		<-gate
+		if err == errLockTimeout {
+			return nil, lockTimeoutError(key)
+		}
+		return nil, err
+	}
+	metrics.MeasureSince([]string{"kv", "lock", "wait"}, start)
+
+	return func() {
+		lock.Unlock()
+		<-gate
+	}, nil
+}
+
+// errLockTimeout is returned by tryLock when its timeout elapses.
+var errLockTimeout = errors.New("timed out waiting for the lock")
+
+// maxLockPollInterval is the longest time tryLock sleeps between two attempts
+// at acquiring a lock.
+const maxLockPollInterval = 50 * time.Millisecond
+
+// tryLock acquires lock, retrying with an increasing interval, until timeout
+// fires or ctx is done.
+func tryLock(ctx context.Context, lock *locksutil.LockEntry, timeout <-chan time.Time) error {
+	interval := time.Millisecond
+	for !lock.TryLock() {
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-timeout:
+			timer.Stop()
+			return errLockTimeout
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		if interval *= 2; interval > maxLockPollInterval {
+			interval = maxLockPollInterval
+		}
+	}
+	return nil
+}
+
+// lockTimeoutError returns the error returned to a request that timed out
+// waiting for the lock of key.
+func lockTimeoutError(key string) error {
+	metrics.IncrCounter([]string{"kv", "lock", "timeout"}, 1)
+	return logical.CodedError(http.StatusTooManyRequests, fmt.Sprintf("timed out waiting for the lock of %q, try again later", key))
+}
@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_LockWaitTimeout(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"lock_wait_timeout": "1s",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Simulate a concurrent writer holding the lock
+	unlock, err := b.(*versionedKVBackend).lockKeyForWrite(context.Background(), storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	codedErr, ok := err.(logical.HTTPCodedError)
+	if !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got: %v", err)
+	}
+
+	unlock()
+
+	// The background operations take the lock without the gate, the wait
+	// for the lock is bounded as well
+	lock := locksutil.LockForKey(b.(*versionedKVBackend).locks, "foo")
+	lock.Lock()
+	_, err = b.HandleRequest(context.Background(), req)
+	codedErr, ok = err.(logical.HTTPCodedError)
+	if !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got: %v", err)
+	}
+	lock.Unlock()
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["lock_wait_timeout"] != "1s" {
+		t.Fatalf("unexpected lock_wait_timeout, resp: %#v", resp)
+	}
+}
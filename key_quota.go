@@ -0,0 +1,277 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// errKeyQuotaExceeded is returned when creating a key under a prefix holding
+// its max_keys keys.
+var errKeyQuotaExceeded = errors.New("key quota exceeded")
+
+// hasKeyQuotas returns true if a prefix of the configuration has a max_keys
+// or a max_keys_warning_threshold.
+func hasKeyQuotas(config *Configuration) bool {
+	for _, pc := range config.PathConfigs {
+		if pc.MaxKeys > 0 || pc.MaxKeysWarningThreshold > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// keyCounts tracks the keys under the prefixes with a key quota. The keys of
+// a prefix are listed the first time it is checked, and then kept up to date
+// as keys are created and deleted. The keys being created are reserved until
+// their creation completes so that concurrent creations cannot exceed a
+// quota together.
+type keyCounts struct {
+	l        sync.Mutex
+	prefixes map[string]*prefixKeys
+}
+
+// prefixKeys are the keys of a prefix. Its lock is held while the keys are
+// listed and while creations are checked against the quota, the other fields
+// are guarded by the lock of the keyCounts.
+type prefixKeys struct {
+	l sync.Mutex
+
+	loaded  bool
+	loading bool
+	keys    map[string]struct{}
+
+	// pending are the updates received while the keys are listed, they are
+	// applied once the listing completes.
+	pending []keyUpdate
+
+	// reserved counts the creations in progress of each key.
+	reserved map[string]int
+}
+
+// keyUpdate is the creation or the deletion of a key.
+type keyUpdate struct {
+	key     string
+	deleted bool
+}
+
+func newKeyCounts() *keyCounts {
+	return &keyCounts{
+		prefixes: make(map[string]*prefixKeys),
+	}
+}
+
+// prefix returns the tracked keys of prefix, creating them if needed.
+func (c *keyCounts) prefix(prefix string) *prefixKeys {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	p, ok := c.prefixes[prefix]
+	if !ok {
+		p = &prefixKeys{
+			keys:     make(map[string]struct{}),
+			reserved: make(map[string]int),
+		}
+		c.prefixes[prefix] = p
+	}
+	return p
+}
+
+// update records the creation, or the deletion if deleted is true, of key in
+// the tracked prefixes matching it.
+func (c *keyCounts) update(key string, deleted bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	for prefix, p := range c.prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		switch {
+		case p.loading:
+			p.pending = append(p.pending, keyUpdate{key: key, deleted: deleted})
+		case p.loaded:
+			p.apply(keyUpdate{key: key, deleted: deleted})
+		}
+	}
+}
+
+func (p *prefixKeys) apply(u keyUpdate) {
+	if u.deleted {
+		delete(p.keys, u.key)
+		return
+	}
+	p.keys[u.key] = struct{}{}
+}
+
+// count returns the number of keys of the prefix, counting the keys being
+// created, and of the created keys that are not among them. The lock of the
+// keyCounts must be held.
+func (p *prefixKeys) count(created []string) int {
+	count := len(p.keys)
+	for key := range p.reserved {
+		if _, ok := p.keys[key]; !ok {
+			count++
+		}
+	}
+	for _, key := range created {
+		_, exists := p.keys[key]
+		if _, reserved := p.reserved[key]; !exists && !reserved {
+			count++
+		}
+	}
+	return count
+}
+
+// loadKeys lists the keys of the prefix if they are not tracked yet. The
+// listing is made without holding the lock of the keyCounts, the updates
+// made meanwhile are applied once it completes. The lock of p must be held.
+func (b *versionedKVBackend) loadKeys(ctx context.Context, s logical.Storage, prefix string, p *prefixKeys) error {
+	c := b.keyCounts
+	c.l.Lock()
+	if p.loaded {
+		c.l.Unlock()
+		return nil
+	}
+	p.loading = true
+	c.l.Unlock()
+
+	// The prefix does not have to end at a folder, so the keys of the
+	// folder it is in are filtered
+	keys, err := b.collectKeys(ctx, s, prefix[:strings.LastIndex(prefix, "/")+1])
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	p.loading = false
+	if err != nil {
+		p.pending = nil
+		return err
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			p.keys[key] = struct{}{}
+		}
+	}
+	for _, u := range p.pending {
+		p.apply(u)
+	}
+	p.pending = nil
+	p.loaded = true
+
+	return nil
+}
+
+// countKeys returns the number of keys starting with prefix.
+func (b *versionedKVBackend) countKeys(ctx context.Context, s logical.Storage, prefix string) (int, error) {
+	p := b.keyCounts.prefix(prefix)
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if err := b.loadKeys(ctx, s, prefix, p); err != nil {
+		return 0, err
+	}
+
+	b.keyCounts.l.Lock()
+	defer b.keyCounts.l.Unlock()
+	return p.count(nil), nil
+}
+
+// trackKeyCount updates the keys of the prefixes matching the key once the
+// change of its metadata has been persisted to s. A nil meta means that the
+// key was deleted.
+func (b *versionedKVBackend) trackKeyCount(s logical.Storage, key string, meta *KeyMetadata) {
+	b.keyCounts.update(key, meta == nil)
+}
+
+// checkKeyQuotas checks the creation of the keys against the quotas of every
+// prefix matching them. It returns an error wrapping errKeyQuotaExceeded if
+// the keys would exceed the max_keys of a prefix, and a warning if they make a
+// prefix reach its max_keys_warning_threshold. Unless an error is returned,
+// the keys are reserved until the returned func is called, which the caller
+// must do once the keys are created or their creation failed.
+func (b *versionedKVBackend) checkKeyQuotas(ctx context.Context, s logical.Storage, config *Configuration, keys []string) (func(), string, error) {
+	created := make(map[string][]string)
+	for prefix, pc := range config.PathConfigs {
+		if pc.MaxKeys == 0 && pc.MaxKeysWarningThreshold == 0 {
+			continue
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				created[prefix] = append(created[prefix], key)
+			}
+		}
+	}
+
+	prefixes := make([]string, 0, len(created))
+	for prefix := range created {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	// The locks of the prefixes are taken in order so that the creations
+	// under overlapping prefixes cannot deadlock
+	tracked := make([]*prefixKeys, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		p := b.keyCounts.prefix(prefix)
+		p.l.Lock()
+		defer p.l.Unlock()
+
+		if err := b.loadKeys(ctx, s, prefix, p); err != nil {
+			return nil, "", err
+		}
+		tracked = append(tracked, p)
+	}
+
+	c := b.keyCounts
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	var warnings []string
+	for i, prefix := range prefixes {
+		pc := config.PathConfigs[prefix]
+		count := tracked[i].count(created[prefix])
+
+		if pc.MaxKeys > 0 && count > int(pc.MaxKeys) {
+			return nil, "", fmt.Errorf("%w: %q would hold %d keys, more than its max_keys of %d", errKeyQuotaExceeded, prefix, count, pc.MaxKeys)
+		}
+		if threshold := pc.MaxKeysWarningThreshold; threshold > 0 && count >= int(threshold) {
+			warning := fmt.Sprintf("%q holds %d keys, reaching its max_keys_warning_threshold of %d", prefix, count, threshold)
+			if pc.MaxKeys > 0 {
+				warning += fmt.Sprintf(" out of the max_keys of %d", pc.MaxKeys)
+			}
+			warnings = append(warnings, warning)
+		}
+	}
+
+	for i, prefix := range prefixes {
+		for _, key := range created[prefix] {
+			tracked[i].reserved[key]++
+		}
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			c.l.Lock()
+			defer c.l.Unlock()
+
+			for i, prefix := range prefixes {
+				p := tracked[i]
+				for _, key := range created[prefix] {
+					if p.reserved[key]--; p.reserved[key] == 0 {
+						delete(p.reserved, key)
+					}
+				}
+			}
+		})
+	}
+
+	return release, strings.Join(warnings, "; "), nil
+}
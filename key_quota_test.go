@@ -0,0 +1,239 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_KeyQuotas(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/team-a/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_keys":                   3,
+			"max_keys_warning_threshold": 2,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	write := func(path string) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		})
+	}
+
+	for i := 1; i <= 3; i++ {
+		resp, err := write(fmt.Sprintf("data/team-a/%d", i))
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if warned := len(resp.Warnings) > 0; warned != (i >= 2) {
+			t.Fatalf("key %d: unexpected warnings %v", i, resp.Warnings)
+		}
+	}
+
+	// New versions of the existing keys are not limited
+	resp, err = write("data/team-a/1")
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = write("data/team-a/4")
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the key to be rejected, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/team-a/4",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_versions": 2,
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the metadata to be rejected, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = write("data/team-b/1")
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Deleting a key frees its slot
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/team-a/1",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = write("data/team-a/4")
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_KeyQuotas_Import(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/team-a/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_keys": 1,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	key := map[string]interface{}{
+		"versions": map[string]interface{}{
+			"1": map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "import/team-a",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"keys": map[string]interface{}{
+				"foo": key,
+				"bar": key,
+			},
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Error().Error(), "max_keys") {
+		t.Fatalf("expected the import to be rejected by the quota, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "metadata/team-a/",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() || resp.Data["keys"] != nil {
+		t.Fatalf("expected no key to be imported, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_KeyQuotas_Concurrent(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/team-a/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_keys": 5,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	var wg sync.WaitGroup
+	var created int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      fmt.Sprintf("data/team-a/%d", i),
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"data": map[string]interface{}{
+						"bar": "baz",
+					},
+				},
+			})
+			if err == nil && resp != nil && !resp.IsError() {
+				atomic.AddInt32(&created, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if created != 5 {
+		t.Fatalf("expected 5 keys to be created, got %d", created)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "metadata/team-a/",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() || len(resp.Data["keys"].([]string)) != 5 {
+		t.Fatalf("expected 5 keys, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_KeyQuotas_MigrateV1(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/app/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_keys": 1,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for _, key := range []string{"app/foo", "app/bar"} {
+		if err := storage.Put(context.Background(), &logical.StorageEntry{Key: key, Value: []byte(`{"bar":"baz"}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "migrate-v1/app",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	job := waitForJob(t, b, storage, resp.Data["id"].(string))
+	if job["updated"] != uint64(1) || job["failed"] != uint64(1) || !strings.Contains(fmt.Sprint(job["errors"]), "max_keys") {
+		t.Fatalf("unexpected job: %#v", job)
+	}
+}
@@ -0,0 +1,24 @@
+package kv
+
+import (
+	"sort"
+)
+
+// paginateKeys sorts keys and returns at most limit of them, starting after
+// the key after. The returned next key is the one to pass as after to get the
+// following page, or an empty string if there is none. There is no limit if
+// limit is zero.
+func paginateKeys(keys []string, after string, limit int) ([]string, string) {
+	sort.Strings(keys)
+
+	if after != "" {
+		keys = keys[sort.Search(len(keys), func(i int) bool { return keys[i] > after }):]
+	}
+
+	if limit == 0 || len(keys) <= limit {
+		return keys, ""
+	}
+
+	keys = keys[:limit]
+	return keys, keys[limit-1]
+}
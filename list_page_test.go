@@ -0,0 +1,83 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPaginateKeys(t *testing.T) {
+	for _, tc := range []struct {
+		after    string
+		limit    int
+		expected []string
+		next     string
+	}{
+		{"", 0, []string{"a", "b", "c/", "d"}, ""},
+		{"", 2, []string{"a", "b"}, "b"},
+		{"b", 2, []string{"c/", "d"}, ""},
+		{"bb", 1, []string{"c/"}, "c/"},
+		{"d", 1, []string{}, ""},
+	} {
+		keys, next := paginateKeys([]string{"d", "b", "c/", "a"}, tc.after, tc.limit)
+		if diff := deep.Equal(keys, tc.expected); diff != nil {
+			t.Fatalf("after %q limit %d: %v", tc.after, tc.limit, diff)
+		}
+		if next != tc.next {
+			t.Fatalf("after %q limit %d: expected next %q, got %q", tc.after, tc.limit, tc.next, next)
+		}
+	}
+}
+
+func TestVersionedKV_Metadata_List_Paginated(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, key := range []string{"e", "c", "a", "d/f", "b"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/apps/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"max_versions": 5,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, path := range []string{"metadata/apps/", "detailed-metadata/apps/"} {
+		var pages [][]string
+		after := ""
+		for {
+			req := &logical.Request{
+				Operation: logical.ListOperation,
+				Path:      path,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"limit": 2,
+					"after": after,
+				},
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+
+			pages = append(pages, resp.Data["keys"].([]string))
+			next, ok := resp.Data["next_after"]
+			if !ok {
+				break
+			}
+			after = next.(string)
+		}
+
+		expected := [][]string{{"a", "b"}, {"c", "d/"}, {"e"}}
+		if diff := deep.Equal(pages, expected); diff != nil {
+			t.Fatalf("%s: %v", path, diff)
+		}
+	}
+}
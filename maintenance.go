@@ -0,0 +1,144 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maintenanceBatchSize is the number of keys processed by each run of the
+// periodic maintenance.
+const maintenanceBatchSize = 1000
+
+// maintenanceStatePath is the path of the progress of the periodic
+// maintenance through the keys of the mount.
+const maintenanceStatePath = "maintenance/state"
+
+// maintenanceBatch returns the keys the periodic maintenance processes on this
+// run: the keys sorting after the last key processed on the previous run, up
+// to maintenanceBatchSize. Once the last key of the mount is reached the next
+// run starts over from the first one. Only the folders leading to the batch
+// are listed, and the progress is persisted so that it survives a restart or
+// a leader change.
+func (b *versionedKVBackend) maintenanceBatch(ctx context.Context, s logical.Storage) ([]string, error) {
+	b.maintenanceLock.Lock()
+	defer b.maintenanceLock.Unlock()
+
+	state := &MaintenanceState{}
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, maintenanceStatePath))
+	if err != nil {
+		return nil, err
+	}
+	if raw != nil {
+		if err := proto.Unmarshal(raw.Value, state); err != nil {
+			return nil, fmt.Errorf("failed to decode maintenance state from storage: %v", err)
+		}
+	}
+
+	batch, err := b.keysAfter(ctx, s, state.Cursor, maintenanceBatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	state.Cursor = ""
+	if len(batch) == maintenanceBatchSize {
+		state.Cursor = batch[len(batch)-1]
+	}
+	buf, err := proto.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, maintenanceStatePath),
+		Value: buf,
+	}); err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+// keysAfter returns, in order, up to limit keys of the mount sorting after
+// cursor. The folders are walked depth first in the order of their entries,
+// which is the order of the keys, and the folders whose keys all sort before
+// cursor are not listed.
+func (b *versionedKVBackend) keysAfter(ctx context.Context, s logical.Storage, cursor string, limit int) ([]string, error) {
+	wrapper, err := b.getKeyEncryptor(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	es := wrapper.Wrap(s)
+
+	var keys []string
+	var walk func(folder string) error
+	walk = func(folder string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entries, err := es.List(ctx, folder)
+		if err != nil {
+			return err
+		}
+		sort.Strings(entries)
+
+		for _, entry := range entries {
+			if len(keys) >= limit {
+				return nil
+			}
+
+			full := folder + entry
+			if !strings.HasSuffix(entry, "/") {
+				if full > cursor {
+					keys = append(keys, full)
+				}
+				continue
+			}
+
+			// The keys of the folder all sort before the cursor unless it
+			// is in the folder
+			if full < cursor && !strings.HasPrefix(cursor, full) {
+				continue
+			}
+			if err := walk(full); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// resumeInterruptedDeletion completes the deletion of key if a previous
+// deletion of the key was interrupted. The keys being deleted by a background
+// job are left to the job.
+func (b *versionedKVBackend) resumeInterruptedDeletion(ctx context.Context, s logical.Storage, key string) error {
+	if b.backgroundDeletion(key) != "" {
+		return nil
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil || !meta.Deleting {
+		return nil
+	}
+
+	return b.deleteKey(ctx, s, meta)
+}
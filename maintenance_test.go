@@ -0,0 +1,125 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMaintenanceBatch(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	ctx := context.Background()
+
+	// The keys are spread across folders so that the batches span them
+	keys := make([]string, 0, 2500)
+	for i := 0; i < 2500; i++ {
+		keys = append(keys, fmt.Sprintf("%d/key-%04d", i%3, i))
+	}
+	for _, key := range keys {
+		if err := kv.writeKeyMetadata(ctx, storage, &KeyMetadata{Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, expected := range []struct {
+		first, last int
+	}{
+		{0, 999},
+		{1000, 1999},
+		{2000, 2499},
+		{0, 999},
+	} {
+		batch, err := kv.maintenanceBatch(ctx, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(batch, keys[expected.first:expected.last+1]) {
+			t.Fatalf("unexpected batch of %d keys from %q to %q", len(batch), batch[0], batch[len(batch)-1])
+		}
+	}
+
+	// The next run resumes after the last key processed even if it was
+	// removed
+	wrapper, err := kv.getKeyEncryptor(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrapper.Wrap(storage).Delete(ctx, keys[999]); err != nil {
+		t.Fatal(err)
+	}
+	batch, err := kv.maintenanceBatch(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1000 || batch[0] != keys[1000] {
+		t.Fatalf("unexpected first key %q", batch[0])
+	}
+
+	// The progress is persisted
+	raw, err := storage.Get(ctx, path.Join(kv.storagePrefix, maintenanceStatePath))
+	if err != nil || raw == nil {
+		t.Fatalf("expected the maintenance state to be persisted, err:%s", err)
+	}
+	state := &MaintenanceState{}
+	if err := proto.Unmarshal(raw.Value, state); err != nil || state.Cursor != keys[1999] {
+		t.Fatalf("unexpected state: %#v, err:%s", state, err)
+	}
+}
+
+func TestMaintenance_InterruptedDeletion(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	ctx := context.Background()
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(ctx, req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	meta, err := kv.getKeyMetadata(ctx, storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Deleting = true
+	if err := kv.writeKeyMetadata(ctx, storage, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	req = &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err = kv.getKeyMetadata(ctx, storage, "foo")
+	if err != nil || meta != nil {
+		t.Fatalf("expected the deletion to be completed, err:%s meta:%#v", err, meta)
+	}
+	versionKey, err := kv.getVersionKey(ctx, "foo", 1, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := storage.Get(ctx, versionKey); err != nil || raw != nil {
+		t.Fatalf("expected the version to be deleted, err:%s", err)
+	}
+}
@@ -0,0 +1,23 @@
+package kv
+
+// clampMaxVersions lowers the max_versions of the key to the
+// max_versions_limit of the mount. It returns true if the key was modified.
+func clampMaxVersions(config *Configuration, meta *KeyMetadata) bool {
+	if limit := config.MaxVersionsLimit; limit > 0 && meta.MaxVersions > limit {
+		meta.MaxVersions = limit
+		return true
+	}
+	return false
+}
+
+// configMaxVersions returns the max_versions of the mount to pass to
+// AddVersion so that no more than max_versions_limit versions are kept when
+// neither the mount nor the key set max_versions. The key must have been
+// clamped with clampMaxVersions.
+func configMaxVersions(config *Configuration, meta *KeyMetadata) uint32 {
+	limit := config.MaxVersionsLimit
+	if limit > 0 && max(config.MaxVersions, meta.MaxVersions) == 0 && limit < defaultMaxVersions {
+		return limit
+	}
+	return config.MaxVersions
+}
@@ -0,0 +1,84 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_MaxVersionsLimit(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_versions":       5,
+			"max_versions_limit": 3,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data = map[string]interface{}{
+		"max_versions_limit": 3,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// max_versions above the limit is clamped with a warning
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_versions": 100,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() || len(resp.Warnings) != 1 {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for _, key := range []string{"foo", "bar"} {
+		for i := 0; i < 5; i++ {
+			req := &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "data/" + key,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"data": map[string]interface{}{
+						"bar": "baz",
+					},
+				},
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+		}
+
+		// The limit also applies to the default max_versions
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if versions := resp.Data["versions"].(map[string]interface{}); len(versions) != 3 {
+			t.Fatalf("%s: expected 3 versions, got %#v", key, versions)
+		}
+		if key == "foo" && resp.Data["max_versions"] != uint32(3) {
+			t.Fatalf("unexpected max_versions %#v", resp.Data["max_versions"])
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package kv
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// defaultMetadataCacheSize is the number of key metadata objects kept in
+	// memory unless set by the metadata_cache_size mount option.
+	defaultMetadataCacheSize = 1024
+
+	// maxMetadataCacheSize is the largest metadata_cache_size accepted.
+	maxMetadataCacheSize = 1 << 20
+)
+
+// parseMetadataCacheSize returns the size of the metadata cache requested by
+// the metadata_cache_size mount option, or the default size if it is not set.
+// A size of zero disables the cache.
+func parseMetadataCacheSize(conf map[string]string) (int, error) {
+	raw, ok := conf["metadata_cache_size"]
+	if !ok || raw == "" {
+		return defaultMetadataCacheSize, nil
+	}
+
+	size, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid metadata_cache_size %q: %w", raw, err)
+	}
+	if size > maxMetadataCacheSize {
+		return 0, fmt.Errorf("metadata_cache_size cannot be greater than %d", maxMetadataCacheSize)
+	}
+
+	return int(size), nil
+}
+
+// metadataCache is an LRU cache of the decrypted key metadata. Entries are
+// cloned on the way in and out so that callers can modify the metadata they
+// get.
+//
+// Readers record the generation of the cache before loading the metadata
+// from storage and only add it if no invalidation happened in the meantime,
+// this way a reader racing with a writer cannot cache a stale value.
+type metadataCache struct {
+	l     sync.Mutex
+	cache *lru.Cache
+	gen   uint64
+}
+
+// newMetadataCache returns a cache holding up to size entries. The cache is
+// disabled if size is zero.
+func newMetadataCache(size int) (*metadataCache, error) {
+	c := &metadataCache{}
+	if size == 0 {
+		return c, nil
+	}
+
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	c.cache = cache
+
+	return c, nil
+}
+
+// get returns a copy of the cached metadata of key, or nil if it is not
+// cached.
+func (c *metadataCache) get(key string) *KeyMetadata {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.cache == nil {
+		return nil
+	}
+	raw, ok := c.cache.Get(key)
+	if !ok {
+		return nil
+	}
+
+	return proto.Clone(raw.(*KeyMetadata)).(*KeyMetadata)
+}
+
+// generation returns the current generation of the cache, it must be read
+// before loading the metadata passed to add.
+func (c *metadataCache) generation() uint64 {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.gen
+}
+
+// add caches a copy of meta unless the cache was invalidated since gen was
+// read.
+func (c *metadataCache) add(meta *KeyMetadata, gen uint64) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.cache == nil || c.gen != gen {
+		return
+	}
+	c.cache.Add(meta.Key, proto.Clone(meta).(*KeyMetadata))
+}
+
+// remove invalidates the cached metadata of key. It must be called once the
+// new metadata has been persisted.
+func (c *metadataCache) remove(key string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.gen++
+	if c.cache != nil {
+		c.cache.Remove(key)
+	}
+}
+
+// purge invalidates all the cached metadata.
+func (c *metadataCache) purge() {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.gen++
+	if c.cache != nil {
+		c.cache.Purge()
+	}
+}
+
+// resize changes the number of entries the cache holds, evicting the least
+// recently used ones if needed. A size of zero disables the cache.
+func (c *metadataCache) resize(size int) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.gen++
+	switch {
+	case size == 0:
+		c.cache = nil
+	case c.cache == nil:
+		cache, err := lru.New(size)
+		if err != nil {
+			return err
+		}
+		c.cache = cache
+	default:
+		c.cache.Resize(size)
+	}
+
+	return nil
+}
+
+// len returns the number of cached entries.
+func (c *metadataCache) len() int {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.cache == nil {
+		return 0
+	}
+	return c.cache.Len()
+}
@@ -0,0 +1,189 @@
+package kv
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestParseMetadataCacheSize(t *testing.T) {
+	tests := map[string]struct {
+		raw     string
+		want    int
+		wantErr bool
+	}{
+		"unset":    {"", defaultMetadataCacheSize, false},
+		"disabled": {"0", 0, false},
+		"size":     {"10", 10, false},
+		"invalid":  {"foo", 0, true},
+		"negative": {"-1", 0, true},
+		"too big":  {"1048577", 0, true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			conf := map[string]string{}
+			if tc.raw != "" {
+				conf["metadata_cache_size"] = tc.raw
+			}
+
+			got, err := parseMetadataCacheSize(conf)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMetadataCache(t *testing.T) {
+	c, err := newMetadataCache(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.add(&KeyMetadata{Key: "foo", CurrentVersion: 1}, c.generation())
+	meta := c.get("foo")
+	if meta == nil || meta.CurrentVersion != 1 {
+		t.Fatalf("unexpected metadata: %#v", meta)
+	}
+
+	// The cached metadata cannot be modified by the callers
+	meta.CurrentVersion = 2
+	if meta := c.get("foo"); meta.CurrentVersion != 1 {
+		t.Fatalf("unexpected metadata: %#v", meta)
+	}
+
+	// Metadata loaded before an invalidation is not cached
+	gen := c.generation()
+	c.remove("foo")
+	c.add(&KeyMetadata{Key: "foo", CurrentVersion: 1}, gen)
+	if meta := c.get("foo"); meta != nil {
+		t.Fatalf("unexpected metadata: %#v", meta)
+	}
+
+	c.add(&KeyMetadata{Key: "foo"}, c.generation())
+	c.add(&KeyMetadata{Key: "bar"}, c.generation())
+	c.add(&KeyMetadata{Key: "baz"}, c.generation())
+	if meta := c.get("foo"); meta != nil {
+		t.Fatalf("expected foo to be evicted: %#v", meta)
+	}
+
+	c.purge()
+	if meta := c.get("bar"); meta != nil {
+		t.Fatalf("unexpected metadata: %#v", meta)
+	}
+
+	disabled, err := newMetadataCache(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled.add(&KeyMetadata{Key: "foo"}, disabled.generation())
+	if meta := disabled.get("foo"); meta != nil {
+		t.Fatalf("unexpected metadata: %#v", meta)
+	}
+}
+
+// metadataReadsStorage counts the reads of the key metadata.
+type metadataReadsStorage struct {
+	logical.Storage
+
+	prefix string
+	reads  int
+}
+
+func (s *metadataReadsStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	if strings.HasPrefix(key, s.prefix) {
+		s.reads++
+	}
+	return s.Storage.Get(ctx, key)
+}
+
+func TestVersionedKV_MetadataCache(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	s := &metadataReadsStorage{
+		Storage: storage,
+		prefix:  path.Join(kv.storagePrefix, metadataPrefix) + "/",
+	}
+
+	write := func(value string) {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   s,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": value,
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	read := func() *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   s,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	write("baz")
+	read()
+	reads := s.reads
+	read()
+	if s.reads != reads {
+		t.Fatalf("expected the metadata to be cached, got %d reads", s.reads-reads)
+	}
+
+	// Writes invalidate the cached metadata
+	write("qux")
+	resp := read()
+	if v := resp.Data["data"].(map[string]interface{})["bar"]; v != "qux" {
+		t.Fatalf("unexpected value: %#v", v)
+	}
+	if resp.Data["metadata"].(map[string]interface{})["version"] != uint64(2) {
+		t.Fatalf("unexpected metadata: %#v", resp.Data["metadata"])
+	}
+
+	// Storage invalidations purge the cache
+	reads = s.reads
+	kv.Invalidate(context.Background(), path.Join(kv.storagePrefix, metadataPrefix, "abc"))
+	read()
+	if s.reads == reads {
+		t.Fatal("expected the metadata to be read from storage")
+	}
+
+	// Deleted keys are not served from the cache
+	req := &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/foo",
+		Storage:   s,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   s,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
@@ -0,0 +1,44 @@
+package kv
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// matchesCustomMetadata returns true if the custom_metadata of the key
+// contains every pair of filter.
+func matchesCustomMetadata(meta *KeyMetadata, filter map[string]string) bool {
+	for k, v := range filter {
+		if value, ok := meta.CustomMetadata[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// filterKeys returns the entries of a listing of prefix whose custom_metadata
+// matches filter and that have the owner and the team, if set. Folders are
+// kept so that clients can descend into them.
+func (b *versionedKVBackend) filterKeys(ctx context.Context, s logical.Storage, prefix string, entries []string, filter map[string]string, owner, team string) ([]string, error) {
+	filtered := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasSuffix(entry, "/") {
+			filtered = append(filtered, entry)
+			continue
+		}
+
+		meta, err := b.getKeyMetadata(ctx, s, prefix+entry)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil || meta.Deleting || !matchesCustomMetadata(meta, filter) || !matchesOwnership(meta, owner, team) {
+			continue
+		}
+
+		filtered = append(filtered, entry)
+	}
+
+	return filtered, nil
+}
@@ -0,0 +1,59 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Metadata_List_Filter(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for key, customMetadata := range map[string]map[string]interface{}{
+		"apps/foo":           {"owner": "payments", "tier": "1"},
+		"apps/bar":           {"owner": "payments", "tier": "2"},
+		"apps/baz":           {"owner": "search"},
+		"apps/qux":           {"tier": "1"},
+		"apps/nested/secret": {"owner": "search"},
+	} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"custom_metadata": customMetadata,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, tc := range []struct {
+		filter   []interface{}
+		expected []string
+	}{
+		{[]interface{}{"owner=payments"}, []string{"bar", "foo", "nested/"}},
+		{[]interface{}{"owner=payments", "tier=1"}, []string{"foo", "nested/"}},
+		{[]interface{}{"owner=nobody"}, []string{"nested/"}},
+	} {
+		req := &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "metadata/apps/",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"filter": tc.filter,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if diff := deep.Equal(resp.Data["keys"], tc.expected); diff != nil {
+			t.Fatalf("%v: %v", tc.filter, diff)
+		}
+	}
+}
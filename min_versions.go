@@ -0,0 +1,195 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// minVersions returns the number of versions of the key that must remain
+// retrievable, the largest of the values set on the mount and on the key.
+func minVersions(config *Configuration, meta *KeyMetadata) uint32 {
+	return max(config.MinVersions, meta.MinVersions)
+}
+
+// keptVersions returns the number of versions kept for the key, as computed
+// by AddVersion.
+func keptVersions(config *Configuration, meta *KeyMetadata) uint32 {
+	kept := max(config.MaxVersions, meta.MaxVersions)
+	if kept == 0 {
+		kept = defaultMaxVersions
+	}
+	if limit := config.MaxVersionsLimit; limit > 0 && kept > limit {
+		return limit
+	}
+	return kept
+}
+
+// isRetrievable returns true if the data of the version can be read, that is
+// if it is neither deleted nor destroyed.
+func isRetrievable(vm *VersionMetadata) (bool, error) {
+	if vm.Destroyed {
+		return false, nil
+	}
+
+	deleted, err := isDeleted(vm)
+	if err != nil {
+		return false, err
+	}
+
+	return !deleted, nil
+}
+
+// retrievableVersions returns the number of versions of the key that can be
+// read.
+func retrievableVersions(meta *KeyMetadata) (uint32, error) {
+	var count uint32
+	for _, vm := range meta.Versions {
+		ok, err := isRetrievable(vm)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// checkMinVersions returns an error if making the provided versions
+// unretrievable would leave fewer retrievable versions than the min_versions
+// of the key.
+func checkMinVersions(config *Configuration, meta *KeyMetadata, versions []uint64) error {
+	required := minVersions(config, meta)
+	if required == 0 {
+		return nil
+	}
+
+	count, err := retrievableVersions(meta)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[uint64]bool, len(versions))
+	remaining := count
+	for _, v := range versions {
+		vm, ok := meta.Versions[v]
+		if !ok || seen[v] {
+			continue
+		}
+		seen[v] = true
+
+		retrievable, err := isRetrievable(vm)
+		if err != nil {
+			return err
+		}
+		if retrievable {
+			remaining--
+		}
+	}
+
+	if remaining < count && remaining < required {
+		return fmt.Errorf("the operation would leave %d retrievable versions but min_versions is %d", remaining, required)
+	}
+
+	return nil
+}
+
+// versionNumbers converts the versions provided to the delete and destroy
+// endpoints.
+func versionNumbers(versions []int) []uint64 {
+	numbers := make([]uint64, 0, len(versions))
+	for _, v := range versions {
+		if v > 0 {
+			numbers = append(numbers, uint64(v))
+		}
+	}
+	return numbers
+}
+
+// validateMinVersions returns an error if the max_versions of the key is
+// lower than its min_versions, in which case the versions kept could not
+// satisfy the minimum.
+func validateMinVersions(config *Configuration, meta *KeyMetadata) error {
+	if required, kept := minVersions(config, meta), keptVersions(config, meta); required > kept {
+		return fmt.Errorf("min_versions %d is greater than max_versions %d", required, kept)
+	}
+	return nil
+}
+
+// ApplyMinVersions defers the scheduled deletion of the n most recent
+// retrievable versions so that delete_version_after cannot make them
+// unretrievable, and restores the deletion of the versions that moved out of
+// that window. The deletion_time of the versions is left untouched, a restored
+// deletion_time that has already passed takes effect immediately. It returns
+// true if the metadata was modified.
+func (k *KeyMetadata) ApplyMinVersions(n uint32) (bool, error) {
+	var kept uint32
+	var modified bool
+	for v := k.CurrentVersion; v > 0 && v >= k.OldestVersion; v-- {
+		vm, ok := k.Versions[v]
+		if !ok {
+			continue
+		}
+
+		// The deletion_time of the versions deferred before it was kept
+		// visible is moved back
+		if vm.DeferredDeletionTime != nil {
+			vm.DeletionTime = vm.DeferredDeletionTime
+			vm.DeferredDeletionTime = nil
+			vm.DeletionDeferred = true
+			modified = true
+		}
+
+		retrievable, err := isRetrievable(vm)
+		if err != nil {
+			return false, err
+		}
+		if retrievable && kept < n {
+			kept++
+			if vm.DeletionTime != nil && !vm.DeletionDeferred {
+				vm.DeletionDeferred = true
+				modified = true
+			}
+			continue
+		}
+
+		if vm.DeletionDeferred {
+			vm.DeletionDeferred = false
+			modified = true
+		}
+	}
+
+	return modified, nil
+}
+
+// applyMinVersions updates the deferred deletions of the versions of key
+// after the min_versions of the mount or of the key changed.
+func (b *versionedKVBackend) applyMinVersions(ctx context.Context, s logical.Storage, key string) error {
+	config, err := b.keyConfig(ctx, s, key)
+	if err != nil {
+		return err
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+
+	modified, err := meta.ApplyMinVersions(minVersions(config, meta))
+	if err != nil || !modified {
+		return err
+	}
+
+	return b.writeKeyMetadata(ctx, s, meta)
+}
@@ -0,0 +1,186 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestKeyMetadata_ApplyMinVersions(t *testing.T) {
+	past, err := ptypes.TimestampProto(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	future, err := ptypes.TimestampProto(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := &KeyMetadata{
+		Versions: map[uint64]*VersionMetadata{
+			1: {DeletionTime: future},
+			2: {DeletionTime: future},
+			3: {DeletionTime: past},
+			4: {DeletionTime: future},
+		},
+		CurrentVersion: 4,
+		OldestVersion:  1,
+	}
+
+	// The deleted version 3 is skipped
+	modified, err := meta.ApplyMinVersions(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified {
+		t.Fatal("expected the metadata to be modified")
+	}
+	for v, deferred := range map[uint64]bool{1: false, 2: true, 3: false, 4: true} {
+		vm := meta.Versions[v]
+		if vm.DeletionDeferred != deferred || vm.DeletionTime == nil {
+			t.Fatalf("unexpected metadata for version %d: %#v", v, vm)
+		}
+	}
+
+	modified, err = meta.ApplyMinVersions(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modified {
+		t.Fatal("expected the metadata not to be modified")
+	}
+
+	// Lowering min_versions restores the deletion
+	if _, err := meta.ApplyMinVersions(1); err != nil {
+		t.Fatal(err)
+	}
+	if vm := meta.Versions[2]; vm.DeletionDeferred || vm.DeletionTime == nil {
+		t.Fatalf("unexpected metadata for version 2: %#v", vm)
+	}
+
+	// The deletion_time hidden by the previous releases is moved back
+	meta.Versions[4] = &VersionMetadata{DeferredDeletionTime: past}
+	if _, err := meta.ApplyMinVersions(1); err != nil {
+		t.Fatal(err)
+	}
+	if vm := meta.Versions[4]; !vm.DeletionDeferred || vm.DeletionTime != past || vm.DeferredDeletionTime != nil {
+		t.Fatalf("unexpected metadata for version 4: %#v", vm)
+	}
+}
+
+func TestVersionedKV_MinVersions(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_versions": 2,
+			"min_versions": 3,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data = map[string]interface{}{
+		"min_versions":         2,
+		"delete_version_after": "2s",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for i := 0; i < 3; i++ {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	read := func(version int) *logical.Response {
+		t.Helper()
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"version": version,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+
+	// The two most recent versions did not expire
+	if resp := read(1); resp.Data["data"] != nil {
+		t.Fatalf("expected version 1 to be deleted, got %#v", resp.Data)
+	}
+	for _, v := range []int{2, 3} {
+		if resp := read(v); resp.Data["data"] == nil {
+			t.Fatalf("expected version %d to be retrievable, got %#v", v, resp.Data)
+		}
+	}
+
+	// and cannot be deleted or destroyed
+	for _, req := range []*logical.Request{
+		{Operation: logical.DeleteOperation, Path: "data/foo"},
+		{Operation: logical.UpdateOperation, Path: "delete/foo", Data: map[string]interface{}{"versions": []int{2}}},
+		{Operation: logical.UpdateOperation, Path: "destroy/foo", Data: map[string]interface{}{"versions": []int{3}}},
+	} {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected an error, got err:%s resp:%#v\n", req.Path, err, resp)
+		}
+	}
+
+	// Once min_versions is lowered the deletion of version 2 takes effect
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"min_versions": 1,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if resp := read(2); resp.Data["data"] != nil {
+		t.Fatalf("expected version 2 to be deleted, got %#v", resp.Data)
+	}
+	if resp := read(3); resp.Data["data"] == nil {
+		t.Fatalf("expected version 3 to be retrievable, got %#v", resp.Data)
+	}
+}
@@ -0,0 +1,108 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// noopWritesAllow writes the versions identical to the current one like
+	// any other version.
+	noopWritesAllow = "allow"
+
+	// noopWritesWarn writes the versions identical to the current one with a
+	// warning.
+	noopWritesWarn = "warn"
+
+	// noopWritesSkip does not write the versions identical to the current
+	// one.
+	noopWritesSkip = "skip"
+)
+
+// noopWrites returns the handling of the no-op writes configured on the
+// mount.
+func noopWrites(config *Configuration) string {
+	if config.NoopWrites == "" {
+		return noopWritesAllow
+	}
+	return config.NoopWrites
+}
+
+// validateNoopWrites returns an error if the noop_writes of the mount is not
+// supported.
+func validateNoopWrites(config *Configuration) error {
+	switch noopWrites(config) {
+	case noopWritesAllow, noopWritesWarn, noopWritesSkip:
+		return nil
+	default:
+		return fmt.Errorf("noop_writes must be one of %q, %q or %q", noopWritesAllow, noopWritesWarn, noopWritesSkip)
+	}
+}
+
+// isNoopWrite reports whether writing data, or a reference to opts.reference,
+// as a new version of the key described by meta would leave its current
+// version unchanged. Only the data is compared, not the options of the
+// versions. The caller must hold the lock for the key.
+func (b *versionedKVBackend) isNoopWrite(ctx context.Context, s logical.Storage, meta *KeyMetadata, data []byte, opts versionOptions) (bool, error) {
+	vm := meta.Versions[meta.CurrentVersion]
+	if vm == nil || vm.Destroyed {
+		return false, nil
+	}
+	if deleted, err := isDeleted(vm); err != nil || deleted {
+		return false, err
+	}
+
+	if vm.Reference != "" || opts.reference != "" {
+		return vm.Reference == opts.reference, nil
+	}
+
+	current, err := b.readVersionData(ctx, s, meta.Key, meta.CurrentVersion)
+	if err != nil {
+		return false, err
+	}
+	written := map[string]interface{}{}
+	if err := json.Unmarshal(data, &written); err != nil {
+		return false, err
+	}
+
+	return reflect.DeepEqual(current, written), nil
+}
+
+// checkNoopWrite handles the writes of data identical to the current version
+// of the key described by meta according to the noop_writes of the mount. It
+// returns the response to return instead of writing the version if the write
+// is skipped, or else the warning to add to the response of the write. The
+// caller must hold the lock for the key.
+func (b *versionedKVBackend) checkNoopWrite(ctx context.Context, s logical.Storage, config *Configuration, meta *KeyMetadata, data []byte, opts versionOptions) (*logical.Response, string, error) {
+	mode := noopWrites(config)
+	if mode == noopWritesAllow || meta.CurrentVersion == 0 {
+		return nil, "", nil
+	}
+
+	noop, err := b.isNoopWrite(ctx, s, meta, data, opts)
+	if err != nil || !noop {
+		return nil, "", err
+	}
+
+	if mode == noopWritesWarn {
+		return nil, fmt.Sprintf("The data is identical to the version %d it replaces", meta.CurrentVersion), nil
+	}
+
+	vm := meta.Versions[meta.CurrentVersion]
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"version":         meta.CurrentVersion,
+			"created_time":    ptypesTimestampToString(vm.CreatedTime),
+			"deletion_time":   ptypesTimestampToString(vm.DeletionTime),
+			"destroyed":       vm.Destroyed,
+			"custom_metadata": meta.CustomMetadata,
+		},
+	}
+	resp.AddWarning(fmt.Sprintf("The data is identical to the current version %d, no new version was written", meta.CurrentVersion))
+
+	return resp, "", nil
+}
@@ -0,0 +1,102 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_NoopWrites(t *testing.T) {
+	b, storage := getBackend(t)
+
+	configure := func(mode string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"noop_writes": mode,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	write := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": data,
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	if resp := configure("ignore"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+
+	// Identical writes create new versions by default
+	write(map[string]interface{}{"bar": "baz"})
+	if resp := write(map[string]interface{}{"bar": "baz"}); resp.Data["version"] != uint64(2) || len(resp.Warnings) != 0 {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+
+	if resp := configure(noopWritesWarn); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+	if resp := write(map[string]interface{}{"bar": "baz"}); resp.Data["version"] != uint64(3) || len(resp.Warnings) != 1 {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+
+	if resp := configure(noopWritesSkip); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+	if resp := write(map[string]interface{}{"bar": "baz"}); resp.Data["version"] != uint64(3) || len(resp.Warnings) != 1 {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+	if resp := write(map[string]interface{}{"bar": "qux"}); resp.Data["version"] != uint64(4) || len(resp.Warnings) != 0 {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+
+	// Patches leaving the data unchanged are skipped as well
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.PatchOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "qux",
+			},
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["version"] != uint64(4) || len(resp.Warnings) != 1 {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+
+	// and a deleted current version is not a no-op
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp := write(map[string]interface{}{"bar": "qux"}); resp.Data["version"] != uint64(5) {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+}
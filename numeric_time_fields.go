@@ -0,0 +1,76 @@
+package kv
+
+import (
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+)
+
+// durationFields are the response fields holding durations formatted as Go
+// duration strings.
+var durationFields = map[string]struct{}{
+	"delete_version_after":        {},
+	"min_delete_version_after":    {},
+	"destroy_version_after":       {},
+	"max_version_age":             {},
+	"destroy_confirmation_window": {},
+	"trash_retention":             {},
+	"lock_wait_timeout":           {},
+	"rotation_period":             {},
+	"response_wrapping_ttl":       {},
+}
+
+// timestampFields are the response fields holding RFC3339 timestamps.
+var timestampFields = map[string]struct{}{
+	"created_time":   {},
+	"updated_time":   {},
+	"deletion_time":  {},
+	"expire_at":      {},
+	"requested_time": {},
+	"last_read_time": {},
+}
+
+// numericTimeFieldsSchema is the field of the read endpoints overriding the
+// numeric_time_fields of the configuration.
+var numericTimeFieldsSchema = &framework.FieldSchema{
+	Type:        framework.TypeBool,
+	Description: "If set during a read, overrides the numeric_time_fields of the backend's configuration.",
+}
+
+// numericTimeFields returns true if the response to the request must include
+// the numeric representations of its durations and timestamps.
+func numericTimeFields(config *Configuration, data *framework.FieldData) bool {
+	if raw, ok := data.GetOk("numeric_time_fields"); ok {
+		return raw.(bool)
+	}
+	return config.NumericTimeFields
+}
+
+// addNumericTimeFields adds the durations of fields in seconds as
+// <field>_seconds and the timestamps as Unix epochs as <field>_unix. Unset
+// timestamps and values that cannot be parsed are left as they are. Nested
+// maps are not walked, so that the data of the secrets is never altered.
+func addNumericTimeFields(fields map[string]interface{}) {
+	numeric := make(map[string]interface{})
+	for name, value := range fields {
+		s, ok := value.(string)
+		if !ok || s == "" {
+			continue
+		}
+
+		if _, ok := durationFields[name]; ok {
+			if d, err := time.ParseDuration(s); err == nil {
+				numeric[name+"_seconds"] = int64(d / time.Second)
+			}
+		}
+		if _, ok := timestampFields[name]; ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				numeric[name+"_unix"] = t.Unix()
+			}
+		}
+	}
+
+	for name, value := range numeric {
+		fields[name] = value
+	}
+}
@@ -0,0 +1,125 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestAddNumericTimeFields(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC)
+	fields := map[string]interface{}{
+		"created_time":         created.Format(time.RFC3339Nano),
+		"deletion_time":        "",
+		"delete_version_after": "2h0m0s",
+		"max_version_age":      "0s",
+		"version":              uint64(1),
+		"description":          "1h",
+	}
+
+	addNumericTimeFields(fields)
+
+	if got := fields["created_time_unix"]; got != created.Unix() {
+		t.Fatalf("expected created_time_unix %d, got %v", created.Unix(), got)
+	}
+	if _, ok := fields["deletion_time_unix"]; ok {
+		t.Fatalf("expected no deletion_time_unix for an unset timestamp: %#v", fields)
+	}
+	if got := fields["delete_version_after_seconds"]; got != int64(7200) {
+		t.Fatalf("expected delete_version_after_seconds 7200, got %v", got)
+	}
+	if got := fields["max_version_age_seconds"]; got != int64(0) {
+		t.Fatalf("expected max_version_age_seconds 0, got %v", got)
+	}
+	if _, ok := fields["description_seconds"]; ok {
+		t.Fatalf("expected only the known fields to be converted: %#v", fields)
+	}
+}
+
+func TestVersionedKV_NumericTimeFields(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"created_time": "not a timestamp",
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	read := func(path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	// The numeric fields are only returned when requested
+	resp = read("data/foo", nil)
+	if _, ok := resp.Data["metadata"].(map[string]interface{})["created_time_unix"]; ok {
+		t.Fatalf("expected no created_time_unix by default, resp: %#v", resp)
+	}
+
+	resp = read("data/foo", map[string]interface{}{"numeric_time_fields": true})
+	if _, ok := resp.Data["metadata"].(map[string]interface{})["created_time_unix"].(int64); !ok {
+		t.Fatalf("expected created_time_unix, resp: %#v", resp)
+	}
+	if len(resp.Data["data"].(map[string]interface{})) != 1 {
+		t.Fatalf("expected the data of the secret to be left as is, resp: %#v", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"numeric_time_fields":  true,
+			"delete_version_after": "2h",
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp = read("config", nil)
+	if resp.Data["delete_version_after_seconds"] != int64(7200) {
+		t.Fatalf("expected delete_version_after_seconds 7200, resp: %#v", resp)
+	}
+
+	resp = read("metadata/foo", nil)
+	if _, ok := resp.Data["updated_time_unix"].(int64); !ok {
+		t.Fatalf("expected updated_time_unix, resp: %#v", resp)
+	}
+	if _, ok := resp.Data["delete_version_after_seconds"].(int64); !ok {
+		t.Fatalf("expected delete_version_after_seconds, resp: %#v", resp)
+	}
+	version := resp.Data["versions"].(map[string]interface{})["1"].(map[string]interface{})
+	if _, ok := version["created_time_unix"].(int64); !ok {
+		t.Fatalf("expected the versions to include created_time_unix, resp: %#v", resp)
+	}
+
+	// The request can opt out of the configured numeric fields
+	resp = read("data/foo", map[string]interface{}{"numeric_time_fields": false})
+	if _, ok := resp.Data["metadata"].(map[string]interface{})["created_time_unix"]; ok {
+		t.Fatalf("expected no created_time_unix when overridden, resp: %#v", resp)
+	}
+}
@@ -0,0 +1,92 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// overlaysFolder is the folder under a secret holding its overlays.
+const overlaysFolder = "overlays"
+
+// overlaySchema is the schema of the read parameter selecting the overlay
+// merged into the data of the secret.
+var overlaySchema = &framework.FieldSchema{
+	Type: framework.TypeString,
+	Description: `
+If provided during a read, the data of the current version of the secret at
+<path>/overlays/<overlay> is merged into the data returned, its fields
+overriding the ones of the secret. The overlay must match the overlay_paths of
+the backend configuration, and is returned to any token allowed to read the
+secret.`,
+}
+
+// overlayKey returns the path of the overlay requested by a data read of key,
+// or an empty string if no overlay is requested. As the overlay is read with
+// the ACL policy of the secret, it must match the overlay_paths of the mount.
+func overlayKey(config *Configuration, key string, data *framework.FieldData) (string, error) {
+	overlay := data.Get("overlay").(string)
+	if overlay == "" {
+		return "", nil
+	}
+
+	if strings.Contains(overlay, "/") {
+		return "", fmt.Errorf("invalid overlay %q", overlay)
+	}
+	if _, ok := data.GetOk("versions"); ok {
+		return "", fmt.Errorf("overlay cannot be combined with versions")
+	}
+	if _, ok := data.GetOk("if_newer_than_version"); ok {
+		return "", fmt.Errorf("overlay cannot be combined with if_newer_than_version")
+	}
+
+	overlayPath := key + "/" + overlaysFolder + "/" + overlay
+	for _, pattern := range config.OverlayPaths {
+		if matchPathPattern(pattern, overlayPath) {
+			return overlayPath, nil
+		}
+	}
+	return "", fmt.Errorf("reading the overlay %q is not allowed by the overlay_paths of the backend configuration", overlayPath)
+}
+
+// applyOverlay merges the data of the current version of the overlay into the
+// data of the response of a data read. A warning is added if the overlay has
+// no readable current version. The responses without data are returned as is.
+// The overlay is rate limited unless it shares the limit of key.
+func (b *versionedKVBackend) applyOverlay(ctx context.Context, req *logical.Request, resp *logical.Response, key, overlay string) (*logical.Response, error) {
+	vData, ok := resp.Data["data"].(map[string]interface{})
+	if !ok || vData == nil {
+		return resp, nil
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	overlayData, version, err := b.resolvedCurrentVersionData(ctx, req, config, limitedPrefixes(config, key), overlay)
+	if errors.Is(err, errUnreadableSource) {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	if overlayData == nil {
+		resp.AddWarning(fmt.Sprintf("The overlay %q has no readable current version and was not applied", overlay))
+		return resp, nil
+	}
+
+	for k, v := range overlayData {
+		vData[k] = v
+	}
+	resp.Data["overlay"] = map[string]interface{}{
+		"path":    overlay,
+		"version": version,
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,105 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Overlay(t *testing.T) {
+	b, storage := getBackend(t)
+
+	configure := func(patterns []string) {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"overlay_paths": patterns,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for path, data := range map[string]map[string]interface{}{
+		"data/apps/foo": {
+			"host":    "db.internal",
+			"timeout": "30s",
+		},
+		"data/apps/foo/overlays/prod": {
+			"host": "db.prod.internal",
+		},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": data,
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	read := func(data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/apps/foo",
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	// The overlays cannot be read until overlay_paths allows them
+	resp, err := read(map[string]interface{}{"overlay": "prod"})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the overlay to be rejected, err:%s resp:%#v\n", err, resp)
+	}
+	configure([]string{"apps/+/overlays/staging"})
+	resp, err = read(map[string]interface{}{"overlay": "prod"})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the overlay to be rejected, err:%s resp:%#v\n", err, resp)
+	}
+
+	configure([]string{"apps/+/overlays/*"})
+	resp, err = read(map[string]interface{}{"overlay": "prod"})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	expected := map[string]interface{}{
+		"host":    "db.prod.internal",
+		"timeout": "30s",
+	}
+	if diff := deep.Equal(resp.Data["data"], expected); diff != nil {
+		t.Fatal(diff)
+	}
+	if overlay := resp.Data["overlay"].(map[string]interface{}); overlay["path"] != "apps/foo/overlays/prod" || overlay["version"] != uint64(1) {
+		t.Fatalf("unexpected overlay: %#v", overlay)
+	}
+
+	// A missing overlay returns the data of the secret with a warning
+	resp, err = read(map[string]interface{}{"overlay": "staging"})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data"].(map[string]interface{})["host"] != "db.internal" || len(resp.Warnings) != 1 {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"overlay": "prod/eu"},
+		{"overlay": "prod", "versions": "1"},
+	} {
+		resp, err = read(data)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("expected %v to be rejected, err:%s resp:%#v\n", data, err, resp)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package kv
+
+import (
+	"fmt"
+)
+
+// validateOwnershipPatterns returns an error if the owner_pattern or the
+// team_pattern of the mount is not a valid regular expression.
+func validateOwnershipPatterns(config *Configuration) error {
+	if _, err := compileDataKeyPattern(config.OwnerPattern); err != nil {
+		return fmt.Errorf("invalid owner_pattern: %w", err)
+	}
+	if _, err := compileDataKeyPattern(config.TeamPattern); err != nil {
+		return fmt.Errorf("invalid team_pattern: %w", err)
+	}
+	return nil
+}
+
+// validateOwnership returns an error if the owner or the team cannot be set
+// on a key because they do not fully match the patterns of the mount. An
+// empty owner or team clears it and is always valid.
+func validateOwnership(config *Configuration, owner, team string) error {
+	for _, f := range []struct {
+		name, value, pattern string
+	}{
+		{"owner", owner, config.OwnerPattern},
+		{"team", team, config.TeamPattern},
+	} {
+		if f.value == "" {
+			continue
+		}
+		re, err := compileDataKeyPattern(f.pattern)
+		if err != nil {
+			return err
+		}
+		if re != nil && !re.MatchString(f.value) {
+			return fmt.Errorf("%s %q does not match the %s_pattern %q configured on the backend", f.name, f.value, f.name, f.pattern)
+		}
+	}
+	return nil
+}
+
+// matchesOwnership returns true if the key has the owner and the team, an
+// empty owner or team matching any key.
+func matchesOwnership(meta *KeyMetadata, owner, team string) bool {
+	return (owner == "" || meta.Owner == owner) && (team == "" || meta.Team == team)
+}
@@ -0,0 +1,159 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestValidateOwnership(t *testing.T) {
+	config := &Configuration{
+		OwnerPattern: "[a-z]+@example\\.com",
+		TeamPattern:  "team-[a-z]+",
+	}
+
+	for _, tc := range []struct {
+		owner, team string
+		valid       bool
+	}{
+		{"", "", true},
+		{"alice@example.com", "team-infra", true},
+		{"alice@example.com", "", true},
+		{"alice@example.org", "team-infra", false},
+		{"alice@example.com", "infra", false},
+		{"alice@example.com.evil", "", false},
+	} {
+		if err := validateOwnership(config, tc.owner, tc.team); (err == nil) != tc.valid {
+			t.Fatalf("unexpected result for %q and %q: %v", tc.owner, tc.team, err)
+		}
+	}
+
+	if err := validateOwnership(&Configuration{}, "anything", "goes"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionedKV_Ownership(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"owner_pattern": "(",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an invalid pattern to be rejected, err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data = map[string]interface{}{
+		"owner_pattern": "[a-z]+",
+		"team_pattern":  "team-[a-z]+",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"owner": "Alice",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	for key, ownership := range map[string][2]string{
+		"foo": {"alice", "team-infra"},
+		"bar": {"bob", "team-infra"},
+		"baz": {"alice", "team-web"},
+	} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"owner": ownership[0],
+				"team":  ownership[1],
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["owner"] != "alice" || resp.Data["team"] != "team-infra" {
+		t.Fatalf("unexpected ownership: %#v", resp.Data)
+	}
+
+	list := func(path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	resp = list("metadata/", map[string]interface{}{"owner": "alice"})
+	if keys := resp.Data["keys"].([]string); !reflect.DeepEqual(keys, []string{"baz", "foo"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	resp = list("detailed-metadata/", map[string]interface{}{"owner": "alice", "team": "team-infra"})
+	if keys := resp.Data["keys"].([]string); !reflect.DeepEqual(keys, []string{"foo"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	info := resp.Data["key_info"].(map[string]interface{})["foo"].(map[string]interface{})
+	if info["owner"] != "alice" || info["team"] != "team-infra" {
+		t.Fatalf("unexpected key_info: %#v", info)
+	}
+
+	// An empty value clears the ownership
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"owner": "",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp = list("metadata/", map[string]interface{}{"team": "team-infra"})
+	if keys := resp.Data["keys"].([]string); !reflect.DeepEqual(keys, []string{"bar", "foo"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	resp = list("metadata/", map[string]interface{}{"owner": "alice"})
+	if keys := resp.Data["keys"].([]string); !reflect.DeepEqual(keys, []string{"baz"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
@@ -0,0 +1,231 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// cacheConfigPath is the location where the cache config is stored.
+const cacheConfigPath string = "config/cache"
+
+// pathCacheConfig returns the path configuration for the endpoint tuning the
+// in-memory caches.
+func pathCacheConfig(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "cache-config$",
+		Fields: map[string]*framework.FieldSchema{
+			"metadata_cache_size": {
+				Type: framework.TypeInt,
+				Description: `
+The number of key metadata objects kept in memory. Zero disables the metadata
+cache. Defaults to the metadata_cache_size mount option.`,
+			},
+			"disable_policy_cache": {
+				Type:        framework.TypeBool,
+				Description: "If true, the key policy is loaded from storage on every access.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.upgradeCheck(b.pathCacheConfigRead()),
+			logical.UpdateOperation: b.upgradeCheck(b.pathCacheConfigWrite()),
+		},
+
+		HelpSynopsis:    cacheConfigHelpSyn,
+		HelpDescription: cacheConfigHelpDesc,
+	}
+}
+
+// pathCacheClear returns the path configuration for the endpoint clearing the
+// in-memory caches.
+func pathCacheClear(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "cache-clear$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathCacheClearWrite()),
+		},
+
+		HelpSynopsis:    cacheClearHelpSyn,
+		HelpDescription: cacheClearHelpDesc,
+	}
+}
+
+func (b *versionedKVBackend) pathCacheConfigRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.cacheSettings(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"metadata_cache_size":    config.MetadataCacheSize,
+				"metadata_cache_entries": b.metadataCache.len(),
+				"disable_policy_cache":   config.DisablePolicyCache,
+			},
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathCacheConfigWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		b.cacheConfigLock.Lock()
+		defer b.cacheConfigLock.Unlock()
+
+		config, err := b.readCacheConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		if sizeRaw, ok := data.GetOk("metadata_cache_size"); ok {
+			size := sizeRaw.(int)
+			if size < 0 || size > maxMetadataCacheSize {
+				return logical.ErrorResponse(fmt.Sprintf("metadata_cache_size must be between 0 and %d", maxMetadataCacheSize)), logical.ErrInvalidRequest
+			}
+			config.MetadataCacheSize = uint32(size)
+		}
+		if disableRaw, ok := data.GetOk("disable_policy_cache"); ok {
+			config.DisablePolicyCache = disableRaw.(bool)
+		}
+
+		bytes, err := proto.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		err = req.Storage.Put(ctx, &logical.StorageEntry{
+			Key:   path.Join(b.storagePrefix, cacheConfigPath),
+			Value: bytes,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := b.applyCacheConfig(config); err != nil {
+			return nil, err
+		}
+		b.cacheConfig = config
+
+		return nil, nil
+	}
+}
+
+// pathCacheClearWrite drops every cached value so that they are loaded from
+// storage again, e.g. after the storage has been restored.
+func (b *versionedKVBackend) pathCacheClearWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		b.clearCaches()
+		return nil, nil
+	}
+}
+
+// cacheSettings returns the cache config, loading and applying it if it has
+// not been loaded yet.
+func (b *versionedKVBackend) cacheSettings(ctx context.Context, s logical.Storage) (*CacheConfig, error) {
+	b.cacheConfigLock.RLock()
+	if b.cacheConfig != nil {
+		defer b.cacheConfigLock.RUnlock()
+		return b.cacheConfig, nil
+	}
+	b.cacheConfigLock.RUnlock()
+	b.cacheConfigLock.Lock()
+	defer b.cacheConfigLock.Unlock()
+
+	if b.cacheConfig != nil {
+		return b.cacheConfig, nil
+	}
+
+	config, err := b.readCacheConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.applyCacheConfig(config); err != nil {
+		return nil, err
+	}
+
+	// Cache the value
+	b.cacheConfig = config
+
+	return config, nil
+}
+
+// readCacheConfig reads the cache config from storage, if it has not been
+// written the settings of the mount options are returned.
+func (b *versionedKVBackend) readCacheConfig(ctx context.Context, s logical.Storage) (*CacheConfig, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, cacheConfigPath))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return &CacheConfig{
+			MetadataCacheSize: uint32(b.metadataCacheSize),
+		}, nil
+	}
+
+	config := &CacheConfig{}
+	if err := proto.Unmarshal(raw.Value, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// applyCacheConfig resizes the caches according to config.
+func (b *versionedKVBackend) applyCacheConfig(config *CacheConfig) error {
+	if err := b.metadataCache.resize(int(config.MetadataCacheSize)); err != nil {
+		return err
+	}
+
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	b.policyCacheDisabled = config.DisablePolicyCache
+	if b.policyCacheDisabled {
+		b.keyEncryptedWrapper = nil
+	}
+
+	return nil
+}
+
+// clearCaches drops the cached salt, storage layout, key policy, config and
+// key metadata.
+func (b *versionedKVBackend) clearCaches() {
+	b.l.Lock()
+	b.salt = nil
+	b.layout = nil
+	b.keyEncryptedWrapper = nil
+	b.l.Unlock()
+
+	b.globalConfigLock.Lock()
+	b.globalConfig = nil
+	b.globalConfigLock.Unlock()
+
+	b.cacheConfigLock.Lock()
+	b.cacheConfig = nil
+	b.cacheConfigLock.Unlock()
+
+	b.metadataCache.purge()
+}
+
+const cacheConfigHelpSyn = `Configures the in-memory caches of the KV store.`
+const cacheConfigHelpDesc = `
+This endpoint sizes or disables the caches the backend keeps in memory to
+avoid loading and decrypting the same values from storage on every request.
+
+"metadata_cache_size" is the number of key metadata objects cached, zero
+disables the metadata cache. "disable_policy_cache" makes the key policy used
+to encrypt the metadata be loaded from storage on every access.
+
+The settings are stored and take precedence over the mount options. Reading
+the endpoint also reports the number of entries currently cached.
+`
+
+const cacheClearHelpSyn = `Clears the in-memory caches of the KV store.`
+const cacheClearHelpDesc = `
+This endpoint drops every value the backend keeps in memory, forcing them to
+be loaded from storage again. It is meant for operators debugging stale reads,
+e.g. after the storage has been restored, without having to reload the mount.
+`
@@ -0,0 +1,203 @@
+package kv
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_CacheConfig(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	s := &metadataReadsStorage{
+		Storage: storage,
+		prefix:  path.Join(kv.storagePrefix, metadataPrefix) + "/",
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "cache-config",
+		Storage:   s,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["metadata_cache_size"] != uint32(defaultMetadataCacheSize) || resp.Data["disable_policy_cache"] != false {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	for _, size := range []int{-1, maxMetadataCacheSize + 1} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "cache-config",
+			Storage:   s,
+			Data: map[string]interface{}{
+				"metadata_cache_size": size,
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error for size %d, err:%s resp:%#v\n", size, err, resp)
+		}
+	}
+
+	// Disable both caches
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "cache-config",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"metadata_cache_size":  0,
+			"disable_policy_cache": true,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	read := func() {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   s,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	read()
+	reads := s.reads
+	read()
+	if s.reads == reads {
+		t.Fatal("expected the metadata to be read from storage")
+	}
+	if kv.keyEncryptedWrapper != nil {
+		t.Fatal("expected the key policy not to be cached")
+	}
+
+	// The settings are reloaded when invalidated
+	kv.Invalidate(context.Background(), path.Join(kv.storagePrefix, cacheConfigPath))
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "cache-config",
+		Storage:   s,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["metadata_cache_size"] != uint32(0) || resp.Data["disable_policy_cache"] != true {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	// Enable the metadata cache again
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "cache-config",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"metadata_cache_size": 10,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	read()
+	reads = s.reads
+	read()
+	if s.reads != reads {
+		t.Fatalf("expected the metadata to be cached, got %d reads", s.reads-reads)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "cache-config",
+		Storage:   s,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["metadata_cache_size"] != uint32(10) || resp.Data["metadata_cache_entries"] != 1 || resp.Data["disable_policy_cache"] != true {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+}
+
+func TestVersionedKV_CacheClear(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	s := &metadataReadsStorage{
+		Storage: storage,
+		prefix:  path.Join(kv.storagePrefix, metadataPrefix) + "/",
+	}
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	read := func() {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   s,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	read()
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "cache-clear",
+		Storage:   s,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if kv.keyEncryptedWrapper != nil || kv.salt != nil || kv.metadataCache.len() != 0 {
+		t.Fatal("expected the caches to be cleared")
+	}
+
+	reads := s.reads
+	read()
+	if s.reads == reads {
+		t.Fatal("expected the metadata to be read from storage")
+	}
+}
@@ -0,0 +1,231 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// checkpointTimeFormat is the format of the times of day in the
+	// checkpoint_times configuration.
+	checkpointTimeFormat = "15:04"
+
+	// maxCheckpoints is the number of checkpoints kept for each key.
+	maxCheckpoints = 90
+)
+
+// pathCheckpoints returns the path configuration for reading the checkpoints
+// of a key.
+func pathCheckpoints(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "checkpoints/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the secret.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathCheckpointsRead()),
+		},
+
+		HelpSynopsis:    checkpointsHelpSyn,
+		HelpDescription: checkpointsHelpDesc,
+	}
+}
+
+// pathCheckpointsRead returns the checkpoints recorded for a key.
+func (b *versionedKVBackend) pathCheckpointsRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+
+		lock := locksutil.LockForKey(b.locks, key)
+		lock.RLock()
+		defer lock.RUnlock()
+
+		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, nil
+		}
+
+		checkpoints := make([]map[string]interface{}, 0, len(meta.Checkpoints))
+		for _, c := range meta.Checkpoints {
+			checkpoints = append(checkpoints, map[string]interface{}{
+				"time":    ptypesTimestampToString(c.Time),
+				"version": c.Version,
+			})
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"checkpoints": checkpoints,
+			},
+		}, nil
+	}
+}
+
+// validateCheckpointTimes returns an error if one of the times of day of the
+// checkpoint_times configuration is invalid.
+func validateCheckpointTimes(times map[string]string) error {
+	for prefix, at := range times {
+		if _, err := time.Parse(checkpointTimeFormat, at); err != nil {
+			return fmt.Errorf("invalid checkpoint time %q for prefix %q, expected the HH:MM format", at, prefix)
+		}
+	}
+	return nil
+}
+
+// checkpointTime returns the time of day at which the checkpoints of the key
+// are recorded, from the longest matching prefix of the checkpoint_times
+// configured on the mount. An empty string is returned if none matches.
+func checkpointTime(config *Configuration, key string) string {
+	var at, longest string
+	for prefix, t := range config.CheckpointTimes {
+		if strings.HasPrefix(key, prefix) && (at == "" || len(prefix) > len(longest)) {
+			at, longest = t, prefix
+		}
+	}
+	return at
+}
+
+// lastCheckpoint returns the most recent occurrence of the time of day at
+// before now.
+func lastCheckpoint(now time.Time, at string) (time.Time, error) {
+	t, err := time.Parse(checkpointTimeFormat, at)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now = now.UTC()
+	last := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	if last.After(now) {
+		last = last.AddDate(0, 0, -1)
+	}
+
+	return last, nil
+}
+
+// versionAt returns the most recent version created at or before t, or zero
+// if there is none.
+func versionAt(meta *KeyMetadata, t time.Time) (uint64, error) {
+	for v := meta.CurrentVersion; v > 0 && v >= meta.OldestVersion; v-- {
+		vm, ok := meta.Versions[v]
+		if !ok || vm.CreatedTime == nil {
+			continue
+		}
+
+		created, err := ptypes.Timestamp(vm.CreatedTime)
+		if err != nil {
+			return 0, err
+		}
+		if !created.After(t) {
+			return v, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// AddCheckpoint records the version that was current at t if no checkpoint
+// was recorded at or after t. The checkpoints referencing versions that were
+// removed and the ones exceeding maxCheckpoints are discarded. It returns true
+// if the metadata was modified.
+func (k *KeyMetadata) AddCheckpoint(t time.Time) (bool, error) {
+	var modified bool
+	checkpoints := k.Checkpoints[:0]
+	for _, c := range k.Checkpoints {
+		if c.Version < k.OldestVersion {
+			modified = true
+			continue
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	k.Checkpoints = checkpoints
+
+	if n := len(k.Checkpoints); n > 0 {
+		last, err := ptypes.Timestamp(k.Checkpoints[n-1].Time)
+		if err != nil {
+			return false, err
+		}
+		if !last.Before(t) {
+			return modified, nil
+		}
+	}
+
+	version, err := versionAt(k, t)
+	if err != nil || version == 0 {
+		return modified, err
+	}
+
+	ts, err := ptypes.TimestampProto(t)
+	if err != nil {
+		return false, err
+	}
+	k.Checkpoints = append(k.Checkpoints, &Checkpoint{
+		Time:    ts,
+		Version: version,
+	})
+	if n := len(k.Checkpoints); n > maxCheckpoints {
+		k.Checkpoints = k.Checkpoints[n-maxCheckpoints:]
+	}
+
+	return true, nil
+}
+
+// recordCheckpoint records the daily checkpoint of key if one is due.
+func (b *versionedKVBackend) recordCheckpoint(ctx context.Context, s logical.Storage, key string) error {
+	config, err := b.config(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	at := checkpointTime(config, key)
+	if at == "" {
+		return nil
+	}
+	last, err := lastCheckpoint(time.Now(), at)
+	if err != nil {
+		return err
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil || meta.Deleting {
+		return nil
+	}
+
+	modified, err := meta.AddCheckpoint(last)
+	if err != nil || !modified {
+		return err
+	}
+
+	return b.writeKeyMetadata(ctx, s, meta)
+}
+
+const checkpointsHelpSyn = `Reads the daily checkpoints of a secret.`
+const checkpointsHelpDesc = `
+When "checkpoint_times" is configured for a prefix, the version of the secrets
+under the prefix that is current at the configured time of day is recorded
+once a day. This endpoint returns the recorded checkpoints of a secret, oldest
+first, which can be used to read the secret as it was at that time with the
+"version" parameter of the data/ endpoint.
+
+Checkpoints referencing versions that were removed are discarded, and at most
+90 checkpoints are kept.
+`
@@ -0,0 +1,173 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLastCheckpoint(t *testing.T) {
+	now := time.Date(2021, 6, 15, 12, 30, 0, 0, time.UTC)
+
+	tests := map[string]time.Time{
+		"09:00": time.Date(2021, 6, 15, 9, 0, 0, 0, time.UTC),
+		"12:30": time.Date(2021, 6, 15, 12, 30, 0, 0, time.UTC),
+		"23:59": time.Date(2021, 6, 14, 23, 59, 0, 0, time.UTC),
+	}
+	for at, want := range tests {
+		got, err := lastCheckpoint(now, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(want) {
+			t.Fatalf("%s: expected %s, got %s", at, want, got)
+		}
+	}
+
+	if _, err := lastCheckpoint(now, "25:00"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestKeyMetadata_AddCheckpoint(t *testing.T) {
+	now := time.Now()
+	meta := &KeyMetadata{
+		Versions:       map[uint64]*VersionMetadata{},
+		CurrentVersion: 3,
+		OldestVersion:  1,
+	}
+	for i, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour} {
+		created, err := ptypes.TimestampProto(now.Add(-age))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta.Versions[uint64(i+1)] = &VersionMetadata{CreatedTime: created}
+	}
+
+	// No version existed at that time
+	modified, err := meta.AddCheckpoint(now.Add(-4 * time.Hour))
+	if err != nil || modified {
+		t.Fatalf("modified: %t, err: %v", modified, err)
+	}
+
+	modified, err = meta.AddCheckpoint(now.Add(-90 * time.Minute))
+	if err != nil || !modified {
+		t.Fatalf("modified: %t, err: %v", modified, err)
+	}
+	if len(meta.Checkpoints) != 1 || meta.Checkpoints[0].Version != 2 {
+		t.Fatalf("unexpected checkpoints %#v", meta.Checkpoints)
+	}
+
+	// A checkpoint is recorded only once
+	modified, err = meta.AddCheckpoint(now.Add(-90 * time.Minute))
+	if err != nil || modified {
+		t.Fatalf("modified: %t, err: %v", modified, err)
+	}
+
+	// Checkpoints of removed versions are discarded
+	delete(meta.Versions, 1)
+	delete(meta.Versions, 2)
+	meta.OldestVersion = 3
+	modified, err = meta.AddCheckpoint(now)
+	if err != nil || !modified {
+		t.Fatalf("modified: %t, err: %v", modified, err)
+	}
+	if len(meta.Checkpoints) != 1 || meta.Checkpoints[0].Version != 3 {
+		t.Fatalf("unexpected checkpoints %#v", meta.Checkpoints)
+	}
+}
+
+func TestVersionedKV_Checkpoints(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"checkpoint_times": map[string]interface{}{
+				"foo": "noon",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err:%s resp:%#v\n", err, resp)
+	}
+
+	for _, key := range []string{"foo/bar", "baz"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	// Backdate the versions so that they were current an hour ago
+	kv := b.(*versionedKVBackend)
+	for _, key := range []string{"foo/bar", "baz"} {
+		meta, err := kv.getKeyMetadata(context.Background(), storage, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta.Versions[1].CreatedTime, err = ptypes.TimestampProto(time.Now().Add(-2 * time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := kv.writeKeyMetadata(context.Background(), storage, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	at := time.Now().UTC().Add(-time.Hour).Format(checkpointTimeFormat)
+
+	req.Data = map[string]interface{}{
+		"checkpoint_times": map[string]interface{}{
+			"foo/": at,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoints := func(key string) []map[string]interface{} {
+		t.Helper()
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "checkpoints/" + key,
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp.Data["checkpoints"].([]map[string]interface{})
+	}
+
+	if c := checkpoints("foo/bar"); len(c) != 1 || c[0]["version"] != uint64(1) {
+		t.Fatalf("unexpected checkpoints %#v", c)
+	}
+	if c := checkpoints("baz"); len(c) != 0 {
+		t.Fatalf("unexpected checkpoints %#v", c)
+	}
+}
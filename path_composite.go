@@ -0,0 +1,104 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathComposite returns the path configuration for reading the composite
+// secrets configured at config/composite.
+func pathComposite(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "composite/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the composite secret.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathCompositeRead()),
+		},
+
+		HelpSynopsis:    compositeHelpSyn,
+		HelpDescription: compositeHelpDesc,
+	}
+}
+
+// pathCompositeRead merges the data of the current versions of the sources of
+// a composite. The sources without a readable current version are skipped
+// with a warning.
+func (b *versionedKVBackend) pathCompositeRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		composite, ok := config.Composites[data.Get("path").(string)]
+		if !ok {
+			return nil, nil
+		}
+
+		merged := map[string]interface{}{}
+		versions := map[string]interface{}{}
+		limited := make(map[string]bool)
+		var warnings []string
+		for _, source := range composite.Sources {
+			vData, version, err := b.resolvedCurrentVersionData(ctx, req, config, limited, source)
+			if errors.Is(err, errUnreadableSource) {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
+			if err != nil {
+				return nil, err
+			}
+			if vData == nil {
+				warnings = append(warnings, fmt.Sprintf("The source %q has no readable current version and was skipped", source))
+				continue
+			}
+
+			for k, v := range vData {
+				merged[k] = v
+			}
+			versions[source] = version
+		}
+
+		// If none of the sources can be read, return
+		if len(versions) == 0 {
+			return nil, nil
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"data": merged,
+				"metadata": map[string]interface{}{
+					"sources": versions,
+				},
+			},
+		}
+		for _, warning := range warnings {
+			resp.AddWarning(warning)
+		}
+
+		return resp, nil
+	}
+}
+
+const compositeHelpSyn = `Reads a secret merged from the data of other secrets.`
+const compositeHelpDesc = `
+Reading "composite/<path>" returns the data of the current versions of the
+sources configured for the composite at config/composite/<path>, merged in
+order: the fields of the later sources override the ones of the earlier ones.
+The fields are merged at the top level only, a nested object of a later
+source replaces the one of an earlier source.
+
+"metadata" holds in "sources" the version read of each source. The sources
+that do not exist or whose current version is deleted or destroyed are skipped
+with a warning, and a 404 is returned if none of them can be read. The sources
+that are references are followed. The response wrapping required by a source
+applies to the reads of the composites including it.
+`
@@ -0,0 +1,121 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Composite(t *testing.T) {
+	b, storage := getBackend(t)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config/composite/apps/foo",
+			Data: map[string]interface{}{
+				"sources": "shared/base,apps/foo/overrides,apps/foo/missing",
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/shared/base",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"host":    "db.internal",
+					"port":    "5432",
+					"timeout": "30s",
+				},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/apps/foo/overrides",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"timeout":  "5s",
+					"password": "hunter2",
+				},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/apps/foo/overrides",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"timeout":  "10s",
+					"password": "hunter2",
+				},
+			},
+		},
+	}
+	for _, req := range requests {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	read := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "composite/apps/foo",
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	// The later sources override the earlier ones and the missing source
+	// is skipped
+	resp := read()
+	expected := map[string]interface{}{
+		"host":     "db.internal",
+		"port":     "5432",
+		"timeout":  "10s",
+		"password": "hunter2",
+	}
+	if diff := deep.Equal(resp.Data["data"], expected); diff != nil {
+		t.Fatal(diff)
+	}
+	expectedSources := map[string]interface{}{
+		"shared/base":        uint64(1),
+		"apps/foo/overrides": uint64(2),
+	}
+	if diff := deep.Equal(resp.Data["metadata"].(map[string]interface{})["sources"], expectedSources); diff != nil {
+		t.Fatal(diff)
+	}
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("expected a warning for the missing source, got %v", resp.Warnings)
+	}
+
+	// A deleted source is skipped as well
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "data/apps/foo/overrides",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp = read()
+	if resp.Data["data"].(map[string]interface{})["timeout"] != "30s" {
+		t.Fatalf("unexpected data: %#v", resp.Data["data"])
+	}
+
+	// Unknown composites are not found
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "composite/apps/bar",
+		Storage:   storage,
+	})
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
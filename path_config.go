@@ -3,6 +3,7 @@ package kv
 import (
 	"context"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -21,16 +22,295 @@ func pathConfig(b *versionedKVBackend) *framework.Path {
 				Type:        framework.TypeInt,
 				Description: "The number of versions to keep for each key. Defaults to 10",
 			},
+			"max_versions_limit": {
+				Type: framework.TypeInt,
+				Description: `
+The largest number of versions a key can keep. Keys whose max_versions is
+greater are clamped to this value. Zero means no limit.`,
+			},
+			"min_versions": {
+				Type: framework.TypeInt,
+				Description: `
+The number of versions of each key that must remain retrievable. Pruning by
+max_version_age, deletion and delete_version_after cannot make the most recent
+versions unretrievable. Cannot be greater than max_versions. Defaults to 0.`,
+			},
+			"max_version_age": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+If set, the age after which versions other than the current one are removed,
+in addition to the max_versions limit. A zero duration clears the current
+setting. Accepts a Go duration format string.`,
+			},
 			"cas_required": {
 				Type:        framework.TypeBool,
 				Description: "If true, the backend will require the cas parameter to be set for each write",
 			},
+			"string_values_only": {
+				Type:        framework.TypeBool,
+				Description: "If true, the backend will reject data writes containing values that are not strings",
+			},
+			"data_key_pattern": {
+				Type: framework.TypeString,
+				Description: `
+If set, a regular expression that the name of every field in written data
+must fully match, for instance "[A-Z0-9_]+". An empty string clears the
+current setting.`,
+			},
+			"allowed_paths": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+If set, the path patterns secrets can be written to. A "+" segment matches any
+single path segment and a trailing "*" matches any suffix.`,
+			},
+			"denied_paths": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+The path patterns secrets cannot be written to. Takes precedence over
+allowed_paths. Uses the same syntax as allowed_paths.`,
+			},
+			"reference_targets": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+The path patterns that references can point to. Uses the same syntax as
+allowed_paths. References cannot be written if it is empty.`,
+			},
+			"overlay_paths": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+The path patterns of the overlays that data reads can merge. Uses the same
+syntax as allowed_paths. Overlays cannot be read if it is empty.`,
+			},
+			"reserved_prefixes": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+The key prefixes that are reserved for content managed by the backend. Keys
+under these prefixes cannot be written or deleted by data operations.`,
+			},
+			"required_paths": {
+				Type: framework.TypeKVPairs,
+				Description: `
+A map of the keys that are expected to exist to a comma separated list of the
+data fields they must contain. Checked by the conformance endpoint.`,
+			},
 			"delete_version_after": {
 				Type: framework.TypeSignedDurationSecond,
 				Description: `
-If set, the length of time before a version is deleted. A negative duration
-disables the use of delete_version_after on all keys. A zero duration
-clears the current setting. Accepts a Go duration format string.`,
+If set, the length of time before a version is deleted. The versions written
+before it was set are deleted by the periodic maintenance once it has elapsed
+since their creation. A negative duration disables the use of
+delete_version_after on all keys. A zero duration clears the current setting.
+Accepts a Go duration format string.`,
+			},
+			"destroy_version_after": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+If set, the length of time after its deletion_time before a version is
+permanently destroyed and its data removed from storage. The versions are
+destroyed by the periodic function and when the secret is read or written. A
+zero duration clears the current setting. Accepts a Go duration format
+string.`,
+			},
+			"min_delete_version_after": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+If set, the shortest delete_version_after that can be set on a key. A zero
+duration clears the current setting. Accepts a Go duration format string.`,
+			},
+			"destroy_confirmation_window": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+If set, destroys require dual control: a destroy is only carried out once an
+entity other than the requester makes the same request within this window. A
+zero duration clears the current setting.`,
+			},
+			"delete_metadata_when_destroyed": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the metadata of the keys is deleted once all of their versions are
+destroyed, whether by destroy or by destroy_version_after.`,
+			},
+			"numeric_time_fields": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the responses include the durations in seconds as <field>_seconds and
+the timestamps as Unix epochs as <field>_unix, next to their string
+representations. Reads can override it with the numeric_time_fields
+parameter.`,
+			},
+			"max_bytes": {
+				Type: framework.TypeInt,
+				Description: `
+If set, the data writes that would take the versions stored in the mount over
+this many bytes are rejected. Zero disables the quota.`,
+			},
+			"read_fallback": {
+				Type: framework.TypeBool,
+				Description: `
+If true, reading a secret whose current version is deleted or destroyed returns
+its newest version that is neither, with a warning, instead of a 404. Reads can
+override it with the read_fallback parameter.`,
+			},
+			"noop_writes": {
+				Type: framework.TypeString,
+				Description: `
+The handling of the data writes and patches identical to the current version
+of the secret. "allow" writes them as new versions, "warn" writes them with a
+warning and "skip" does not write them. Defaults to "allow".`,
+			},
+			"deduplicate_versions": {
+				Type: framework.TypeBool,
+				Description: `
+If true, a new version whose data is identical to the data of a version of the
+secret that is still kept references its stored data instead of storing it
+again.`,
+			},
+			"event_changed_keys": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the events and webhook notifications of data writes and patches list
+the names of the keys of the data that were added, removed and modified, never
+their values.`,
+			},
+			"max_snapshots": {
+				Type: framework.TypeInt,
+				Description: `
+If set, the number of snapshots kept. Creating a snapshot deletes the oldest
+ones beyond it. Zero keeps every snapshot.`,
+			},
+			"trash_retention": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+If set, deleting the metadata of a secret moves it to the trash, where it can
+be restored from for this long before being purged. A zero duration clears
+the current setting so that metadata deletes destroy the secrets immediately.`,
+			},
+			"lock_wait_timeout": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+If set, the longest time a request waits for the lock of a contended key
+before being rejected with a 429 status code. A zero duration clears the
+current setting so that requests wait indefinitely.`,
+			},
+			"data_schemas": {
+				Type: framework.TypeKVPairs,
+				Description: `
+A map of key prefixes to JSON Schema documents. Data written to a key under
+one of the prefixes must validate against the schema of the longest matching
+prefix, unless the key sets its own data_schema.`,
+			},
+			"readonly_mirrors": {
+				Type: framework.TypeKVPairs,
+				Description: `
+A map of mirror prefixes to source prefixes. The secrets under a source prefix
+can be read and listed through readonly-mirror/ under the mirror prefix.`,
+			},
+			"readonly_mirror_fields": {
+				Type: framework.TypeKVPairs,
+				Description: `
+A map of mirror prefixes to a comma separated list of the data fields exposed
+by the mirror. If a mirror is absent all the fields are exposed.`,
+			},
+			"max_history_bytes": {
+				Type: framework.TypeKVPairs,
+				Description: `
+A map of key prefixes to the largest cumulative size, in bytes, of the stored
+versions of the keys under the prefix. The oldest versions are removed when the
+size of the versions of a key exceeds the value of the longest matching
+prefix, unless the key sets its own max_history_bytes.`,
+			},
+			"default_custom_metadata": {
+				Type: framework.TypeKVPairs,
+				Description: `
+The custom_metadata new keys are created with. The custom_metadata provided
+when creating a key overrides the defaults.`,
+			},
+			"custom_metadata_max_keys": {
+				Type: framework.TypeInt,
+				Description: `
+The largest number of custom_metadata keys of a secret. Zero uses the default
+of 64.`,
+			},
+			"custom_metadata_max_key_length": {
+				Type: framework.TypeInt,
+				Description: `
+The longest custom_metadata key, in bytes. Zero uses the default of 128.`,
+			},
+			"custom_metadata_max_value_length": {
+				Type: framework.TypeInt,
+				Description: `
+The longest custom_metadata value, in bytes. Zero uses the default of 512.`,
+			},
+			"custom_metadata_required_keys": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+The custom_metadata keys new secrets must be created with.`,
+			},
+			"custom_metadata_allowed_keys": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+If set, the only custom_metadata keys secrets can set. A trailing "*" allows
+every key starting with the rest of the entry.`,
+			},
+			"custom_metadata_value_patterns": {
+				Type: framework.TypeKVPairs,
+				Description: `
+A map of custom_metadata keys to regular expressions their values must fully
+match.`,
+			},
+			"classifications": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+The classification labels keys can be given, from the least to the most
+sensitive.`,
+			},
+			"classification_warning_threshold": {
+				Type: framework.TypeString,
+				Description: `
+The classification from which data reads return a warning. Must be one of the
+classifications.`,
+			},
+			"owner_pattern": {
+				Type: framework.TypeString,
+				Description: `
+If set, a regular expression that the owner of every secret must fully match.
+An empty string clears the current setting.`,
+			},
+			"team_pattern": {
+				Type: framework.TypeString,
+				Description: `
+If set, a regular expression that the team of every secret must fully match.
+An empty string clears the current setting.`,
+			},
+			"track_reads": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the time of the most recent data read of the keys and the number of
+reads of their versions are recorded in their metadata. The reads are
+recorded periodically rather than on each read, and are best-effort: the
+reads served by performance standbys and secondaries are not recorded, and
+those not recorded yet are lost when the node restarts or steps down.`,
+			},
+			"seal_wrap_versions": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the version entries are marked for seal wrapping when they are
+written, so that the data is protected by the seal of clusters supporting it
+in addition to the encryption of the storage barrier.`,
+			},
+			"encrypt_custom_metadata": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the custom_metadata of the keys is stored encrypted with the key
+policy of the backend when their metadata is written. It is decrypted
+transparently on read.`,
+			},
+			"checkpoint_times": {
+				Type: framework.TypeKVPairs,
+				Description: `
+A map of key prefixes to a time of day in UTC, in the HH:MM format. Once a day
+the version of the keys under the prefix that is current at the time of the
+longest matching prefix is recorded as a checkpoint.`,
 			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -38,9 +318,6 @@ clears the current setting. Accepts a Go duration format string.`,
 				Callback: b.upgradeCheck(b.pathConfigWrite()),
 				Summary:  "Configure backend level settings that are applied to every key in the key-value store.",
 			},
-			logical.CreateOperation: &framework.PathOperation{
-				Callback: b.upgradeCheck(b.pathConfigWrite()),
-			},
 			logical.ReadOperation: &framework.PathOperation{
 				Callback: b.upgradeCheck(b.pathConfigRead()),
 				Summary:  "Read the backend level settings.",
@@ -61,8 +338,17 @@ func (b *versionedKVBackend) pathConfigRead() framework.OperationFunc {
 		}
 
 		rdata := map[string]interface{}{
-			"max_versions": config.MaxVersions,
-			"cas_required": config.CasRequired,
+			"max_versions":       config.MaxVersions,
+			"min_versions":       config.MinVersions,
+			"max_versions_limit": config.MaxVersionsLimit,
+			"cas_required":       config.CasRequired,
+			"string_values_only": config.StringValuesOnly,
+			"data_key_pattern":   config.DataKeyPattern,
+			"allowed_paths":      nonNilStrings(config.AllowedPaths),
+			"denied_paths":       nonNilStrings(config.DeniedPaths),
+			"reference_targets":  nonNilStrings(config.ReferenceTargets),
+			"overlay_paths":      nonNilStrings(config.OverlayPaths),
+			"reserved_prefixes":  nonNilStrings(config.ReservedPrefixes),
 		}
 
 		var deleteVersionAfter time.Duration
@@ -73,6 +359,53 @@ func (b *versionedKVBackend) pathConfigRead() framework.OperationFunc {
 			}
 		}
 		rdata["delete_version_after"] = deleteVersionAfter.String()
+		rdata["min_delete_version_after"] = minDeleteVersionAfter(config).String()
+		rdata["destroy_version_after"] = destroyVersionAfter(config).String()
+		rdata["max_version_age"] = getMaxVersionAge(config).String()
+		rdata["destroy_confirmation_window"] = destroyConfirmationWindow(config).String()
+		rdata["trash_retention"] = trashRetention(config).String()
+		rdata["delete_metadata_when_destroyed"] = config.DeleteMetadataWhenDestroyed
+		rdata["numeric_time_fields"] = config.NumericTimeFields
+		rdata["read_fallback"] = config.ReadFallback
+		rdata["noop_writes"] = noopWrites(config)
+		rdata["deduplicate_versions"] = config.DeduplicateVersions
+		rdata["event_changed_keys"] = config.EventChangedKeys
+		rdata["max_bytes"] = config.MaxBytes
+		rdata["max_snapshots"] = config.MaxSnapshots
+		rdata["lock_wait_timeout"] = lockWaitTimeout(config).String()
+		rdata["data_schemas"] = config.DataSchemas
+		rdata["required_paths"] = config.RequiredPaths
+		rdata["checkpoint_times"] = config.CheckpointTimes
+		rdata["default_custom_metadata"] = config.DefaultCustomMetadata
+		rdata["max_history_bytes"] = config.MaxHistoryBytes
+		rdata["readonly_mirrors"] = config.ReadonlyMirrors
+		rdata["readonly_mirror_fields"] = config.ReadonlyMirrorFields
+
+		maxKeys, maxKeyLength, maxValueLength := customMetadataLimits(config)
+		rdata["custom_metadata_max_keys"] = maxKeys
+		rdata["custom_metadata_max_key_length"] = maxKeyLength
+		rdata["custom_metadata_max_value_length"] = maxValueLength
+		rdata["custom_metadata_required_keys"] = nonNilStrings(config.CustomMetadataRequiredKeys)
+		rdata["custom_metadata_allowed_keys"] = nonNilStrings(config.CustomMetadataAllowedKeys)
+		rdata["custom_metadata_value_patterns"] = config.CustomMetadataValuePatterns
+		rdata["classifications"] = nonNilStrings(config.Classifications)
+		rdata["classification_warning_threshold"] = config.ClassificationWarningThreshold
+		rdata["owner_pattern"] = config.OwnerPattern
+		rdata["team_pattern"] = config.TeamPattern
+		rdata["track_reads"] = config.TrackReads
+		rdata["seal_wrap_versions"] = config.SealWrapVersions
+		rdata["encrypt_custom_metadata"] = config.EncryptCustomMetadata
+
+		layout, err := b.storageLayout(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		rdata["version_shards"] = layout.VersionShards
+		rdata["deterministic_paths"] = layout.DeterministicPaths
+
+		if numericTimeFields(config, data) {
+			addNumericTimeFields(rdata)
+		}
 
 		return &logical.Response{
 			Data: rdata,
@@ -84,25 +417,159 @@ func (b *versionedKVBackend) pathConfigRead() framework.OperationFunc {
 func (b *versionedKVBackend) pathConfigWrite() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		maxRaw, mOk := data.GetOk("max_versions")
+		minRaw, minOk := data.GetOk("min_versions")
+		limitRaw, limitOk := data.GetOk("max_versions_limit")
 		casRaw, cOk := data.GetOk("cas_required")
+		svoRaw, svoOk := data.GetOk("string_values_only")
+		dkpRaw, dkpOk := data.GetOk("data_key_pattern")
+		apRaw, apOk := data.GetOk("allowed_paths")
+		dpRaw, dpOk := data.GetOk("denied_paths")
+		rtRaw, rtOk := data.GetOk("reference_targets")
+		olRaw, olOk := data.GetOk("overlay_paths")
+		rpRaw, rpOk := data.GetOk("reserved_prefixes")
 		dvaRaw, dvaOk := data.GetOk("delete_version_after")
+		minDvaRaw, minDvaOk := data.GetOk("min_delete_version_after")
+		destroyRaw, destroyOk := data.GetOk("destroy_version_after")
+		ageRaw, ageOk := data.GetOk("max_version_age")
+		dcwRaw, dcwOk := data.GetOk("destroy_confirmation_window")
+		trashRaw, trashOk := data.GetOk("trash_retention")
+		dmwdRaw, dmwdOk := data.GetOk("delete_metadata_when_destroyed")
+		ntfRaw, ntfOk := data.GetOk("numeric_time_fields")
+		rfRaw, rfOk := data.GetOk("read_fallback")
+		nwRaw, nwOk := data.GetOk("noop_writes")
+		ddRaw, ddOk := data.GetOk("deduplicate_versions")
+		eckRaw, eckOk := data.GetOk("event_changed_keys")
+		msRaw, msOk := data.GetOk("max_snapshots")
+		mbRaw, mbOk := data.GetOk("max_bytes")
+		lwtRaw, lwtOk := data.GetOk("lock_wait_timeout")
+		dsRaw, dsOk := data.GetOk("data_schemas")
+		reqRaw, reqOk := data.GetOk("required_paths")
+		ctRaw, ctOk := data.GetOk("checkpoint_times")
+		dcmRaw, dcmOk := data.GetOk("default_custom_metadata")
+		mhbRaw, mhbOk := data.GetOk("max_history_bytes")
+		rmRaw, rmOk := data.GetOk("readonly_mirrors")
+		rmfRaw, rmfOk := data.GetOk("readonly_mirror_fields")
+		cmKeysRaw, cmKeysOk := data.GetOk("custom_metadata_max_keys")
+		cmKeyLenRaw, cmKeyLenOk := data.GetOk("custom_metadata_max_key_length")
+		cmValueLenRaw, cmValueLenOk := data.GetOk("custom_metadata_max_value_length")
+		cmRequiredRaw, cmRequiredOk := data.GetOk("custom_metadata_required_keys")
+		cmAllowedRaw, cmAllowedOk := data.GetOk("custom_metadata_allowed_keys")
+		cmPatternsRaw, cmPatternsOk := data.GetOk("custom_metadata_value_patterns")
+		clRaw, clOk := data.GetOk("classifications")
+		cwtRaw, cwtOk := data.GetOk("classification_warning_threshold")
+		opRaw, opOk := data.GetOk("owner_pattern")
+		tpRaw, tpOk := data.GetOk("team_pattern")
+		trRaw, trOk := data.GetOk("track_reads")
+		swvRaw, swvOk := data.GetOk("seal_wrap_versions")
+		ecmRaw, ecmOk := data.GetOk("encrypt_custom_metadata")
 
 		// Fast path validation
-		if !mOk && !cOk && !dvaOk {
+		if !mOk && !minOk && !limitOk && !cOk && !svoOk && !dkpOk && !apOk && !dpOk && !rtOk && !olOk && !rpOk && !dvaOk && !minDvaOk && !destroyOk && !ageOk && !dcwOk && !trashOk && !dmwdOk && !ntfOk && !rfOk && !nwOk && !ddOk && !eckOk && !msOk && !mbOk && !lwtOk && !dsOk && !reqOk && !ctOk && !rmOk && !rmfOk && !dcmOk && !mhbOk && !cmKeysOk && !cmKeyLenOk && !cmValueLenOk && !cmRequiredOk && !cmAllowedOk && !cmPatternsOk && !clOk && !cwtOk && !opOk && !tpOk && !trOk && !swvOk && !ecmOk {
 			return nil, nil
 		}
 
+		if (cmKeysOk && cmKeysRaw.(int) < 0) || (cmKeyLenOk && cmKeyLenRaw.(int) < 0) || (cmValueLenOk && cmValueLenRaw.(int) < 0) {
+			return logical.ErrorResponse("custom_metadata limits cannot be negative"), nil
+		}
+
+		if dkpOk {
+			if _, err := compileDataKeyPattern(dkpRaw.(string)); err != nil {
+				return logical.ErrorResponse("invalid data_key_pattern: %s", err), nil
+			}
+		}
+
+		if dsOk {
+			for prefix, schema := range dsRaw.(map[string]string) {
+				if _, err := compileDataSchema(schema); err != nil {
+					return logical.ErrorResponse("data schema for prefix %q: %s", prefix, err), nil
+				}
+			}
+		}
+
+		if cmPatternsOk {
+			for key, pattern := range cmPatternsRaw.(map[string]string) {
+				if _, err := compileCustomMetadataValuePattern(pattern); err != nil {
+					return logical.ErrorResponse("custom_metadata value pattern for key %q: %s", key, err), nil
+				}
+			}
+		}
+
+		if ctOk {
+			if err := validateCheckpointTimes(ctRaw.(map[string]string)); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+
+		var historyBytes map[string]uint64
+		if mhbOk {
+			var err error
+			historyBytes, err = parseMaxHistoryBytes(mhbRaw.(map[string]string))
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+
+		if dcmOk {
+			// The keys under the enrichment namespace are managed by the
+			// synchronization
+			namespace, err := b.enrichmentNamespace(ctx, req.Storage)
+			if err != nil {
+				return nil, err
+			}
+			for k := range dcmRaw.(map[string]string) {
+				if namespace != "" && strings.HasPrefix(k, namespace) {
+					return logical.ErrorResponse("default_custom_metadata key %q is under the reserved namespace %q", k, namespace), nil
+				}
+			}
+		}
+
+		b.configWriteLock.Lock()
+		defer b.configWriteLock.Unlock()
+
 		config, err := b.config(ctx, req.Storage)
 		if err != nil {
 			return nil, err
 		}
 
+		// Enabling dual control checks the current settings as if they
+		// were set by the request
+		previousMaxVersions := config.MaxVersions
+		if dcwOk {
+			previousMaxVersions = 0
+		}
 		if mOk {
 			config.MaxVersions = uint32(maxRaw.(int))
 		}
+		if minOk {
+			config.MinVersions = uint32(minRaw.(int))
+		}
+		if limitOk {
+			config.MaxVersionsLimit = uint32(limitRaw.(int))
+		}
 		if cOk {
 			config.CasRequired = casRaw.(bool)
 		}
+		if svoOk {
+			config.StringValuesOnly = svoRaw.(bool)
+		}
+		if dkpOk {
+			config.DataKeyPattern = dkpRaw.(string)
+		}
+		if apOk {
+			config.AllowedPaths = apRaw.([]string)
+		}
+		if dpOk {
+			config.DeniedPaths = dpRaw.([]string)
+		}
+		if rtOk {
+			config.ReferenceTargets = rtRaw.([]string)
+		}
+		if olOk {
+			config.OverlayPaths = olRaw.([]string)
+		}
+		if rpOk {
+			config.ReservedPrefixes = rpRaw.([]string)
+		}
 
 		if dvaOk {
 			dva := dvaRaw.(int)
@@ -115,29 +582,228 @@ func (b *versionedKVBackend) pathConfigWrite() framework.OperationFunc {
 				config.DeleteVersionAfter = ptypes.DurationProto(time.Duration(dva) * time.Second)
 			}
 		}
+		if minDvaOk {
+			if minDva := minDvaRaw.(int); minDva > 0 {
+				config.MinDeleteVersionAfter = ptypes.DurationProto(time.Duration(minDva) * time.Second)
+			} else {
+				config.MinDeleteVersionAfter = nil
+			}
+		}
+		if destroyOk {
+			if destroy := destroyRaw.(int); destroy > 0 {
+				config.DestroyVersionAfter = ptypes.DurationProto(time.Duration(destroy) * time.Second)
+			} else {
+				config.DestroyVersionAfter = nil
+			}
+		}
+		if ageOk {
+			if age := ageRaw.(int); age > 0 {
+				config.MaxVersionAge = ptypes.DurationProto(time.Duration(age) * time.Second)
+			} else {
+				config.MaxVersionAge = nil
+			}
+		}
+		if dcwOk {
+			if window := dcwRaw.(int); window > 0 {
+				config.DestroyConfirmationWindow = ptypes.DurationProto(time.Duration(window) * time.Second)
+			} else {
+				config.DestroyConfirmationWindow = nil
+			}
+		}
+		if trashOk {
+			if retention := trashRaw.(int); retention > 0 {
+				config.TrashRetention = ptypes.DurationProto(time.Duration(retention) * time.Second)
+			} else {
+				config.TrashRetention = nil
+			}
+		}
+		if dmwdOk {
+			config.DeleteMetadataWhenDestroyed = dmwdRaw.(bool)
+		}
+		if ntfOk {
+			config.NumericTimeFields = ntfRaw.(bool)
+		}
+		if rfOk {
+			config.ReadFallback = rfRaw.(bool)
+		}
+		if nwOk {
+			config.NoopWrites = nwRaw.(string)
+		}
+		if ddOk {
+			config.DeduplicateVersions = ddRaw.(bool)
+		}
+		if eckOk {
+			config.EventChangedKeys = eckRaw.(bool)
+		}
+		if msOk {
+			if msRaw.(int) < 0 {
+				return logical.ErrorResponse("max_snapshots cannot be negative"), nil
+			}
+			config.MaxSnapshots = uint32(msRaw.(int))
+		}
+		if mbOk {
+			if mbRaw.(int) < 0 {
+				return logical.ErrorResponse("max_bytes cannot be negative"), nil
+			}
+			config.MaxBytes = uint64(mbRaw.(int))
+		}
+		if lwtOk {
+			if lwt := lwtRaw.(int); lwt > 0 {
+				config.LockWaitTimeout = ptypes.DurationProto(time.Duration(lwt) * time.Second)
+			} else {
+				config.LockWaitTimeout = nil
+			}
+		}
+		if dsOk {
+			config.DataSchemas = dsRaw.(map[string]string)
+		}
+		if reqOk {
+			config.RequiredPaths = reqRaw.(map[string]string)
+		}
+		if ctOk {
+			config.CheckpointTimes = ctRaw.(map[string]string)
+		}
+		if dcmOk {
+			config.DefaultCustomMetadata = dcmRaw.(map[string]string)
+		}
+		if mhbOk {
+			config.MaxHistoryBytes = historyBytes
+		}
+		if rmOk {
+			config.ReadonlyMirrors = rmRaw.(map[string]string)
+		}
+		if rmfOk {
+			config.ReadonlyMirrorFields = rmfRaw.(map[string]string)
+		}
+		if cmKeysOk {
+			config.CustomMetadataMaxKeys = uint32(cmKeysRaw.(int))
+		}
+		if cmKeyLenOk {
+			config.CustomMetadataMaxKeyLength = uint32(cmKeyLenRaw.(int))
+		}
+		if cmValueLenOk {
+			config.CustomMetadataMaxValueLength = uint32(cmValueLenRaw.(int))
+		}
+		if cmRequiredOk {
+			config.CustomMetadataRequiredKeys = cmRequiredRaw.([]string)
+		}
+		if cmAllowedOk {
+			config.CustomMetadataAllowedKeys = cmAllowedRaw.([]string)
+		}
+		if cmPatternsOk {
+			config.CustomMetadataValuePatterns = cmPatternsRaw.(map[string]string)
+		}
+		if clOk {
+			config.Classifications = clRaw.([]string)
+		}
+		if cwtOk {
+			config.ClassificationWarningThreshold = cwtRaw.(string)
+		}
+		if opOk {
+			config.OwnerPattern = opRaw.(string)
+		}
+		if tpOk {
+			config.TeamPattern = tpRaw.(string)
+		}
+		if trOk {
+			config.TrackReads = trRaw.(bool)
+		}
+		if swvOk {
+			config.SealWrapVersions = swvRaw.(bool)
+		}
+		if ecmOk {
+			config.EncryptCustomMetadata = ecmRaw.(bool)
+		}
 
-		bytes, err := proto.Marshal(config)
-		if err != nil {
-			return nil, err
+		if dva, min := deleteVersionAfter(config), minDeleteVersionAfter(config); dva > 0 && dva < min {
+			return logical.ErrorResponse("delete_version_after %s is less than min_delete_version_after %s", dva, min), nil
+		}
+		if limit := config.MaxVersionsLimit; limit > 0 && config.MaxVersions > limit {
+			return logical.ErrorResponse("max_versions %d is greater than max_versions_limit %d", config.MaxVersions, limit), nil
+		}
+		if err := validateMinVersions(config, &KeyMetadata{}); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		setting := erasingSetting(
+			maxVersionsIf(mOk || dcwOk, config.MaxVersions),
+			previousMaxVersions,
+			durationIf(destroyOk || dcwOk, config.DestroyVersionAfter),
+			durationIf(ageOk || dcwOk, config.MaxVersionAge),
+			(mhbOk || dcwOk) && len(config.MaxHistoryBytes) > 0,
+		)
+		if err := checkDualControlSetting(config, setting); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if dcwOk {
+			for prefix, pc := range config.PathConfigs {
+				setting := erasingSetting(pc.MaxVersions, 0, pc.DestroyVersionAfter, pc.MaxVersionAge, false)
+				if err := checkDualControlSetting(config, setting); err != nil {
+					return logical.ErrorResponse("config/path/%s: %s", prefix, err), nil
+				}
+			}
+			for prefix, template := range config.Templates {
+				setting := erasingSetting(template.MaxVersions, 0, nil, nil, false)
+				if err := checkDualControlSetting(config, setting); err != nil {
+					return logical.ErrorResponse("templates/%s: %s", prefix, err), nil
+				}
+			}
+		}
+		if err := validateReadonlyMirrors(config); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if err := validateNoopWrites(config); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if err := validateClassifications(config); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if err := validateOwnershipPatterns(config); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if len(config.DefaultCustomMetadata) > 0 {
+			if err := validateCustomMetadata(config, config.DefaultCustomMetadata); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
 		}
 
-		err = req.Storage.Put(ctx, &logical.StorageEntry{
-			Key:   path.Join(b.storagePrefix, configPath),
-			Value: bytes,
-		})
-		if err != nil {
+		if err := b.storeConfig(ctx, req.Storage, config); err != nil {
 			return nil, err
 		}
 
-		b.globalConfigLock.Lock()
-		defer b.globalConfigLock.Unlock()
-
-		b.globalConfig = config
+		if trOk && config.TrackReads {
+			resp := &logical.Response{}
+			resp.AddWarning(trackReadsWarning)
+			return resp, nil
+		}
 
 		return nil, nil
 	}
 }
 
+// storeConfig writes the configuration to storage and caches it. The caller
+// must hold the configWriteLock since it read the configuration it modified.
+func (b *versionedKVBackend) storeConfig(ctx context.Context, s logical.Storage, config *Configuration) error {
+	bytes, err := proto.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	err = s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, configPath),
+		Value: bytes,
+	})
+	if err != nil {
+		return err
+	}
+
+	b.globalConfigLock.Lock()
+	defer b.globalConfigLock.Unlock()
+
+	b.globalConfig = config
+
+	return nil
+}
+
 const confHelpSyn = `Configures settings for the KV store`
 const confHelpDesc = `
 This path configures backend level settings that are applied to every key in the
@@ -146,11 +812,183 @@ key-value store. This parameter accetps:
 	* max_versions (int) - The number of versions to keep for each key. Defaults
 	  to 10
 
+	* max_versions_limit (int) - The largest number of versions a key can
+	  keep. Keys whose max_versions is greater are clamped to this value
+
+	* min_versions (int) - The number of versions of each key that must remain
+	  retrievable. Pruning by max_version_age, deletion and
+	  delete_version_after cannot make the most recent versions
+	  unretrievable. Cannot be greater than max_versions
+
+	* max_version_age (duration) - If set, the age after which versions other
+	  than the current one are removed, in addition to the max_versions
+	  limit. A zero duration clears the current setting.
+
 	* cas_required (bool) - If true, the backend will require the cas parameter
 	  to be set for each write
 
+	* string_values_only (bool) - If true, the backend will reject data writes
+	  containing values that are not strings
+
+	* data_key_pattern (string) - If set, a regular expression that the name
+	  of every field in written data must fully match
+
+	* allowed_paths (list) - If set, the path patterns secrets can be written
+	  to. A "+" segment matches any single path segment and a trailing "*"
+	  matches any suffix
+
+	* denied_paths (list) - The path patterns secrets cannot be written to.
+	  Takes precedence over allowed_paths
+
+	* reference_targets (list) - The path patterns that references can point
+	  to. References cannot be written if it is empty
+
+	* overlay_paths (list) - The path patterns of the overlays that data
+	  reads can merge with the overlay parameter. Overlays cannot be read if
+	  it is empty. The overlay is read with the policy of the secret, so
+	  only overlays that every reader of the secret may see should match
+
+	* reserved_prefixes (list) - The key prefixes that are reserved for content
+	  managed by the backend and cannot be written or deleted by data
+	  operations
+
 	* delete_version_after (duration) - If set, the length of time before a
 	  version is deleted. A negative duration disables the use of
 	  delete_version_after on all keys. A zero duration clears the current
 	  setting. Accepts a Go duration format string.
+
+	* min_delete_version_after (duration) - If set, the shortest
+	  delete_version_after that can be set on a key. A zero duration clears
+	  the current setting.
+
+	* destroy_version_after (duration) - If set, the length of time after its
+	  deletion_time before a version is permanently destroyed, either by the
+	  periodic function or when the secret is read or written. A zero
+	  duration clears the current setting.
+
+	* destroy_confirmation_window (duration) - If set, a destroy, or the
+	  permanent deletion of the metadata of a secret, is only carried out
+	  once an entity other than the requester makes the same request within
+	  the window. While a request waits for its confirmation, the other
+	  entities cannot request a different destroy of the secret. The
+	  settings erasing versions on their own, max_versions
+	  of 1, destroy_version_after, max_version_age and max_history_bytes,
+	  cannot be set while it is, whether on the backend, on a prefix, on a
+	  secret or on the version being written, and the import and the
+	  snapshot restore cannot overwrite existing secrets. A zero duration
+	  clears the current setting.
+
+	* trash_retention (duration) - If set, deleting the metadata of a secret
+	  moves it to the trash, from which it can be restored until it is
+	  purged after this duration. A zero duration clears the current setting.
+
+	* delete_metadata_when_destroyed (bool) - If true, the metadata of the
+	  keys is deleted once all of their versions are destroyed
+
+	* numeric_time_fields (bool) - If true, the responses include the
+	  durations in seconds as <field>_seconds and the timestamps as Unix
+	  epochs as <field>_unix. Reads can override it with the
+	  numeric_time_fields parameter.
+
+	* read_fallback (bool) - If true, reading a secret whose current version
+	  is deleted or destroyed returns its newest readable version with a
+	  warning. Reads can override it with the read_fallback parameter.
+
+	* noop_writes (string) - The handling of the data writes and patches
+	  identical to the current version of the secret: "allow" writes them,
+	  "warn" writes them with a warning and "skip" returns the current
+	  version without writing a new one. Defaults to "allow".
+
+	* deduplicate_versions (bool) - If true, a new version whose data is
+	  identical to a version of the secret that is still kept shares its
+	  stored data instead of storing a copy. The content hash of a version
+	  is only recorded while it is enabled, so the versions written before
+	  it was enabled are not shared.
+
+	* event_changed_keys (bool) - If true, the events and webhook
+	  notifications of data writes and patches hold the comma separated
+	  names of the keys of the data that were added, removed and modified
+	  in "added_keys", "removed_keys" and "modified_keys". The values are
+	  never included.
+
+	* max_snapshots (int) - If set, the number of snapshots kept. Creating a
+	  snapshot deletes the oldest snapshots beyond it.
+
+	* max_bytes (int) - If set, the data writes that would take the versions
+	  stored in the mount over this many bytes are rejected. The usage is
+	  reported by the quotas endpoint.
+
+	* lock_wait_timeout (duration) - If set, the longest time a request waits
+	  for the lock of a contended key before being rejected with a 429 status
+	  code. A zero duration clears the current setting.
+
+	* data_schemas (map) - A map of key prefixes to JSON Schema documents that
+	  data written under the prefix must validate against.
+
+	* required_paths (map) - A map of the keys that are expected to exist to a
+	  comma separated list of the data fields they must contain.
+
+	* readonly_mirrors (map) - A map of mirror prefixes to source prefixes. The
+	  secrets under a source prefix can be read and listed through
+	  readonly-mirror/ under the mirror prefix
+
+	* readonly_mirror_fields (map) - A map of mirror prefixes to a comma
+	  separated list of the data fields exposed by the mirror
+
+	* max_history_bytes (map) - A map of key prefixes to the largest cumulative
+	  size, in bytes, of the stored versions of the keys under the prefix
+
+	* default_custom_metadata (map) - The custom_metadata new keys are created
+	  with. The custom_metadata provided when creating a key overrides the
+	  defaults
+
+	* custom_metadata_max_keys (int) - The largest number of custom_metadata
+	  keys of a secret. Defaults to 64
+
+	* custom_metadata_max_key_length (int) - The longest custom_metadata key,
+	  in bytes. Defaults to 128
+
+	* custom_metadata_max_value_length (int) - The longest custom_metadata
+	  value, in bytes. Defaults to 512. The limits apply when custom_metadata
+	  is written; the existing custom_metadata of secrets is left untouched
+
+	* custom_metadata_required_keys (list) - The custom_metadata keys new
+	  secrets must be created with, either when writing their metadata or
+	  through the default_custom_metadata and templates
+
+	* custom_metadata_allowed_keys (list) - If set, the only custom_metadata
+	  keys secrets can set. A trailing "*" allows every key starting with the
+	  rest of the entry
+
+	* custom_metadata_value_patterns (map) - A map of custom_metadata keys to
+	  regular expressions their values must fully match
+
+	* classifications (list) - The classification labels keys can be given,
+	  from the least to the most sensitive
+
+	* classification_warning_threshold (string) - The classification from
+	  which data reads return a warning
+
+	* owner_pattern (string) - If set, a regular expression that the owner
+	  of every secret must fully match
+
+	* team_pattern (string) - If set, a regular expression that the team of
+	  every secret must fully match
+
+	* track_reads (bool) - If true, the time of the most recent data read of
+	  the keys and the approximate number of reads of their versions are
+	  recorded in their metadata and returned by metadata reads. The reads
+	  are best-effort: those served by performance standbys and secondaries
+	  are not recorded, and those not recorded yet are lost when the node
+	  restarts or steps down
+
+	* seal_wrap_versions (bool) - If true, the version entries are marked for
+	  seal wrapping when they are written
+
+	* encrypt_custom_metadata (bool) - If true, the custom_metadata of the keys
+	  is stored encrypted with the key policy of the backend
+
+	* checkpoint_times (map) - A map of key prefixes to a time of day in UTC, in
+	  the HH:MM format, at which the current version of the keys under the
+	  prefix is recorded as a daily checkpoint.
 `
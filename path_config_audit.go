@@ -0,0 +1,140 @@
+package kv
+
+import (
+	"context"
+	"path"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// auditConfigPath is the location where the audit config is stored.
+const auditConfigPath string = "config/audit"
+
+var (
+	// defaultNonHMACRequestKeys are the request fields carrying labels,
+	// version numbers and check-and-set parameters.
+	defaultNonHMACRequestKeys = []string{"custom_metadata", "options", "version", "versions", "cas_required", "max_versions"}
+
+	// defaultNonHMACResponseKeys are the response fields carrying labels and
+	// version information.
+	defaultNonHMACResponseKeys = []string{"custom_metadata", "metadata", "version", "current_version", "oldest_version", "versions"}
+)
+
+// pathConfigAudit returns the path configuration for the endpoint listing
+// the fields that should not be HMAC'd in the audit logs.
+func pathConfigAudit(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/audit$",
+		Fields: map[string]*framework.FieldSchema{
+			"audit_non_hmac_request_keys": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The request data fields that should be logged in clear by the audit devices.",
+			},
+			"audit_non_hmac_response_keys": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The response data fields that should be logged in clear by the audit devices.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.upgradeCheck(b.pathConfigAuditRead()),
+			logical.UpdateOperation: b.upgradeCheck(b.pathConfigAuditWrite()),
+			logical.DeleteOperation: b.upgradeCheck(b.pathConfigAuditDelete()),
+		},
+
+		HelpSynopsis:    auditHelpSyn,
+		HelpDescription: auditHelpDesc,
+	}
+}
+
+func (b *versionedKVBackend) pathConfigAuditRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.auditConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"audit_non_hmac_request_keys":  config.NonHmacRequestKeys,
+				"audit_non_hmac_response_keys": config.NonHmacResponseKeys,
+			},
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathConfigAuditWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.auditConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		if keysRaw, ok := data.GetOk("audit_non_hmac_request_keys"); ok {
+			config.NonHmacRequestKeys = strutil.RemoveDuplicates(keysRaw.([]string), false)
+		}
+		if keysRaw, ok := data.GetOk("audit_non_hmac_response_keys"); ok {
+			config.NonHmacResponseKeys = strutil.RemoveDuplicates(keysRaw.([]string), false)
+		}
+
+		// The data of the secrets must never be logged in clear
+		for _, keys := range [][]string{config.NonHmacRequestKeys, config.NonHmacResponseKeys} {
+			if strutil.StrListContains(keys, "data") {
+				return logical.ErrorResponse(`"data" cannot be logged without being HMAC'd`), logical.ErrInvalidRequest
+			}
+		}
+
+		bytes, err := proto.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, req.Storage.Put(ctx, &logical.StorageEntry{
+			Key:   path.Join(b.storagePrefix, auditConfigPath),
+			Value: bytes,
+		})
+	}
+}
+
+func (b *versionedKVBackend) pathConfigAuditDelete() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		return nil, req.Storage.Delete(ctx, path.Join(b.storagePrefix, auditConfigPath))
+	}
+}
+
+// auditConfig returns the audit config, if it has not been written the
+// default fields are returned.
+func (b *versionedKVBackend) auditConfig(ctx context.Context, s logical.Storage) (*AuditConfig, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, auditConfigPath))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return &AuditConfig{
+			NonHmacRequestKeys:  append([]string(nil), defaultNonHMACRequestKeys...),
+			NonHmacResponseKeys: append([]string(nil), defaultNonHMACResponseKeys...),
+		}, nil
+	}
+
+	config := &AuditConfig{}
+	if err := proto.Unmarshal(raw.Value, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+const auditHelpSyn = `Configures the fields logged without HMAC by the audit devices.`
+const auditHelpDesc = `
+This endpoint lists the request and response data fields that carry
+human-readable labels and version information, such as custom_metadata, the
+version numbers and the check-and-set parameters, so that they can be logged
+in clear by the audit devices. The "data" field cannot be listed.
+
+Vault applies the non-HMAC fields of a mount from its tuning, the values
+returned by this endpoint use the names of the tuning parameters so that they
+can be passed as is to "sys/mounts/<mount>/tune". Deleting the configuration
+restores the default fields.
+`
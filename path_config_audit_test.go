@@ -0,0 +1,75 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_ConfigAudit(t *testing.T) {
+	b, storage := getBackend(t)
+
+	read := func() *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config/audit",
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	resp := read()
+	if !reflect.DeepEqual(resp.Data["audit_non_hmac_request_keys"], defaultNonHMACRequestKeys) ||
+		!reflect.DeepEqual(resp.Data["audit_non_hmac_response_keys"], defaultNonHMACResponseKeys) {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/audit",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"audit_non_hmac_request_keys": "custom_metadata,versions,custom_metadata",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp = read()
+	if !reflect.DeepEqual(resp.Data["audit_non_hmac_request_keys"], []string{"custom_metadata", "versions"}) ||
+		!reflect.DeepEqual(resp.Data["audit_non_hmac_response_keys"], defaultNonHMACResponseKeys) {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	// The data of the secrets is always HMAC'd
+	req.Data = map[string]interface{}{
+		"audit_non_hmac_response_keys": []string{"data"},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/audit",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp = read()
+	if !reflect.DeepEqual(resp.Data["audit_non_hmac_request_keys"], defaultNonHMACRequestKeys) {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+}
@@ -0,0 +1,165 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathConfigComposite returns the path configuration for CRUD operations on
+// the sources of the composite secrets.
+func pathConfigComposite(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/composite/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the composite secret, read at composite/<path>.",
+			},
+			"sources": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+The paths of the secrets whose data is merged, in order. The fields of the
+later sources override the ones of the earlier ones.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathConfigCompositeWrite()),
+			logical.ReadOperation:   b.upgradeCheck(b.pathConfigCompositeRead()),
+			logical.DeleteOperation: b.upgradeCheck(b.pathConfigCompositeDelete()),
+			logical.ListOperation:   b.upgradeCheck(b.pathConfigCompositeList()),
+		},
+
+		HelpSynopsis:    configCompositeHelpSyn,
+		HelpDescription: configCompositeHelpDesc,
+	}
+}
+
+// validateCompositeSources returns an error if sources is empty or if one of
+// them is not the path of a secret or is listed twice.
+func validateCompositeSources(sources []string) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("no sources provided")
+	}
+
+	seen := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		if source == "" || strings.HasPrefix(source, "/") || strings.HasSuffix(source, "/") {
+			return fmt.Errorf("invalid source %q", source)
+		}
+		if seen[source] {
+			return fmt.Errorf("duplicate source %q", source)
+		}
+		seen[source] = true
+	}
+	return nil
+}
+
+func (b *versionedKVBackend) pathConfigCompositeRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		composite, ok := config.Composites[data.Get("path").(string)]
+		if !ok {
+			return nil, nil
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"sources": composite.Sources,
+			},
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathConfigCompositeWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		p := data.Get("path").(string)
+		if p == "" || strings.HasSuffix(p, "/") {
+			return logical.ErrorResponse("invalid path %q", p), nil
+		}
+
+		sources := data.Get("sources").([]string)
+		if err := validateCompositeSources(sources); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		b.configWriteLock.Lock()
+		defer b.configWriteLock.Unlock()
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		if config.Composites == nil {
+			config.Composites = map[string]*Composite{}
+		}
+		config.Composites[p] = &Composite{
+			Sources: sources,
+		}
+
+		return nil, b.storeConfig(ctx, req.Storage, config)
+	}
+}
+
+func (b *versionedKVBackend) pathConfigCompositeDelete() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		b.configWriteLock.Lock()
+		defer b.configWriteLock.Unlock()
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		p := data.Get("path").(string)
+		if _, ok := config.Composites[p]; !ok {
+			return nil, nil
+		}
+		delete(config.Composites, p)
+
+		return nil, b.storeConfig(ctx, req.Storage, config)
+	}
+}
+
+// pathConfigCompositeList lists the composite secrets whose path starts with
+// the provided prefix.
+func (b *versionedKVBackend) pathConfigCompositeList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := data.Get("path").(string)
+		paths := []string{}
+		for p := range config.Composites {
+			if strings.HasPrefix(p, prefix) {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+
+		return logical.ListResponse(paths), nil
+	}
+}
+
+const configCompositeHelpSyn = `Configures the sources of a composite secret.`
+const configCompositeHelpDesc = `
+This path configures the ordered list of the secrets whose data is merged into
+the composite secret at the same path, read at composite/<path>. For instance,
+a composite merging a shared base configuration with the overrides of an
+application can be consumed as a single secret.
+
+The composites are configured by the operators of the mount. Reading a
+composite returns the data of its sources regardless of the policies of the
+sources, so the access to the composite/ paths should be granted accordingly.
+`
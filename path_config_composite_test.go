@@ -0,0 +1,149 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestValidateCompositeSources(t *testing.T) {
+	for _, tc := range []struct {
+		sources []string
+		valid   bool
+	}{
+		{[]string{"shared/base", "apps/foo/overrides"}, true},
+		{nil, false},
+		{[]string{"shared/"}, false},
+		{[]string{""}, false},
+		{[]string{"shared/base", "shared/base"}, false},
+	} {
+		if err := validateCompositeSources(tc.sources); (err == nil) != tc.valid {
+			t.Fatalf("unexpected result for %v: %v", tc.sources, err)
+		}
+	}
+}
+
+func TestVersionedKV_ConfigComposite(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/composite/apps/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"sources": "shared/base,shared/base",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data["sources"] = "shared/base,apps/foo/overrides"
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/composite/apps/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["sources"], []string{"shared/base", "apps/foo/overrides"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "config/composite/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["keys"], []string{"apps/foo"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/composite/apps/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/composite/apps/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("expected the composite to be deleted, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_ConfigComposite_Concurrent(t *testing.T) {
+	b, s := getBackend(t)
+	storage := &slowPutStorage{Storage: s}
+
+	// The composite secrets share the configuration with the templates, the
+	// concurrent changes of both must not overwrite each other
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      fmt.Sprintf("config/composite/apps/app%d", i),
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"sources": "shared/base",
+			},
+		}
+		if i%2 == 1 {
+			req.Path = fmt.Sprintf("templates/app%d/", i)
+			req.Data = map[string]interface{}{
+				"max_versions": 2,
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err == nil && resp != nil && resp.IsError() {
+				err = resp.Error()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := b.(*versionedKVBackend).config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Composites) != 10 || len(config.Templates) != 10 {
+		t.Fatalf("expected 10 composite secrets and 10 templates, got %d and %d", len(config.Composites), len(config.Templates))
+	}
+}
@@ -0,0 +1,461 @@
+package kv
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// encryptionConfigPath is the location where the encryption config is
+	// stored.
+	encryptionConfigPath string = "config/encryption"
+
+	// encryptionModeLocal stores the version data as is, protected by the
+	// barrier and seal wrapping.
+	encryptionModeLocal = "local"
+
+	// encryptionModeTransit encrypts the version data with a key of a
+	// Transit mount before storing it.
+	encryptionModeTransit = "transit"
+
+	// defaultTransitMountPath is the path of the Transit mount unless set in
+	// the config.
+	defaultTransitMountPath = "transit"
+
+	// transitTimeout bounds the time spent in a request to the Transit
+	// mount.
+	transitTimeout = 30 * time.Second
+
+	// transitMaxRetries is the number of times a failed request to the
+	// Transit mount is retried.
+	transitMaxRetries = 2
+)
+
+// pathConfigEncryption returns the path configuration for selecting how the
+// version data is encrypted.
+func pathConfigEncryption(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/encryption$",
+		Fields: map[string]*framework.FieldSchema{
+			"mode": {
+				Type: framework.TypeString,
+				Description: `
+How the version data is encrypted, "local" to rely on the barrier and seal
+wrapping or "transit" to encrypt it with a key of a Transit mount. Defaults
+to "local".`,
+			},
+			"address": {
+				Type:        framework.TypeString,
+				Description: "The address of the Vault cluster serving the Transit mount.",
+			},
+			"token": {
+				Type:        framework.TypeString,
+				Description: "The token used to call the Transit mount. It is never returned.",
+			},
+			"namespace": {
+				Type:        framework.TypeString,
+				Description: "The namespace of the Transit mount.",
+			},
+			"mount_path": {
+				Type:        framework.TypeString,
+				Description: `The path of the Transit mount. Defaults to "transit".`,
+			},
+			"key_name": {
+				Type:        framework.TypeString,
+				Description: "The name of the Transit key encrypting the version data.",
+			},
+			"ca_cert": {
+				Type: framework.TypeString,
+				Description: `
+The PEM encoded certificate of the authority the certificate of the Vault
+cluster is verified against. The system roots are used if empty.`,
+			},
+			"tls_server_name": {
+				Type:        framework.TypeString,
+				Description: "The name the certificate of the Vault cluster is verified against, if different from the host of the address.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathConfigEncryptionWrite()),
+			logical.ReadOperation:   b.upgradeCheck(b.pathConfigEncryptionRead()),
+		},
+
+		HelpSynopsis:    encryptionHelpSyn,
+		HelpDescription: encryptionHelpDesc,
+	}
+}
+
+func (b *versionedKVBackend) pathConfigEncryptionRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, _, err := b.encryption(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		// The token is never returned
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"mode":            config.Mode,
+				"address":         config.Address,
+				"token_set":       config.Token != "",
+				"namespace":       config.Namespace,
+				"mount_path":      config.MountPath,
+				"key_name":        config.KeyName,
+				"ca_cert":         config.CaCert,
+				"tls_server_name": config.TlsServerName,
+			},
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathConfigEncryptionWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		b.encryptionLock.Lock()
+		defer b.encryptionLock.Unlock()
+
+		config, err := b.readEncryptionConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		if modeRaw, ok := data.GetOk("mode"); ok {
+			config.Mode = modeRaw.(string)
+		}
+		if addressRaw, ok := data.GetOk("address"); ok {
+			config.Address = addressRaw.(string)
+		}
+		if tokenRaw, ok := data.GetOk("token"); ok {
+			config.Token = tokenRaw.(string)
+		}
+		if namespaceRaw, ok := data.GetOk("namespace"); ok {
+			config.Namespace = namespaceRaw.(string)
+		}
+		if mountPathRaw, ok := data.GetOk("mount_path"); ok {
+			config.MountPath = strings.Trim(mountPathRaw.(string), "/")
+		}
+		if keyNameRaw, ok := data.GetOk("key_name"); ok {
+			config.KeyName = keyNameRaw.(string)
+		}
+		if caCertRaw, ok := data.GetOk("ca_cert"); ok {
+			config.CaCert = caCertRaw.(string)
+		}
+		if serverNameRaw, ok := data.GetOk("tls_server_name"); ok {
+			config.TlsServerName = serverNameRaw.(string)
+		}
+
+		switch config.Mode {
+		case encryptionModeLocal:
+		case encryptionModeTransit:
+			if config.Address == "" {
+				return logical.ErrorResponse("missing address"), nil
+			}
+			if err := validateServiceURL(config.Address); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			if config.Token == "" {
+				return logical.ErrorResponse("missing token"), nil
+			}
+			if config.MountPath == "" {
+				return logical.ErrorResponse("mount_path cannot be empty"), nil
+			}
+			if config.KeyName == "" {
+				return logical.ErrorResponse("missing key_name"), nil
+			}
+		default:
+			return logical.ErrorResponse("unknown mode %q, must be %q or %q", config.Mode, encryptionModeLocal, encryptionModeTransit), nil
+		}
+
+		if _, err := parseCACert(config.CaCert); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		client, err := newTransitClient(config)
+		if err != nil {
+			return nil, err
+		}
+
+		// Make sure the key can be used before any version is encrypted
+		// with it
+		if config.Mode == encryptionModeTransit {
+			if _, err := transitEncrypt(ctx, client, config.MountPath, config.KeyName, []byte("{}")); err != nil {
+				return logical.ErrorResponse("the Transit key cannot be used: %s", err), nil
+			}
+		}
+
+		return nil, b.writeEncryptionConfig(ctx, req.Storage, config, client)
+	}
+}
+
+// encryption returns the encryption config and the client of the Transit
+// mount it refers to, if any.
+func (b *versionedKVBackend) encryption(ctx context.Context, s logical.Storage) (*EncryptionConfig, *api.Client, error) {
+	b.encryptionLock.RLock()
+	if b.encryptionConfig != nil {
+		defer b.encryptionLock.RUnlock()
+		return b.encryptionConfig, b.transitClient, nil
+	}
+	b.encryptionLock.RUnlock()
+	b.encryptionLock.Lock()
+	defer b.encryptionLock.Unlock()
+
+	if b.encryptionConfig != nil {
+		return b.encryptionConfig, b.transitClient, nil
+	}
+
+	config, err := b.readEncryptionConfig(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := newTransitClient(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Cache the value
+	b.encryptionConfig = config
+	b.transitClient = client
+
+	return config, client, nil
+}
+
+// readEncryptionConfig reads the encryption config from storage. The caller
+// must hold encryptionLock.
+func (b *versionedKVBackend) readEncryptionConfig(ctx context.Context, s logical.Storage) (*EncryptionConfig, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, encryptionConfigPath))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &EncryptionConfig{
+		Mode:      encryptionModeLocal,
+		MountPath: defaultTransitMountPath,
+	}
+	if raw == nil {
+		return config, nil
+	}
+	if err := proto.Unmarshal(raw.Value, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// writeEncryptionConfig stores the encryption config and caches it with the
+// client of its Transit mount. The caller must hold encryptionLock.
+func (b *versionedKVBackend) writeEncryptionConfig(ctx context.Context, s logical.Storage, config *EncryptionConfig, client *api.Client) error {
+	bytes, err := proto.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	err = s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, encryptionConfigPath),
+		Value: bytes,
+	})
+	if err != nil {
+		return err
+	}
+
+	b.encryptionConfig = config
+	b.transitClient = client
+	return nil
+}
+
+// newTransitClient returns a client of the Vault cluster serving the Transit
+// mount of config, or nil if no cluster is configured. The client is built
+// from config alone: the VAULT_* environment of the plugin process is not
+// used.
+func newTransitClient(config *EncryptionConfig) (*api.Client, error) {
+	if config.Address == "" {
+		return nil, nil
+	}
+
+	httpClient, err := newHTTPClient(transitTimeout, config.CaCert, config.TlsServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := api.NewClient(&api.Config{
+		Address:    config.Address,
+		HttpClient: httpClient,
+		Timeout:    transitTimeout,
+		MaxRetries: transitMaxRetries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// NewClient still reads the token and the namespace from the
+	// environment
+	client.SetToken(config.Token)
+	if config.Namespace != "" {
+		client.SetNamespace(config.Namespace)
+	} else {
+		client.ClearNamespace()
+	}
+
+	return client, nil
+}
+
+// renewTransitToken renews the token of the Transit mount once half of its
+// TTL has elapsed, so that a renewable token does not expire while versions
+// are encrypted with it. The tokens that are not renewable, or do not expire,
+// are left untouched.
+func (b *versionedKVBackend) renewTransitToken(ctx context.Context, s logical.Storage) error {
+	_, client, err := b.encryption(ctx, s)
+	if err != nil || client == nil {
+		return err
+	}
+
+	secret, err := client.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("looking up the Transit token: %w", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return errors.New("empty response looking up the Transit token")
+	}
+
+	renewable, err := secret.TokenIsRenewable()
+	if err != nil || !renewable {
+		return err
+	}
+	ttl, err := secret.TokenTTL()
+	if err != nil || ttl == 0 {
+		return err
+	}
+	creationTTL, err := parseutil.ParseDurationSecond(secret.Data["creation_ttl"])
+	if err != nil {
+		return err
+	}
+	if ttl > creationTTL/2 {
+		return nil
+	}
+
+	if _, err := client.Auth().Token().RenewSelfWithContext(ctx, 0); err != nil {
+		return fmt.Errorf("renewing the Transit token: %w", err)
+	}
+	return nil
+}
+
+// transitEncrypt encrypts plaintext with the key keyName of the Transit mount
+// mounted at mountPath.
+func transitEncrypt(ctx context.Context, client *api.Client, mountPath, keyName string, plaintext []byte) (string, error) {
+	secret, err := client.Logical().WriteWithContext(ctx, path.Join(mountPath, "encrypt", keyName), map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", errors.New("empty response from the Transit mount")
+	}
+
+	ciphertext, ok := secret.Data["ciphertext"].(string)
+	if !ok || ciphertext == "" {
+		return "", errors.New("no ciphertext returned by the Transit mount")
+	}
+
+	return ciphertext, nil
+}
+
+// transitDecrypt decrypts ciphertext with the key keyName of the Transit mount
+// mounted at mountPath.
+func transitDecrypt(ctx context.Context, client *api.Client, mountPath, keyName, ciphertext string) ([]byte, error) {
+	secret, err := client.Logical().WriteWithContext(ctx, path.Join(mountPath, "decrypt", keyName), map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("empty response from the Transit mount")
+	}
+
+	plaintext, ok := secret.Data["plaintext"].(string)
+	if !ok {
+		return nil, errors.New("no plaintext returned by the Transit mount")
+	}
+
+	return base64.StdEncoding.DecodeString(plaintext)
+}
+
+// encryptVersion encrypts the data of version with the Transit key when the
+// transit encryption mode is enabled.
+func (b *versionedKVBackend) encryptVersion(ctx context.Context, s logical.Storage, version *Version) error {
+	config, client, err := b.encryption(ctx, s)
+	if err != nil {
+		return err
+	}
+	if config.Mode != encryptionModeTransit {
+		return nil
+	}
+
+	ciphertext, err := transitEncrypt(ctx, client, config.MountPath, config.KeyName, version.Data)
+	if err != nil {
+		return fmt.Errorf("encrypting version data with Transit: %w", err)
+	}
+
+	version.TransitCiphertext = ciphertext
+	version.TransitKey = config.KeyName
+	version.Data = nil
+	return nil
+}
+
+// versionPlaintext returns the data of version, decrypting it with the
+// Transit key it was encrypted with if needed. Versions written before the
+// mode changed remain readable as long as the Transit mount is configured.
+func (b *versionedKVBackend) versionPlaintext(ctx context.Context, s logical.Storage, version *Version) ([]byte, error) {
+	if version.TransitCiphertext == "" {
+		return version.Data, nil
+	}
+
+	config, client, err := b.encryption(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, errors.New("version data encrypted with Transit but no Transit mount is configured")
+	}
+
+	plaintext, err := transitDecrypt(ctx, client, config.MountPath, version.TransitKey, version.TransitCiphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting version data with Transit: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+const encryptionHelpSyn = `Configures how the version data is encrypted.`
+const encryptionHelpDesc = `
+By default the version data is stored as is, protected by the barrier and
+seal wrapping. Setting "mode" to "transit" encrypts the data of every new
+version with the key "key_name" of a Transit mount before storing it, so that
+the key management team keeps custody of the wrapping key outside the KV
+plugin. The Transit mount at "mount_path" is called on the Vault cluster at
+"address" with "token", in "namespace" if set. The certificate of the cluster
+is verified against "ca_cert" and "tls_server_name" if set. The client is
+built from this config alone, the VAULT_* environment of the plugin is not
+used. The key is checked when the config is written.
+
+The token is renewed by the periodic maintenance once half of its TTL has
+elapsed. A token that is not renewable, or that reaches its max TTL, must be
+replaced by writing a new "token" before it expires.
+
+The key a version was encrypted with is recorded with the version: switching
+back to "local" or to another key leaves the existing versions encrypted and
+readable as long as the Transit mount remains configured and the token can
+decrypt with their key. Cloud KMS services are not called directly.
+
+The token is never returned, "token_set" reports whether it is configured.
+`
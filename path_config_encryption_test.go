@@ -0,0 +1,267 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// newTransitServer returns a server emulating the encrypt and decrypt
+// endpoints of a Transit mount holding the key "kv".
+func newTransitServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.transit" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+
+		var data map[string]string
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/kv":
+			data = map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]}
+		case "/v1/transit/decrypt/kv":
+			data = map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"], "vault:v1:")}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestVersionedKV_Config_Encryption(t *testing.T) {
+	srv := newTransitServer(t)
+	defer srv.Close()
+
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/local",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{"bar": "local"},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The key is checked when the config is written
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/encryption",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"mode":     "transit",
+			"address":  srv.URL,
+			"token":    "s.transit",
+			"key_name": "missing",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data["key_name"] = "kv"
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/encryption",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["mode"] != "transit" || resp.Data["token_set"] != true || resp.Data["mount_path"] != "transit" || resp.Data["token"] != nil {
+		t.Fatalf("unexpected config: %#v", resp.Data)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{"bar": "baz"},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	versionKey, err := b.(*versionedKVBackend).getVersionKey(context.Background(), "foo", 1, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := storage.Get(context.Background(), versionKey)
+	if err != nil || raw == nil {
+		t.Fatalf("err:%s entry:%#v\n", err, raw)
+	}
+	version := &Version{}
+	if err := proto.Unmarshal(raw.Value, version); err != nil {
+		t.Fatal(err)
+	}
+	if len(version.Data) != 0 || !strings.HasPrefix(version.TransitCiphertext, "vault:v1:") || version.TransitKey != "kv" {
+		t.Fatalf("expected the version data to be encrypted, got %#v", version)
+	}
+
+	// The versions written in either mode are readable
+	for key, want := range map[string]string{"foo": "baz", "local": "local"} {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if resp.Data["data"].(map[string]interface{})["bar"] != want {
+			t.Fatalf("bad response %#v", resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.PatchOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{"other": "value"},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	data := resp.Data["data"].(map[string]interface{})
+	if data["bar"] != "baz" || data["other"] != "value" {
+		t.Fatalf("bad response %#v", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/encryption",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"mode": "kms",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Config_Encryption_TransitClient(t *testing.T) {
+	// The environment of the plugin process is not used
+	t.Setenv("VAULT_TOKEN", "s.env")
+	t.Setenv("VAULT_NAMESPACE", "env")
+
+	var renewals int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.transit" || r.Header.Get("X-Vault-Namespace") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		var data map[string]interface{}
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/kv":
+			data = map[string]interface{}{"ciphertext": "vault:v1:e30="}
+		case "/v1/auth/token/lookup-self":
+			data = map[string]interface{}{"renewable": true, "ttl": 600, "creation_ttl": 3600}
+		case "/v1/auth/token/renew-self":
+			atomic.AddInt32(&renewals, 1)
+			json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": "s.transit", "renewable": true, "lease_duration": 3600}})
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer srv.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+	b, storage := getBackend(t)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	config := map[string]interface{}{
+		"mode":     "transit",
+		"address":  srv.URL,
+		"token":    "s.transit",
+		"key_name": "kv",
+	}
+
+	// The certificate of the cluster is not trusted without the ca_cert
+	resp, err := handle(logical.UpdateOperation, "config/encryption", config)
+	if err != nil || resp == nil || !strings.Contains(resp.Error().Error(), "certificate") {
+		t.Fatalf("expected a certificate error, err:%s resp:%#v\n", err, resp)
+	}
+
+	config["ca_cert"] = "not a certificate"
+	resp, err = handle(logical.UpdateOperation, "config/encryption", config)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	config["ca_cert"] = caCert
+	config["tls_server_name"] = "example.com"
+	resp, err = handle(logical.UpdateOperation, "config/encryption", config)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The token is renewed once half of its TTL has elapsed
+	if _, err := handle(logical.RollbackOperation, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&renewals) != 1 {
+		t.Fatalf("expected the token to be renewed once, got %d", renewals)
+	}
+}
@@ -0,0 +1,497 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// enrichmentConfigPath is the location where the enrichment integration
+	// config is stored.
+	enrichmentConfigPath string = "config/enrichment"
+
+	// defaultEnrichmentInterval is the time between two synchronizations
+	// unless set in the config.
+	defaultEnrichmentInterval = time.Hour
+
+	// defaultEnrichmentNamespace is the prefix of the custom_metadata keys
+	// managed by the synchronization unless set in the config.
+	defaultEnrichmentNamespace = "cmdb/"
+
+	// enrichmentTimeout bounds the time spent fetching the annotations.
+	enrichmentTimeout = 30 * time.Second
+
+	// maxEnrichmentResponseSize is the largest annotation document that
+	// will be read from the source.
+	maxEnrichmentResponseSize = 32 << 20
+)
+
+// pathConfigEnrichment returns the path configuration for the integration
+// synchronizing custom_metadata annotations from an external source.
+func pathConfigEnrichment(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/enrichment$",
+		Fields: map[string]*framework.FieldSchema{
+			"url": {
+				Type: framework.TypeString,
+				Description: `
+The HTTP endpoint the annotations are pulled from. It must return a JSON
+object mapping path patterns to objects of string annotations.`,
+			},
+			"interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "The time between two synchronizations. Defaults to 1h.",
+			},
+			"namespace": {
+				Type: framework.TypeString,
+				Description: `
+The prefix of the custom_metadata keys managed by the synchronization. Keys
+under this prefix cannot be set through the metadata endpoint. Defaults to
+"cmdb/".`,
+			},
+			"ca_cert": {
+				Type: framework.TypeString,
+				Description: `
+The PEM encoded certificate of the authority the certificate of the endpoint
+is verified against. The system roots are used if empty.`,
+			},
+			"tls_server_name": {
+				Type:        framework.TypeString,
+				Description: "The name the certificate of the endpoint is verified against, if different from the host of the url.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.upgradeCheck(b.pathConfigEnrichmentWrite()),
+				Summary:  "Configure the synchronization of annotations from an external source.",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.upgradeCheck(b.pathConfigEnrichmentRead()),
+				Summary:  "Read the synchronization settings and status.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.upgradeCheck(b.pathConfigEnrichmentDelete()),
+				Summary:  "Disable the synchronization.",
+			},
+		},
+
+		HelpSynopsis:    enrichmentHelpSyn,
+		HelpDescription: enrichmentHelpDesc,
+	}
+}
+
+func (b *versionedKVBackend) pathConfigEnrichmentRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.enrichmentConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			return nil, nil
+		}
+
+		interval, err := ptypes.Duration(config.Interval)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"url":             config.Url,
+				"interval":        interval.String(),
+				"namespace":       config.Namespace,
+				"last_sync_time":  ptypesTimestampToString(config.LastSyncTime),
+				"last_error":      config.LastError,
+				"last_updated":    config.LastUpdated,
+				"last_job_id":     config.LastJobId,
+				"ca_cert":         config.CaCert,
+				"tls_server_name": config.TlsServerName,
+			},
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathConfigEnrichmentWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		b.enrichmentLock.Lock()
+		defer b.enrichmentLock.Unlock()
+
+		config, err := b.enrichmentConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			config = &EnrichmentConfig{
+				Interval:  ptypes.DurationProto(defaultEnrichmentInterval),
+				Namespace: defaultEnrichmentNamespace,
+			}
+		}
+
+		if urlRaw, ok := data.GetOk("url"); ok {
+			config.Url = urlRaw.(string)
+		}
+		if intervalRaw, ok := data.GetOk("interval"); ok {
+			if intervalRaw.(int) <= 0 {
+				return logical.ErrorResponse("interval must be positive"), nil
+			}
+			config.Interval = ptypes.DurationProto(time.Duration(intervalRaw.(int)) * time.Second)
+		}
+		if namespaceRaw, ok := data.GetOk("namespace"); ok {
+			config.Namespace = namespaceRaw.(string)
+		}
+		if caCertRaw, ok := data.GetOk("ca_cert"); ok {
+			config.CaCert = caCertRaw.(string)
+		}
+		if serverNameRaw, ok := data.GetOk("tls_server_name"); ok {
+			config.TlsServerName = serverNameRaw.(string)
+		}
+
+		if config.Url == "" {
+			return logical.ErrorResponse("missing url"), nil
+		}
+		if err := validateServiceURL(config.Url); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if _, err := parseCACert(config.CaCert); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if config.Namespace == "" {
+			return logical.ErrorResponse("namespace cannot be empty"), nil
+		}
+
+		// Force a synchronization with the new settings
+		config.LastSyncTime = nil
+
+		return nil, b.writeEnrichmentConfig(ctx, req.Storage, config)
+	}
+}
+
+func (b *versionedKVBackend) pathConfigEnrichmentDelete() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		b.enrichmentLock.Lock()
+		defer b.enrichmentLock.Unlock()
+
+		return nil, req.Storage.Delete(ctx, path.Join(b.storagePrefix, enrichmentConfigPath))
+	}
+}
+
+// enrichmentConfig returns the enrichment integration config, if it has not
+// been configured it will return nil.
+func (b *versionedKVBackend) enrichmentConfig(ctx context.Context, s logical.Storage) (*EnrichmentConfig, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, enrichmentConfigPath))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	config := &EnrichmentConfig{}
+	if err := proto.Unmarshal(raw.Value, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (b *versionedKVBackend) writeEnrichmentConfig(ctx context.Context, s logical.Storage, config *EnrichmentConfig) error {
+	bytes, err := proto.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, enrichmentConfigPath),
+		Value: bytes,
+	})
+}
+
+// enrichmentNamespace returns the prefix of the custom_metadata keys managed
+// by the enrichment integration, or an empty string if it is not configured.
+func (b *versionedKVBackend) enrichmentNamespace(ctx context.Context, s logical.Storage) (string, error) {
+	config, err := b.enrichmentConfig(ctx, s)
+	if err != nil || config == nil {
+		return "", err
+	}
+
+	return config.Namespace, nil
+}
+
+// syncEnrichment starts a synchronization of the annotations if the
+// synchronization interval has elapsed and no synchronization is running. The
+// annotations are pulled and merged in the custom_metadata of the matching
+// keys in the background, so that the periodic maintenance is not held up.
+func (b *versionedKVBackend) syncEnrichment(ctx context.Context, s logical.Storage) error {
+	b.enrichmentLock.Lock()
+	defer b.enrichmentLock.Unlock()
+
+	if b.enrichmentSyncing {
+		return nil
+	}
+
+	config, err := b.enrichmentConfig(ctx, s)
+	if err != nil || config == nil {
+		return err
+	}
+
+	if config.LastSyncTime != nil {
+		lastSync, err := ptypes.Timestamp(config.LastSyncTime)
+		if err != nil {
+			return err
+		}
+		interval, err := ptypes.Duration(config.Interval)
+		if err != nil {
+			return err
+		}
+		if time.Since(lastSync) < interval {
+			return nil
+		}
+	}
+
+	client, err := newHTTPClient(enrichmentTimeout, config.CaCert, config.TlsServerName)
+	if err != nil {
+		return err
+	}
+
+	// The synchronization is recorded as started so that the next one waits
+	// for the interval whatever its outcome
+	config.LastSyncTime = ptypes.TimestampNow()
+	config.LastUpdated = 0
+	config.LastError = ""
+	config.LastJobId = ""
+	if err := b.writeEnrichmentConfig(ctx, s, config); err != nil {
+		return err
+	}
+
+	b.enrichmentSyncing = true
+	b.jobsWG.Add(1)
+	go func() {
+		defer b.jobsWG.Done()
+		b.runEnrichment(s, client, config)
+	}()
+
+	return nil
+}
+
+// runEnrichment fetches the annotations and starts the job updating every key
+// whose managed custom_metadata differs from them. The keys that cannot be
+// updated are skipped and reported by the job.
+func (b *versionedKVBackend) runEnrichment(s logical.Storage, client *http.Client, config *EnrichmentConfig) {
+	ctx := b.jobsCtx
+
+	annotations, err := fetchAnnotations(ctx, client, config.Url)
+	if err != nil {
+		b.finishEnrichment(ctx, s, config, nil, fmt.Errorf("failed to fetch annotations: %w", err))
+		return
+	}
+
+	// Apply the least specific patterns first so that longer ones take
+	// precedence
+	patterns := make([]string, 0, len(annotations))
+	for pattern := range annotations {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) < len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	keys, err := b.collectKeys(ctx, s, "")
+	if err != nil {
+		b.finishEnrichment(ctx, s, config, nil, err)
+		return
+	}
+
+	job, err := b.startJob(ctx, s, "enrichment", "", keys, func(ctx context.Context, key string) (bool, error) {
+		desired := map[string]string{}
+		for _, pattern := range patterns {
+			if !matchPathPattern(pattern, key) {
+				continue
+			}
+			for name, value := range annotations[pattern] {
+				desired[config.Namespace+name] = value
+			}
+		}
+
+		return b.enrichKey(ctx, s, key, config.Namespace, desired)
+	}, func(ctx context.Context, job *Job) error {
+		var syncErr error
+		if job.Failed > 0 {
+			syncErr = fmt.Errorf("failed to update %d of %d keys, see jobs/%s", job.Failed, job.Total, job.Id)
+		}
+		b.finishEnrichment(ctx, s, config, job, syncErr)
+		return nil
+	})
+	if err != nil {
+		b.finishEnrichment(ctx, s, config, nil, err)
+		return
+	}
+
+	b.enrichmentLock.Lock()
+	defer b.enrichmentLock.Unlock()
+
+	current, err := b.enrichmentConfig(ctx, s)
+	if err != nil || current == nil || current.Url != config.Url || current.Namespace != config.Namespace {
+		return
+	}
+	current.LastJobId = job.Id
+	if err := b.writeEnrichmentConfig(ctx, s, current); err != nil {
+		b.Logger().Error("failed to record the synchronization job", "job_id", job.Id, "error", err)
+	}
+}
+
+// finishEnrichment records the outcome of a synchronization in the config so
+// that it can be reported to operators. job is nil if the synchronization
+// failed before its job was started.
+func (b *versionedKVBackend) finishEnrichment(ctx context.Context, s logical.Storage, config *EnrichmentConfig, job *Job, syncErr error) {
+	if syncErr != nil {
+		b.Logger().Error("failed to synchronize annotations", "url", config.Url, "error", syncErr)
+	}
+
+	b.enrichmentLock.Lock()
+	defer b.enrichmentLock.Unlock()
+
+	b.enrichmentSyncing = false
+
+	// Re-read the config so that a concurrent update is not overwritten
+	current, err := b.enrichmentConfig(ctx, s)
+	if err != nil || current == nil {
+		return
+	}
+	if current.Url != config.Url || current.Namespace != config.Namespace {
+		return
+	}
+
+	current.LastError = ""
+	if syncErr != nil {
+		current.LastError = syncErr.Error()
+	}
+	if job != nil {
+		current.LastJobId = job.Id
+		current.LastUpdated = job.Updated
+	}
+
+	if err := b.writeEnrichmentConfig(ctx, s, current); err != nil {
+		b.Logger().Error("failed to record the synchronization", "error", err)
+	}
+}
+
+// enrichKey replaces the custom_metadata entries of key under namespace with
+// desired. It returns true if the metadata was modified.
+func (b *versionedKVBackend) enrichKey(ctx context.Context, s logical.Storage, key, namespace string, desired map[string]string) (bool, error) {
+	config, err := b.keyConfig(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil || meta == nil {
+		return false, err
+	}
+
+	customMetadata := make(map[string]string, len(meta.CustomMetadata)+len(desired))
+	var modified bool
+	for k, v := range meta.CustomMetadata {
+		if !strings.HasPrefix(k, namespace) {
+			customMetadata[k] = v
+			continue
+		}
+		if desired[k] != v {
+			modified = true
+		}
+	}
+	for k, v := range desired {
+		if meta.CustomMetadata[k] != v {
+			modified = true
+		}
+		customMetadata[k] = v
+	}
+
+	if !modified {
+		return false, nil
+	}
+
+	if err := validateCustomMetadata(config, customMetadata); err != nil {
+		return false, err
+	}
+
+	meta.CustomMetadata = customMetadata
+	return true, b.writeKeyMetadata(ctx, s, meta)
+}
+
+// fetchAnnotations retrieves the JSON document mapping path patterns to
+// annotations from url.
+func fetchAnnotations(ctx context.Context, client *http.Client, url string) (map[string]map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+	}
+
+	annotations := map[string]map[string]string{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxEnrichmentResponseSize)).Decode(&annotations); err != nil {
+		return nil, fmt.Errorf("failed to decode annotations: %w", err)
+	}
+
+	return annotations, nil
+}
+
+const enrichmentHelpSyn = `Synchronizes custom_metadata annotations from an external source.`
+const enrichmentHelpDesc = `
+This path configures an integration that periodically pulls annotations, such
+as the owner or the cost center of a secret, from an HTTP endpoint and merges
+them in the custom_metadata of the matching keys. This parameter accepts:
+
+	* url (string) - The HTTP endpoint the annotations are pulled from. It
+	  must return a JSON object mapping path patterns to objects of string
+	  annotations, for instance {"prod/+/db": {"owner": "dba"}}. Patterns use
+	  the syntax of allowed_paths and the longest matching pattern wins
+
+	* interval (duration) - The time between two synchronizations. Defaults
+	  to 1h
+
+	* namespace (string) - The prefix of the custom_metadata keys managed by
+	  the synchronization. Keys under this prefix cannot be set through the
+	  metadata endpoint. Defaults to "cmdb/"
+
+	* ca_cert (string) - The PEM encoded certificate of the authority the
+	  certificate of the endpoint is verified against. The system roots are
+	  used if empty
+
+	* tls_server_name (string) - The name the certificate of the endpoint is
+	  verified against, if different from the host of the url
+
+The annotations are fetched with a timeout and the keys are updated by a
+background job, whose ID is returned in last_job_id. The keys that cannot be
+updated are skipped and reported by the job. Reading this path also returns
+the time, number of keys updated and error of the last synchronization.
+`
@@ -0,0 +1,395 @@
+package kv
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathConfigPath returns the path configuration for CRUD operations on the
+// settings of a prefix.
+func pathConfigPath(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/path/" + framework.MatchAllRegex("prefix"),
+		Fields: map[string]*framework.FieldSchema{
+			"prefix": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the keys the settings apply to.",
+			},
+			"max_versions": {
+				Type:        framework.TypeInt,
+				Description: "The number of versions to keep for each key under the prefix. Zero uses the backend's max_versions.",
+			},
+			"cas_required": {
+				Type:        framework.TypeBool,
+				Description: "If true, the cas parameter is required for each write to the keys under the prefix",
+			},
+			"cas_optional": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the cas parameter is not required for the writes to the keys under
+the prefix even if the backend's cas_required is set. The cas_required of the
+keys still applies.`,
+			},
+			"delete_version_after": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+The delete_version_after of the keys under the prefix. Cannot be less than the
+backend's min_delete_version_after. A zero duration uses the backend's
+delete_version_after.`,
+			},
+			"destroy_version_after": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+The destroy_version_after of the keys under the prefix. A zero duration uses
+the backend's destroy_version_after.`,
+			},
+			"max_version_age": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+The max_version_age of the keys under the prefix. A zero duration uses the
+backend's max_version_age.`,
+			},
+			"read_rate_limit": {
+				Type: framework.TypeFloat,
+				Description: `
+The largest number of data reads per second of the keys under the prefix,
+combined, including the reads through composites, overlays, references and
+read-only mirrors. Zero disables the limit.`,
+			},
+			"write_rate_limit": {
+				Type: framework.TypeFloat,
+				Description: `
+The largest number of data writes, patches and deletes per second of the keys
+under the prefix, combined, including the field renames, the field injections
+and the imports. Zero disables the limit.`,
+			},
+			"max_keys": {
+				Type: framework.TypeInt,
+				Description: `
+The largest number of keys under the prefix. The creation of new keys is
+rejected once it is reached. Zero disables the quota.`,
+			},
+			"max_bytes": {
+				Type: framework.TypeInt,
+				Description: `
+The largest number of bytes of the versions stored under the prefix. The data
+writes over it are rejected. Zero disables the quota.`,
+			},
+			"max_keys_warning_threshold": {
+				Type: framework.TypeInt,
+				Description: `
+The number of keys under the prefix from which the creation of new keys
+returns a warning. Zero disables the warning.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathConfigPathWrite()),
+			logical.ReadOperation:   b.upgradeCheck(b.pathConfigPathRead()),
+			logical.DeleteOperation: b.upgradeCheck(b.pathConfigPathDelete()),
+			logical.ListOperation:   b.upgradeCheck(b.pathConfigPathList()),
+		},
+
+		HelpSynopsis:    configPathHelpSyn,
+		HelpDescription: configPathHelpDesc,
+	}
+}
+
+// keyPathConfig returns the settings of the longest prefix matching key along
+// with the prefix, or nil if no prefix matches.
+func keyPathConfig(config *Configuration, key string) (string, *PathConfig) {
+	var pc *PathConfig
+	var longest string
+	for prefix, c := range config.PathConfigs {
+		if strings.HasPrefix(key, prefix) && (pc == nil || len(prefix) > len(longest)) {
+			pc, longest = c, prefix
+		}
+	}
+	return longest, pc
+}
+
+// applyPathConfig overrides the settings of the configuration with the ones
+// set for the longest prefix matching key.
+func applyPathConfig(config *Configuration, key string) {
+	_, pc := keyPathConfig(config, key)
+	if pc == nil {
+		return
+	}
+
+	if pc.MaxVersions > 0 {
+		config.MaxVersions = pc.MaxVersions
+	}
+	switch {
+	case pc.CasRequired:
+		config.CasRequired = true
+	case pc.CasOptional:
+		config.CasRequired = false
+	}
+	if dva := deleteVersionAfter(pc); dva > 0 {
+		config.DeleteVersionAfter = ptypes.DurationProto(dva)
+	}
+	if destroy := destroyVersionAfter(pc); destroy > 0 {
+		config.DestroyVersionAfter = ptypes.DurationProto(destroy)
+	}
+	if age := getMaxVersionAge(pc); age > 0 {
+		config.MaxVersionAge = ptypes.DurationProto(age)
+	}
+}
+
+// keyConfig returns the configuration of the mount with the settings of the
+// longest prefix matching key applied.
+func (b *versionedKVBackend) keyConfig(ctx context.Context, s logical.Storage, key string) (*Configuration, error) {
+	config, err := b.config(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	applyPathConfig(config, key)
+
+	return config, nil
+}
+
+func (b *versionedKVBackend) pathConfigPathRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		pc, ok := config.PathConfigs[data.Get("prefix").(string)]
+		if !ok {
+			return nil, nil
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"max_versions":               pc.MaxVersions,
+				"cas_required":               pc.CasRequired,
+				"cas_optional":               pc.CasOptional,
+				"delete_version_after":       deleteVersionAfter(pc).String(),
+				"destroy_version_after":      destroyVersionAfter(pc).String(),
+				"max_version_age":            getMaxVersionAge(pc).String(),
+				"read_rate_limit":            pc.ReadRateLimit,
+				"write_rate_limit":           pc.WriteRateLimit,
+				"max_keys":                   pc.MaxKeys,
+				"max_keys_warning_threshold": pc.MaxKeysWarningThreshold,
+				"max_bytes":                  pc.MaxBytes,
+			},
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathConfigPathWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("prefix").(string)
+		if prefix == "" {
+			return logical.ErrorResponse("missing prefix"), nil
+		}
+
+		b.configWriteLock.Lock()
+		defer b.configWriteLock.Unlock()
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		pc, ok := config.PathConfigs[prefix]
+		if !ok {
+			pc = &PathConfig{}
+		}
+
+		previousMaxVersions := pc.MaxVersions
+		maxRaw, maxOk := data.GetOk("max_versions")
+		if maxOk {
+			pc.MaxVersions = uint32(maxRaw.(int))
+		}
+		if casRaw, ok := data.GetOk("cas_required"); ok {
+			pc.CasRequired = casRaw.(bool)
+		}
+		if casOptionalRaw, ok := data.GetOk("cas_optional"); ok {
+			pc.CasOptional = casOptionalRaw.(bool)
+		}
+		if pc.CasRequired && pc.CasOptional {
+			return logical.ErrorResponse("cas_required and cas_optional cannot both be set"), nil
+		}
+		if dvaRaw, ok := data.GetOk("delete_version_after"); ok {
+			dva := time.Duration(dvaRaw.(int)) * time.Second
+			switch min := minDeleteVersionAfter(config); {
+			case dva < 0:
+				return logical.ErrorResponse("delete_version_after cannot be negative"), nil
+			case dva == 0:
+				pc.DeleteVersionAfter = nil
+			case dva < min:
+				return logical.ErrorResponse("delete_version_after %s is less than the min_delete_version_after %s configured on the backend", dva, min), nil
+			default:
+				pc.DeleteVersionAfter = ptypes.DurationProto(dva)
+			}
+		}
+		destroyRaw, destroyOk := data.GetOk("destroy_version_after")
+		if destroyOk {
+			if destroy := destroyRaw.(int); destroy > 0 {
+				pc.DestroyVersionAfter = ptypes.DurationProto(time.Duration(destroy) * time.Second)
+			} else {
+				pc.DestroyVersionAfter = nil
+			}
+		}
+		ageRaw, ageOk := data.GetOk("max_version_age")
+		if ageOk {
+			if age := ageRaw.(int); age > 0 {
+				pc.MaxVersionAge = ptypes.DurationProto(time.Duration(age) * time.Second)
+			} else {
+				pc.MaxVersionAge = nil
+			}
+		}
+
+		if readRateRaw, ok := data.GetOk("read_rate_limit"); ok {
+			if readRateRaw.(float64) < 0 {
+				return logical.ErrorResponse("read_rate_limit cannot be negative"), nil
+			}
+			pc.ReadRateLimit = readRateRaw.(float64)
+		}
+		if writeRateRaw, ok := data.GetOk("write_rate_limit"); ok {
+			if writeRateRaw.(float64) < 0 {
+				return logical.ErrorResponse("write_rate_limit cannot be negative"), nil
+			}
+			pc.WriteRateLimit = writeRateRaw.(float64)
+		}
+
+		if maxKeysRaw, ok := data.GetOk("max_keys"); ok {
+			if maxKeysRaw.(int) < 0 {
+				return logical.ErrorResponse("max_keys cannot be negative"), nil
+			}
+			pc.MaxKeys = uint32(maxKeysRaw.(int))
+		}
+		if maxBytesRaw, ok := data.GetOk("max_bytes"); ok {
+			if maxBytesRaw.(int) < 0 {
+				return logical.ErrorResponse("max_bytes cannot be negative"), nil
+			}
+			pc.MaxBytes = uint64(maxBytesRaw.(int))
+		}
+		if thresholdRaw, ok := data.GetOk("max_keys_warning_threshold"); ok {
+			if thresholdRaw.(int) < 0 {
+				return logical.ErrorResponse("max_keys_warning_threshold cannot be negative"), nil
+			}
+			pc.MaxKeysWarningThreshold = uint32(thresholdRaw.(int))
+		}
+
+		if limit := config.MaxVersionsLimit; limit > 0 && pc.MaxVersions > limit {
+			return logical.ErrorResponse("max_versions %d is greater than max_versions_limit %d", pc.MaxVersions, limit), nil
+		}
+
+		// The settings erasing versions on their own would bypass the
+		// confirmation of destroys
+		setting := erasingSetting(
+			maxVersionsIf(maxOk, pc.MaxVersions),
+			previousMaxVersions,
+			durationIf(destroyOk, pc.DestroyVersionAfter),
+			durationIf(ageOk, pc.MaxVersionAge),
+			false,
+		)
+		if err := checkDualControlSetting(config, setting); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		if config.PathConfigs == nil {
+			config.PathConfigs = map[string]*PathConfig{}
+		}
+		config.PathConfigs[prefix] = pc
+
+		effective := proto.Clone(config).(*Configuration)
+		applyPathConfig(effective, prefix)
+		if err := validateMinVersions(effective, &KeyMetadata{}); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		return nil, b.storeConfig(ctx, req.Storage, config)
+	}
+}
+
+func (b *versionedKVBackend) pathConfigPathDelete() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		b.configWriteLock.Lock()
+		defer b.configWriteLock.Unlock()
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := data.Get("prefix").(string)
+		if _, ok := config.PathConfigs[prefix]; !ok {
+			return nil, nil
+		}
+		delete(config.PathConfigs, prefix)
+
+		return nil, b.storeConfig(ctx, req.Storage, config)
+	}
+}
+
+// pathConfigPathList lists the prefixes with settings starting with the
+// provided prefix.
+func (b *versionedKVBackend) pathConfigPathList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := data.Get("prefix").(string)
+		prefixes := []string{}
+		for p := range config.PathConfigs {
+			if strings.HasPrefix(p, prefix) {
+				prefixes = append(prefixes, p)
+			}
+		}
+		sort.Strings(prefixes)
+
+		return logical.ListResponse(prefixes), nil
+	}
+}
+
+const configPathHelpSyn = `Configures settings for the keys under a prefix.`
+const configPathHelpDesc = `
+This path configures the max_versions, cas_required, delete_version_after,
+destroy_version_after and max_version_age of the keys under a prefix,
+overriding the settings of the backend. The settings of the longest prefix
+matching a key are used, and are combined with the settings of the key itself
+the same way the settings of the backend are.
+
+Unlike templates, the settings apply to existing keys as well as to new ones.
+
+The cas_optional setting exempts the keys under the prefix from the
+cas_required of the backend, so that CAS can be required for the whole mount
+but scratch prefixes. As with the other settings, only the longest matching
+prefix applies, and the cas_required set on a key is still enforced.
+
+The read_rate_limit and write_rate_limit cap the data requests per second of
+all the keys under the prefix combined, allowing bursts of up to one second of
+requests. The requests over the limit are rejected with a 429 status code and
+the time to wait before retrying. The limits are enforced by each Vault node
+separately. The read_rate_limit also applies to the secrets read through
+composites, overlays, references and read-only mirrors, and the
+write_rate_limit to rename-key, inject-field and import. A request reaching
+several keys under a prefix counts as one request under it.
+
+The max_keys quota rejects the creation of new keys under the prefix once it
+holds that many keys, and max_keys_warning_threshold adds a warning to the
+creations once it holds that many keys. Unlike the other settings, the quotas
+of every prefix matching a new key apply, not only the ones of the longest
+prefix. The keys are counted when a key is created, which requires listing
+the keys under the prefix, so keys created concurrently can exceed the quota
+slightly.
+
+The max_bytes quota rejects the writes of versions that would take the
+versions stored under the prefix over that many bytes, counting the new version
+before the older versions it replaces are removed. As with max_keys, the
+quotas of every prefix matching the key apply. The usage is reported by the
+quotas endpoint.
+`
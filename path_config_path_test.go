@@ -0,0 +1,301 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestApplyPathConfig(t *testing.T) {
+	config := &Configuration{
+		MaxVersions: 10,
+		PathConfigs: map[string]*PathConfig{
+			"ci/":          {MaxVersions: 3},
+			"ci/prod/":     {CasRequired: true},
+			"ci/prod/tmp/": {CasOptional: true},
+		},
+	}
+
+	tests := map[string]struct {
+		maxVersions uint32
+		casRequired bool
+	}{
+		"other":           {10, false},
+		"ci/foo":          {3, false},
+		"ci/prod/foo":     {10, true},
+		"ci/prod/tmp/foo": {10, false},
+	}
+	for key, tc := range tests {
+		c := proto.Clone(config).(*Configuration)
+		applyPathConfig(c, key)
+		if c.MaxVersions != tc.maxVersions || c.CasRequired != tc.casRequired {
+			t.Fatalf("%s: unexpected configuration %#v", key, c)
+		}
+	}
+}
+
+func TestVersionedKV_ConfigPath(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for prefix, data := range map[string]map[string]interface{}{
+		"ci/": {
+			"max_versions": 3,
+		},
+		"prod/": {
+			"cas_required": true,
+		},
+	} {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/path/" + prefix,
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "config/path/",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["keys"], []string{"ci/", "prod/"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/path/ci/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["max_versions"] != uint32(3) {
+		t.Fatalf("unexpected settings %#v", resp.Data)
+	}
+
+	write := func(key string) *logical.Response {
+		t.Helper()
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil && err != logical.ErrInvalidRequest {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// The keys under ci/ keep 3 versions
+	for i := 0; i < 5; i++ {
+		if resp := write("ci/foo"); resp == nil || resp.IsError() {
+			t.Fatalf("unexpected response %#v", resp)
+		}
+	}
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/ci/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if versions := resp.Data["versions"].(map[string]interface{}); len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %#v", versions)
+	}
+
+	// and the keys under prod/ require the cas parameter
+	if resp := write("prod/foo"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+	if resp := write("foo"); resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/path/prod/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp := write("prod/foo"); resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+}
+
+func TestVersionedKV_ConfigPath_CasOptional(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for path, data := range map[string]map[string]interface{}{
+		"config": {
+			"cas_required": true,
+		},
+		"config/path/scratch/": {
+			"cas_optional": true,
+		},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	write := func(key string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		})
+		if err != nil && err != logical.ErrInvalidRequest {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// The keys under scratch/ do not require the cas parameter
+	if resp := write("scratch/foo"); resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response %#v", resp)
+	}
+	if resp := write("prod/foo"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+
+	// but the cas_required of the keys still applies
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata/scratch/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"cas_required": true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp := write("scratch/foo"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/scratch/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"cas_required": true,
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected cas_required and cas_optional to be rejected, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+// slowPutStorage delays the writes so that concurrent requests overlap.
+type slowPutStorage struct {
+	logical.Storage
+}
+
+func (s *slowPutStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	time.Sleep(time.Millisecond)
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestVersionedKV_ConfigPath_Concurrent(t *testing.T) {
+	b, s := getBackend(t)
+	storage := &slowPutStorage{Storage: s}
+
+	// The concurrent changes of the configuration must not overwrite each
+	// other
+	var wg sync.WaitGroup
+	errs := make(chan error, 21)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      fmt.Sprintf("config/path/app%d/", i),
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"max_versions": 2,
+				},
+			})
+			if err == nil && resp != nil && resp.IsError() {
+				err = resp.Error()
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"max_versions": 5,
+			},
+		})
+		if err == nil && resp != nil && resp.IsError() {
+			err = resp.Error()
+		}
+		errs <- err
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := b.(*versionedKVBackend).config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxVersions != 5 {
+		t.Fatalf("expected max_versions 5, got %d", config.MaxVersions)
+	}
+	if len(config.PathConfigs) != 20 {
+		t.Fatalf("expected 20 prefixes, got %d", len(config.PathConfigs))
+	}
+}
@@ -20,7 +20,7 @@ func TestVersionedKV_Config(t *testing.T) {
 	}
 
 	req := &logical.Request{
-		Operation: logical.CreateOperation,
+		Operation: logical.UpdateOperation,
 		Path:      "config",
 		Storage:   storage,
 		Data:      data,
@@ -107,7 +107,7 @@ func TestVersionedKV_Config_DeleteVersionAfter(t *testing.T) {
 				"delete_version_after": tt.ds1,
 			}
 			req = &logical.Request{
-				Operation: logical.CreateOperation,
+				Operation: logical.UpdateOperation,
 				Path:      "config",
 				Storage:   storage,
 				Data:      data,
@@ -135,7 +135,7 @@ func TestVersionedKV_Config_DeleteVersionAfter(t *testing.T) {
 				"delete_version_after": tt.ds2,
 			}
 			req = &logical.Request{
-				Operation: logical.CreateOperation,
+				Operation: logical.UpdateOperation,
 				Path:      "config",
 				Storage:   storage,
 				Data:      data,
@@ -0,0 +1,605 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// webhooksConfigPath is the location where the webhooks are stored.
+	webhooksConfigPath string = "config/webhooks"
+
+	// webhookTimeout bounds the time spent on each delivery attempt.
+	webhookTimeout = 10 * time.Second
+
+	// webhookRetryBackoff is the time waited before retrying a failed
+	// delivery, it doubles after each attempt.
+	webhookRetryBackoff = time.Second
+
+	// maxWebhookRetries is the largest max_retries accepted.
+	maxWebhookRetries = 10
+
+	// webhookSignatureHeader is the header holding the HMAC-SHA256 of the
+	// body of the notifications.
+	webhookSignatureHeader = "X-Vault-KV-Signature"
+
+	// webhookDeliveriesPrefix is the prefix where the notifications waiting
+	// to be delivered are stored.
+	webhookDeliveriesPrefix = "webhook-deliveries/"
+
+	// webhookWorkers is the number of deliveries made concurrently.
+	webhookWorkers = 4
+
+	// webhookQueueSize is the number of deliveries waiting for a worker in
+	// memory. The deliveries that do not fit are left in storage for the
+	// periodic function.
+	webhookQueueSize = 1024
+)
+
+// webhookEvents are the types of the events webhooks can be notified of.
+var webhookEvents = []string{eventDataWrite, eventDataDelete, eventDataUndelete, eventMetadataDelete, eventDestroy}
+
+// pathConfigWebhooks returns the path configuration for CRUD operations on
+// the webhooks notified of the changes of the secrets.
+func pathConfigWebhooks(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/webhooks/?" + framework.OptionalParamRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the webhook.",
+			},
+			"url": {
+				Type:        framework.TypeString,
+				Description: "The HTTP endpoint the notifications are posted to.",
+			},
+			"secret": {
+				Type: framework.TypeString,
+				Description: `
+The key the notifications are signed with. If set, the hex encoded
+HMAC-SHA256 of the body is sent in the X-Vault-KV-Signature header.`,
+			},
+			"prefixes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The prefixes of the keys whose changes are notified. Every key is notified if empty.",
+			},
+			"events": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `
+The types of the events notified, among kv-v2/data-write, kv-v2/data-delete,
+kv-v2/data-undelete, kv-v2/metadata-delete and kv-v2/destroy. Every event is
+notified if empty.`,
+			},
+			"max_retries": {
+				Type:        framework.TypeInt,
+				Description: "The number of times a failed notification is retried, with an exponential backoff.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathConfigWebhooksWrite()),
+			logical.ReadOperation:   b.upgradeCheck(b.pathConfigWebhooksRead()),
+			logical.DeleteOperation: b.upgradeCheck(b.pathConfigWebhooksDelete()),
+			logical.ListOperation:   b.upgradeCheck(b.pathConfigWebhooksList()),
+		},
+
+		HelpSynopsis:    webhooksHelpSyn,
+		HelpDescription: webhooksHelpDesc,
+	}
+}
+
+func (b *versionedKVBackend) pathConfigWebhooksRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.webhooks(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		hook, ok := config.Webhooks[data.Get("name").(string)]
+		if !ok {
+			return nil, nil
+		}
+
+		// The secret is never returned
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"url":         hook.Url,
+				"signed":      hook.Secret != "",
+				"prefixes":    hook.Prefixes,
+				"events":      hook.Events,
+				"max_retries": hook.MaxRetries,
+			},
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathConfigWebhooksWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		name := data.Get("name").(string)
+		if name == "" {
+			return logical.ErrorResponse("missing name"), nil
+		}
+
+		b.webhooksLock.Lock()
+		defer b.webhooksLock.Unlock()
+
+		config, err := b.readWebhooks(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		hook, ok := config.Webhooks[name]
+		if !ok {
+			hook = &Webhook{}
+		}
+
+		if urlRaw, ok := data.GetOk("url"); ok {
+			hook.Url = urlRaw.(string)
+		}
+		if secretRaw, ok := data.GetOk("secret"); ok {
+			hook.Secret = secretRaw.(string)
+		}
+		if prefixesRaw, ok := data.GetOk("prefixes"); ok {
+			hook.Prefixes = prefixesRaw.([]string)
+		}
+		if eventsRaw, ok := data.GetOk("events"); ok {
+			hook.Events = eventsRaw.([]string)
+		}
+		if retriesRaw, ok := data.GetOk("max_retries"); ok {
+			retries := retriesRaw.(int)
+			if retries < 0 || retries > maxWebhookRetries {
+				return logical.ErrorResponse("max_retries must be between 0 and %d", maxWebhookRetries), nil
+			}
+			hook.MaxRetries = uint32(retries)
+		}
+
+		if hook.Url == "" {
+			return logical.ErrorResponse("missing url"), nil
+		}
+		if !strings.HasPrefix(hook.Url, "http://") && !strings.HasPrefix(hook.Url, "https://") {
+			return logical.ErrorResponse("url must be an HTTP or HTTPS URL"), nil
+		}
+		for _, event := range hook.Events {
+			if !strutil.StrListContains(webhookEvents, event) {
+				return logical.ErrorResponse("unknown event %q, must be one of %s", event, strings.Join(webhookEvents, ", ")), nil
+			}
+		}
+
+		if config.Webhooks == nil {
+			config.Webhooks = map[string]*Webhook{}
+		}
+		config.Webhooks[name] = hook
+
+		return nil, b.writeWebhooks(ctx, req.Storage, config)
+	}
+}
+
+func (b *versionedKVBackend) pathConfigWebhooksDelete() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		b.webhooksLock.Lock()
+		defer b.webhooksLock.Unlock()
+
+		config, err := b.readWebhooks(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		name := data.Get("name").(string)
+		if _, ok := config.Webhooks[name]; !ok {
+			return nil, nil
+		}
+		delete(config.Webhooks, name)
+
+		return nil, b.writeWebhooks(ctx, req.Storage, config)
+	}
+}
+
+func (b *versionedKVBackend) pathConfigWebhooksList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.webhooks(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		names := []string{}
+		for name := range config.Webhooks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return logical.ListResponse(names), nil
+	}
+}
+
+// webhooks returns the configured webhooks, they are cached until
+// invalidated.
+func (b *versionedKVBackend) webhooks(ctx context.Context, s logical.Storage) (*WebhookConfig, error) {
+	b.webhooksLock.RLock()
+	if b.webhookConfig != nil {
+		defer b.webhooksLock.RUnlock()
+		return b.webhookConfig, nil
+	}
+	b.webhooksLock.RUnlock()
+	b.webhooksLock.Lock()
+	defer b.webhooksLock.Unlock()
+
+	if b.webhookConfig != nil {
+		return b.webhookConfig, nil
+	}
+
+	config, err := b.readWebhooks(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache the value
+	b.webhookConfig = config
+
+	return config, nil
+}
+
+// readWebhooks reads the webhooks from storage. The caller must hold
+// webhooksLock.
+func (b *versionedKVBackend) readWebhooks(ctx context.Context, s logical.Storage) (*WebhookConfig, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, webhooksConfigPath))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &WebhookConfig{}
+	if raw == nil {
+		return config, nil
+	}
+	if err := proto.Unmarshal(raw.Value, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// writeWebhooks stores the webhooks and caches them. The caller must hold
+// webhooksLock.
+func (b *versionedKVBackend) writeWebhooks(ctx context.Context, s logical.Storage, config *WebhookConfig) error {
+	bytes, err := proto.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	err = s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, webhooksConfigPath),
+		Value: bytes,
+	})
+	if err != nil {
+		return err
+	}
+
+	b.webhookConfig = config
+	return nil
+}
+
+// webhookTask is a delivery waiting for a webhook worker.
+type webhookTask struct {
+	s  logical.Storage
+	id string
+}
+
+// notifyWebhooks posts the event to the webhooks interested in it. The
+// notifications are stored and then delivered in the background by a bounded
+// pool of workers, so that the request is not slowed down by the webhooks and
+// the notifications survive a restart.
+func (b *versionedKVBackend) notifyWebhooks(ctx context.Context, s logical.Storage, eventType, key string, metadata map[string]string) {
+	config, err := b.webhooks(ctx, s)
+	if err != nil {
+		b.Logger().Warn("failed to load the webhooks", "error", err)
+		return
+	}
+	if len(config.Webhooks) == 0 {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"event_type": eventType,
+		"time":       time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for k, v := range metadata {
+		payload[k] = v
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		b.Logger().Warn("failed to encode the webhook notification", "error", err)
+		return
+	}
+
+	for name, hook := range config.Webhooks {
+		if !webhookMatches(hook, eventType, key) {
+			continue
+		}
+
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			b.Logger().Warn("failed to store the webhook notification", "name", name, "error", err)
+			continue
+		}
+		delivery := &WebhookDelivery{
+			Id:              id,
+			Webhook:         name,
+			EventType:       eventType,
+			Body:            body,
+			NextAttemptTime: ptypes.TimestampNow(),
+		}
+		if err := b.writeWebhookDelivery(ctx, s, delivery); err != nil {
+			b.Logger().Warn("failed to store the webhook notification", "name", name, "error", err)
+			continue
+		}
+
+		b.enqueueWebhook(s, id)
+	}
+}
+
+// startWebhookWorkers starts the workers delivering the notifications, once.
+func (b *versionedKVBackend) startWebhookWorkers() {
+	b.webhookWorkersOnce.Do(func() {
+		for i := 0; i < webhookWorkers; i++ {
+			b.jobsWG.Add(1)
+			go func() {
+				defer b.jobsWG.Done()
+
+				for {
+					select {
+					case <-b.jobsCtx.Done():
+						return
+					case task := <-b.webhookQueue:
+						b.deliverWebhook(b.jobsCtx, task)
+					}
+				}
+			}()
+		}
+	})
+}
+
+// enqueueWebhook queues the delivery id for the workers unless it is already
+// queued or waiting for a retry. When the queue is full the delivery is left
+// to the next run of the periodic function.
+func (b *versionedKVBackend) enqueueWebhook(s logical.Storage, id string) {
+	b.startWebhookWorkers()
+
+	b.webhookPendingLock.Lock()
+	defer b.webhookPendingLock.Unlock()
+
+	if _, ok := b.webhookPending[id]; ok {
+		return
+	}
+	select {
+	case b.webhookQueue <- webhookTask{s: s, id: id}:
+		b.webhookPending[id] = struct{}{}
+	default:
+		b.Logger().Warn("the webhook queue is full, the delivery is deferred", "id", id)
+	}
+}
+
+// requeueWebhook queues a delivery whose retry is due. When the queue is full
+// the delivery is left to the next run of the periodic function.
+func (b *versionedKVBackend) requeueWebhook(task webhookTask) {
+	if b.jobsCtx.Err() != nil {
+		return
+	}
+
+	select {
+	case b.webhookQueue <- task:
+	default:
+		b.releaseWebhook(task.id)
+	}
+}
+
+// releaseWebhook records that the delivery id is no longer queued nor waiting
+// for a retry.
+func (b *versionedKVBackend) releaseWebhook(id string) {
+	b.webhookPendingLock.Lock()
+	defer b.webhookPendingLock.Unlock()
+
+	delete(b.webhookPending, id)
+}
+
+// retryWebhookDeliveries queues the stored deliveries that are due, among
+// which those that did not fit in the queue or were interrupted by a restart.
+func (b *versionedKVBackend) retryWebhookDeliveries(ctx context.Context, s logical.Storage) error {
+	ids, err := s.List(ctx, path.Join(b.storagePrefix, webhookDeliveriesPrefix)+"/")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		b.webhookPendingLock.Lock()
+		_, pending := b.webhookPending[id]
+		b.webhookPendingLock.Unlock()
+		if pending {
+			continue
+		}
+
+		delivery, err := b.getWebhookDelivery(ctx, s, id)
+		if err != nil {
+			return err
+		}
+		if delivery == nil {
+			continue
+		}
+		next, err := ptypes.Timestamp(delivery.NextAttemptTime)
+		if err != nil {
+			return err
+		}
+		if next.After(now) {
+			continue
+		}
+
+		b.enqueueWebhook(s, id)
+	}
+
+	return nil
+}
+
+// webhookMatches returns whether hook is interested in the event of
+// eventType about key.
+func webhookMatches(hook *Webhook, eventType, key string) bool {
+	if len(hook.Events) > 0 && !strutil.StrListContains(hook.Events, eventType) {
+		return false
+	}
+	if len(hook.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range hook.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverWebhook makes a delivery attempt of the notification of task. The
+// delivery is removed once it succeeds or its webhook's max_retries is
+// exhausted, otherwise it is retried with an exponential backoff.
+func (b *versionedKVBackend) deliverWebhook(ctx context.Context, task webhookTask) {
+	delivery, err := b.getWebhookDelivery(ctx, task.s, task.id)
+	if err != nil || delivery == nil {
+		if err != nil {
+			b.Logger().Error("failed to load the webhook notification", "id", task.id, "error", err)
+		}
+		b.releaseWebhook(task.id)
+		return
+	}
+
+	config, err := b.webhooks(ctx, task.s)
+	if err != nil {
+		b.Logger().Error("failed to load the webhooks", "error", err)
+		b.releaseWebhook(task.id)
+		return
+	}
+
+	// The notifications of the webhooks that were removed are dropped
+	hook, ok := config.Webhooks[delivery.Webhook]
+	if ok {
+		err = b.postWebhook(ctx, hook, delivery.EventType, delivery.Body)
+	}
+	switch {
+	case ok && err != nil && ctx.Err() != nil:
+		// The delivery is resumed once the backend is started again
+		b.releaseWebhook(task.id)
+		return
+	case ok && err != nil && delivery.Attempts < hook.MaxRetries:
+		backoff := webhookRetryBackoff << delivery.Attempts
+		delivery.Attempts++
+		delivery.NextAttemptTime, _ = ptypes.TimestampProto(time.Now().Add(backoff))
+		if werr := b.writeWebhookDelivery(ctx, task.s, delivery); werr != nil {
+			b.Logger().Error("failed to record the webhook delivery attempt", "name", delivery.Webhook, "error", werr)
+		}
+		time.AfterFunc(backoff, func() {
+			b.requeueWebhook(task)
+		})
+		return
+	case ok && err != nil:
+		b.Logger().Error("failed to deliver webhook notification", "name", delivery.Webhook, "event_type", delivery.EventType, "error", err)
+	}
+
+	if err := b.deleteWebhookDelivery(ctx, task.s, task.id); err != nil {
+		b.Logger().Error("failed to remove the webhook notification", "id", task.id, "error", err)
+	}
+	b.releaseWebhook(task.id)
+}
+
+// postWebhook makes a single delivery attempt of body to hook.
+func (b *versionedKVBackend) postWebhook(ctx context.Context, hook *Webhook, eventType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-KV-Event", eventType)
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := b.webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// getWebhookDelivery returns the stored delivery with the provided ID, or nil
+// if it does not exist.
+func (b *versionedKVBackend) getWebhookDelivery(ctx context.Context, s logical.Storage, id string) (*WebhookDelivery, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, webhookDeliveriesPrefix, id))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	delivery := &WebhookDelivery{}
+	if err := proto.Unmarshal(raw.Value, delivery); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook delivery from storage: %v", err)
+	}
+
+	return delivery, nil
+}
+
+func (b *versionedKVBackend) writeWebhookDelivery(ctx context.Context, s logical.Storage, delivery *WebhookDelivery) error {
+	buf, err := proto.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, webhookDeliveriesPrefix, delivery.Id),
+		Value: buf,
+	})
+}
+
+func (b *versionedKVBackend) deleteWebhookDelivery(ctx context.Context, s logical.Storage, id string) error {
+	return s.Delete(ctx, path.Join(b.storagePrefix, webhookDeliveriesPrefix, id))
+}
+
+const webhooksHelpSyn = `Configures the webhooks notified of the changes of the secrets.`
+const webhooksHelpDesc = `
+This path manages the HTTP endpoints notified when the secrets are written,
+deleted, undeleted or destroyed, e.g. to redeploy the applications using a
+secret once it has been rotated.
+
+Each notification is a JSON object posted to the url of the webhook with the
+"event_type", the "mount" and the "path" of the secret, the "versions"
+affected if any and the "time" of the change. When a secret is set, the hex
+encoded HMAC-SHA256 of the body, prefixed with "sha256=", is sent in the
+X-Vault-KV-Signature header so that the receiver can authenticate the
+notification.
+
+The notifications are stored and delivered in the background by a bounded
+pool of workers, so that they survive a restart of the backend. Failed
+deliveries are retried up to max_retries times with an exponential backoff.
+Only the changes of the keys under one of the prefixes, and of the types
+listed in events, are notified.
+`
@@ -0,0 +1,291 @@
+package kv
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestWebhookMatches(t *testing.T) {
+	tests := map[string]struct {
+		hook *Webhook
+		want bool
+	}{
+		"everything":       {&Webhook{}, true},
+		"matching prefix":  {&Webhook{Prefixes: []string{"bar/", "foo/"}}, true},
+		"other prefix":     {&Webhook{Prefixes: []string{"bar/"}}, false},
+		"matching event":   {&Webhook{Events: []string{eventDataWrite}}, true},
+		"other event":      {&Webhook{Events: []string{eventDestroy}}, false},
+		"prefix and event": {&Webhook{Prefixes: []string{"foo/"}, Events: []string{eventDestroy}}, false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := webhookMatches(tc.hook, eventDataWrite, "foo/bar"); got != tc.want {
+				t.Fatalf("expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVersionedKV_Webhooks(t *testing.T) {
+	type notification struct {
+		body      map[string]interface{}
+		signature string
+	}
+	notifications := make(chan notification, 10)
+	var failures int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first delivery to exercise the retries
+		if failures == 0 {
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		raw, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		mac := hmac.New(sha256.New, []byte("s3cr3t"))
+		mac.Write(raw)
+
+		n := notification{
+			signature: hex.EncodeToString(mac.Sum(nil)),
+		}
+		if r.Header.Get(webhookSignatureHeader) != "sha256="+n.signature {
+			t.Errorf("unexpected signature %q", r.Header.Get(webhookSignatureHeader))
+		}
+		if err := json.Unmarshal(raw, &n.body); err != nil {
+			t.Error(err)
+		}
+		notifications <- n
+	}))
+	defer server.Close()
+
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/webhooks/deploy",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"url":         server.URL,
+			"secret":      "s3cr3t",
+			"prefixes":    "app/",
+			"events":      "kv-v2/data-write,kv-v2/destroy",
+			"max_retries": 1,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for name, data := range map[string]map[string]interface{}{
+		"missing url":   {"prefixes": "foo/"},
+		"invalid url":   {"url": "ftp://example.com"},
+		"unknown event": {"url": server.URL, "events": "foo"},
+		"retries":       {"url": server.URL, "max_retries": maxWebhookRetries + 1},
+	} {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/webhooks/invalid",
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected an error, err:%s resp:%#v\n", name, err, resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/webhooks/deploy",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	expected := map[string]interface{}{
+		"url":         server.URL,
+		"signed":      true,
+		"prefixes":    []string{"app/"},
+		"events":      []string{eventDataWrite, eventDestroy},
+		"max_retries": uint32(1),
+	}
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, resp.Data)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "config/webhooks/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"deploy"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+
+	// Only the writes under app/ are notified
+	for _, key := range []string{"other/foo", "app/foo"} {
+		req = &logical.Request{
+			Operation:  logical.CreateOperation,
+			Path:       "data/" + key,
+			Storage:    storage,
+			MountPoint: "secret/",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	select {
+	case n := <-notifications:
+		if n.body["event_type"] != eventDataWrite || n.body["path"] != "app/foo" || n.body["mount"] != "secret/" || n.body["versions"] != "1" {
+			t.Fatalf("unexpected notification: %#v", n.body)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the notification")
+	}
+
+	select {
+	case n := <-notifications:
+		t.Fatalf("unexpected notification: %#v", n.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/webhooks/deploy",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/webhooks/deploy",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Webhooks_StoredDeliveries(t *testing.T) {
+	bodies := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies <- string(raw)
+	}))
+	defer server.Close()
+
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/webhooks/deploy",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"url": server.URL,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The deliveries left in storage by a restart are made by the periodic
+	// function once they are due, those of removed webhooks are dropped
+	for id, webhook := range map[string]string{"due": "deploy", "removed": "other"} {
+		delivery := &WebhookDelivery{
+			Id:              id,
+			Webhook:         webhook,
+			EventType:       eventDataWrite,
+			Body:            []byte(`{"path":"` + id + `"}`),
+			NextAttemptTime: ptypes.TimestampNow(),
+		}
+		if err := kv.writeWebhookDelivery(context.Background(), storage, delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	later, _ := ptypes.TimestampProto(time.Now().Add(time.Hour))
+	if err := kv.writeWebhookDelivery(context.Background(), storage, &WebhookDelivery{
+		Id:              "later",
+		Webhook:         "deploy",
+		EventType:       eventDataWrite,
+		Body:            []byte(`{"path":"later"}`),
+		NextAttemptTime: later,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req = &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case body := <-bodies:
+		if body != `{"path":"due"}` {
+			t.Fatalf("unexpected notification: %s", body)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the notification")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ids, err := storage.List(context.Background(), "test/"+webhookDeliveriesPrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(ids, []string{"later"}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected deliveries: %#v", ids)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case body := <-bodies:
+		t.Fatalf("unexpected notification: %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
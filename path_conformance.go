@@ -0,0 +1,119 @@
+package kv
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathConformance returns the path configuration for the endpoint checking
+// the secrets against the required_paths manifest of the configuration.
+func pathConformance(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "conformance$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathConformanceRead()),
+		},
+
+		HelpSynopsis:    conformanceHelpSyn,
+		HelpDescription: conformanceHelpDesc,
+	}
+}
+
+// pathConformanceRead reports the required paths that are missing and those
+// whose current version lacks some of the required data fields.
+func (b *versionedKVBackend) pathConformanceRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := make([]string, 0, len(config.RequiredPaths))
+		for key := range config.RequiredPaths {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		missing := []string{}
+		malformed := map[string][]string{}
+		for _, key := range keys {
+			missingFields, found, err := b.checkConformance(ctx, req.Storage, key, strutil.ParseStringSlice(config.RequiredPaths[key], ","))
+			if err != nil {
+				return nil, err
+			}
+
+			switch {
+			case !found:
+				missing = append(missing, key)
+			case len(missingFields) > 0:
+				malformed[key] = missingFields
+			}
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"conformant": len(missing) == 0 && len(malformed) == 0,
+				"checked":    len(keys),
+				"missing":    missing,
+				"malformed":  malformed,
+			},
+		}, nil
+	}
+}
+
+// checkConformance returns the required fields absent from the current
+// version of key. found is false if the key does not exist or if its current
+// version is deleted or destroyed.
+func (b *versionedKVBackend) checkConformance(ctx context.Context, s logical.Storage, key string, fields []string) (missingFields []string, found bool, err error) {
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if meta == nil {
+		return nil, false, nil
+	}
+
+	vm, ok := meta.Versions[meta.CurrentVersion]
+	if !ok || vm.Destroyed {
+		return nil, false, nil
+	}
+	deleted, err := isDeleted(vm)
+	if err != nil {
+		return nil, false, err
+	}
+	if deleted {
+		return nil, false, nil
+	}
+
+	if len(fields) == 0 {
+		return nil, true, nil
+	}
+
+	vData, err := b.readVersionData(ctx, s, key, meta.CurrentVersion)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, field := range fields {
+		if _, ok := vData[field]; !ok {
+			missingFields = append(missingFields, field)
+		}
+	}
+
+	return missingFields, true, nil
+}
+
+const conformanceHelpSyn = `Checks the secrets against the required_paths manifest.`
+const conformanceHelpDesc = `
+This path reports whether the secrets declared in the required_paths parameter
+of the backend configuration exist and contain the expected data fields.
+
+The "missing" list holds the required keys that do not exist or whose current
+version is deleted or destroyed. The "malformed" map holds, for each key whose
+current version lacks some of the required fields, the names of the missing
+fields. "conformant" is true when both are empty.
+`
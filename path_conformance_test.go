@@ -0,0 +1,82 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Conformance(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"required_paths": map[string]interface{}{
+				"app/db":      "username, password",
+				"app/api":     "token",
+				"app/missing": "",
+				"app/deleted": "",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for key, data := range map[string]map[string]interface{}{
+		"app/db":      {"username": "admin"},
+		"app/api":     {"token": "secret"},
+		"app/deleted": {"foo": "bar"},
+	} {
+		req = &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": data,
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "data/app/deleted",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "conformance",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected := map[string]interface{}{
+		"conformant": false,
+		"checked":    4,
+		"missing":    []string{"app/deleted", "app/missing"},
+		"malformed": map[string][]string{
+			"app/db": {"password"},
+		},
+	}
+	if diff := deep.Equal(resp.Data, expected); len(diff) > 0 {
+		t.Fatal(diff)
+	}
+}
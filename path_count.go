@@ -0,0 +1,77 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathCount returns the path configuration for counting the keys under a
+// prefix.
+func pathCount(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "count/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the keys to count.",
+			},
+			"versions": {
+				Type:        framework.TypeBool,
+				Description: "If true, the number of versions of the keys is also returned.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathCountRead()),
+		},
+
+		HelpSynopsis:    countHelpSyn,
+		HelpDescription: countHelpDesc,
+	}
+}
+
+// pathCountRead returns the number of keys under a prefix, descending into
+// all the sub-folders, and optionally the number of their versions.
+func (b *versionedKVBackend) pathCountRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		keys, err := b.collectKeys(ctx, req.Storage, data.Get("path").(string))
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"keys": len(keys),
+			},
+		}
+
+		if !data.Get("versions").(bool) {
+			return resp, nil
+		}
+
+		var versions int
+		for _, key := range keys {
+			meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+			if err != nil {
+				return nil, err
+			}
+			if meta == nil {
+				continue
+			}
+			versions += len(meta.Versions)
+		}
+		resp.Data["versions"] = versions
+
+		return resp, nil
+	}
+}
+
+const countHelpSyn = `Counts the secrets under a prefix.`
+const countHelpDesc = `
+Reading "count/<prefix>" returns in "keys" the number of secrets under the
+prefix, descending into all the sub-folders, without returning the keys
+themselves. If the "versions" parameter is true, the number of versions kept
+in the metadata of the secrets is returned in "versions"; this requires
+reading the metadata of every secret.
+`
@@ -0,0 +1,57 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Count(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, key := range []string{"apps/foo", "apps/foo", "apps/nested/bar", "other/baz"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"foo": "bar",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, tc := range []struct {
+		path     string
+		versions bool
+		keys     int
+		count    interface{}
+	}{
+		{"count/apps/", false, 2, nil},
+		{"count/apps/", true, 2, 3},
+		{"count/", true, 3, 4},
+		{"count/missing/", true, 0, 0},
+	} {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      tc.path,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"versions": tc.versions,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if resp.Data["keys"] != tc.keys || resp.Data["versions"] != tc.count {
+			t.Fatalf("%s: unexpected response %#v", tc.path, resp.Data)
+		}
+	}
+}
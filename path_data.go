@@ -6,12 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -32,6 +36,21 @@ func pathData(b *versionedKVBackend) *framework.Path {
 				Type:        framework.TypeInt,
 				Description: "If provided during a read, the value at the version number will be returned",
 			},
+			"versions": {
+				Type:        framework.TypeCommaIntSlice,
+				Description: "If provided during a read, the values at these version numbers will be returned under \"versions\".",
+			},
+			"if_newer_than_version": {
+				Type:        framework.TypeInt,
+				Description: "If provided during a read, a 304 status code is returned without data unless the version read is newer than this version number.",
+			},
+			"return_reference": {
+				Type:        framework.TypeBool,
+				Description: "If true during a read of a reference, the reference itself is returned instead of the data of the secret it points to.",
+			},
+			"numeric_time_fields": numericTimeFieldsSchema,
+			"read_fallback":       readFallbackSchema,
+			"overlay":             overlaySchema,
 			"options": {
 				Type: framework.TypeMap,
 				Description: `Options for writing a KV entry.
@@ -39,19 +58,35 @@ func pathData(b *versionedKVBackend) *framework.Path {
 Set the "cas" value to use a Check-And-Set operation. If not set the write will
 be allowed. If set to 0 a write will only be allowed if the key doesn’t exist.
 If the index is non-zero the write will only be allowed if the key’s current
-version matches the version specified in the cas parameter.`,
+version matches the version specified in the cas parameter.
+
+Set the "delete_version_after" value to override the delete_version_after of
+the key and of the backend for the version being written only. It cannot be
+greater than the backend's delete_version_after or less than the backend's
+min_delete_version_after.
+
+Set the "destroy_version_after" value to override the destroy_version_after of
+the key and of the backend for the version being written only. It cannot be
+set while the backend's destroy_confirmation_window is.`,
 			},
 			"data": {
 				Type:        framework.TypeMap,
 				Description: "The contents of the data map will be stored and returned on read.",
 			},
+			"reference": {
+				Type: framework.TypeString,
+				Description: `
+If provided instead of data, the version written is a reference to the secret
+at this path, whose data is returned by the reads of the version. The path must
+match the reference_targets of the backend.`,
+			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.upgradeCheck(b.pathDataWrite()),
-			logical.CreateOperation: b.upgradeCheck(b.pathDataWrite()),
-			logical.ReadOperation:   b.upgradeCheck(b.pathDataRead()),
-			logical.DeleteOperation: b.upgradeCheck(b.pathDataDelete()),
-			logical.PatchOperation:  b.upgradeCheck(b.pathDataPatch()),
+			logical.UpdateOperation: b.instrument("data", b.upgradeCheck(b.rateLimit(true, b.reservedPrefixCheck(b.pathDataWrite())))),
+			logical.CreateOperation: b.instrument("data", b.upgradeCheck(b.rateLimit(true, b.reservedPrefixCheck(b.pathDataWrite())))),
+			logical.ReadOperation:   b.instrument("data", b.upgradeCheck(b.rateLimit(false, b.pathDataRead()))),
+			logical.DeleteOperation: b.instrument("data", b.upgradeCheck(b.rateLimit(true, b.reservedPrefixCheck(b.pathDataDelete())))),
+			logical.PatchOperation:  b.instrument("data", b.upgradeCheck(b.rateLimit(true, b.reservedPrefixCheck(b.pathDataPatch())))),
 		},
 
 		ExistenceCheck: b.dataExistenceCheck(),
@@ -86,89 +121,353 @@ func (b *versionedKVBackend) pathDataRead() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		key := data.Get("path").(string)
 
-		lock := locksutil.LockForKey(b.locks, key)
-		lock.RLock()
-		defer lock.RUnlock()
-
-		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+		config, err := b.config(ctx, req.Storage)
 		if err != nil {
 			return nil, err
 		}
-		if meta == nil {
-			return nil, nil
-		}
 
-		verNum := meta.CurrentVersion
-		verParam := data.Get("version").(int)
-		if verParam > 0 {
-			verNum = uint64(verParam)
+		overlay, err := overlayKey(config, key, data)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 		}
 
-		// If there is no version with that number, return
-		vm := meta.Versions[verNum]
-		if vm == nil {
-			return nil, nil
+		resp, target, err := b.readData(ctx, req, data, key, false)
+		if err == nil && target != "" {
+			// The lock of the reference is released before the secrets
+			// it points to are read
+			resp, err = b.resolveReference(ctx, req, data, key, target)
+		}
+		if err != nil || resp == nil || overlay == "" {
+			return resp, err
 		}
 
-		resp := &logical.Response{
-			Data: map[string]interface{}{
-				"data": nil,
-				"metadata": map[string]interface{}{
-					"version":         verNum,
-					"created_time":    ptypesTimestampToString(vm.CreatedTime),
-					"deletion_time":   ptypesTimestampToString(vm.DeletionTime),
-					"destroyed":       vm.Destroyed,
-					"custom_metadata": meta.CustomMetadata,
-				},
-			},
+		return b.applyOverlay(ctx, req, resp, key, overlay)
+	}
+}
+
+// readData handles the data read of key. If the version read is a reference
+// to follow, no response is returned along with the path it points to. The
+// version parameters only apply to the secret requested, the secrets reached
+// by following references are read at their current version.
+func (b *versionedKVBackend) readData(ctx context.Context, req *logical.Request, data *framework.FieldData, key string, referenced bool) (*logical.Response, string, error) {
+	// The versions due for destroy are destroyed once the read lock is
+	// released
+	var purge bool
+	defer func() {
+		if purge {
+			b.purgeDueVersions(ctx, req.Storage, key)
+		}
+	}()
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+	if err != nil {
+		return nil, "", err
+	}
+	if meta == nil || meta.Deleting {
+		return nil, "", nil
+	}
+
+	keyConfig, err := b.keyConfig(ctx, req.Storage, key)
+	if err != nil {
+		return nil, "", err
+	}
+	due, err := versionsDueForDestroy(keyConfig, meta, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	purge = len(due) > 0
+
+	if versionsRaw, ok := data.GetOk("versions"); ok && !referenced {
+		if _, ok := data.GetOk("version"); ok {
+			return logical.ErrorResponse("version cannot be combined with versions"), "", logical.ErrInvalidRequest
 		}
+		if _, ok := data.GetOk("if_newer_than_version"); ok {
+			return logical.ErrorResponse("if_newer_than_version cannot be combined with versions"), "", logical.ErrInvalidRequest
+		}
+		resp, err := b.readVersions(ctx, req, data, keyConfig, meta, versionsRaw.([]int))
+		return resp, "", err
+	}
 
-		// If the version has been deleted return metadata with a 404
-		if vm.DeletionTime != nil {
-			deletionTime, err := ptypes.Timestamp(vm.DeletionTime)
-			if err != nil {
-				return nil, err
-			}
+	verNum := meta.CurrentVersion
+	verParam := data.Get("version").(int)
+	if referenced {
+		verParam = 0
+	}
+	if verParam > 0 {
+		verNum = uint64(verParam)
+	}
+
+	// If there is no version with that number, return
+	vm := meta.Versions[verNum]
+	if vm == nil {
+		return nil, "", nil
+	}
+
+	now := time.Now()
+	numeric := numericTimeFields(keyConfig, data)
+	metadata, readable, err := dataReadMetadata(meta, verNum, vm, numeric, now)
+	if err != nil {
+		return nil, "", err
+	}
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"data":     nil,
+			"metadata": metadata,
+		},
+	}
 
-			if deletionTime.Before(time.Now()) {
-				return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
+	// If the key has expired return metadata with a 404
+	expired, err := isExpired(meta)
+	if err != nil {
+		return nil, "", err
+	}
+	if expired {
+		resp.AddWarning(fmt.Sprintf("The secret expired at %s", ptypesTimestampToString(meta.ExpireAt)))
+		resp, err := logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
+		return resp, "", err
+	}
 
+	// If the current version has been deleted or destroyed, the newest
+	// readable version is returned instead when the fallback is enabled
+	if !readable && verParam <= 0 && readFallback(keyConfig, data) {
+		fallback, err := latestReadableVersion(meta, now)
+		if err != nil {
+			return nil, "", err
+		}
+		if fallback > 0 {
+			resp.AddWarning(fmt.Sprintf("Version %d is deleted or destroyed, version %d was returned instead", verNum, fallback))
+			verNum, vm = fallback, meta.Versions[fallback]
+			metadata, readable, err = dataReadMetadata(meta, verNum, vm, numeric, now)
+			if err != nil {
+				return nil, "", err
 			}
+			resp.Data["metadata"] = metadata
 		}
+	}
 
-		// If the version has been destroyed return metadata with a 404
-		if vm.Destroyed {
-			return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
+	// If the version has been deleted or destroyed return metadata with
+	// a 404
+	if !readable {
+		resp, err := logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
+		return resp, "", err
+	}
 
-		}
+	// References are followed unless the reference itself is requested
+	if vm.Reference != "" && !data.Get("return_reference").(bool) {
+		return nil, vm.Reference, nil
+	}
+
+	// If the client already has the version, return a 304 without a body
+	// since none is allowed with this status code
+	if ifNewerThan := data.Get("if_newer_than_version").(int); ifNewerThan > 0 && verNum <= uint64(ifNewerThan) {
+		resp, err := logical.RespondWithStatusCode(nil, req, http.StatusNotModified)
+		return resp, "", err
+	}
 
-		versionKey, err := b.getVersionKey(ctx, key, verNum, req.Storage)
+	// The data of the keys requiring response wrapping is never
+	// returned in plaintext
+	if err := checkResponseWrapping(meta, requestWrapTTL(req)); err != nil {
+		return logical.ErrorResponse(err.Error()), "", logical.ErrInvalidRequest
+	}
+
+	// A reference has no data of its own
+	if vm.Reference == "" {
+		vData, err := b.readVersionData(ctx, req.Storage, key, verNum)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		resp.Data["data"] = vData
+		recordVersionSize("read", vm)
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, "", err
+	}
+	addDataReadWarnings(resp, config, meta)
+	b.recordRead(config, key, verNum)
+
+	return resp, "", nil
+}
+
+// readVersions handles the reads of several versions of a key at once. Each
+// version that exists is returned under its number with its metadata, and with
+// its data unless it is deleted or destroyed. The caller must hold the read
+// lock of the key.
+func (b *versionedKVBackend) readVersions(ctx context.Context, req *logical.Request, data *framework.FieldData, keyConfig *Configuration, meta *KeyMetadata, versions []int) (*logical.Response, error) {
+	if len(versions) == 0 {
+		return logical.ErrorResponse("no versions provided"), logical.ErrInvalidRequest
+	}
+
+	expired, err := isExpired(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	numeric := numericTimeFields(keyConfig, data)
+	now := time.Now()
+
+	var toRead []uint64
+	read := make(map[string]interface{}, len(versions))
+	for _, v := range versions {
+		if v <= 0 {
+			return logical.ErrorResponse("invalid version %d", v), logical.ErrInvalidRequest
+		}
+		verNum := uint64(v)
+		vm := meta.Versions[verNum]
+		if vm == nil {
+			continue
 		}
 
-		raw, err := req.Storage.Get(ctx, versionKey)
+		metadata, readable, err := dataReadMetadata(meta, verNum, vm, numeric, now)
 		if err != nil {
 			return nil, err
 		}
-		if raw == nil {
-			return nil, errors.New("could not find version data")
+		read[strconv.FormatUint(verNum, 10)] = map[string]interface{}{
+			"data":     nil,
+			"metadata": metadata,
+		}
+		if readable && !expired && vm.Reference == "" {
+			toRead = append(toRead, verNum)
 		}
+	}
+
+	// If none of the versions exist, return
+	if len(read) == 0 {
+		return nil, nil
+	}
 
-		version := &Version{}
-		if err := proto.Unmarshal(raw.Value, version); err != nil {
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"versions": read,
+		},
+	}
+	if expired {
+		resp.AddWarning(fmt.Sprintf("The secret expired at %s", ptypesTimestampToString(meta.ExpireAt)))
+		return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
+	}
+	if len(toRead) == 0 {
+		return resp, nil
+	}
+
+	if err := checkResponseWrapping(meta, requestWrapTTL(req)); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	for _, verNum := range toRead {
+		vData, err := b.readVersionData(ctx, req.Storage, meta.Key, verNum)
+		if err != nil {
 			return nil, err
 		}
+		read[strconv.FormatUint(verNum, 10)].(map[string]interface{})["data"] = vData
+		recordVersionSize("read", meta.Versions[verNum])
+		b.recordRead(config, meta.Key, verNum)
+	}
+	addDataReadWarnings(resp, config, meta)
 
-		vData := map[string]interface{}{}
-		if err := json.Unmarshal(version.Data, &vData); err != nil {
-			return nil, err
+	return resp, nil
+}
+
+// dataReadMetadata returns the metadata returned with the data of the version
+// verNum of the key, and whether the data of the version can be read, that is
+// whether the version is neither deleted nor destroyed at now.
+func dataReadMetadata(meta *KeyMetadata, verNum uint64, vm *VersionMetadata, numeric bool, now time.Time) (map[string]interface{}, bool, error) {
+	metadata := map[string]interface{}{
+		"version":         verNum,
+		"created_time":    ptypesTimestampToString(vm.CreatedTime),
+		"deletion_time":   ptypesTimestampToString(vm.DeletionTime),
+		"destroyed":       vm.Destroyed,
+		"custom_metadata": meta.CustomMetadata,
+	}
+	if vm.Reference != "" {
+		metadata["reference"] = vm.Reference
+	}
+	if numeric {
+		addNumericTimeFields(metadata)
+	}
+
+	if vm.DeletionTime != nil {
+		deletionTime, err := ptypes.Timestamp(vm.DeletionTime)
+		if err != nil {
+			return nil, false, err
+		}
+		switch {
+		case deletionTime.After(now):
+			// Let the consumers refresh the secret before the version
+			// disappears
+			metadata["expires_in"] = expiresIn(deletionTime, now)
+		case !vm.DeletionDeferred:
+			return metadata, false, nil
 		}
+	}
 
-		resp.Data["data"] = vData
+	return metadata, !vm.Destroyed, nil
+}
 
-		return resp, nil
+// addDataReadWarnings adds the warnings about the state of the key to the
+// response of a data read.
+func addDataReadWarnings(resp *logical.Response, config *Configuration, meta *KeyMetadata) {
+	if warning := classificationWarning(config, meta); warning != "" {
+		resp.AddWarning(warning)
+	}
+	if warning := deprecationWarning(meta); warning != "" {
+		resp.AddWarning(warning)
+	}
+	if due, dueTime := rotationDue(meta, time.Now()); due {
+		resp.AddWarning(fmt.Sprintf("The secret has been due for rotation since %s", dueTime.Format(time.RFC3339)))
+	}
+}
+
+// readVersionData returns the decoded data stored for a version of a key.
+func (b *versionedKVBackend) readVersionData(ctx context.Context, s logical.Storage, key string, verNum uint64) (map[string]interface{}, error) {
+	buf, err := b.readVersionJSON(ctx, s, key, verNum)
+	if err != nil {
+		return nil, err
+	}
+
+	vData := map[string]interface{}{}
+	if err := json.Unmarshal(buf, &vData); err != nil {
+		return nil, err
 	}
+
+	return vData, nil
+}
+
+// readVersionJSON returns the data of the version verNum of key as it was
+// written, encoded in JSON.
+func (b *versionedKVBackend) readVersionJSON(ctx context.Context, s logical.Storage, key string, verNum uint64) ([]byte, error) {
+	versionKey, err := b.getVersionKey(ctx, key, verNum, s)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := s.Get(ctx, versionKey)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, errors.New("could not find version data")
+	}
+
+	version := &Version{}
+	if err := proto.Unmarshal(raw.Value, version); err != nil {
+		return nil, err
+	}
+
+	version, err = b.resolveVersionData(ctx, s, key, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.versionPlaintext(ctx, s, version)
 }
 
 // validateCheckAndSetOption will validate the cas flag from the options map
@@ -192,15 +491,196 @@ func validateCheckAndSetOption(data *framework.FieldData, config *Configuration,
 			return errors.New("error parsing check-and-set parameter")
 		}
 		if uint64(cas) != meta.CurrentVersion {
+			recordCASFailure("mismatch")
 			return errors.New("check-and-set parameter did not match the current version")
 		}
 	} else if config.CasRequired || meta.CasRequired {
+		recordCASFailure("missing")
 		return errors.New("check-and-set parameter required for this call")
 	}
 
 	return nil
 }
 
+// versionOptions holds the settings of the options map that apply to the
+// version being written only.
+type versionOptions struct {
+	deleteVersionAfter  time.Duration
+	destroyVersionAfter time.Duration
+
+	// reference is the path the version points to if it is a reference
+	reference string
+
+	// bytesReserved is true if the caller reserved the bytes of the version
+	// against the byte quotas along with the rest of its writes
+	bytesReserved bool
+}
+
+// parseVersionOptions returns the settings of the options map provided that
+// apply to the version being written.
+func parseVersionOptions(data *framework.FieldData, config *Configuration) (versionOptions, error) {
+	var opts versionOptions
+	var err error
+
+	opts.deleteVersionAfter, err = deleteVersionAfterOption(data, config)
+	if err != nil {
+		return versionOptions{}, err
+	}
+
+	opts.destroyVersionAfter, err = destroyVersionAfterOption(data, config)
+	if err != nil {
+		return versionOptions{}, err
+	}
+
+	return opts, nil
+}
+
+// deleteVersionAfterOption returns the delete_version_after value from the
+// options map provided, or zero if it is not set. The value applies to the
+// version being written only and must fall within the bounds set by the
+// engine's config.
+func deleteVersionAfterOption(data *framework.FieldData, config *Configuration) (time.Duration, error) {
+	optionsRaw, ok := data.GetOk("options")
+	if !ok {
+		return 0, nil
+	}
+	dvaRaw, ok := optionsRaw.(map[string]interface{})["delete_version_after"]
+	if !ok {
+		return 0, nil
+	}
+
+	dva, err := parseutil.ParseDurationSecond(dvaRaw)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing delete_version_after parameter: %w", err)
+	}
+
+	switch maxDva, minDva := deleteVersionAfter(config), minDeleteVersionAfter(config); {
+	case dva <= 0:
+		return 0, errors.New("delete_version_after parameter must be positive")
+	case config.IsDeleteVersionAfterDisabled():
+		return 0, errors.New("delete_version_after is disabled on this backend")
+	case maxDva > 0 && dva > maxDva:
+		return 0, fmt.Errorf("delete_version_after parameter %s is greater than the delete_version_after %s configured on the backend", dva, maxDva)
+	case dva < minDva:
+		return 0, fmt.Errorf("delete_version_after parameter %s is less than the min_delete_version_after %s configured on the backend", dva, minDva)
+	}
+
+	return dva, nil
+}
+
+// destroyVersionAfterOption returns the destroy_version_after value from the
+// options map provided, or zero if it is not set. It cannot be set while the
+// destroys must be confirmed, as the version would be destroyed without a
+// confirmation.
+func destroyVersionAfterOption(data *framework.FieldData, config *Configuration) (time.Duration, error) {
+	optionsRaw, ok := data.GetOk("options")
+	if !ok {
+		return 0, nil
+	}
+	dvaRaw, ok := optionsRaw.(map[string]interface{})["destroy_version_after"]
+	if !ok {
+		return 0, nil
+	}
+
+	dva, err := parseutil.ParseDurationSecond(dvaRaw)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing destroy_version_after parameter: %w", err)
+	}
+	if dva <= 0 {
+		return 0, errors.New("destroy_version_after parameter must be positive")
+	}
+	if err := checkDualControlSetting(config, "destroy_version_after"); err != nil {
+		return 0, err
+	}
+
+	return dva, nil
+}
+
+const stringValuesValidationErrorPrefix = "string_values_only validation failed"
+
+// validateStringValues ensures every value of the JSON encoded data is a
+// string. Consumers such as environment variable injectors cannot represent
+// numbers, booleans or nested objects faithfully, so mounts serving them can
+// opt into rejecting those values instead of having them silently mangled.
+func validateStringValues(data []byte) error {
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	var errs *multierror.Error
+	for key, value := range values {
+		if _, ok := value.(string); !ok {
+			errs = multierror.Append(errs, fmt.Errorf("%s: value for key %q is %s but must be a string",
+				stringValuesValidationErrorPrefix,
+				key,
+				jsonTypeName(value)))
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// jsonTypeName returns the JSON type name of a decoded JSON value.
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case float64, json.Number:
+		return "a number"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+const dataKeyPatternValidationErrorPrefix = "data_key_pattern validation failed"
+
+// compileDataKeyPattern compiles the configured data_key_pattern so that it
+// must match the whole field name. A nil regexp is returned for an empty
+// pattern.
+func compileDataKeyPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// validateDataKeys ensures the name of every field of the JSON encoded data
+// matches the provided pattern and reports every offending field.
+func validateDataKeys(pattern string, data []byte) error {
+	re, err := compileDataKeyPattern(pattern)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dataKeyPatternValidationErrorPrefix, err)
+	}
+	if re == nil {
+		return nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	var errs *multierror.Error
+	for key := range values {
+		if !re.MatchString(key) {
+			errs = multierror.Append(errs, fmt.Errorf("%s: key %q does not match %q",
+				dataKeyPatternValidationErrorPrefix,
+				key,
+				pattern))
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
 // cleanupOldVersions is responsible for cleaning up old versions. Once a key
 // has more than the configured allowed versions the oldest version will be
 // permanently deleted. A list of version keys to delete will be created.
@@ -244,6 +724,176 @@ func (b *versionedKVBackend) cleanupOldVersions(ctx context.Context, storage log
 	return ""
 }
 
+// validateVersionData performs the validations that every new version of a
+// key must pass regardless of the operation that produced it.
+func validateVersionData(config *Configuration, meta *KeyMetadata, data []byte) error {
+	if err := validateDataSchema(dataSchema(config, meta), data); err != nil {
+		return err
+	}
+
+	if config.StringValuesOnly {
+		if err := validateStringValues(data); err != nil {
+			return err
+		}
+	}
+
+	if err := validateDataKeys(config.DataKeyPattern, data); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// writeVersion stores data as the next version of the key described by meta.
+// The deletion_time of the version is set based on the delete_version_after
+// value of either the key metadata or the engine's config, the key metadata is
+// updated and persisted and the versions that fell out of the max_versions
+// window or are older than the max_version_age are cleaned up. The caller must
+// hold the lock for the key. A non-empty warning is returned if the cleanup of
+// old versions failed.
+func (b *versionedKVBackend) writeVersion(ctx context.Context, s logical.Storage, config *Configuration, meta *KeyMetadata, data []byte, opts versionOptions) (*VersionMetadata, string, error) {
+	if meta.Deleting {
+		return nil, "", errKeyDeleting
+	}
+
+	// Create a version key for the new version
+	versionKey, err := b.getVersionKey(ctx, meta.Key, meta.CurrentVersion+1, s)
+	if err != nil {
+		return nil, "", err
+	}
+	version := &Version{
+		Data:        data,
+		CreatedTime: ptypes.TimestampNow(),
+	}
+
+	// The data is only hashed if deduplication is enabled, in which case the
+	// versions identical to a kept version share its stored data. A
+	// reference has no data to hash.
+	var hash string
+	if opts.reference == "" && config.DeduplicateVersions {
+		hash, err = b.contentHash(ctx, s, data)
+		if err != nil {
+			return nil, "", err
+		}
+		version.DataVersion = sharedDataVersion(meta, hash)
+	}
+	if version.DataVersion > 0 {
+		version.Data = nil
+	} else if err := b.encryptVersion(ctx, s, version); err != nil {
+		return nil, "", err
+	}
+
+	ctime, err := ptypes.Timestamp(version.CreatedTime)
+	if err != nil {
+		return nil, "", fmt.Errorf("unexpected error converting %T(%v) to time.Time: %v", version.CreatedTime, version.CreatedTime, err)
+	}
+
+	var dtime time.Time
+	var ok bool
+	switch {
+	case opts.deleteVersionAfter > 0:
+		dtime, ok = ctime.Add(opts.deleteVersionAfter), true
+	case !config.IsDeleteVersionAfterDisabled():
+		dtime, ok = deletionTime(ctime, deleteVersionAfter(config), deleteVersionAfter(meta))
+	}
+	if ok {
+		dt, err := ptypes.TimestampProto(dtime)
+		if err != nil {
+			return nil, "", fmt.Errorf("error setting deletion_time: converting %v to protobuf: %v", dtime, err)
+		}
+		version.DeletionTime = dt
+	}
+
+	buf, err := proto.Marshal(version)
+	if err != nil {
+		return nil, "", err
+	}
+	if !opts.bytesReserved {
+		release, err := b.checkByteQuotas(config, meta.Key, uint64(len(buf)))
+		if err != nil {
+			return nil, "", err
+		}
+		defer release()
+	}
+
+	// Write the new version before the metadata referencing it
+	if err := s.Put(ctx, &logical.StorageEntry{
+		Key:      versionKey,
+		Value:    buf,
+		SealWrap: config.SealWrapVersions,
+	}); err != nil {
+		return nil, "", err
+	}
+
+	// Add version to the key metadata and calculate version to delete
+	// based on the max_versions specified by either the secret's key
+	// metadata or the engine's config, within the engine's
+	// max_versions_limit
+	clampMaxVersions(config, meta)
+	vm, versionToDelete := meta.AddVersion(version.CreatedTime, version.DeletionTime, configMaxVersions(config, meta))
+	vm.Size = uint64(len(buf))
+	vm.Reference = opts.reference
+	vm.ContentHash = hash
+	vm.DataVersion = version.DataVersion
+	if opts.destroyVersionAfter > 0 {
+		vm.DestroyVersionAfter = ptypes.DurationProto(opts.destroyVersionAfter)
+	}
+
+	// Also remove the versions that are older than the max_version_age
+	if age := maxVersionAge(config, meta); age > 0 {
+		v, err := meta.PruneVersionsCreatedBefore(ctime.Add(-age), minVersions(config, meta))
+		if err != nil {
+			return nil, "", err
+		}
+		if v > versionToDelete {
+			versionToDelete = v
+		}
+	}
+
+	// and the oldest versions exceeding the max_history_bytes
+	if limit := maxHistoryBytes(config, meta); limit > 0 {
+		if _, err := b.recordVersionSizes(ctx, s, meta); err != nil {
+			return nil, "", err
+		}
+		v, err := meta.PruneVersionsExceedingBytes(limit, minVersions(config, meta))
+		if err != nil {
+			return nil, "", err
+		}
+		if v > versionToDelete {
+			versionToDelete = v
+		}
+	}
+
+	// Keep the most recent versions retrievable
+	if _, err := meta.ApplyMinVersions(minVersions(config, meta)); err != nil {
+		return nil, "", err
+	}
+
+	// Destroy the deleted versions whose destroy_version_after has elapsed
+	// instead of waiting for the periodic function to reach the key
+	destroyed, err := versionsDueForDestroy(config, meta, ctime)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, verNum := range destroyed {
+		meta.Versions[verNum].Destroyed = true
+	}
+
+	if err := b.rehomeSharedData(ctx, s, meta); err != nil {
+		return nil, "", err
+	}
+
+	err = b.writeKeyMetadata(ctx, s, meta)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := b.deleteVersions(ctx, s, meta.Key, destroyed); err != nil {
+		return nil, "", err
+	}
+
+	return vm, b.cleanupOldVersions(ctx, s, meta.Key, versionToDelete), nil
+}
+
 // pathDataWrite handles create and update commands to a kv entry
 func (b *versionedKVBackend) pathDataWrite() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -252,17 +902,36 @@ func (b *versionedKVBackend) pathDataWrite() framework.OperationFunc {
 			return logical.ErrorResponse("missing path"), nil
 		}
 
-		config, err := b.config(ctx, req.Storage)
+		config, err := b.keyConfig(ctx, req.Storage, key)
 		if err != nil {
 			return nil, err
 		}
 
+		if err := checkPathAllowed(config, key); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
+		opts, err := parseVersionOptions(data, config)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
 		// Parse data, this can happen before the lock so we can fail early if
 		// not set.
 		var marshaledData []byte
 		{
 			dataRaw, ok := data.GetOk("data")
-			if !ok {
+			referenceRaw, refOk := data.GetOk("reference")
+			switch {
+			case ok && refOk:
+				return logical.ErrorResponse("data and reference cannot be combined"), logical.ErrInvalidRequest
+			case refOk:
+				opts.reference = referenceRaw.(string)
+				if err := checkReferenceTarget(config, key, opts.reference); err != nil {
+					return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+				}
+				dataRaw = map[string]interface{}{}
+			case !ok:
 				return logical.ErrorResponse("no data provided"), logical.ErrInvalidRequest
 			}
 			marshaledData, err = json.Marshal(dataRaw.(map[string]interface{}))
@@ -271,73 +940,88 @@ func (b *versionedKVBackend) pathDataWrite() framework.OperationFunc {
 			}
 		}
 
-		lock := locksutil.LockForKey(b.locks, key)
-		lock.Lock()
-		defer lock.Unlock()
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
 
 		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
 		if err != nil {
 			return nil, err
 		}
+		var quotaWarning string
 		if meta == nil {
 			meta = &KeyMetadata{
-				Key:      key,
-				Versions: map[uint64]*VersionMetadata{},
+				Key:            key,
+				Versions:       map[uint64]*VersionMetadata{},
+				CustomMetadata: defaultCustomMetadata(config, key, nil),
 			}
-		}
+			applyTemplate(config, meta)
 
-		err = validateCheckAndSetOption(data, config, meta)
-		if err != nil {
-			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
-		}
+			if err := validateRequiredCustomMetadata(config, meta.CustomMetadata); err != nil {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
 
-		// Create a version key for the new version
-		versionKey, err := b.getVersionKey(ctx, key, meta.CurrentVersion+1, req.Storage)
-		if err != nil {
-			return nil, err
+			var release func()
+			release, quotaWarning, err = b.checkKeyQuotas(ctx, req.Storage, config, []string{key})
+			if errors.Is(err, errKeyQuotaExceeded) {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
+			if err != nil {
+				return nil, err
+			}
+			defer release()
 		}
-		version := &Version{
-			Data:        marshaledData,
-			CreatedTime: ptypes.TimestampNow(),
+		if meta.Deleting {
+			return logical.ErrorResponse(errKeyDeleting.Error()), logical.ErrInvalidRequest
 		}
 
-		ctime, err := ptypes.Timestamp(version.CreatedTime)
+		err = validateCheckAndSetOption(data, config, meta)
 		if err != nil {
-			return logical.ErrorResponse("unexpected error converting %T(%v) to time.Time: %v", version.CreatedTime, version.CreatedTime, err), logical.ErrInvalidRequest
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 		}
 
-		if !config.IsDeleteVersionAfterDisabled() {
-			if dtime, ok := deletionTime(ctime, deleteVersionAfter(config), deleteVersionAfter(meta)); ok {
-				dt, err := ptypes.TimestampProto(dtime)
-				if err != nil {
-					return logical.ErrorResponse("error setting deletion_time: converting %v to protobuf: %v", dtime, err), logical.ErrInvalidRequest
-				}
-				version.DeletionTime = dt
+		// A reference has no data to validate
+		if opts.reference == "" {
+			err = validateVersionData(config, meta, marshaledData)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 			}
 		}
 
-		buf, err := proto.Marshal(version)
+		skipped, noopWarning, err := b.checkNoopWrite(ctx, req.Storage, config, meta, marshaledData, opts)
 		if err != nil {
 			return nil, err
 		}
-
-		// Write the new version
-		if err := req.Storage.Put(ctx, &logical.StorageEntry{
-			Key:   versionKey,
-			Value: buf,
-		}); err != nil {
-			return nil, err
+		if skipped != nil {
+			return skipped, nil
 		}
 
-		// Add version to the key metadata and calculate version to delete
-		// based on the max_versions specified by either the secret's key
-		// metadata or the engine's config
-		vm, versionToDelete := meta.AddVersion(version.CreatedTime, version.DeletionTime, config.MaxVersions)
+		// A reference has no keys of its own to compare. The changed keys
+		// are computed before the version is written so that a write that
+		// succeeded is never reported as failed.
+		var changed map[string]string
+		if opts.reference == "" {
+			previous, err := b.previousVersionJSON(ctx, req.Storage, config, meta)
+			if err != nil {
+				return nil, err
+			}
+			changed, err = changedKeysMetadata(config, previous, marshaledData)
+			if err != nil {
+				return nil, err
+			}
+		}
 
-		err = b.writeKeyMetadata(ctx, req.Storage, meta)
+		vm, warning, err := b.writeVersion(ctx, req.Storage, config, meta, marshaledData, opts)
+		if errors.Is(err, errByteQuotaExceeded) {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
 		if err != nil {
 			return nil, err
 		}
+		recordVersionSize("write", vm)
+		b.sendEventWithMetadata(ctx, req, eventDataWrite, key, []uint64{meta.CurrentVersion}, changed)
 
 		resp := &logical.Response{
 			Data: map[string]interface{}{
@@ -349,12 +1033,17 @@ func (b *versionedKVBackend) pathDataWrite() framework.OperationFunc {
 			},
 		}
 
-		warning := b.cleanupOldVersions(ctx, req.Storage, key, versionToDelete)
 		if warning != "" {
 			// A failed attempt to clean up old versions will be retried on
 			// next write attempt, prefer a warning over an error resp
 			resp.AddWarning(warning)
 		}
+		if quotaWarning != "" {
+			resp.AddWarning(quotaWarning)
+		}
+		if noopWarning != "" {
+			resp.AddWarning(noopWarning)
+		}
 
 		return resp, nil
 	}
@@ -397,6 +1086,28 @@ func (b *versionedKVBackend) pathDataPatch() framework.OperationFunc {
 			return logical.ErrorResponse("no data provided"), logical.ErrInvalidRequest
 		}
 
+		config, err := b.keyConfig(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkPathAllowed(config, key); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
+		opts, err := parseVersionOptions(data, config)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+
+		// The metadata must be read while holding the lock so that a
+		// concurrent write or delete cannot be overwritten
 		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
 		if err != nil {
 			return nil, err
@@ -407,10 +1118,8 @@ func (b *versionedKVBackend) pathDataPatch() framework.OperationFunc {
 		if meta == nil {
 			return nil, nil
 		}
-
-		config, err := b.config(ctx, req.Storage)
-		if err != nil {
-			return nil, err
+		if meta.Deleting {
+			return logical.ErrorResponse(errKeyDeleting.Error()), logical.ErrInvalidRequest
 		}
 
 		err = validateCheckAndSetOption(data, config, meta)
@@ -418,10 +1127,6 @@ func (b *versionedKVBackend) pathDataPatch() framework.OperationFunc {
 			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 		}
 
-		lock := locksutil.LockForKey(b.locks, key)
-		lock.Lock()
-		defer lock.Unlock()
-
 		currentVersion := meta.CurrentVersion
 
 		versionMetadata := meta.Versions[currentVersion]
@@ -443,42 +1148,27 @@ func (b *versionedKVBackend) pathDataPatch() framework.OperationFunc {
 			},
 		}
 
-		if versionMetadata.DeletionTime != nil {
-			deletionTime, err := ptypes.Timestamp(versionMetadata.DeletionTime)
-			if err != nil {
-				return nil, err
-			}
-
-			if deletionTime.Before(time.Now()) {
-				return logical.RespondWithStatusCode(notFoundResp, req, http.StatusNotFound)
-			}
+		if deleted, err := isDeleted(versionMetadata); err != nil {
+			return nil, err
+		} else if deleted {
+			return logical.RespondWithStatusCode(notFoundResp, req, http.StatusNotFound)
 		}
 
 		if versionMetadata.Destroyed {
 			return logical.RespondWithStatusCode(notFoundResp, req, http.StatusNotFound)
 		}
 
-		currentVersionKey, err := b.getVersionKey(ctx, key, currentVersion, req.Storage)
-		if err != nil {
-			return nil, err
+		if versionMetadata.Reference != "" {
+			return logical.ErrorResponse("the current version is a reference to %q and cannot be patched", versionMetadata.Reference), logical.ErrInvalidRequest
 		}
 
-		raw, err := req.Storage.Get(ctx, currentVersionKey)
+		existingData, err := b.readVersionJSON(ctx, req.Storage, key, currentVersion)
 		if err != nil {
 			return nil, err
 		}
-		if raw == nil {
-			return nil, errors.New("could not find version data")
-		}
-
-		existingVersion := &Version{}
-
-		if err := proto.Unmarshal(raw.Value, existingVersion); err != nil {
-			return nil, err
-		}
 
 		var versionData map[string]interface{}
-		if err := json.Unmarshal(existingVersion.Data, &versionData); err != nil {
+		if err := json.Unmarshal(existingData, &versionData); err != nil {
 			return nil, err
 		}
 
@@ -487,54 +1177,33 @@ func (b *versionedKVBackend) pathDataPatch() framework.OperationFunc {
 			return nil, err
 		}
 
-		newVersion := &Version{
-			Data:        patchedBytes,
-			CreatedTime: ptypes.TimestampNow(),
-		}
-
-		ctime, err := ptypes.Timestamp(newVersion.CreatedTime)
+		err = validateVersionData(config, meta, patchedBytes)
 		if err != nil {
-			return logical.ErrorResponse("unexpected error converting %T(%v) to time.Time: %v", newVersion.CreatedTime, newVersion.CreatedTime, err), logical.ErrInvalidRequest
-		}
-
-		// Set the deletion_time for the new version based on delete_version_after value if set
-		// on either the secret's key metadata or the engine's config
-		if !config.IsDeleteVersionAfterDisabled() {
-			if dtime, ok := deletionTime(ctime, deleteVersionAfter(config), deleteVersionAfter(meta)); ok {
-				dt, err := ptypes.TimestampProto(dtime)
-				if err != nil {
-					return logical.ErrorResponse("error setting deletion_time: converting %v to protobuf: %v", dtime, err), logical.ErrInvalidRequest
-				}
-				newVersion.DeletionTime = dt
-			}
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 		}
 
-		buf, err := proto.Marshal(newVersion)
+		skipped, noopWarning, err := b.checkNoopWrite(ctx, req.Storage, config, meta, patchedBytes, opts)
 		if err != nil {
 			return nil, err
 		}
+		if skipped != nil {
+			return skipped, nil
+		}
 
-		newVersionKey, err := b.getVersionKey(ctx, key, meta.CurrentVersion+1, req.Storage)
+		changed, err := changedKeysMetadata(config, existingData, patchedBytes)
 		if err != nil {
 			return nil, err
 		}
 
-		if err := req.Storage.Put(ctx, &logical.StorageEntry{
-			Key:   newVersionKey,
-			Value: buf,
-		}); err != nil {
-			return nil, err
+		newVersionMetadata, warning, err := b.writeVersion(ctx, req.Storage, config, meta, patchedBytes, opts)
+		if errors.Is(err, errByteQuotaExceeded) {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 		}
-
-		// Add version to the key metadata and calculate version to delete
-		// based on the max_versions specified by either the secret's key
-		// metadata or the engine's config
-		newVersionMetadata, versionToDelete := meta.AddVersion(newVersion.CreatedTime, newVersion.DeletionTime, config.MaxVersions)
-
-		err = b.writeKeyMetadata(ctx, req.Storage, meta)
 		if err != nil {
 			return nil, err
 		}
+		recordVersionSize("write", newVersionMetadata)
+		b.sendEventWithMetadata(ctx, req, eventDataWrite, key, []uint64{meta.CurrentVersion}, changed)
 
 		resp := &logical.Response{
 			Data: map[string]interface{}{
@@ -546,12 +1215,14 @@ func (b *versionedKVBackend) pathDataPatch() framework.OperationFunc {
 			},
 		}
 
-		warning := b.cleanupOldVersions(ctx, req.Storage, key, versionToDelete)
 		if warning != "" {
 			// A failed attempt to clean up old versions will be retried on
 			// next patch attempt, prefer a warning over an error resp
 			resp.AddWarning(warning)
 		}
+		if noopWarning != "" {
+			resp.AddWarning(noopWarning)
+		}
 
 		return resp, nil
 	}
@@ -561,9 +1232,11 @@ func (b *versionedKVBackend) pathDataDelete() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		key := data.Get("path").(string)
 
-		lock := locksutil.LockForKey(b.locks, key)
-		lock.Lock()
-		defer lock.Unlock()
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
 
 		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
 		if err != nil {
@@ -580,23 +1253,31 @@ func (b *versionedKVBackend) pathDataDelete() framework.OperationFunc {
 			return nil, nil
 		}
 
-		if lv.DeletionTime != nil {
-			deletionTime, err := ptypes.Timestamp(lv.DeletionTime)
-			if err != nil {
-				return nil, err
-			}
+		if deleted, err := isDeleted(lv); err != nil || deleted {
+			return nil, err
+		}
 
-			if deletionTime.Before(time.Now()) {
-				return nil, nil
-			}
+		config, err := b.keyConfig(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkMinVersions(config, meta, []uint64{meta.CurrentVersion}); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 		}
 
 		lv.DeletionTime = ptypes.TimestampNow()
+		lv.DeferredDeletionTime = nil
+		lv.DeletionDeferred = false
+
+		if _, err := meta.ApplyMinVersions(minVersions(config, meta)); err != nil {
+			return nil, err
+		}
 
 		err = b.writeKeyMetadata(ctx, req.Storage, meta)
 		if err != nil {
 			return nil, err
 		}
+		b.sendEvent(ctx, req, eventDataDelete, key, []uint64{meta.CurrentVersion})
 
 		return nil, nil
 	}
@@ -646,6 +1327,21 @@ func (k *KeyMetadata) AddVersion(createdTime, deletionTime *timestamp.Timestamp,
 	return vm, 0
 }
 
+// isDeleted returns true if the deletion_time of the version has passed and
+// min_versions does not defer it.
+func isDeleted(vm *VersionMetadata) (bool, error) {
+	if vm.DeletionTime == nil || vm.DeletionDeferred {
+		return false, nil
+	}
+
+	deletionTime, err := ptypes.Timestamp(vm.DeletionTime)
+	if err != nil {
+		return false, err
+	}
+
+	return !deletionTime.After(time.Now()), nil
+}
+
 func max(a, b uint32) uint32 {
 	if b > a {
 		return b
@@ -672,7 +1368,48 @@ the patch command and the data object is used to perform a partial update on the
 current version of the secret and store the encrypted result in the storage backend. 
 
 A read operation will return the latest version for a key unless the "version"
-parameter is set, then it returns the version at that number.
+parameter is set, then it returns the version at that number. The metadata
+returned with the data always includes the custom_metadata of the key, so that
+it does not have to be read separately. If the "numeric_time_fields" parameter
+is true, or if it is not set and it is enabled in the backend's configuration,
+the metadata also includes the timestamps as Unix epochs, such as
+"created_time_unix".
+
+If the current version is deleted or destroyed and the "read_fallback"
+parameter is true, or if it is not set and it is enabled in the backend's
+configuration, a read operation without a "version" returns the newest version
+that is neither, with a warning, instead of a 404.
+
+If the "if_newer_than_version" parameter is set, a read operation of a version
+that is not newer than this version number returns a 304 status code without
+data, so that the clients polling a secret do not read it again until it
+changes.
+
+If the "versions" parameter is set to a comma separated list of version numbers,
+a read operation returns the data and the metadata of each of those versions
+that exists under "versions", keyed by version number. The data of the deleted
+and destroyed versions is null.
+
+If the "overlay" parameter is set, a read operation merges the data of the
+current version of the secret at "<path>/overlays/<overlay>" into the data
+returned, its fields overriding the ones of the secret, so that the settings
+of an environment can be layered over the shared ones. The version of the
+overlay read is returned in "overlay". If the overlay cannot be read, the data
+of the secret is returned with a warning.
+
+The overlays can only be read if they match the overlay_paths of the backend
+configuration. The overlay is read by the plugin itself, so the ACL policies
+of Vault are only checked against "data/<path>": a token allowed to read the
+secret can read all of its overlays that overlay_paths allows.
+
+If a write operation sets "reference" to the path of another secret instead of
+"data", the version written is a reference to that secret. A read operation of
+a reference returns the data and the metadata of the current version of the
+secret it points to, following the references it reaches, along with its path
+in "resolved_path". The "return_reference" parameter returns the reference
+itself, with the path it points to in the "reference" metadata field and null
+data. The paths that can be referenced are restricted by the
+"reference_targets" of the backend's configuration.
 
 Delete operations are a soft delete. They will mark the latest version as
 deleted, but the underlying data will not be fully removed. Delete operations
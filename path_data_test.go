@@ -7,6 +7,7 @@ import (
 	"github.com/go-test/deep"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -27,6 +28,9 @@ func getBackend(t *testing.T) (logical.Backend, logical.Storage) {
 	if err != nil {
 		t.Fatalf("unable to create backend: %v", err)
 	}
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: config.StorageView}); err != nil {
+		t.Fatalf("unable to initialize backend: %v", err)
+	}
 
 	// Wait for the upgrade to finish
 	time.Sleep(time.Second)
@@ -658,7 +662,7 @@ func TestVersionedKV_Patch_CASValidation(t *testing.T) {
 	}
 
 	req := &logical.Request{
-		Operation: logical.CreateOperation,
+		Operation: logical.UpdateOperation,
 		Path:      "config",
 		Storage:   storage,
 		Data:      config,
@@ -1046,7 +1050,7 @@ func TestVersionedKV_Patch_CurrentVersionDestroyed(t *testing.T) {
 	}
 
 	req = &logical.Request{
-		Operation: logical.CreateOperation,
+		Operation: logical.UpdateOperation,
 		Path:      "destroy/foo",
 		Storage:   storage,
 		Data:      versionsToDestroy,
@@ -1134,3 +1138,507 @@ func TestVersionedKV_Patch_CurrentVersionDestroyed(t *testing.T) {
 		t.Fatalf("Expected 404 status code for destroyed version: resp:%#v\n", resp)
 	}
 }
+
+func TestVersionedKV_Data_Put_StringValuesOnly(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"string_values_only": true,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"string": "bar",
+				"number": 1,
+				"bool":   true,
+				"object": map[string]interface{}{"nested": "value"},
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected validation error, err:%s resp:%#v\n", err, resp)
+	}
+
+	respError := resp.Error().Error()
+	if !strings.Contains(respError, "3 errors occurred") {
+		t.Fatalf("expected 3 validation errors, resp: %#v", resp)
+	}
+	for _, want := range []string{
+		`value for key "number" is a number`,
+		`value for key "bool" is a boolean`,
+		`value for key "object" is an object`,
+	} {
+		if !strings.Contains(respError, want) {
+			t.Fatalf("expected error %q, resp: %#v", want, resp)
+		}
+	}
+
+	req.Data = map[string]interface{}{
+		"data": map[string]interface{}{
+			"string": "bar",
+			"number": "1",
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.PatchOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"number": 1,
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected validation error, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Data_Put_DataKeyPattern(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data_key_pattern": "[A-Z",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected invalid pattern error, err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data = map[string]interface{}{
+		"data_key_pattern": "[A-Z0-9_]+",
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"DB_PASSWORD": "secret",
+				"db_user":     "admin",
+				"DB-HOST":     "localhost",
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected validation error, err:%s resp:%#v\n", err, resp)
+	}
+
+	respError := resp.Error().Error()
+	if !strings.Contains(respError, "2 errors occurred") {
+		t.Fatalf("expected 2 validation errors, resp: %#v", resp)
+	}
+	if !strings.Contains(respError, `key "db_user"`) || !strings.Contains(respError, `key "DB-HOST"`) {
+		t.Fatalf("expected errors for db_user and DB-HOST, resp: %#v", resp)
+	}
+
+	req.Data = map[string]interface{}{
+		"data": map[string]interface{}{
+			"DB_PASSWORD": "secret",
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Data_Put_DeleteVersionAfterOption(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"delete_version_after":     "24h",
+			"min_delete_version_after": "1m",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for _, dva := range []interface{}{"48h", "30s", "-1h", "bad"} {
+		req = &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"options": map[string]interface{}{
+					"delete_version_after": dva,
+				},
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%v: expected error, err:%s resp:%#v\n", dva, err, resp)
+		}
+	}
+
+	req.Data["options"] = map[string]interface{}{
+		"delete_version_after": "5m",
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, resp.Data["created_time"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := time.Parse(time.RFC3339Nano, resp.Data["deletion_time"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Sub(created) != 5*time.Minute {
+		t.Fatalf("expected the version to be deleted after 5m, resp: %#v", resp)
+	}
+
+	// The override only applies to the version being written
+	delete(req.Data, "options")
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	created, err = time.Parse(time.RFC3339Nano, resp.Data["created_time"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err = time.Parse(time.RFC3339Nano, resp.Data["deletion_time"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Sub(created) != 24*time.Hour {
+		t.Fatalf("expected the version to be deleted after 24h, resp: %#v", resp)
+	}
+}
+
+func TestVersionedKV_Data_Get_ExpiresIn(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if _, ok := resp.Data["metadata"].(map[string]interface{})["expires_in"]; ok {
+		t.Fatalf("expected no expires_in without a deletion time, resp: %#v", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"delete_version_after": "1h",
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "qux",
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expiresIn, ok := resp.Data["metadata"].(map[string]interface{})["expires_in"].(int64)
+	if !ok || expiresIn <= 0 || expiresIn > int64(time.Hour/time.Second) {
+		t.Fatalf("expected expires_in to be within an hour, resp: %#v", resp)
+	}
+}
+
+func TestVersionedKV_Data_Get_Versions(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for i := 1; i <= 3; i++ {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": fmt.Sprintf("baz%d", i),
+				},
+			},
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "delete/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"versions": "2",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"versions": "1,2,3,4",
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	versions := resp.Data["versions"].(map[string]interface{})
+	if len(versions) != 3 {
+		t.Fatalf("expected the 3 existing versions, resp: %#v", resp)
+	}
+	for _, v := range []string{"1", "3"} {
+		version := versions[v].(map[string]interface{})
+		if diff := deep.Equal(version["data"], map[string]interface{}{"bar": "baz" + v}); len(diff) > 0 {
+			t.Fatalf("version %s: %v", v, diff)
+		}
+	}
+	deleted := versions["2"].(map[string]interface{})
+	if deleted["data"] != nil || deleted["metadata"].(map[string]interface{})["deletion_time"] == "" {
+		t.Fatalf("expected the deleted version to be returned without data, resp: %#v", resp)
+	}
+
+	req.Data["version"] = 1
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected error, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Data_Get_IfNewerThanVersion(t *testing.T) {
+	b, storage := getBackend(t)
+
+	write := func() {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	read := func(ifNewerThan int) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"if_newer_than_version": ifNewerThan,
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	write()
+
+	resp := read(1)
+	if resp.Data["http_status_code"] != 304 || resp.Data["http_raw_body"] != nil {
+		t.Fatalf("expected a 304 without a body, resp: %#v", resp)
+	}
+
+	write()
+
+	resp = read(1)
+	if resp.Data["data"] == nil || resp.Data["metadata"].(map[string]interface{})["version"] != uint64(2) {
+		t.Fatalf("expected version 2 to be returned, resp: %#v", resp)
+	}
+}
+
+// sealWrapRecordingStorage records whether the entries written were marked
+// for seal wrapping, which the in-memory storage does not keep.
+type sealWrapRecordingStorage struct {
+	logical.Storage
+
+	l           sync.Mutex
+	sealWrapped map[string]bool
+}
+
+func (s *sealWrapRecordingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	s.l.Lock()
+	s.sealWrapped[entry.Key] = entry.SealWrap
+	s.l.Unlock()
+
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestVersionedKV_Data_Put_SealWrapVersions(t *testing.T) {
+	b, inmem := getBackend(t)
+	storage := &sealWrapRecordingStorage{
+		Storage:     inmem,
+		sealWrapped: map[string]bool{},
+	}
+
+	write := func() {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	sealWrapped := func(version uint64) bool {
+		versionKey, err := b.(*versionedKVBackend).getVersionKey(context.Background(), "foo", version, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storage.l.Lock()
+		defer storage.l.Unlock()
+		sealWrapped, ok := storage.sealWrapped[versionKey]
+		if !ok {
+			t.Fatalf("version %d not written", version)
+		}
+		return sealWrapped
+	}
+
+	write()
+	if sealWrapped(1) {
+		t.Fatal("expected the version not to be marked for seal wrapping")
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"seal_wrap_versions": true,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	write()
+	if !sealWrapped(2) {
+		t.Fatal("expected the version to be marked for seal wrapping")
+	}
+}
@@ -6,7 +6,6 @@ import (
 
 	"github.com/golang/protobuf/ptypes"
 	"github.com/hashicorp/vault/sdk/framework"
-	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -15,7 +14,7 @@ func pathsDelete(b *versionedKVBackend) []*framework.Path {
 	return []*framework.Path{
 		&framework.Path{
 			Pattern: "delete/" + framework.MatchAllRegex("path"),
-			Fields: map[string]*framework.FieldSchema{
+			Fields: withVersionSelectorFields(map[string]*framework.FieldSchema{
 				"path": {
 					Type:        framework.TypeString,
 					Description: "Location of the secret.",
@@ -24,10 +23,9 @@ func pathsDelete(b *versionedKVBackend) []*framework.Path {
 					Type:        framework.TypeCommaIntSlice,
 					Description: "The versions to be archived. The versioned data will not be deleted, but it will no longer be returned in normal get requests.",
 				},
-			},
+			}),
 			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.UpdateOperation: b.upgradeCheck(b.pathDeleteWrite()),
-				logical.CreateOperation: b.upgradeCheck(b.pathDeleteWrite()),
+				logical.UpdateOperation: b.instrument("delete", b.upgradeCheck(b.reservedPrefixCheck(b.pathDeleteWrite()))),
 			},
 
 			HelpSynopsis:    deleteHelpSyn,
@@ -46,8 +44,7 @@ func pathsDelete(b *versionedKVBackend) []*framework.Path {
 				},
 			},
 			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.UpdateOperation: b.upgradeCheck(b.pathUndeleteWrite()),
-				logical.CreateOperation: b.upgradeCheck(b.pathUndeleteWrite()),
+				logical.UpdateOperation: b.instrument("undelete", b.upgradeCheck(b.reservedPrefixCheck(b.pathUndeleteWrite()))),
 			},
 
 			HelpSynopsis:    undeleteHelpSyn,
@@ -66,14 +63,16 @@ func (b *versionedKVBackend) pathUndeleteWrite() framework.OperationFunc {
 			return logical.ErrorResponse("No version number provided"), logical.ErrInvalidRequest
 		}
 
-		config, err := b.config(ctx, req.Storage)
+		config, err := b.keyConfig(ctx, req.Storage, key)
 		if err != nil {
 			return nil, err
 		}
 
-		lock := locksutil.LockForKey(b.locks, key)
-		lock.Lock()
-		defer lock.Unlock()
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
 
 		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
 		if err != nil {
@@ -82,6 +81,9 @@ func (b *versionedKVBackend) pathUndeleteWrite() framework.OperationFunc {
 		if meta == nil {
 			return nil, nil
 		}
+		if meta.Deleting {
+			return logical.ErrorResponse(errKeyDeleting.Error()), logical.ErrInvalidRequest
+		}
 
 		for _, verNum := range versions {
 			// If there is no version or the version is destroyed continue
@@ -90,6 +92,8 @@ func (b *versionedKVBackend) pathUndeleteWrite() framework.OperationFunc {
 				continue
 			}
 			lv.DeletionTime = nil
+			lv.DeferredDeletionTime = nil
+			lv.DeletionDeferred = false
 
 			if !config.IsDeleteVersionAfterDisabled() {
 				if dtime, ok := deletionTime(time.Now(), deleteVersionAfter(config), deleteVersionAfter(meta)); ok {
@@ -101,10 +105,16 @@ func (b *versionedKVBackend) pathUndeleteWrite() framework.OperationFunc {
 				}
 			}
 		}
+
+		if _, err := meta.ApplyMinVersions(minVersions(config, meta)); err != nil {
+			return nil, err
+		}
+
 		err = b.writeKeyMetadata(ctx, req.Storage, meta)
 		if err != nil {
 			return nil, err
 		}
+		b.sendEvent(ctx, req, eventDataUndelete, key, versionNumbers(versions))
 
 		return nil, nil
 	}
@@ -116,13 +126,21 @@ func (b *versionedKVBackend) pathDeleteWrite() framework.OperationFunc {
 		key := data.Get("path").(string)
 
 		versions := data.Get("versions").([]int)
-		if len(versions) == 0 {
+		selector, err := parseVersionSelector(data, time.Now())
+		switch {
+		case err != nil:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		case selector != nil && len(versions) > 0:
+			return logical.ErrorResponse("versions cannot be combined with before_version, before_time or older_than"), logical.ErrInvalidRequest
+		case selector == nil && len(versions) == 0:
 			return logical.ErrorResponse("No version number provided"), logical.ErrInvalidRequest
 		}
 
-		lock := locksutil.LockForKey(b.locks, key)
-		lock.Lock()
-		defer lock.Unlock()
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
 
 		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
 		if err != nil {
@@ -132,6 +150,21 @@ func (b *versionedKVBackend) pathDeleteWrite() framework.OperationFunc {
 			return nil, nil
 		}
 
+		if selector != nil {
+			versions, err = selector.versions(meta)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		config, err := b.keyConfig(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkMinVersions(config, meta, versionNumbers(versions)); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
 		for _, verNum := range versions {
 			// If there is no latest version, or the latest version is already
 			// deleted or destroyed continue
@@ -140,26 +173,28 @@ func (b *versionedKVBackend) pathDeleteWrite() framework.OperationFunc {
 				continue
 			}
 
-			if lv.DeletionTime != nil {
-				deletionTime, err := ptypes.Timestamp(lv.DeletionTime)
-				if err != nil {
-					return nil, err
-				}
-
-				if deletionTime.Before(time.Now()) {
-					continue
-				}
+			if deleted, err := isDeleted(lv); err != nil {
+				return nil, err
+			} else if deleted {
+				continue
 			}
 
 			lv.DeletionTime = ptypes.TimestampNow()
+			lv.DeferredDeletionTime = nil
+			lv.DeletionDeferred = false
+		}
+
+		if _, err := meta.ApplyMinVersions(minVersions(config, meta)); err != nil {
+			return nil, err
 		}
 
 		err = b.writeKeyMetadata(ctx, req.Storage, meta)
 		if err != nil {
 			return nil, err
 		}
+		b.sendEvent(ctx, req, eventDataDelete, key, versionNumbers(versions))
 
-		return nil, nil
+		return selectedVersionsResponse(selector, versions), nil
 	}
 }
 
@@ -168,6 +203,12 @@ const deleteHelpDesc = `
 Deletes the data for the provided version and path in the key-value store. The
 versioned data will not be fully removed, but marked as deleted and will no
 longer be returned in normal get requests. This operation can be undone.
+
+Instead of listing "versions", the versions can be selected with
+"before_version", the versions lower than a version number, and either
+"before_time", the versions created before an RFC3339 timestamp, or
+"older_than", the versions created longer ago than a duration. The versions
+must match all the selectors set, and the selected versions are returned.
 `
 
 const undeleteHelpSyn = `Undeletes one or more versions from the KV store.`
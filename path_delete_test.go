@@ -2,6 +2,7 @@ package kv
 
 import (
 	"context"
+	"reflect"
 	"testing"
 	"time"
 
@@ -63,7 +64,7 @@ func TestVersionedKV_Delete_Put(t *testing.T) {
 	}
 
 	req = &logical.Request{
-		Operation: logical.CreateOperation,
+		Operation: logical.UpdateOperation,
 		Path:      "delete/foo",
 		Storage:   storage,
 		Data:      data,
@@ -161,7 +162,7 @@ func TestVersionedKV_Undelete_Put(t *testing.T) {
 	}
 
 	req = &logical.Request{
-		Operation: logical.CreateOperation,
+		Operation: logical.UpdateOperation,
 		Path:      "delete/foo",
 		Storage:   storage,
 		Data:      data,
@@ -177,7 +178,7 @@ func TestVersionedKV_Undelete_Put(t *testing.T) {
 	}
 
 	req = &logical.Request{
-		Operation: logical.CreateOperation,
+		Operation: logical.UpdateOperation,
 		Path:      "undelete/foo",
 		Storage:   storage,
 		Data:      data,
@@ -207,3 +208,95 @@ func TestVersionedKV_Undelete_Put(t *testing.T) {
 		t.Fatalf("Bad response: %#v", resp)
 	}
 }
+
+func TestVersionedKV_Delete_VersionSelectors(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, value := range []string{"v1", "v2", "v3"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"bar": value},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"versions": "1", "before_version": 2},
+		{"before_time": "2020-01-01T00:00:00Z", "older_than": "1h"},
+		{"before_time": "yesterday"},
+		{"before_version": -1},
+	} {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "delete/foo",
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error for %v, err:%s resp:%#v\n", data, err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "delete/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"before_version": 3,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["versions"], []int{1, 2}) {
+		t.Fatalf("unexpected versions: %#v", resp.Data["versions"])
+	}
+
+	// The versions were all created less than an hour ago
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "destroy/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"older_than": "1h",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if len(resp.Data["versions"].([]int)) != 0 {
+		t.Fatalf("unexpected versions: %#v", resp.Data["versions"])
+	}
+
+	req.Data = map[string]interface{}{
+		"before_version": 3,
+		"before_time":    time.Now().Add(time.Minute).UTC().Format(time.RFC3339),
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["versions"], []int{1, 2}) {
+		t.Fatalf("unexpected versions: %#v", resp.Data["versions"])
+	}
+
+	meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for verNum, want := range map[uint64]bool{1: true, 2: true, 3: false} {
+		if meta.Versions[verNum].Destroyed != want {
+			t.Fatalf("expected destroyed of version %d to be %t", verNum, want)
+		}
+	}
+}
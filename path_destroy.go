@@ -2,9 +2,11 @@ package kv
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
-	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -12,7 +14,7 @@ import (
 func pathDestroy(b *versionedKVBackend) *framework.Path {
 	return &framework.Path{
 		Pattern: "destroy/" + framework.MatchAllRegex("path"),
-		Fields: map[string]*framework.FieldSchema{
+		Fields: withVersionSelectorFields(map[string]*framework.FieldSchema{
 			"path": {
 				Type:        framework.TypeString,
 				Description: "Location of the secret.",
@@ -21,10 +23,15 @@ func pathDestroy(b *versionedKVBackend) *framework.Path {
 				Type:        framework.TypeCommaIntSlice,
 				Description: "The versions to destroy. Their data will be permanently deleted.",
 			},
-		},
+			"all_versions": {
+				Type: framework.TypeBool,
+				Description: `
+If true, every version of the secret is destroyed while its metadata, including
+the history of its versions, is retained. Cannot be combined with versions.`,
+			},
+		}),
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.upgradeCheck(b.pathDestroyWrite()),
-			logical.CreateOperation: b.upgradeCheck(b.pathDestroyWrite()),
+			logical.UpdateOperation: b.instrument("destroy", b.upgradeCheck(b.reservedPrefixCheck(b.pathDestroyWrite()))),
 		},
 
 		HelpSynopsis:    destroyHelpSyn,
@@ -37,13 +44,24 @@ func (b *versionedKVBackend) pathDestroyWrite() framework.OperationFunc {
 		key := data.Get("path").(string)
 
 		versions := data.Get("versions").([]int)
-		if len(versions) == 0 {
+		allVersions := data.Get("all_versions").(bool)
+		selector, err := parseVersionSelector(data, time.Now())
+		switch {
+		case err != nil:
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		case allVersions && (len(versions) > 0 || selector != nil):
+			return logical.ErrorResponse("versions cannot be combined with all_versions"), logical.ErrInvalidRequest
+		case selector != nil && len(versions) > 0:
+			return logical.ErrorResponse("versions cannot be combined with before_version, before_time or older_than"), logical.ErrInvalidRequest
+		case !allVersions && selector == nil && len(versions) == 0:
 			return logical.ErrorResponse("no version number provided"), logical.ErrInvalidRequest
 		}
 
-		lock := locksutil.LockForKey(b.locks, key)
-		lock.Lock()
-		defer lock.Unlock()
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
 
 		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
 		if err != nil {
@@ -53,6 +71,49 @@ func (b *versionedKVBackend) pathDestroyWrite() framework.OperationFunc {
 			return nil, nil
 		}
 
+		switch {
+		case allVersions:
+			versions = allVersionNumbers(meta)
+		case selector != nil:
+			versions, err = selector.versions(meta)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(versions) == 0 {
+			return selectedVersionsResponse(selector, versions), nil
+		}
+
+		config, err := b.keyConfig(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkMinVersions(config, meta, versionNumbers(versions)); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		// With dual control, the destroy is only carried out once another
+		// entity makes the same request within the window
+		if window := destroyConfirmationWindow(config); window > 0 {
+			confirmed, err := confirmDestroy(meta, versionNumbers(versions), false, req.EntityID, window, time.Now())
+			switch {
+			case errors.Is(err, errDestroyNoEntity), errors.Is(err, errDestroySameEntity):
+				return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+			case errors.Is(err, errDestroyPending):
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			case err != nil:
+				return nil, err
+			}
+
+			if !confirmed {
+				if err := b.writeKeyMetadata(ctx, req.Storage, meta); err != nil {
+					return nil, err
+				}
+
+				return pendingDestroyResponse(meta, window), nil
+			}
+		}
+
 		for _, verNum := range versions {
 			// If there is no version, or the version is already destroyed,
 			// continue
@@ -64,26 +125,37 @@ func (b *versionedKVBackend) pathDestroyWrite() framework.OperationFunc {
 			lv.Destroyed = true
 		}
 
-		// Write the metadata key before deleting the versions
-		err = b.writeKeyMetadata(ctx, req.Storage, meta)
-		if err != nil {
+		if _, err := meta.ApplyMinVersions(minVersions(config, meta)); err != nil {
 			return nil, err
 		}
 
-		for _, verNum := range versions {
-			// Delete versioned data
-			versionKey, err := b.getVersionKey(ctx, key, uint64(verNum), req.Storage)
-			if err != nil {
+		// The metadata is not kept once no version is left
+		if deleteMetadataWhenDestroyed(config, meta) && allVersionsDestroyed(meta) {
+			if err := b.deleteKey(ctx, req.Storage, meta); err != nil {
 				return nil, err
 			}
+			b.sendEvent(ctx, req, eventDestroy, key, versionNumbers(versions))
+			b.sendEvent(ctx, req, eventMetadataDelete, key, nil)
 
-			err = req.Storage.Delete(ctx, versionKey)
-			if err != nil {
-				return nil, err
-			}
+			return selectedVersionsResponse(selector, versions), nil
+		}
+
+		// Write the metadata key before deleting the versions
+		if err := b.rehomeSharedData(ctx, req.Storage, meta); err != nil {
+			return nil, err
+		}
+		err = b.writeKeyMetadata(ctx, req.Storage, meta)
+		if err != nil {
+			return nil, err
 		}
 
-		return nil, nil
+		// Delete versioned data
+		if err := b.deleteVersions(ctx, req.Storage, key, versionNumbers(versions)); err != nil {
+			return nil, err
+		}
+		b.sendEvent(ctx, req, eventDestroy, key, versionNumbers(versions))
+
+		return selectedVersionsResponse(selector, versions), nil
 	}
 }
 
@@ -91,4 +163,41 @@ const destroyHelpSyn = `Permanently removes one or more versions in the KV store
 const destroyHelpDesc = `
 Permanently removes the specified version data for the provided key and version
 numbers from the key-value store.
+
+If "all_versions" is true, the data of every version is destroyed instead. The
+metadata of the secret, including its custom_metadata and the history of its
+versions, is retained as a record of the existence of the secret, unless
+delete_metadata_when_destroyed is enabled.
+
+If delete_metadata_when_destroyed is enabled on the backend or the secret, the
+metadata of the secret is deleted once all of its versions are destroyed.
+
+The versions can also be selected like for delete with "before_version" and
+either "before_time" or "older_than", in which case the selected versions are
+returned.
+
+If destroy_confirmation_window is configured, the first request only records
+a pending destroy and returns it. The versions are destroyed once an entity
+other than the requester makes the same request within the window. Requests
+made with a token that is not tied to an entity are rejected.
 `
+
+// allVersionNumbers returns the numbers of the versions of the key that are
+// not destroyed yet, in ascending order.
+func allVersionNumbers(meta *KeyMetadata) []int {
+	versions := make([]int, 0, len(meta.Versions))
+	for verNum, vm := range meta.Versions {
+		if !vm.Destroyed {
+			versions = append(versions, int(verNum))
+		}
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// deleteMetadataWhenDestroyed returns true if the metadata of the key is
+// deleted once all of its versions are destroyed, which is the case if either
+// the backend or the key enables it.
+func deleteMetadataWhenDestroyed(config *Configuration, meta *KeyMetadata) bool {
+	return config.DeleteMetadataWhenDestroyed || meta.DeleteMetadataWhenDestroyed
+}
@@ -62,7 +62,7 @@ func TestVersionedKV_Destroy_Put(t *testing.T) {
 	}
 
 	req = &logical.Request{
-		Operation: logical.CreateOperation,
+		Operation: logical.UpdateOperation,
 		Path:      "destroy/foo",
 		Storage:   storage,
 		Data:      data,
@@ -92,3 +92,161 @@ func TestVersionedKV_Destroy_Put(t *testing.T) {
 		t.Fatalf("Bad response: %#v", resp)
 	}
 }
+
+func TestVersionedKV_Destroy_AllVersions(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, value := range []string{"v1", "v2", "v3"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"bar": value},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{"owner": "team"},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "destroy/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"versions":     "1",
+			"all_versions": true,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	delete(req.Data, "versions")
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The metadata is retained
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["current_version"] != uint64(3) || resp.Data["custom_metadata"].(map[string]string)["owner"] != "team" {
+		t.Fatalf("unexpected metadata: %#v", resp.Data)
+	}
+	versions := resp.Data["versions"].(map[string]interface{})
+	if len(versions) != 3 {
+		t.Fatalf("expected the history of the versions to be retained, got %#v", versions)
+	}
+	for num, v := range versions {
+		if v.(map[string]interface{})["destroyed"] != true {
+			t.Fatalf("expected version %s to be destroyed", num)
+		}
+	}
+
+	for verNum := uint64(1); verNum <= 3; verNum++ {
+		versionKey, err := b.(*versionedKVBackend).getVersionKey(context.Background(), "foo", verNum, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := storage.Get(context.Background(), versionKey)
+		if err != nil || entry != nil {
+			t.Fatalf("expected the data of version %d to be deleted, err:%s entry:%#v", verNum, err, entry)
+		}
+	}
+}
+
+func TestVersionedKV_Destroy_DeleteMetadataWhenDestroyed(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, key := range []string{"foo", "bar"} {
+		for i := 0; i < 2; i++ {
+			req := &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "data/" + key,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"data": map[string]interface{}{"bar": "baz"},
+				},
+			}
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"delete_metadata_when_destroyed": true,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	destroy := func(key, versions string) {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "destroy/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"versions": versions,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	exists := func(key string) bool {
+		meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return meta != nil
+	}
+
+	// The metadata is kept while a version is left
+	destroy("foo", "1")
+	if !exists("foo") {
+		t.Fatal("expected the metadata to be kept")
+	}
+	destroy("foo", "2")
+	if exists("foo") {
+		t.Fatal("expected the metadata to be deleted")
+	}
+
+	// The setting of the key does not apply to the others
+	destroy("bar", "1,2")
+	if !exists("bar") {
+		t.Fatal("expected the metadata to be kept")
+	}
+}
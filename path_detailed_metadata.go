@@ -0,0 +1,314 @@
+package kv
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathDetailedMetadata returns the path configuration for listing keys along
+// with a summary of their metadata.
+func pathDetailedMetadata(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "detailed-metadata/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the secrets to list.",
+			},
+			"limit": {
+				Type: framework.TypeInt,
+				Description: `
+The largest number of keys to return. If more keys are available, the response
+contains a "next_after" value to pass as "after" to get the next page. Zero
+means no limit.`,
+			},
+			"after": {
+				Type:        framework.TypeString,
+				Description: "Only the keys sorting after this value are returned.",
+			},
+			"sort": {
+				Type:    framework.TypeString,
+				Default: "name",
+				Description: `
+The order of the keys: "name", "created_time" or "updated_time". When sorting
+by time, the folders are returned after the keys. "after" can only be used
+when sorting by name in ascending order.`,
+			},
+			"order": {
+				Type:        framework.TypeString,
+				Default:     "asc",
+				Description: `The direction of the sort: "asc" or "desc".`,
+			},
+			"filter": {
+				Type: framework.TypeKVPairs,
+				Description: `
+Only the keys whose custom_metadata contains every key-value pair are
+returned, along with the folders.`,
+			},
+			"owner": {
+				Type:        framework.TypeString,
+				Description: "Only the keys with this owner are returned, along with the folders.",
+			},
+			"team": {
+				Type:        framework.TypeString,
+				Description: "Only the keys of this team are returned, along with the folders.",
+			},
+			"updated_after": {
+				Type: framework.TypeString,
+				Description: `
+An RFC 3339 timestamp. Only the keys updated after it are returned, along with
+the folders.`,
+			},
+			"deleted": {
+				Type: framework.TypeBool,
+				Description: `
+Only the keys whose current version is deleted but not destroyed are returned,
+along with the folders. When used with "destroyed", the keys matching either
+are returned.`,
+			},
+			"destroyed": {
+				Type: framework.TypeBool,
+				Description: `
+Only the keys whose versions are all destroyed are returned, along with the
+folders. When used with "deleted", the keys matching either are returned.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.upgradeCheck(b.pathDetailedMetadataList()),
+		},
+
+		HelpSynopsis:    detailedMetadataHelpSyn,
+		HelpDescription: detailedMetadataHelpDesc,
+	}
+}
+
+// detailedKeySorters compares the metadata of two keys for each sort option
+// of the detailed listing, other than "name".
+var detailedKeySorters = map[string]func(a, b *KeyMetadata) bool{
+	"created_time": func(a, b *KeyMetadata) bool {
+		return timestampBefore(a.CreatedTime, b.CreatedTime)
+	},
+	"updated_time": func(a, b *KeyMetadata) bool {
+		return timestampBefore(a.UpdatedTime, b.UpdatedTime)
+	},
+}
+
+// timestampBefore returns true if a is before b. A nil timestamp is before
+// any other.
+func timestampBefore(a, b *timestamp.Timestamp) bool {
+	switch {
+	case b == nil:
+		return false
+	case a == nil:
+		return true
+	case a.Seconds != b.Seconds:
+		return a.Seconds < b.Seconds
+	default:
+		return a.Nanos < b.Nanos
+	}
+}
+
+// currentVersionDeleted returns true if the current version of the key is
+// deleted and can still be undeleted.
+func currentVersionDeleted(meta *KeyMetadata) (bool, error) {
+	vm, ok := meta.Versions[meta.CurrentVersion]
+	if !ok || vm.Destroyed {
+		return false, nil
+	}
+	return isDeleted(vm)
+}
+
+// allVersionsDestroyed returns true if the key has versions and all of them
+// are destroyed.
+func allVersionsDestroyed(meta *KeyMetadata) bool {
+	if len(meta.Versions) == 0 {
+		return false
+	}
+	for _, vm := range meta.Versions {
+		if !vm.Destroyed {
+			return false
+		}
+	}
+	return true
+}
+
+// pathDetailedMetadataList lists the keys under a prefix and returns the
+// summary of the metadata of each key in the key_info of the response.
+func (b *versionedKVBackend) pathDetailedMetadataList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("path").(string)
+		after := data.Get("after").(string)
+
+		limit := data.Get("limit").(int)
+		if limit < 0 {
+			return logical.ErrorResponse("limit cannot be negative"), nil
+		}
+
+		sortBy := data.Get("sort").(string)
+		less, ok := detailedKeySorters[sortBy]
+		if !ok && sortBy != "name" {
+			return logical.ErrorResponse("invalid sort %q, expected name, created_time or updated_time", sortBy), nil
+		}
+
+		order := data.Get("order").(string)
+		if order != "asc" && order != "desc" {
+			return logical.ErrorResponse("invalid order %q, expected asc or desc", order), nil
+		}
+
+		// Only the listings sorted by name in ascending order can be paged
+		// through
+		paged := sortBy == "name" && order == "asc"
+		if !paged && after != "" {
+			return logical.ErrorResponse("after can only be used when sorting by name in ascending order"), nil
+		}
+
+		filter := map[string]string{}
+		if filterRaw, ok := data.GetOk("filter"); ok {
+			filter = filterRaw.(map[string]string)
+		}
+		owner, team := data.Get("owner").(string), data.Get("team").(string)
+
+		var updatedAfter *timestamp.Timestamp
+		if raw := data.Get("updated_after").(string); raw != "" {
+			t, err := time.Parse(time.RFC3339Nano, raw)
+			if err != nil {
+				return logical.ErrorResponse("invalid updated_after: %s", err), nil
+			}
+			updatedAfter, err = ptypes.TimestampProto(t)
+			if err != nil {
+				return logical.ErrorResponse("invalid updated_after: %s", err), nil
+			}
+		}
+
+		onlyDeleted := data.Get("deleted").(bool)
+		onlyDestroyed := data.Get("destroyed").(bool)
+
+		wrapper, err := b.getKeyEncryptor(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		es := wrapper.Wrap(req.Storage)
+
+		entries, err := es.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip the keys of the previous pages. When paging, the limit is
+		// applied while reading the metadata so that only the keys of the
+		// page are read.
+		entries, _ = paginateKeys(entries, after, 0)
+
+		var next string
+		var keys, folders []string
+		metas := make(map[string]*KeyMetadata, len(entries))
+		for _, entry := range entries {
+			if paged && limit > 0 && len(keys) == limit {
+				next = keys[len(keys)-1]
+				break
+			}
+
+			if strings.HasSuffix(entry, "/") {
+				folders = append(folders, entry)
+				if sortBy == "name" {
+					keys = append(keys, entry)
+				}
+				continue
+			}
+
+			meta, err := b.getKeyMetadata(ctx, req.Storage, prefix+entry)
+			if err != nil {
+				return nil, err
+			}
+			if meta == nil || meta.Deleting || !matchesCustomMetadata(meta, filter) || !matchesOwnership(meta, owner, team) {
+				continue
+			}
+			if updatedAfter != nil && !timestampBefore(updatedAfter, meta.UpdatedTime) {
+				continue
+			}
+			if onlyDeleted || onlyDestroyed {
+				deleted, err := currentVersionDeleted(meta)
+				if err != nil {
+					return nil, err
+				}
+				if !(onlyDeleted && deleted) && !(onlyDestroyed && allVersionsDestroyed(meta)) {
+					continue
+				}
+			}
+
+			keys = append(keys, entry)
+			metas[entry] = meta
+		}
+
+		if !paged {
+			switch {
+			case sortBy == "name":
+				sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+			default:
+				// The keys sorted by time are followed by the folders,
+				// which have no metadata
+				sort.SliceStable(keys, func(i, j int) bool {
+					if order == "desc" {
+						return less(metas[keys[j]], metas[keys[i]])
+					}
+					return less(metas[keys[i]], metas[keys[j]])
+				})
+				keys = append(keys, folders...)
+			}
+			if limit > 0 && len(keys) > limit {
+				keys = keys[:limit]
+			}
+		}
+
+		keyInfo := make(map[string]interface{}, len(metas))
+		for _, key := range keys {
+			meta, ok := metas[key]
+			if !ok {
+				continue
+			}
+			keyInfo[key] = map[string]interface{}{
+				"current_version": meta.CurrentVersion,
+				"oldest_version":  meta.OldestVersion,
+				"versions":        len(meta.Versions),
+				"created_time":    ptypesTimestampToString(meta.CreatedTime),
+				"updated_time":    ptypesTimestampToString(meta.UpdatedTime),
+				"custom_metadata": meta.CustomMetadata,
+				"owner":           meta.Owner,
+				"team":            meta.Team,
+			}
+		}
+
+		resp := logical.ListResponseWithInfo(keys, keyInfo)
+		if next != "" {
+			resp.Data["next_after"] = next
+		}
+
+		return resp, nil
+	}
+}
+
+const detailedMetadataHelpSyn = `Lists secrets along with a summary of their metadata.`
+const detailedMetadataHelpDesc = `
+Listing "detailed-metadata/<prefix>" returns the same keys as listing
+"metadata/<prefix>" and, in "key_info", the current_version, oldest_version,
+number of versions, created_time, updated_time, custom_metadata, owner and
+team of each secret, so that the metadata of every listed secret does not have
+to be read separately.
+
+The "filter" parameter restricts the secrets returned to the ones whose
+custom_metadata contains every key-value pair, "owner" and "team" to the ones
+with an owner or a team, and "updated_after" to the ones updated after a
+timestamp so that a job can poll for the secrets changed since its last run. The "deleted" and "destroyed" parameters return the secrets
+whose current version is deleted or whose versions are all destroyed, to review
+them before removing their metadata. The "sort" and "order"
+parameters sort the keys by name, created_time or updated_time, and "limit"
+and "after" page through the keys sorted by name in ascending order.
+`
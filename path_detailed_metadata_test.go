@@ -0,0 +1,280 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_DetailedMetadata_List(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, key := range []string{"apps/foo", "apps/foo", "apps/nested/bar"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"foo": "bar",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/apps/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{
+				"owner": "payments",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "detailed-metadata/apps/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal(resp.Data["keys"], []string{"foo", "nested/"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	info := resp.Data["key_info"].(map[string]interface{})
+	if len(info) != 1 {
+		t.Fatalf("unexpected key_info: %#v", info)
+	}
+	foo := info["foo"].(map[string]interface{})
+	if foo["current_version"] != uint64(2) || foo["versions"] != 2 {
+		t.Fatalf("unexpected info: %#v", foo)
+	}
+	if diff := deep.Equal(foo["custom_metadata"], map[string]string{"owner": "payments"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "detailed-metadata/apps/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"filter": []interface{}{"owner=search"},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["keys"], []string{"nested/"}); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestVersionedKV_DetailedMetadata_List_Sort(t *testing.T) {
+	b, storage := getBackend(t)
+
+	// Write the keys so that their update order differs from their names
+	for _, key := range []string{"b", "c", "a", "nested/d", "b"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/apps/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"foo": "bar",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, tc := range []struct {
+		data     map[string]interface{}
+		expected []string
+	}{
+		{map[string]interface{}{}, []string{"a", "b", "c", "nested/"}},
+		{map[string]interface{}{"order": "desc"}, []string{"nested/", "c", "b", "a"}},
+		{map[string]interface{}{"sort": "created_time"}, []string{"b", "c", "a", "nested/"}},
+		{map[string]interface{}{"sort": "updated_time", "order": "desc"}, []string{"b", "a", "c", "nested/"}},
+		{map[string]interface{}{"sort": "updated_time", "order": "desc", "limit": 2}, []string{"b", "a"}},
+	} {
+		req := &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "detailed-metadata/apps/",
+			Storage:   storage,
+			Data:      tc.data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if diff := deep.Equal(resp.Data["keys"], tc.expected); diff != nil {
+			t.Fatalf("%v: %v", tc.data, diff)
+		}
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"sort": "size"},
+		{"order": "up"},
+		{"sort": "updated_time", "after": "a"},
+	} {
+		req := &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "detailed-metadata/apps/",
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error for %v, err:%s resp:%#v\n", data, err, resp)
+		}
+	}
+}
+
+func TestVersionedKV_DetailedMetadata_List_UpdatedAfter(t *testing.T) {
+	b, storage := getBackend(t)
+
+	writeKey := func(key string) {
+		t.Helper()
+
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/apps/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"foo": "bar",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	listKeys := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+
+		req := &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "detailed-metadata/apps/",
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	writeKey("a")
+	writeKey("b")
+	writeKey("nested/c")
+	time.Sleep(10 * time.Millisecond)
+
+	lastRun := time.Now().UTC().Format(time.RFC3339Nano)
+	time.Sleep(10 * time.Millisecond)
+	writeKey("b")
+	writeKey("d")
+
+	resp := listKeys(map[string]interface{}{"updated_after": lastRun})
+	if resp.IsError() {
+		t.Fatalf("unexpected error: %#v", resp)
+	}
+	if diff := deep.Equal(resp.Data["keys"], []string{"b", "d", "nested/"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	resp = listKeys(map[string]interface{}{"updated_after": "yesterday"})
+	if !resp.IsError() {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+}
+
+func TestVersionedKV_DetailedMetadata_List_Deleted(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, key := range []string{"live", "deleted", "destroyed", "nested/key"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/apps/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"foo": "bar",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.DeleteOperation,
+			Path:      "data/apps/deleted",
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "destroy/apps/destroyed",
+			Data: map[string]interface{}{
+				"versions": []int{1},
+			},
+		},
+	} {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, tc := range []struct {
+		data     map[string]interface{}
+		expected []string
+	}{
+		{map[string]interface{}{}, []string{"deleted", "destroyed", "live", "nested/"}},
+		{map[string]interface{}{"deleted": true}, []string{"deleted", "nested/"}},
+		{map[string]interface{}{"destroyed": true}, []string{"destroyed", "nested/"}},
+		{map[string]interface{}{"deleted": true, "destroyed": true}, []string{"deleted", "destroyed", "nested/"}},
+	} {
+		req := &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "detailed-metadata/apps/",
+			Storage:   storage,
+			Data:      tc.data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if diff := deep.Equal(resp.Data["keys"], tc.expected); diff != nil {
+			t.Fatalf("%v: %v", tc.data, diff)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathExists returns the path configuration for checking whether a key exists
+// without reading its data.
+func pathExists(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "exists/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the secret.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathExistsRead()),
+		},
+
+		HelpSynopsis:    existsHelpSyn,
+		HelpDescription: existsHelpDesc,
+	}
+}
+
+// pathExistsRead returns whether a key exists and its current version.
+func (b *versionedKVBackend) pathExistsRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		meta, err := b.getKeyMetadata(ctx, req.Storage, data.Get("path").(string))
+		if err != nil {
+			return nil, err
+		}
+
+		exists := meta != nil && !meta.Deleting
+		var currentVersion uint64
+		if exists {
+			currentVersion = meta.CurrentVersion
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"exists":          exists,
+				"current_version": currentVersion,
+			},
+		}, nil
+	}
+}
+
+const existsHelpSyn = `Checks whether a secret exists without reading it.`
+const existsHelpDesc = `
+Reading "exists/<path>" returns in "exists" whether the secret exists and in
+"current_version" its current version, or 0 if it does not exist. Neither the
+data nor the metadata of the secret is returned, so that health checks and
+preflight checks can be granted access to this path without being able to read
+the secrets. A secret whose current version is deleted or destroyed still
+exists until its metadata is deleted.
+`
@@ -0,0 +1,72 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Exists(t *testing.T) {
+	b, storage := getBackend(t)
+
+	exists := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "exists/foo",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	resp := exists()
+	if resp.Data["exists"] != false || resp.Data["current_version"] != uint64(0) {
+		t.Fatalf("expected the secret not to exist, resp: %#v", resp)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	resp = exists()
+	if resp.Data["exists"] != true || resp.Data["current_version"] != uint64(2) {
+		t.Fatalf("expected the secret to exist at version 2, resp: %#v", resp)
+	}
+	if _, ok := resp.Data["data"]; ok {
+		t.Fatalf("expected no data to be returned, resp: %#v", resp)
+	}
+
+	req := &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp = exists()
+	if resp.Data["exists"] != false {
+		t.Fatalf("expected the deleted secret not to exist, resp: %#v", resp)
+	}
+}
@@ -0,0 +1,420 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// exportFormatVersion is the version of the format of the export bundles.
+const exportFormatVersion = 1
+
+// The versions whose data is included in an export.
+const (
+	exportVersionsNone    = "none"
+	exportVersionsCurrent = "current"
+	exportVersionsAll     = "all"
+)
+
+// The formats of an export.
+const (
+	exportFormatBundle = "bundle"
+	exportFormatKV1    = "kv1"
+)
+
+// exportBundle is the portable document produced by export/. The keys are
+// relative to the exported prefix so that the bundle can be imported under
+// another prefix.
+type exportBundle struct {
+	FormatVersion int                     `json:"format_version" mapstructure:"format_version"`
+	Prefix        string                  `json:"prefix" mapstructure:"prefix"`
+	ExportedTime  string                  `json:"exported_time" mapstructure:"exported_time"`
+	Keys          map[string]*exportedKey `json:"keys" mapstructure:"keys"`
+}
+
+// exportedKey holds the metadata of a key and its versions in an export
+// bundle.
+type exportedKey struct {
+	CurrentVersion      uint64            `json:"current_version" mapstructure:"current_version"`
+	OldestVersion       uint64            `json:"oldest_version" mapstructure:"oldest_version"`
+	CreatedTime         string            `json:"created_time" mapstructure:"created_time"`
+	UpdatedTime         string            `json:"updated_time" mapstructure:"updated_time"`
+	MaxVersions         uint32            `json:"max_versions" mapstructure:"max_versions"`
+	MinVersions         uint32            `json:"min_versions" mapstructure:"min_versions"`
+	MaxHistoryBytes     uint64            `json:"max_history_bytes" mapstructure:"max_history_bytes"`
+	CasRequired         bool              `json:"cas_required" mapstructure:"cas_required"`
+	DeleteVersionAfter  string            `json:"delete_version_after" mapstructure:"delete_version_after"`
+	DestroyVersionAfter string            `json:"destroy_version_after" mapstructure:"destroy_version_after"`
+	MaxVersionAge       string            `json:"max_version_age" mapstructure:"max_version_age"`
+	RotationPeriod      string            `json:"rotation_period" mapstructure:"rotation_period"`
+	CustomMetadata      map[string]string `json:"custom_metadata" mapstructure:"custom_metadata"`
+	DataSchema          string            `json:"data_schema" mapstructure:"data_schema"`
+	ExpireAt            string            `json:"expire_at" mapstructure:"expire_at"`
+	Classification      string            `json:"classification" mapstructure:"classification"`
+	Owner               string            `json:"owner" mapstructure:"owner"`
+	Team                string            `json:"team" mapstructure:"team"`
+	Deprecated          bool              `json:"deprecated" mapstructure:"deprecated"`
+	DeprecationMessage  string            `json:"deprecation_message" mapstructure:"deprecation_message"`
+	RequireWrapping     bool              `json:"require_response_wrapping" mapstructure:"require_response_wrapping"`
+	WrappingTTL         string            `json:"response_wrapping_ttl" mapstructure:"response_wrapping_ttl"`
+
+	Versions map[string]*exportedVersion `json:"versions" mapstructure:"versions"`
+}
+
+// exportedVersion holds a version of a key in an export bundle. Data is only
+// set for the selected versions that are neither deleted nor destroyed.
+type exportedVersion struct {
+	CreatedTime  string                 `json:"created_time" mapstructure:"created_time"`
+	DeletionTime string                 `json:"deletion_time" mapstructure:"deletion_time"`
+	Destroyed    bool                   `json:"destroyed" mapstructure:"destroyed"`
+	Data         map[string]interface{} `json:"data,omitempty" mapstructure:"data"`
+	Reference    string                 `json:"reference,omitempty" mapstructure:"reference"`
+}
+
+// pathExport returns the path configuration for exporting the keys under a
+// prefix.
+func pathExport(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "export/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the keys to export.",
+			},
+			"versions": {
+				Type:    framework.TypeString,
+				Default: exportVersionsCurrent,
+				Description: `
+The versions whose data is exported: "none", "current" or "all". The metadata
+of every version is always exported.`,
+			},
+			"format": {
+				Type:    framework.TypeString,
+				Default: exportFormatBundle,
+				Description: `
+The format of the export: "bundle" returns the metadata and versions of the
+keys, "kv1" returns the data of their current version as KV v1 would store it.`,
+			},
+			"wrap_ttl": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+If set, the bundle is returned in a response-wrapping token with this TTL
+instead of in the response.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.upgradeCheck(b.pathExportRead()),
+			logical.UpdateOperation: b.upgradeCheck(b.pathExportRead()),
+		},
+
+		HelpSynopsis:    exportHelpSyn,
+		HelpDescription: exportHelpDesc,
+	}
+}
+
+// pathExportRead returns the export bundle of the keys under a prefix,
+// descending into all the sub-folders.
+func (b *versionedKVBackend) pathExportRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("path").(string)
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		versions := data.Get("versions").(string)
+		switch versions {
+		case exportVersionsNone, exportVersionsCurrent, exportVersionsAll:
+		default:
+			return logical.ErrorResponse("invalid versions %q, expected none, current or all", versions), nil
+		}
+
+		wrapTTL := data.Get("wrap_ttl").(int)
+		if wrapTTL < 0 {
+			return logical.ErrorResponse("wrap_ttl cannot be negative"), nil
+		}
+
+		// The response is wrapped with wrap_ttl, or with the TTL of the
+		// request if not set
+		responseWrapTTL := time.Duration(wrapTTL) * time.Second
+		if responseWrapTTL == 0 {
+			responseWrapTTL = requestWrapTTL(req)
+		}
+
+		var resp *logical.Response
+		switch format := data.Get("format").(string); format {
+		case exportFormatBundle:
+			exported, err := b.exportPrefix(ctx, req.Storage, prefix, versions)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkExportWrapping(exported, prefix, responseWrapTTL); err != nil {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
+
+			resp = &logical.Response{
+				Data: map[string]interface{}{
+					"format_version": exportFormatVersion,
+					"prefix":         prefix,
+					"exported_time":  time.Now().UTC().Format(time.RFC3339Nano),
+					"keys":           exported,
+				},
+			}
+		case exportFormatKV1:
+			exported, err := b.exportPrefixKV1(ctx, req.Storage, prefix, responseWrapTTL)
+			if errors.Is(err, errResponseWrappingRequired) {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			resp = &logical.Response{
+				Data: map[string]interface{}{
+					"format":        exportFormatKV1,
+					"prefix":        prefix,
+					"exported_time": time.Now().UTC().Format(time.RFC3339Nano),
+					"keys":          exported,
+				},
+			}
+		default:
+			return logical.ErrorResponse("invalid format %q, expected bundle or kv1", format), nil
+		}
+		if wrapTTL > 0 {
+			resp.WrapInfo = &wrapping.ResponseWrapInfo{
+				TTL: time.Duration(wrapTTL) * time.Second,
+			}
+		}
+
+		return resp, nil
+	}
+}
+
+// exportPrefix returns the exported keys under prefix, descending into all
+// the sub-folders, relative to the prefix.
+func (b *versionedKVBackend) exportPrefix(ctx context.Context, s logical.Storage, prefix, versions string) (map[string]*exportedKey, error) {
+	keys, err := b.collectKeys(ctx, s, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make(map[string]*exportedKey, len(keys))
+	for _, key := range keys {
+		ek, err := b.exportKey(ctx, s, key, versions)
+		if err != nil {
+			return nil, err
+		}
+		if ek == nil {
+			continue
+		}
+		exported[strings.TrimPrefix(key, prefix)] = ek
+	}
+
+	return exported, nil
+}
+
+// checkExportWrapping returns an error if the data of an exported key under
+// prefix requires response wrapping that wrapTTL does not satisfy.
+func checkExportWrapping(exported map[string]*exportedKey, prefix string, wrapTTL time.Duration) error {
+	for key, ek := range exported {
+		if !ek.RequireWrapping {
+			continue
+		}
+
+		hasData := false
+		for _, ev := range ek.Versions {
+			hasData = hasData || ev.Data != nil
+		}
+		if !hasData {
+			continue
+		}
+
+		maxTTL, err := parseutil.ParseDurationSecond(ek.WrappingTTL)
+		if err != nil {
+			return err
+		}
+		if err := checkWrapTTL(prefix+key, maxTTL, wrapTTL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportKey returns the exported metadata and versions of key, or nil if the
+// key does not exist or is being deleted.
+func (b *versionedKVBackend) exportKey(ctx context.Context, s logical.Storage, key, versions string) (*exportedKey, error) {
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil || meta.Deleting {
+		return nil, nil
+	}
+
+	var deleteVersionAfter time.Duration
+	if meta.GetDeleteVersionAfter() != nil {
+		deleteVersionAfter, err = ptypes.Duration(meta.GetDeleteVersionAfter())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ek := &exportedKey{
+		CurrentVersion:      meta.CurrentVersion,
+		OldestVersion:       meta.OldestVersion,
+		CreatedTime:         ptypesTimestampToString(meta.CreatedTime),
+		UpdatedTime:         ptypesTimestampToString(meta.UpdatedTime),
+		MaxVersions:         meta.MaxVersions,
+		MinVersions:         meta.MinVersions,
+		MaxHistoryBytes:     meta.MaxHistoryBytes,
+		CasRequired:         meta.CasRequired,
+		DeleteVersionAfter:  deleteVersionAfter.String(),
+		DestroyVersionAfter: destroyVersionAfter(meta).String(),
+		MaxVersionAge:       getMaxVersionAge(meta).String(),
+		RotationPeriod:      rotationPeriod(meta).String(),
+		CustomMetadata:      meta.CustomMetadata,
+		DataSchema:          meta.DataSchema,
+		ExpireAt:            ptypesTimestampToString(meta.ExpireAt),
+		Classification:      meta.Classification,
+		Owner:               meta.Owner,
+		Team:                meta.Team,
+		Deprecated:          meta.Deprecated,
+		DeprecationMessage:  meta.DeprecationMessage,
+		RequireWrapping:     meta.RequireResponseWrapping,
+		WrappingTTL:         responseWrappingTTL(meta).String(),
+		Versions:            make(map[string]*exportedVersion, len(meta.Versions)),
+	}
+
+	for verNum, vm := range meta.Versions {
+		ev := &exportedVersion{
+			CreatedTime:  ptypesTimestampToString(vm.CreatedTime),
+			DeletionTime: ptypesTimestampToString(vm.DeletionTime),
+			Destroyed:    vm.Destroyed,
+		}
+		ek.Versions[strconv.FormatUint(verNum, 10)] = ev
+
+		if versions == exportVersionsNone || (versions == exportVersionsCurrent && verNum != meta.CurrentVersion) || vm.Destroyed {
+			continue
+		}
+		deleted, err := isDeleted(vm)
+		if err != nil {
+			return nil, err
+		}
+		if deleted {
+			continue
+		}
+
+		// A reference is exported as the path it points to
+		if vm.Reference != "" {
+			ev.Reference = vm.Reference
+			continue
+		}
+
+		ev.Data, err = b.readVersionData(ctx, s, key, verNum)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ek, nil
+}
+
+// exportPrefixKV1 returns the JSON data of the current version of the keys
+// under prefix, descending into all the sub-folders, relative to the prefix,
+// for a response wrapped with a token of wrapTTL.
+// The data is returned as it was written so that its values are preserved
+// exactly, the keys whose current version is deleted or destroyed are left
+// out like KV v1 would.
+func (b *versionedKVBackend) exportPrefixKV1(ctx context.Context, s logical.Storage, prefix string, wrapTTL time.Duration) (map[string]json.RawMessage, error) {
+	keys, err := b.collectKeys(ctx, s, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make(map[string]json.RawMessage, len(keys))
+	for _, key := range keys {
+		buf, err := b.exportKeyKV1(ctx, s, key, wrapTTL)
+		if err != nil {
+			return nil, err
+		}
+		if buf == nil {
+			continue
+		}
+		exported[strings.TrimPrefix(key, prefix)] = buf
+	}
+
+	return exported, nil
+}
+
+// exportKeyKV1 returns the JSON data of the current version of key, or nil if
+// the key has no readable current version. It fails if the key requires
+// response wrapping that wrapTTL does not satisfy.
+func (b *versionedKVBackend) exportKeyKV1(ctx context.Context, s logical.Storage, key string, wrapTTL time.Duration) (json.RawMessage, error) {
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil || meta.Deleting {
+		return nil, nil
+	}
+
+	vm := meta.Versions[meta.CurrentVersion]
+	if vm == nil || vm.Destroyed {
+		return nil, nil
+	}
+	deleted, err := isDeleted(vm)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, nil
+	}
+	if err := checkResponseWrapping(meta, wrapTTL); err != nil {
+		return nil, err
+	}
+
+	return b.readVersionJSON(ctx, s, key, meta.CurrentVersion)
+}
+
+const exportHelpSyn = `Exports the secrets under a prefix as a portable bundle.`
+const exportHelpDesc = `
+Reading "export/<prefix>" returns the secrets under the prefix, descending
+into all the sub-folders, as a structured bundle suitable for backups or for
+cloning an environment. The "keys" map of the bundle holds, for each secret
+relative to the prefix, its metadata, custom_metadata and settings, along with
+the metadata of its versions.
+
+The "versions" parameter selects the versions whose data is exported: "none",
+"current" (the default) or "all". The data of the deleted and destroyed
+versions is never exported.
+
+If "format" is "kv1", the "keys" map holds instead the data of the current
+version of each secret exactly as it was written, which is the value KV v1
+stores for the secret, for consumers that must be rolled back to a KV v1
+mount. The secrets whose current version is deleted or destroyed are left out
+and "versions" is ignored.
+
+If "wrap_ttl" is set, the bundle is returned in a response-wrapping token with
+this TTL so that it can be handed over without being exposed.
+The data of the secrets with require_response_wrapping is only exported if the
+response is wrapped, by "wrap_ttl" or by the request, with a TTL within their
+response_wrapping_ttl.
+`
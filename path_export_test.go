@@ -0,0 +1,189 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Export(t *testing.T) {
+	b, storage := getBackend(t)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/app/foo",
+			Data: map[string]interface{}{
+				"max_versions":    5,
+				"custom_metadata": map[string]interface{}{"owner": "team"},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/app/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"bar": "baz"},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/app/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"bar": "qux"},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/app/nested/bar",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"foo": "bar"},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/other",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"foo": "bar"},
+			},
+		},
+	}
+	for _, req := range requests {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	export := func(data map[string]interface{}) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "export/app",
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	resp := export(nil)
+	if resp.Data["format_version"] != exportFormatVersion || resp.Data["prefix"] != "app/" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+	keys := resp.Data["keys"].(map[string]*exportedKey)
+	if len(keys) != 2 || keys["nested/bar"] == nil {
+		t.Fatalf("unexpected keys: %#v", keys)
+	}
+
+	foo := keys["foo"]
+	if foo == nil || foo.CurrentVersion != 2 || foo.MaxVersions != 5 || !reflect.DeepEqual(foo.CustomMetadata, map[string]string{"owner": "team"}) {
+		t.Fatalf("unexpected key: %#v", foo)
+	}
+	if len(foo.Versions) != 2 || foo.Versions["1"].Data != nil || !reflect.DeepEqual(foo.Versions["2"].Data, map[string]interface{}{"bar": "qux"}) {
+		t.Fatalf("unexpected versions: %#v", foo.Versions)
+	}
+
+	keys = export(map[string]interface{}{"versions": "all"}).Data["keys"].(map[string]*exportedKey)
+	if !reflect.DeepEqual(keys["foo"].Versions["1"].Data, map[string]interface{}{"bar": "baz"}) {
+		t.Fatalf("unexpected versions: %#v", keys["foo"].Versions)
+	}
+
+	keys = export(map[string]interface{}{"versions": "none"}).Data["keys"].(map[string]*exportedKey)
+	if keys["foo"].Versions["2"].Data != nil {
+		t.Fatalf("unexpected versions: %#v", keys["foo"].Versions)
+	}
+
+	resp = export(map[string]interface{}{"wrap_ttl": "1m"})
+	if resp.WrapInfo == nil || resp.WrapInfo.TTL != time.Minute {
+		t.Fatalf("expected the response to be wrapped, got %#v", resp.WrapInfo)
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "export/app",
+		Storage:   storage,
+		Data:      map[string]interface{}{"versions": "some"},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_ExportKV1(t *testing.T) {
+	b, storage := getBackend(t)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/app/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"bar": "baz"},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/app/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "qux",
+					"big": json.Number("12345678901234567890"),
+				},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/app/deleted",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"foo": "bar"},
+			},
+		},
+		{
+			Operation: logical.DeleteOperation,
+			Path:      "data/app/deleted",
+		},
+	}
+	for _, req := range requests {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "export/app",
+		Storage:   storage,
+		Data:      map[string]interface{}{"format": "kv1"},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["format"] != "kv1" || resp.Data["prefix"] != "app/" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	// The values are exported exactly as they were written
+	keys := resp.Data["keys"].(map[string]json.RawMessage)
+	expected := map[string]json.RawMessage{
+		"foo": json.RawMessage(`{"bar":"qux","big":12345678901234567890}`),
+	}
+	if len(keys) != len(expected) || string(keys["foo"]) != string(expected["foo"]) {
+		t.Fatalf("unexpected keys: %s", keys)
+	}
+
+	req.Data = map[string]interface{}{"format": "kv2"}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+}
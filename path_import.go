@@ -0,0 +1,480 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// The strategies applied when an imported key already exists.
+const (
+	importConflictFail      = "fail"
+	importConflictSkip      = "skip"
+	importConflictOverwrite = "overwrite"
+)
+
+// pathImport returns the path configuration for importing an export bundle
+// under a prefix.
+func pathImport(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "import/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Prefix the keys of the bundle are imported under.",
+			},
+			"format_version": {
+				Type:        framework.TypeInt,
+				Default:     exportFormatVersion,
+				Description: "The format_version of the bundle.",
+			},
+			"keys": {
+				Type:        framework.TypeMap,
+				Description: "The keys of the bundle, as returned by export/.",
+			},
+			"conflict": {
+				Type:    framework.TypeString,
+				Default: importConflictFail,
+				Description: `
+What to do when an imported key already exists: "fail" (the default) rejects
+the whole import, "skip" leaves the existing key untouched and "overwrite"
+replaces its settings and writes the imported versions on top of the existing
+ones.`,
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Description: "If true, the changes the import would make are returned without being made.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathImportWrite()),
+		},
+
+		HelpSynopsis:    importHelpSyn,
+		HelpDescription: importHelpDesc,
+	}
+}
+
+// importedKey is a key of an import bundle along with its metadata settings
+// and the data of its versions, oldest first.
+type importedKey struct {
+	key      string
+	settings *KeyMetadata
+	versions []importedVersion
+}
+
+// importedVersion is a version of an imported key to write, either its data
+// encoded in JSON or the path it references.
+type importedVersion struct {
+	data      []byte
+	reference string
+}
+
+// importedBytes returns the bytes the versions of the imported keys take once
+// stored, by key. The overhead of the encryption is not accounted for.
+func importedBytes(imports []*importedKey) (map[string]uint64, error) {
+	now := ptypes.TimestampNow()
+	sizes := make(map[string]uint64, len(imports))
+	for _, imported := range imports {
+		for _, v := range imported.versions {
+			buf, err := proto.Marshal(&Version{
+				Data:        v.data,
+				CreatedTime: now,
+			})
+			if err != nil {
+				return nil, err
+			}
+			sizes[imported.key] += uint64(len(buf))
+		}
+	}
+	return sizes, nil
+}
+
+// pathImportWrite imports the keys of an export bundle. The bundle is
+// validated and the conflicts are resolved before any key is written.
+func (b *versionedKVBackend) pathImportWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("path").(string)
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		if version := data.Get("format_version").(int); version != exportFormatVersion {
+			return logical.ErrorResponse("unsupported format_version %d, expected %d", version, exportFormatVersion), nil
+		}
+
+		conflict := data.Get("conflict").(string)
+		switch conflict {
+		case importConflictFail, importConflictSkip, importConflictOverwrite:
+		default:
+			return logical.ErrorResponse("invalid conflict %q, expected fail, skip or overwrite", conflict), nil
+		}
+
+		bundle, err := decodeImportKeys(data.Get("keys").(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse("invalid keys: %s", err), nil
+		}
+
+		rels := make([]string, 0, len(bundle))
+		for rel := range bundle {
+			rels = append(rels, rel)
+		}
+		sort.Strings(rels)
+
+		created, overwritten, skipped := []string{}, []string{}, []string{}
+		var imports []*importedKey
+		for _, rel := range rels {
+			key := prefix + rel
+
+			config, err := b.keyConfig(ctx, req.Storage, key)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkPathAllowed(config, key); err != nil {
+				return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+			}
+			if err := checkReservedPrefix(config, key); err != nil {
+				return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+			}
+
+			imported, err := parseImportedKey(config, key, bundle[rel])
+			if err != nil {
+				return logical.ErrorResponse("key %q: %s", rel, err), nil
+			}
+
+			meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case meta == nil:
+				created = append(created, key)
+			case conflict == importConflictSkip:
+				skipped = append(skipped, key)
+				continue
+			case conflict == importConflictOverwrite && destroyConfirmationWindow(config) > 0:
+				// Overwriting the settings and the versions of the key
+				// would bypass the confirmation of its destroys
+				return logical.ErrorResponse("key %q: %s", rel, errDualControlOverwrite), logical.ErrPermissionDenied
+			default:
+				overwritten = append(overwritten, key)
+			}
+			imports = append(imports, imported)
+		}
+
+		if conflict == importConflictFail && len(overwritten) > 0 {
+			return logical.ErrorResponse("keys already exist: %s", strings.Join(overwritten, ", ")), nil
+		}
+
+		// The rate limits and the quotas are checked for the whole import so
+		// that it is not interrupted halfway through. The import counts as
+		// one write under each rate limited prefix it writes to.
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		limited := make(map[string]bool)
+		for _, imported := range imports {
+			if err := b.checkRateLimitOnce(config, limited, imported.key, true); err != nil {
+				return nil, err
+			}
+		}
+		release, quotaWarning, err := b.checkKeyQuotas(ctx, req.Storage, config, created)
+		if errors.Is(err, errKeyQuotaExceeded) {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		if hasByteQuotas(config) {
+			sizes, err := importedBytes(imports)
+			if err != nil {
+				return nil, err
+			}
+			releaseBytes, err := b.checkBatchByteQuotas(config, sizes)
+			if errors.Is(err, errByteQuotaExceeded) {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
+			if err != nil {
+				return nil, err
+			}
+			defer releaseBytes()
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"created":     created,
+				"overwritten": overwritten,
+				"skipped":     skipped,
+				"dry_run":     data.Get("dry_run").(bool),
+			},
+		}
+		if quotaWarning != "" {
+			resp.AddWarning(quotaWarning)
+		}
+		if data.Get("dry_run").(bool) {
+			return resp, nil
+		}
+
+		for _, imported := range imports {
+			warning, err := b.importKey(ctx, req, imported, conflict)
+			if err != nil {
+				return nil, fmt.Errorf("importing %q: %w", imported.key, err)
+			}
+			if warning != "" {
+				resp.AddWarning(warning)
+			}
+		}
+
+		return resp, nil
+	}
+}
+
+// decodeImportKeys decodes the keys of an export bundle.
+func decodeImportKeys(raw map[string]interface{}) (map[string]*exportedKey, error) {
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys map[string]*exportedKey
+	if err := json.Unmarshal(buf, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// parseImportedKey validates an exported key against config and returns the
+// settings and the data of the versions to import.
+func parseImportedKey(config *Configuration, key string, ek *exportedKey) (*importedKey, error) {
+	if ek == nil {
+		return nil, fmt.Errorf("missing metadata")
+	}
+
+	settings := &KeyMetadata{
+		Key:                     key,
+		MaxVersions:             ek.MaxVersions,
+		MinVersions:             ek.MinVersions,
+		MaxHistoryBytes:         ek.MaxHistoryBytes,
+		CasRequired:             ek.CasRequired,
+		CustomMetadata:          ek.CustomMetadata,
+		DataSchema:              ek.DataSchema,
+		Classification:          ek.Classification,
+		Owner:                   ek.Owner,
+		Team:                    ek.Team,
+		Deprecated:              ek.Deprecated,
+		DeprecationMessage:      ek.DeprecationMessage,
+		RequireResponseWrapping: ek.RequireWrapping,
+	}
+
+	for name, d := range map[string]struct {
+		raw string
+		dst **duration.Duration
+	}{
+		"delete_version_after":  {ek.DeleteVersionAfter, &settings.DeleteVersionAfter},
+		"destroy_version_after": {ek.DestroyVersionAfter, &settings.DestroyVersionAfter},
+		"max_version_age":       {ek.MaxVersionAge, &settings.MaxVersionAge},
+		"rotation_period":       {ek.RotationPeriod, &settings.RotationPeriod},
+		"response_wrapping_ttl": {ek.WrappingTTL, &settings.ResponseWrappingTtl},
+	} {
+		if d.raw == "" {
+			continue
+		}
+		parsed, err := parseutil.ParseDurationSecond(d.raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if parsed < 0 {
+			return nil, fmt.Errorf("%s cannot be negative", name)
+		}
+		if parsed > 0 {
+			*d.dst = ptypes.DurationProto(parsed)
+		}
+	}
+
+	if ek.ExpireAt != "" {
+		t, err := time.Parse(time.RFC3339Nano, ek.ExpireAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expire_at: %w", err)
+		}
+		settings.ExpireAt, err = ptypes.TimestampProto(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expire_at: %w", err)
+		}
+	}
+
+	if settings.DataSchema != "" {
+		if _, err := compileDataSchema(settings.DataSchema); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateClassification(config, settings.Classification); err != nil {
+		return nil, err
+	}
+	if err := validateOwnership(config, settings.Owner, settings.Team); err != nil {
+		return nil, err
+	}
+	if len(settings.CustomMetadata) > 0 {
+		if err := validateCustomMetadata(config, settings.CustomMetadata); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateMinVersions(config, settings); err != nil {
+		return nil, err
+	}
+
+	// The versions with data are imported in order, the others are only
+	// part of the history of the exported key
+	verNums := make([]uint64, 0, len(ek.Versions))
+	for raw, ev := range ek.Versions {
+		verNum, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q", raw)
+		}
+		if ev != nil && (ev.Data != nil || ev.Reference != "") {
+			verNums = append(verNums, verNum)
+		}
+	}
+	sort.Slice(verNums, func(i, j int) bool { return verNums[i] < verNums[j] })
+
+	imported := &importedKey{
+		key:      key,
+		settings: settings,
+	}
+	for _, verNum := range verNums {
+		ev := ek.Versions[strconv.FormatUint(verNum, 10)]
+		if ev.Reference != "" {
+			if err := checkReferenceTarget(config, key, ev.Reference); err != nil {
+				return nil, fmt.Errorf("version %d: %w", verNum, err)
+			}
+			imported.versions = append(imported.versions, importedVersion{
+				data:      []byte("{}"),
+				reference: ev.Reference,
+			})
+			continue
+		}
+
+		buf, err := json.Marshal(ev.Data)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateVersionData(config, settings, buf); err != nil {
+			return nil, fmt.Errorf("version %d: %w", verNum, err)
+		}
+		imported.versions = append(imported.versions, importedVersion{data: buf})
+	}
+
+	return imported, nil
+}
+
+// importKey writes the settings and the versions of an imported key. A
+// non-empty warning is returned if the cleanup of old versions failed.
+func (b *versionedKVBackend) importKey(ctx context.Context, req *logical.Request, imported *importedKey, conflict string) (string, error) {
+	config, err := b.keyConfig(ctx, req.Storage, imported.key)
+	if err != nil {
+		return "", err
+	}
+
+	unlock, err := b.lockKeyForWrite(ctx, req.Storage, imported.key)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	meta, err := b.getKeyMetadata(ctx, req.Storage, imported.key)
+	if err != nil {
+		return "", err
+	}
+	if meta == nil {
+		now := ptypes.TimestampNow()
+		meta = &KeyMetadata{
+			Key:         imported.key,
+			Versions:    map[uint64]*VersionMetadata{},
+			CreatedTime: now,
+			UpdatedTime: now,
+		}
+	} else if conflict == importConflictSkip {
+		// The key was created since the import was planned
+		return "", nil
+	}
+	if meta.Deleting {
+		return "", errKeyDeleting
+	}
+
+	settings := imported.settings
+	meta.MaxVersions = settings.MaxVersions
+	meta.MinVersions = settings.MinVersions
+	meta.MaxHistoryBytes = settings.MaxHistoryBytes
+	meta.CasRequired = settings.CasRequired
+	meta.DeleteVersionAfter = settings.DeleteVersionAfter
+	meta.DestroyVersionAfter = settings.DestroyVersionAfter
+	meta.MaxVersionAge = settings.MaxVersionAge
+	meta.RotationPeriod = settings.RotationPeriod
+	meta.CustomMetadata = settings.CustomMetadata
+	meta.DataSchema = settings.DataSchema
+	meta.ExpireAt = settings.ExpireAt
+	meta.Classification = settings.Classification
+	meta.Owner = settings.Owner
+	meta.Team = settings.Team
+	meta.Deprecated = settings.Deprecated
+	meta.DeprecationMessage = settings.DeprecationMessage
+	meta.RequireResponseWrapping = settings.RequireResponseWrapping
+	meta.ResponseWrappingTtl = settings.ResponseWrappingTtl
+	clampMaxVersions(config, meta)
+
+	if len(imported.versions) == 0 {
+		return "", b.writeKeyMetadata(ctx, req.Storage, meta)
+	}
+
+	var warnings []string
+	written := make([]uint64, 0, len(imported.versions))
+	for _, v := range imported.versions {
+		vm, warning, err := b.writeVersion(ctx, req.Storage, config, meta, v.data, versionOptions{reference: v.reference, bytesReserved: true})
+		if err != nil {
+			return "", err
+		}
+		recordVersionSize("write", vm)
+		written = append(written, meta.CurrentVersion)
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	b.sendEvent(ctx, req, eventDataWrite, imported.key, written)
+
+	return strings.Join(warnings, "; "), nil
+}
+
+const importHelpSyn = `Imports a bundle of secrets produced by export.`
+const importHelpDesc = `
+Writing to "import/<prefix>" imports the "keys" of a bundle returned by
+export/ under the prefix. The settings and custom_metadata of each key are
+set from the bundle and the versions exported with their data are written as
+new versions, oldest first. The version numbers and timestamps of the bundle
+are not preserved and the check-and-set requirements do not apply.
+
+The "conflict" parameter decides what happens to the keys that already exist:
+"fail" (the default) rejects the import, "skip" leaves them untouched and
+"overwrite" replaces their settings and writes the imported versions on top of
+their existing versions. The whole bundle is validated before any key is
+written. Existing keys whose destroys must be confirmed by another entity,
+through the destroy_confirmation_window, cannot be overwritten.
+
+The keys under a reserved prefix cannot be imported. The import counts as one
+write against the write_rate_limit of each prefix it writes to.
+
+The response lists the keys "created", "overwritten" and "skipped". If
+"dry_run" is true, the changes are returned without being made.
+`
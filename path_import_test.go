@@ -0,0 +1,193 @@
+package kv
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Import(t *testing.T) {
+	b, storage := getBackend(t)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/app/foo",
+			Data: map[string]interface{}{
+				"max_versions":    5,
+				"rotation_period": "1h",
+				"custom_metadata": map[string]interface{}{"owner": "team"},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/app/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"bar": "baz"},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/app/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"bar": "qux"},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/app/bar",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"foo": "bar"},
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/clone/bar",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"foo": "existing"},
+			},
+		},
+	}
+	for _, req := range requests {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "export/app",
+		Storage:   storage,
+		Data:      map[string]interface{}{"versions": "all"},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	keys := map[string]interface{}{}
+	for k, v := range resp.Data["keys"].(map[string]*exportedKey) {
+		keys[k] = v
+	}
+
+	importBundle := func(data map[string]interface{}) (*logical.Response, error) {
+		data["keys"] = keys
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "import/clone",
+			Storage:   storage,
+			Data:      data,
+		}
+		return b.HandleRequest(context.Background(), req)
+	}
+
+	// clone/bar already exists
+	resp, err = importBundle(map[string]interface{}{})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = importBundle(map[string]interface{}{"conflict": "overwrite", "dry_run": true})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["created"], []string{"clone/foo"}) || !reflect.DeepEqual(resp.Data["overwritten"], []string{"clone/bar"}) {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+	meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "clone/foo")
+	if err != nil || meta != nil {
+		t.Fatalf("expected the dry run not to write, err:%s meta:%#v", err, meta)
+	}
+
+	resp, err = importBundle(map[string]interface{}{"conflict": "skip"})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["created"], []string{"clone/foo"}) || !reflect.DeepEqual(resp.Data["skipped"], []string{"clone/bar"}) {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	meta, err = b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "clone/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.CurrentVersion != 2 || meta.MaxVersions != 5 || rotationPeriod(meta).String() != "1h0m0s" || !reflect.DeepEqual(meta.CustomMetadata, map[string]string{"owner": "team"}) {
+		t.Fatalf("unexpected metadata: %#v", meta)
+	}
+
+	for key, expected := range map[string]string{
+		"clone/foo": "qux",
+		"clone/bar": "existing",
+	} {
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		data := resp.Data["data"].(map[string]interface{})
+		if data["bar"] != expected && data["foo"] != expected {
+			t.Fatalf("%s: unexpected data %#v", key, data)
+		}
+	}
+
+	resp, err = importBundle(map[string]interface{}{"conflict": "overwrite"})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	meta, err = b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "clone/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.CurrentVersion != 2 {
+		t.Fatalf("expected the imported version on top of the existing one, got %d", meta.CurrentVersion)
+	}
+
+	resp, err = importBundle(map[string]interface{}{"format_version": 2})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	// The import counts as one write under the rate limited prefixes
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/clone/",
+		Storage:   storage,
+		Data:      map[string]interface{}{"write_rate_limit": 0.001},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = importBundle(map[string]interface{}{"conflict": "overwrite"})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	_, err = importBundle(map[string]interface{}{"conflict": "overwrite"})
+	if codedErr, ok := err.(logical.HTTPCodedError); !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got %v", err)
+	}
+
+	// The keys under a reserved prefix cannot be imported
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]interface{}{"reserved_prefixes": []string{"clone/"}},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = importBundle(map[string]interface{}{"conflict": "overwrite"})
+	if err != logical.ErrPermissionDenied {
+		t.Fatalf("expected a permission denied error, err:%s resp:%#v\n", err, resp)
+	}
+}
@@ -0,0 +1,119 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathInfo returns the path configuration for the endpoint reporting the
+// optional subsystems of the backend.
+func pathInfo(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "info$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathInfoRead()),
+		},
+
+		HelpSynopsis:    infoHelpSyn,
+		HelpDescription: infoHelpDesc,
+	}
+}
+
+// pathInfoRead reports which optional subsystems are available and enabled
+// and the limits configured on the backend.
+func (b *versionedKVBackend) pathInfoRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		enrichment, err := b.enrichmentConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		layout, err := b.storageLayout(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixKeys, err := b.getPrefixKeys(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		encryption, _, err := b.encryption(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		maxKeys, maxKeyLength, maxValueLength := customMetadataLimits(config)
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"features": map[string]interface{}{
+					"patch":                   true,
+					"subkeys":                 false,
+					"events":                  false,
+					"quotas":                  hasKeyQuotas(config) || hasByteQuotas(config),
+					"tidy":                    true,
+					"import":                  true,
+					"export":                  true,
+					"jobs":                    true,
+					"checkpoints":             len(config.CheckpointTimes) > 0,
+					"classifications":         len(config.Classifications) > 0,
+					"composites":              len(config.Composites) > 0,
+					"conformance":             len(config.RequiredPaths) > 0,
+					"data_schemas":            len(config.DataSchemas) > 0,
+					"enrichment":              enrichment != nil,
+					"path_config":             len(config.PathConfigs) > 0,
+					"readonly_mirrors":        len(config.ReadonlyMirrors) > 0,
+					"references":              len(config.ReferenceTargets) > 0,
+					"templates":               len(config.Templates) > 0,
+					"version_shards":          layout.VersionShards > 0,
+					"deterministic_paths":     layout.DeterministicPaths,
+					"delete_version_after":    !config.IsDeleteVersionAfterDisabled(),
+					"destroy_version_after":   destroyVersionAfter(config) > 0,
+					"dual_control_destroy":    destroyConfirmationWindow(config) > 0,
+					"trash":                   trashRetention(config) > 0,
+					"max_version_age":         getMaxVersionAge(config) > 0,
+					"max_history_bytes":       len(config.MaxHistoryBytes) > 0,
+					"cas_required":            config.CasRequired,
+					"string_values_only":      config.StringValuesOnly,
+					"default_custom_metadata": len(config.DefaultCustomMetadata) > 0,
+					"seal_wrap_versions":      config.SealWrapVersions,
+					"encrypt_custom_metadata": config.EncryptCustomMetadata,
+					"prefix_keys":             len(prefixKeys) > 0,
+					"transit_encryption":      encryption.Mode == encryptionModeTransit,
+				},
+				"limits": map[string]interface{}{
+					"max_versions":                     keptVersions(config, &KeyMetadata{}),
+					"max_versions_limit":               config.MaxVersionsLimit,
+					"min_versions":                     config.MinVersions,
+					"min_delete_version_after":         minDeleteVersionAfter(config).String(),
+					"lock_wait_timeout":                lockWaitTimeout(config).String(),
+					"max_custom_metadata_keys":         maxKeys,
+					"max_custom_metadata_key_length":   maxKeyLength,
+					"max_custom_metadata_value_length": maxValueLength,
+					"max_checkpoints":                  maxCheckpoints,
+					"version_shards":                   layout.VersionShards,
+				},
+			},
+		}, nil
+	}
+}
+
+const infoHelpSyn = `Reports the optional subsystems and limits of the KV store.`
+const infoHelpDesc = `
+This endpoint returns a machine-readable description of the backend so that
+clients can detect the features available on a deployment.
+
+"features" maps each optional subsystem to whether it is available and
+enabled on this mount. Subsystems that this version of the backend does not
+provide are reported as false.
+
+"limits" holds the effective limits applied by the backend.
+`
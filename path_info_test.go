@@ -0,0 +1,89 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Info(t *testing.T) {
+	b, storage := getBackend(t)
+
+	readInfo := func() (map[string]interface{}, map[string]interface{}) {
+		t.Helper()
+
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "info",
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		return resp.Data["features"].(map[string]interface{}), resp.Data["limits"].(map[string]interface{})
+	}
+
+	features, limits := readInfo()
+	for feature, expected := range map[string]bool{
+		"patch":      true,
+		"subkeys":    false,
+		"events":     false,
+		"quotas":     false,
+		"tidy":       true,
+		"import":     true,
+		"export":     true,
+		"templates":  false,
+		"enrichment": false,
+	} {
+		if features[feature] != expected {
+			t.Fatalf("expected feature %q to be %t, got %v", feature, expected, features[feature])
+		}
+	}
+	if limits["max_versions"] != uint32(10) {
+		t.Fatalf("unexpected max_versions: %v", limits["max_versions"])
+	}
+	if limits["max_custom_metadata_keys"] != maxCustomMetadataKeys {
+		t.Fatalf("unexpected max_custom_metadata_keys: %v", limits["max_custom_metadata_keys"])
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_versions":       5,
+			"max_versions_limit": 20,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "templates/team/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_versions": 3,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	features, limits = readInfo()
+	if features["templates"] != true {
+		t.Fatalf("expected templates to be enabled, got %v", features["templates"])
+	}
+	if limits["max_versions"] != uint32(5) {
+		t.Fatalf("unexpected max_versions: %v", limits["max_versions"])
+	}
+	if limits["max_versions_limit"] != uint32(20) {
+		t.Fatalf("unexpected max_versions_limit: %v", limits["max_versions_limit"])
+	}
+}
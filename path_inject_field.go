@@ -0,0 +1,215 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathInjectField returns the path configuration for the inject-field endpoint
+func pathInjectField(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "inject-field/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the secrets to update.",
+			},
+			"key": {
+				Type:        framework.TypeString,
+				Description: "The name of the field to add or update in the data of each secret.",
+			},
+			"value": {
+				Type:        framework.TypeString,
+				Description: "The value to set the field to.",
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Description: "If true, the secrets that would be updated are returned and nothing is written.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathInjectFieldWrite()),
+		},
+
+		HelpSynopsis:    injectFieldHelpSyn,
+		HelpDescription: injectFieldHelpDesc,
+	}
+}
+
+// pathInjectFieldWrite sets a field in the current version of every secret
+// under a prefix. Unless dry_run is set the secrets are updated by a
+// background job whose ID is returned.
+func (b *versionedKVBackend) pathInjectFieldWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("path").(string)
+		field := data.Get("key").(string)
+		value := data.Get("value").(string)
+		dryRun := data.Get("dry_run").(bool)
+
+		if field == "" {
+			return logical.ErrorResponse("missing key"), logical.ErrInvalidRequest
+		}
+
+		keys, err := b.collectKeys(ctx, req.Storage, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		if dryRun {
+			updates := []string{}
+			var failed []string
+			for _, key := range keys {
+				updated, err := b.injectField(ctx, req, key, field, value, true)
+				switch {
+				case err != nil:
+					failed = append(failed, key+": "+err.Error())
+				case updated:
+					updates = append(updates, key)
+				}
+			}
+
+			resp := &logical.Response{
+				Data: map[string]interface{}{
+					"total": len(keys),
+					"keys":  updates,
+				},
+			}
+			for _, f := range failed {
+				resp.AddWarning(f)
+			}
+
+			return resp, nil
+		}
+
+		// The job counts as one write under each rate limited prefix it
+		// writes to
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		limited := make(map[string]bool)
+		for _, key := range keys {
+			if err := b.checkRateLimitOnce(config, limited, key, true); err != nil {
+				return nil, err
+			}
+		}
+
+		job, err := b.startJob(ctx, req.Storage, "inject-field", prefix, keys, func(ctx context.Context, key string) (bool, error) {
+			return b.injectField(ctx, req, key, field, value, false)
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: jobResponseData(job),
+		}, nil
+	}
+}
+
+// injectField sets field to value in the data of the current version of key
+// and writes the result as a new version. Keys whose current version is
+// deleted or destroyed, or that already hold the value, are left untouched.
+// If dryRun is true nothing is written. It returns true if the key is, or
+// would be, updated.
+func (b *versionedKVBackend) injectField(ctx context.Context, req *logical.Request, key, field, value string, dryRun bool) (bool, error) {
+	s := req.Storage
+
+	config, err := b.keyConfig(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+
+	if err := checkPathAllowed(config, key); err != nil {
+		return false, err
+	}
+	if err := checkReservedPrefix(config, key); err != nil {
+		return false, err
+	}
+
+	unlock, err := b.lockKeyForWrite(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+	if meta == nil {
+		return false, nil
+	}
+
+	// The writes of the keys requiring the cas parameter are left to their
+	// owners, who know the version they expect to replace
+	if config.CasRequired || meta.CasRequired {
+		return false, errCasRequired
+	}
+
+	// References have no data of their own
+	vm := meta.Versions[meta.CurrentVersion]
+	if vm == nil || vm.Destroyed || vm.Reference != "" {
+		return false, nil
+	}
+	if deleted, err := isDeleted(vm); err != nil || deleted {
+		return false, err
+	}
+
+	versionData, err := b.readVersionData(ctx, s, key, meta.CurrentVersion)
+	if err != nil {
+		return false, err
+	}
+
+	if current, ok := versionData[field]; ok && reflect.DeepEqual(current, value) {
+		return false, nil
+	}
+	versionData[field] = value
+
+	marshaledData, err := json.Marshal(versionData)
+	if err != nil {
+		return false, err
+	}
+
+	if err := validateVersionData(config, meta, marshaledData); err != nil {
+		return false, err
+	}
+
+	if dryRun {
+		return true, nil
+	}
+
+	_, warning, err := b.writeVersion(ctx, s, config, meta, marshaledData, versionOptions{})
+	if err != nil {
+		return false, err
+	}
+	b.sendEvent(ctx, req, eventDataWrite, key, []uint64{meta.CurrentVersion})
+	if warning != "" {
+		b.Logger().Warn(warning, "key", key)
+	}
+
+	return true, nil
+}
+
+// errCasRequired is returned when a key updated by inject-field requires the
+// cas parameter.
+var errCasRequired = errors.New("the key requires the cas parameter and cannot be updated by inject-field")
+
+const injectFieldHelpSyn = `Sets a field in the data of every secret under a prefix.`
+const injectFieldHelpDesc = `
+Adds or updates a single field in the data of the current version of every
+secret under the provided prefix, each change creating a new version. Secrets
+whose current version is deleted or destroyed, or that already hold the value,
+are skipped. Secrets that require the cas parameter, through their metadata,
+the backend or the settings of their prefix, are not updated and are reported
+as failures.
+
+If "dry_run" is set, the secrets that would be updated are returned and nothing
+is written. Otherwise the secrets are updated by a background job whose progress
+can be read from the jobs/ endpoint using the returned ID.
+`
@@ -0,0 +1,162 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_InjectField(t *testing.T) {
+	b, storage := getBackend(t)
+
+	secrets := map[string]map[string]interface{}{
+		"app/a":     {"user": "a"},
+		"app/b":     {"user": "b", "region": "eu-west-1"},
+		"app/sub/c": {"user": "c", "region": "us-east-1"},
+		"other":     {"user": "d"},
+	}
+	for key, data := range secrets {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": data,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "inject-field/app",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"key":     "region",
+			"value":   "eu-west-1",
+			"dry_run": true,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["total"] != 3 {
+		t.Fatalf("expected 3 keys under the prefix, resp: %#v", resp)
+	}
+	if diff := deep.Equal(resp.Data["keys"], []string{"app/a", "app/sub/c"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// The dry run must not have created a version
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/app/a",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if v := resp.Data["metadata"].(map[string]interface{})["version"]; v != uint64(1) {
+		t.Fatalf("expected version 1, got %v", v)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "inject-field/app",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"key":   "region",
+			"value": "eu-west-1",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	id := resp.Data["id"].(string)
+
+	job := waitForJob(t, b, storage, id)
+	if job["total"] != uint64(3) || job["processed"] != uint64(3) || job["updated"] != uint64(2) || job["failed"] != uint64(0) {
+		t.Fatalf("unexpected job progress: %#v", job)
+	}
+
+	expected := map[string]map[string]interface{}{
+		"app/a":     {"user": "a", "region": "eu-west-1"},
+		"app/b":     {"user": "b", "region": "eu-west-1"},
+		"app/sub/c": {"user": "c", "region": "eu-west-1"},
+		"other":     {"user": "d"},
+	}
+	for key, data := range expected {
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if diff := deep.Equal(resp.Data["data"], data); diff != nil {
+			t.Fatalf("%s: %v", key, diff)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "jobs/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["keys"], []string{id}); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestVersionedKV_InjectField_CasRequired(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, req := range []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"data/app/a", map[string]interface{}{"data": map[string]interface{}{"bar": "baz"}}},
+		{"metadata/app/a", map[string]interface{}{"cas_required": true}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      req.path,
+			Storage:   storage,
+			Data:      req.data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "inject-field/app",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"key":     "region",
+			"value":   "eu-west-1",
+			"dry_run": true,
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if len(resp.Data["keys"].([]string)) != 0 || len(resp.Warnings) != 1 {
+		t.Fatalf("expected the key requiring cas to be reported, resp: %#v", resp)
+	}
+}
@@ -2,15 +2,18 @@ package kv
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hashicorp/vault/sdk/framework"
-	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -24,6 +27,7 @@ func pathMetadata(b *versionedKVBackend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Location of the secret.",
 			},
+			"numeric_time_fields": numericTimeFieldsSchema,
 			"cas_required": {
 				Type: framework.TypeBool,
 				Description: `
@@ -34,15 +38,49 @@ If false, the backend’s configuration will be used.`,
 				Type: framework.TypeInt,
 				Description: `
 The number of versions to keep. If not set, the backend’s configured max
-version is used.`,
+version is used. Values greater than the backend's max_versions_limit are
+lowered to the limit.`,
+			},
+			"max_history_bytes": {
+				Type: framework.TypeInt,
+				Description: `
+The largest cumulative size, in bytes, of the stored versions. The oldest
+versions are removed when it is exceeded. If not set, the backend's
+max_history_bytes configured for the longest matching prefix is used.`,
+			},
+			"min_versions": {
+				Type: framework.TypeInt,
+				Description: `
+The number of versions that must remain retrievable. Pruning by
+max_version_age, deletion and delete_version_after cannot make the most recent
+versions unretrievable. The largest of this value and the backend's configured
+min_versions is used.`,
+			},
+			"max_version_age": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+The age after which versions other than the current one are removed, in
+addition to the max_versions limit. If not set, the backend's configured
+max_version_age is used. A zero duration clears the current setting.
+`,
 			},
 			"delete_version_after": {
 				Type: framework.TypeDurationSecond,
 				Description: `
 The length of time before a version is deleted. If not set, the backend's
 configured delete_version_after is used. Cannot be greater than the
-backend's delete_version_after. A zero duration clears the current setting.
-A negative duration will cause an error.
+backend's delete_version_after or less than the backend's
+min_delete_version_after. A zero duration clears the current setting. A
+negative duration will cause an error.
+`,
+			},
+			"destroy_version_after": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+The length of time after its deletion_time before a version is permanently
+destroyed. If not set, the backend's configured destroy_version_after is
+used. Cannot be greater than the backend's destroy_version_after. A zero
+duration clears the current setting.
 `,
 			},
 			"custom_metadata": {
@@ -50,15 +88,150 @@ A negative duration will cause an error.
 				Description: `
 User-provided key-value pairs that are used to describe arbitrary and
 version-agnostic information about a secret.
+`,
+			},
+			"custom_metadata_patch": {
+				Type: framework.TypeMap,
+				Description: `
+Key-value pairs merged into the existing custom_metadata of the secret. Keys
+set to null are removed and the other keys are left untouched. Cannot be used
+together with custom_metadata.
+`,
+			},
+			"data_schema": {
+				Type: framework.TypeString,
+				Description: `
+A JSON Schema document that data written to the key must validate against.
+If not set, the schema configured on the backend for the longest matching
+prefix is used. An empty string clears the current setting.
+`,
+			},
+			"recurse": {
+				Type: framework.TypeBool,
+				Description: `
+Used when listing. If true, the whole subtree is walked and the fully
+qualified keys of the secrets are returned.
+`,
+			},
+			"glob": {
+				Type: framework.TypeString,
+				Description: `
+Used when listing. A pattern matched against the path of the secrets relative
+to the listed prefix, segment by segment, such as "apps/*/database". The fully
+qualified keys and folders matching the pattern are returned.
+`,
+			},
+			"limit": {
+				Type: framework.TypeInt,
+				Description: `
+Used when listing. The largest number of keys to return. If more keys are
+available, the response contains a "next_after" value to pass as "after" to
+get the next page. Zero means no limit.
+`,
+			},
+			"after": {
+				Type: framework.TypeString,
+				Description: `
+Used when listing. Only the keys sorting after this value are returned.
+`,
+			},
+			"depth": {
+				Type: framework.TypeInt,
+				Description: `
+Used when listing recursively. The number of folder levels to descend into,
+the folders at the limit are returned with a trailing slash. Zero means no
+limit.
+`,
+			},
+			"filter": {
+				Type: framework.TypeKVPairs,
+				Description: `
+Used when listing. Only the keys whose custom_metadata contains every
+key-value pair are returned, along with the folders.
+`,
+			},
+			"classification": {
+				Type: framework.TypeString,
+				Description: `
+The classification label of the secret. Must be one of the classifications
+configured on the backend. An empty string clears the current setting.
+`,
+			},
+			"owner": {
+				Type: framework.TypeString,
+				Description: `
+The owner of the secret. Must fully match the owner_pattern configured on the
+backend. An empty string clears the current setting. When listing, only the
+keys with this owner are returned, along with the folders.
+`,
+			},
+			"team": {
+				Type: framework.TypeString,
+				Description: `
+The team responsible for the secret. Must fully match the team_pattern
+configured on the backend. An empty string clears the current setting. When
+listing, only the keys of this team are returned, along with the folders.
+`,
+			},
+			"rotation_period": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+The age after which the current version of the secret is due for rotation.
+Data reads of a secret due for rotation return a warning, and the secrets due
+for rotation are listed by rotation-due/. A zero duration clears the current
+setting.
+`,
+			},
+			"deprecated": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the secret is deprecated. Data reads of a deprecated secret succeed
+with a warning.
+`,
+			},
+			"deprecation_message": {
+				Type: framework.TypeString,
+				Description: `
+The message added to the warning of the data reads of a deprecated secret,
+for instance the path of its replacement.
+`,
+			},
+			"delete_metadata_when_destroyed": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the metadata of the secret is deleted once all of its versions are
+destroyed. It is also deleted if the backend enables this setting.
+`,
+			},
+			"require_response_wrapping": {
+				Type: framework.TypeBool,
+				Description: `
+If true, the data reads of the secret fail unless the response is wrapped, so
+that its data is never returned in plaintext.
+`,
+			},
+			"response_wrapping_ttl": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+The maximum TTL of the wrapping token of the data reads of a secret requiring
+response wrapping. A zero duration removes the limit.
+`,
+			},
+			"expire_at": {
+				Type: framework.TypeString,
+				Description: `
+An RFC3339 timestamp after which the secret is expired. Reads of an expired
+secret fail and its versions are deleted in the background. An empty string
+clears the current setting.
 `,
 			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.upgradeCheck(b.pathMetadataWrite()),
-			logical.CreateOperation: b.upgradeCheck(b.pathMetadataWrite()),
-			logical.ReadOperation:   b.upgradeCheck(b.pathMetadataRead()),
-			logical.DeleteOperation: b.upgradeCheck(b.pathMetadataDelete()),
-			logical.ListOperation:   b.upgradeCheck(b.pathMetadataList()),
+			logical.UpdateOperation: b.instrument("metadata", b.upgradeCheck(b.reservedPrefixCheck(b.pathMetadataWrite()))),
+			logical.CreateOperation: b.instrument("metadata", b.upgradeCheck(b.reservedPrefixCheck(b.pathMetadataWrite()))),
+			logical.ReadOperation:   b.instrument("metadata", b.upgradeCheck(b.pathMetadataRead())),
+			logical.DeleteOperation: b.instrument("metadata", b.upgradeCheck(b.reservedPrefixCheck(b.pathMetadataDelete()))),
+			logical.ListOperation:   b.instrument("metadata", b.upgradeCheck(b.pathMetadataList())),
 		},
 
 		ExistenceCheck: b.metadataExistenceCheck(),
@@ -101,8 +274,58 @@ func (b *versionedKVBackend) pathMetadataList() framework.OperationFunc {
 		es := wrapper.Wrap(req.Storage)
 
 		// Use encrypted key storage to list the keys
-		keys, err := es.List(ctx, key)
-		return logical.ListResponse(keys), err
+		var keys []string
+		listPrefix := key
+		glob := data.Get("glob").(string)
+		switch {
+		case glob != "" && data.Get("recurse").(bool):
+			return logical.ErrorResponse("glob and recurse cannot be used together"), nil
+		case glob != "":
+			if err := validateGlob(glob); err != nil {
+				return logical.ErrorResponse("invalid glob: %s", err), nil
+			}
+
+			// The keys matching a glob are fully qualified
+			keys, err = b.globKeys(ctx, req.Storage, key, glob)
+			listPrefix = ""
+		case data.Get("recurse").(bool):
+			depth := data.Get("depth").(int)
+			if depth < 0 {
+				return logical.ErrorResponse("depth cannot be negative"), nil
+			}
+
+			// The keys of a recursive listing are fully qualified
+			keys, err = b.walkKeys(ctx, req.Storage, key, depth)
+			listPrefix = ""
+		default:
+			keys, err = es.List(ctx, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		filterRaw, filterOk := data.GetOk("filter")
+		owner, team := data.Get("owner").(string), data.Get("team").(string)
+		if filterOk || owner != "" || team != "" {
+			filter, _ := filterRaw.(map[string]string)
+			keys, err = b.filterKeys(ctx, req.Storage, listPrefix, keys, filter, owner, team)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		limit := data.Get("limit").(int)
+		if limit < 0 {
+			return logical.ErrorResponse("limit cannot be negative"), nil
+		}
+		keys, next := paginateKeys(keys, data.Get("after").(string), limit)
+
+		resp := logical.ListResponse(keys)
+		if next != "" {
+			resp.Data["next_after"] = next
+		}
+
+		return resp, nil
 	}
 }
 
@@ -118,13 +341,21 @@ func (b *versionedKVBackend) pathMetadataRead() framework.OperationFunc {
 			return nil, nil
 		}
 
+		pending := b.pendingReadOf(key)
+
 		versions := make(map[string]interface{}, len(meta.Versions))
 		for i, v := range meta.Versions {
-			versions[fmt.Sprintf("%d", i)] = map[string]interface{}{
+			version := map[string]interface{}{
 				"created_time":  ptypesTimestampToString(v.CreatedTime),
 				"deletion_time": ptypesTimestampToString(v.DeletionTime),
 				"destroyed":     v.Destroyed,
+				"read_count":    v.ReadCount + pending.readCount(i),
+				"size":          v.Size,
 			}
+			if v.Reference != "" {
+				version["reference"] = v.Reference
+			}
+			versions[fmt.Sprintf("%d", i)] = version
 		}
 
 		var deleteVersionAfter time.Duration
@@ -135,42 +366,156 @@ func (b *versionedKVBackend) pathMetadataRead() framework.OperationFunc {
 			}
 		}
 
-		return &logical.Response{
+		resp := &logical.Response{
 			Data: map[string]interface{}{
-				"versions":             versions,
-				"current_version":      meta.CurrentVersion,
-				"oldest_version":       meta.OldestVersion,
-				"created_time":         ptypesTimestampToString(meta.CreatedTime),
-				"updated_time":         ptypesTimestampToString(meta.UpdatedTime),
-				"max_versions":         meta.MaxVersions,
-				"cas_required":         meta.CasRequired,
-				"delete_version_after": deleteVersionAfter.String(),
-				"custom_metadata":      meta.CustomMetadata,
+				"versions":                       versions,
+				"current_version":                meta.CurrentVersion,
+				"oldest_version":                 meta.OldestVersion,
+				"created_time":                   ptypesTimestampToString(meta.CreatedTime),
+				"updated_time":                   ptypesTimestampToString(meta.UpdatedTime),
+				"max_versions":                   meta.MaxVersions,
+				"min_versions":                   meta.MinVersions,
+				"max_history_bytes":              meta.MaxHistoryBytes,
+				"cas_required":                   meta.CasRequired,
+				"delete_version_after":           deleteVersionAfter.String(),
+				"destroy_version_after":          destroyVersionAfter(meta).String(),
+				"max_version_age":                getMaxVersionAge(meta).String(),
+				"custom_metadata":                meta.CustomMetadata,
+				"data_schema":                    meta.DataSchema,
+				"expire_at":                      ptypesTimestampToString(meta.ExpireAt),
+				"classification":                 meta.Classification,
+				"owner":                          meta.Owner,
+				"team":                           meta.Team,
+				"rotation_period":                rotationPeriod(meta).String(),
+				"deprecated":                     meta.Deprecated,
+				"deprecation_message":            meta.DeprecationMessage,
+				"require_response_wrapping":      meta.RequireResponseWrapping,
+				"response_wrapping_ttl":          responseWrappingTTL(meta).String(),
+				"pending_destroy":                pendingDestroyData(meta),
+				"delete_metadata_when_destroyed": meta.DeleteMetadataWhenDestroyed,
+				"deleting":                       meta.Deleting,
+				"last_read_time":                 pending.lastRead(meta),
 			},
-		}, nil
+		}
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if numericTimeFields(config, data) {
+			addNumericTimeFields(resp.Data)
+			for _, v := range versions {
+				addNumericTimeFields(v.(map[string]interface{}))
+			}
+			if pendingDestroy, ok := resp.Data["pending_destroy"].(map[string]interface{}); ok && pendingDestroy != nil {
+				addNumericTimeFields(pendingDestroy)
+			}
+		}
+
+		return resp, nil
 	}
 }
 
+// The default limits of custom_metadata, used when the configuration of the
+// mount does not set them.
 const maxCustomMetadataKeys = 64
 const maxCustomMetadataKeyLength = 128
 const maxCustomMetadataValueLength = 512
 const customMetadataValidationErrorPrefix = "custom_metadata validation failed"
 
+// customMetadataLimits returns the largest number of custom_metadata keys of a
+// secret and the longest key and value allowed by the configuration.
+func customMetadataLimits(config *Configuration) (keys, keyLength, valueLength int) {
+	keys, keyLength, valueLength = maxCustomMetadataKeys, maxCustomMetadataKeyLength, maxCustomMetadataValueLength
+	if config.CustomMetadataMaxKeys > 0 {
+		keys = int(config.CustomMetadataMaxKeys)
+	}
+	if config.CustomMetadataMaxKeyLength > 0 {
+		keyLength = int(config.CustomMetadataMaxKeyLength)
+	}
+	if config.CustomMetadataMaxValueLength > 0 {
+		valueLength = int(config.CustomMetadataMaxValueLength)
+	}
+	return keys, keyLength, valueLength
+}
+
+// defaultCustomMetadata returns a copy of the default_custom_metadata of the
+// mount, overridden by the custom_metadata of the template matching key and
+// then by customMetadata.
+func defaultCustomMetadata(config *Configuration, key string, customMetadata map[string]string) map[string]string {
+	var templateCustomMetadata map[string]string
+	if template := keyTemplate(config, key); template != nil {
+		templateCustomMetadata = template.CustomMetadata
+	}
+	if len(config.DefaultCustomMetadata) == 0 && len(templateCustomMetadata) == 0 {
+		return customMetadata
+	}
+
+	merged := make(map[string]string, len(config.DefaultCustomMetadata)+len(templateCustomMetadata)+len(customMetadata))
+	for k, v := range config.DefaultCustomMetadata {
+		merged[k] = v
+	}
+	for k, v := range templateCustomMetadata {
+		merged[k] = v
+	}
+	for k, v := range customMetadata {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// validateCustomMetadataPatch checks that the values of the
+// custom_metadata_patch field are strings, or null to remove the key.
+func validateCustomMetadataPatch(patch map[string]interface{}) error {
+	for k, v := range patch {
+		switch v.(type) {
+		case nil, string:
+		default:
+			return fmt.Errorf("custom_metadata_patch value for key %q must be a string or null", k)
+		}
+	}
+	return nil
+}
+
+// patchCustomMetadata returns a copy of customMetadata with the string values
+// of patch set and the keys set to nil in patch removed.
+func patchCustomMetadata(customMetadata map[string]string, patch map[string]interface{}) map[string]string {
+	patched := make(map[string]string, len(customMetadata)+len(patch))
+	for k, v := range customMetadata {
+		patched[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(patched, k)
+			continue
+		}
+		patched[k] = v.(string)
+	}
+	return patched
+}
+
 // Perform input validation on custom_metadata field. If the key count
-// exceeds maxCustomMetadataKeys, the validation will be short-circuited
+// exceeds the maximum number of keys, the validation will be short-circuited
 // to prevent unnecessary (and potentially costly) validation to be run.
-// If the key count falls at or below maxCustomMetadataKeys, multiple
-// checks will be made per key and value. These checks include:
-//   - 0 < length of key <= maxCustomMetadataKeyLength
-//   - 0 < length of value <= maxCustomMetadataValueLength
+// If the key count falls at or below the maximum, multiple checks will be
+// made per key and value. These checks include:
+//   - 0 < length of key <= maximum key length
+//   - 0 < length of value <= maximum value length
 //   - keys and values cannot include unprintable characters
-func validateCustomMetadata(customMetadata map[string]string) error {
+//   - keys must be allowed by the custom_metadata_allowed_keys of the mount
+//   - values must match the custom_metadata_value_patterns of the mount
+//
+// The limits are the ones returned by customMetadataLimits.
+func validateCustomMetadata(config *Configuration, customMetadata map[string]string) error {
 	var errs *multierror.Error
 
-	if keyCount := len(customMetadata); keyCount > maxCustomMetadataKeys {
+	maxKeys, maxKeyLength, maxValueLength := customMetadataLimits(config)
+
+	if keyCount := len(customMetadata); keyCount > maxKeys {
 		errs = multierror.Append(errs, fmt.Errorf("%s: payload must contain at most %d keys, provided %d",
 			customMetadataValidationErrorPrefix,
-			maxCustomMetadataKeys,
+			maxKeys,
 			keyCount))
 
 		return errs.ErrorOrNil()
@@ -178,20 +523,20 @@ func validateCustomMetadata(customMetadata map[string]string) error {
 
 	// Perform validation on each key and value and return ALL errors
 	for key, value := range customMetadata {
-		if keyLen := len(key); 0 == keyLen || keyLen > maxCustomMetadataKeyLength {
+		if keyLen := len(key); 0 == keyLen || keyLen > maxKeyLength {
 			errs = multierror.Append(errs, fmt.Errorf("%s: length of key %q is %d but must be 0 < len(key) <= %d",
 				customMetadataValidationErrorPrefix,
 				key,
 				keyLen,
-				maxCustomMetadataKeyLength))
+				maxKeyLength))
 		}
 
-		if valueLen := len(value); 0 == valueLen || valueLen > maxCustomMetadataValueLength {
+		if valueLen := len(value); 0 == valueLen || valueLen > maxValueLength {
 			errs = multierror.Append(errs, fmt.Errorf("%s: length of value for key %q is %d but must be 0 < len(value) <= %d",
 				customMetadataValidationErrorPrefix,
 				key,
 				valueLen,
-				maxCustomMetadataValueLength))
+				maxValueLength))
 		}
 
 		if !strutil.Printable(key) {
@@ -208,11 +553,69 @@ func validateCustomMetadata(customMetadata map[string]string) error {
 				customMetadataValidationErrorPrefix,
 				key))
 		}
+
+		if !customMetadataKeyAllowed(config, key) {
+			errs = multierror.Append(errs, fmt.Errorf("%s: key %q is not allowed",
+				customMetadataValidationErrorPrefix,
+				key))
+		}
+
+		if pattern, ok := config.CustomMetadataValuePatterns[key]; ok {
+			re, err := compileCustomMetadataValuePattern(pattern)
+			if err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("%s: %w", customMetadataValidationErrorPrefix, err))
+			} else if !re.MatchString(value) {
+				errs = multierror.Append(errs, fmt.Errorf("%s: value for key %q does not match %q",
+					customMetadataValidationErrorPrefix,
+					key,
+					pattern))
+			}
+		}
 	}
 
 	return errs.ErrorOrNil()
 }
 
+// customMetadataKeyAllowed returns true if the custom_metadata_allowed_keys of
+// the mount are empty or allow key.
+func customMetadataKeyAllowed(config *Configuration, key string) bool {
+	if len(config.CustomMetadataAllowedKeys) == 0 {
+		return true
+	}
+	for _, allowed := range config.CustomMetadataAllowedKeys {
+		if strings.HasSuffix(allowed, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(allowed, "*")) {
+				return true
+			}
+		} else if key == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// compileCustomMetadataValuePattern compiles a pattern of
+// custom_metadata_value_patterns so that it must match the whole value.
+func compileCustomMetadataValuePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// validateRequiredCustomMetadata returns an error listing the
+// custom_metadata_required_keys of the mount missing from the
+// custom_metadata of a new secret.
+func validateRequiredCustomMetadata(config *Configuration, customMetadata map[string]string) error {
+	var missing []string
+	for _, key := range config.CustomMetadataRequiredKeys {
+		if _, ok := customMetadata[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s: missing required keys %s", customMetadataValidationErrorPrefix, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
 func (b *versionedKVBackend) pathMetadataWrite() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		key := data.Get("path").(string)
@@ -221,67 +624,300 @@ func (b *versionedKVBackend) pathMetadataWrite() framework.OperationFunc {
 		}
 
 		maxRaw, mOk := data.GetOk("max_versions")
+		minRaw, minOk := data.GetOk("min_versions")
+		mhbRaw, mhbOk := data.GetOk("max_history_bytes")
 		casRaw, cOk := data.GetOk("cas_required")
 		deleteVersionAfterRaw, dvaOk := data.GetOk("delete_version_after")
+		destroyVersionAfterRaw, destroyOk := data.GetOk("destroy_version_after")
+		maxVersionAgeRaw, ageOk := data.GetOk("max_version_age")
 		customMetadataRaw, cmOk := data.GetOk("custom_metadata")
+		customMetadataPatchRaw, cmpOk := data.GetOk("custom_metadata_patch")
+		dataSchemaRaw, dsOk := data.GetOk("data_schema")
+		expireAtRaw, eaOk := data.GetOk("expire_at")
+		classificationRaw, clOk := data.GetOk("classification")
+		ownerRaw, ownerOk := data.GetOk("owner")
+		teamRaw, teamOk := data.GetOk("team")
+		rotationPeriodRaw, rpOk := data.GetOk("rotation_period")
+		deprecatedRaw, depOk := data.GetOk("deprecated")
+		deprecationMessageRaw, depMsgOk := data.GetOk("deprecation_message")
+		requireWrappingRaw, rwOk := data.GetOk("require_response_wrapping")
+		dmwdRaw, dmwdOk := data.GetOk("delete_metadata_when_destroyed")
+		wrappingTTLRaw, rwTTLOk := data.GetOk("response_wrapping_ttl")
 
 		// Fast path validation
-		if !mOk && !cOk && !dvaOk && !cmOk {
+		if !mOk && !minOk && !mhbOk && !cOk && !dvaOk && !destroyOk && !ageOk && !cmOk && !cmpOk && !dsOk && !eaOk && !clOk && !ownerOk && !teamOk && !depOk && !depMsgOk && !rpOk && !rwOk && !rwTTLOk && !dmwdOk {
 			return nil, nil
 		}
 
-		config, err := b.config(ctx, req.Storage)
+		config, err := b.keyConfig(ctx, req.Storage, key)
 		if err != nil {
 			return nil, err
 		}
 
+		if err := checkPathAllowed(config, key); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
 		customMetadataMap := map[string]string{}
 
 		if cmOk {
 			customMetadataMap = customMetadataRaw.(map[string]string)
-			customMetadataErrs := validateCustomMetadata(customMetadataMap)
+			customMetadataErrs := validateCustomMetadata(config, customMetadataMap)
 
 			if customMetadataErrs != nil {
 				return logical.ErrorResponse(customMetadataErrs.Error()), nil
 			}
 		}
 
+		var customMetadataPatch map[string]interface{}
+		if cmpOk {
+			if cmOk {
+				return logical.ErrorResponse("custom_metadata and custom_metadata_patch cannot be used together"), nil
+			}
+			customMetadataPatch = customMetadataPatchRaw.(map[string]interface{})
+			if err := validateCustomMetadataPatch(customMetadataPatch); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+
+		// The keys under the enrichment namespace are managed by the
+		// synchronization and cannot be set by users
+		namespace, err := b.enrichmentNamespace(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if namespace != "" {
+			for k := range customMetadataMap {
+				if strings.HasPrefix(k, namespace) {
+					return logical.ErrorResponse("custom_metadata key %q is under the reserved namespace %q", k, namespace), nil
+				}
+			}
+			for k := range customMetadataPatch {
+				if strings.HasPrefix(k, namespace) {
+					return logical.ErrorResponse("custom_metadata_patch key %q is under the reserved namespace %q", k, namespace), nil
+				}
+			}
+		}
+
+		if dsOk && dataSchemaRaw.(string) != "" {
+			if _, err := compileDataSchema(dataSchemaRaw.(string)); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+
+		var expiration *timestamp.Timestamp
+		if eaOk && expireAtRaw.(string) != "" {
+			t, err := time.Parse(time.RFC3339, expireAtRaw.(string))
+			if err != nil {
+				return logical.ErrorResponse("invalid expire_at: %s", err), nil
+			}
+			expiration, err = ptypes.TimestampProto(t)
+			if err != nil {
+				return logical.ErrorResponse("invalid expire_at: %s", err), nil
+			}
+		}
+
+		if clOk {
+			if err := validateClassification(config, classificationRaw.(string)); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+
+		if err := validateOwnership(config, data.Get("owner").(string), data.Get("team").(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		if rpOk && rotationPeriodRaw.(int) < 0 {
+			return logical.ErrorResponse("rotation_period cannot be negative"), nil
+		}
+
+		if rwTTLOk && wrappingTTLRaw.(int) < 0 {
+			return logical.ErrorResponse("response_wrapping_ttl cannot be negative"), nil
+		}
+
+		if dvaOk {
+			dva := time.Duration(deleteVersionAfterRaw.(int)) * time.Second
+			if min := minDeleteVersionAfter(config); dva != 0 && dva < min {
+				return logical.ErrorResponse("delete_version_after %s is less than the min_delete_version_after %s configured on the backend", dva, min), nil
+			}
+		}
+
 		var resp *logical.Response
 		if cOk && config.CasRequired && !casRaw.(bool) {
 			resp = &logical.Response{}
 			resp.AddWarning("\"cas_required\" set to false, but is mandated by backend config. This value will be ignored.")
 		}
 
-		lock := locksutil.LockForKey(b.locks, key)
-		lock.Lock()
-		defer lock.Unlock()
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
 
 		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
 		if err != nil {
 			return nil, err
 		}
-		if meta == nil {
+		created := meta == nil
+		if created {
 			now := ptypes.TimestampNow()
 			meta = &KeyMetadata{
-				Key:         key,
-				Versions:    map[uint64]*VersionMetadata{},
-				CreatedTime: now,
-				UpdatedTime: now,
+				Key:            key,
+				Versions:       map[uint64]*VersionMetadata{},
+				CreatedTime:    now,
+				UpdatedTime:    now,
+				CustomMetadata: defaultCustomMetadata(config, key, nil),
+			}
+			applyTemplate(config, meta)
+
+			// The custom_metadata provided overrides the defaults
+			if cmOk {
+				customMetadataMap = defaultCustomMetadata(config, key, customMetadataMap)
+				if err := validateCustomMetadata(config, customMetadataMap); err != nil {
+					return logical.ErrorResponse(err.Error()), nil
+				}
 			}
 		}
+		if meta.Deleting {
+			return logical.ErrorResponse(errKeyDeleting.Error()), logical.ErrInvalidRequest
+		}
 
+		previousMaxVersions := meta.MaxVersions
 		if mOk {
 			meta.MaxVersions = uint32(maxRaw.(int))
 		}
+		if minOk {
+			meta.MinVersions = uint32(minRaw.(int))
+		}
+		if mhbOk {
+			if mhb := mhbRaw.(int); mhb > 0 {
+				meta.MaxHistoryBytes = uint64(mhb)
+			} else {
+				meta.MaxHistoryBytes = 0
+			}
+		}
+		if clampMaxVersions(config, meta) && mOk {
+			if resp == nil {
+				resp = &logical.Response{}
+			}
+			resp.AddWarning(fmt.Sprintf("\"max_versions\" is greater than the max_versions_limit %d configured on the backend and was set to %d.", config.MaxVersionsLimit, meta.MaxVersions))
+		}
+		if err := validateMinVersions(config, meta); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 		if cOk {
 			meta.CasRequired = casRaw.(bool)
 		}
 		if dvaOk {
 			meta.DeleteVersionAfter = ptypes.DurationProto(time.Duration(deleteVersionAfterRaw.(int)) * time.Second)
 		}
+		if ageOk {
+			if age := maxVersionAgeRaw.(int); age > 0 {
+				meta.MaxVersionAge = ptypes.DurationProto(time.Duration(age) * time.Second)
+			} else {
+				meta.MaxVersionAge = nil
+			}
+		}
+		if destroyOk {
+			if destroy := destroyVersionAfterRaw.(int); destroy > 0 {
+				meta.DestroyVersionAfter = ptypes.DurationProto(time.Duration(destroy) * time.Second)
+			} else {
+				meta.DestroyVersionAfter = nil
+			}
+		}
 		if cmOk {
+			if namespace != "" {
+				for k, v := range meta.CustomMetadata {
+					if strings.HasPrefix(k, namespace) {
+						customMetadataMap[k] = v
+					}
+				}
+			}
 			meta.CustomMetadata = customMetadataMap
 		}
+		if cmpOk {
+			patched := patchCustomMetadata(meta.CustomMetadata, customMetadataPatch)
+			if err := validateCustomMetadata(config, patched); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			meta.CustomMetadata = patched
+		}
+		if dsOk {
+			meta.DataSchema = dataSchemaRaw.(string)
+		}
+		if eaOk {
+			meta.ExpireAt = expiration
+		}
+		if clOk {
+			meta.Classification = classificationRaw.(string)
+		}
+		if ownerOk {
+			meta.Owner = ownerRaw.(string)
+		}
+		if teamOk {
+			meta.Team = teamRaw.(string)
+		}
+		if rpOk {
+			if period := rotationPeriodRaw.(int); period > 0 {
+				meta.RotationPeriod = ptypes.DurationProto(time.Duration(period) * time.Second)
+			} else {
+				meta.RotationPeriod = nil
+			}
+		}
+		if depOk {
+			meta.Deprecated = deprecatedRaw.(bool)
+		}
+		if depMsgOk {
+			meta.DeprecationMessage = deprecationMessageRaw.(string)
+		}
+		if rwOk {
+			meta.RequireResponseWrapping = requireWrappingRaw.(bool)
+		}
+		if dmwdOk {
+			meta.DeleteMetadataWhenDestroyed = dmwdRaw.(bool)
+		}
+		if rwTTLOk {
+			if ttl := wrappingTTLRaw.(int); ttl > 0 {
+				meta.ResponseWrappingTtl = ptypes.DurationProto(time.Duration(ttl) * time.Second)
+			} else {
+				meta.ResponseWrappingTtl = nil
+			}
+		}
+		if created {
+			if err := validateRequiredCustomMetadata(config, meta.CustomMetadata); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+
+			release, quotaWarning, err := b.checkKeyQuotas(ctx, req.Storage, config, []string{key})
+			if errors.Is(err, errKeyQuotaExceeded) {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			if quotaWarning != "" {
+				if resp == nil {
+					resp = &logical.Response{}
+				}
+				resp.AddWarning(quotaWarning)
+			}
+		}
+		// The settings erasing versions on their own would bypass the
+		// confirmation of destroys
+		setting := erasingSetting(
+			maxVersionsIf(mOk, meta.MaxVersions),
+			previousMaxVersions,
+			durationIf(destroyOk, meta.DestroyVersionAfter),
+			durationIf(ageOk, meta.MaxVersionAge),
+			mhbOk && meta.MaxHistoryBytes > 0,
+		)
+		if err := checkDualControlSetting(config, setting); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		if _, err := meta.ApplyMinVersions(minVersions(config, meta)); err != nil {
+			return nil, err
+		}
 
 		err = b.writeKeyMetadata(ctx, req.Storage, meta)
 		return resp, err
@@ -292,9 +928,11 @@ func (b *versionedKVBackend) pathMetadataDelete() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		key := data.Get("path").(string)
 
-		lock := locksutil.LockForKey(b.locks, key)
-		lock.Lock()
-		defer lock.Unlock()
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
 
 		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
 		if err != nil {
@@ -304,35 +942,174 @@ func (b *versionedKVBackend) pathMetadataDelete() framework.OperationFunc {
 			return nil, nil
 		}
 
-		// Delete each version.
-		for id, _ := range meta.Versions {
-			versionKey, err := b.getVersionKey(ctx, key, id, req.Storage)
+		if id := b.backgroundDeletion(key); id != "" {
+			job, err := b.getJob(ctx, req.Storage, id)
 			if err != nil {
 				return nil, err
 			}
+			if job != nil {
+				return deletionJobResponse(job), nil
+			}
+		}
+
+		config, err := b.keyConfig(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+
+		// With dual control, the deletion is only carried out once another
+		// entity deletes the key within the window. A deletion that was
+		// interrupted has already been confirmed. The confirmation is
+		// required before trashing the key too, as the trash is purged
+		// without one.
+		if window := destroyConfirmationWindow(config); window > 0 && !meta.Deleting {
+			confirmed, err := confirmDestroy(meta, versionNumbers(allVersionNumbers(meta)), true, req.EntityID, window, time.Now())
+			switch {
+			case errors.Is(err, errDestroyNoEntity), errors.Is(err, errDestroySameEntity):
+				return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+			case errors.Is(err, errDestroyPending):
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			case err != nil:
+				return nil, err
+			}
+
+			if !confirmed {
+				if err := b.writeKeyMetadata(ctx, req.Storage, meta); err != nil {
+					return nil, err
+				}
+				return pendingDestroyResponse(meta, window), nil
+			}
+		}
+
+		// With the trash enabled the key is retained until restored or
+		// purged. An interrupted deletion is resumed rather than trashed.
+		if retention := trashRetention(config); retention > 0 && !meta.Deleting {
+			if err := b.trashKey(ctx, req.Storage, meta, retention); err != nil {
+				return nil, err
+			}
+			b.sendEvent(ctx, req, eventMetadataDelete, key, nil)
+			return nil, nil
+		}
 
-			err = req.Storage.Delete(ctx, versionKey)
+		// The keys with many versions are deleted in the background so
+		// that the request does not time out
+		if len(meta.Versions) > asyncDeletionThreshold {
+			job, err := b.startKeyDeletion(ctx, req.Storage, meta)
 			if err != nil {
 				return nil, err
 			}
+			b.sendEvent(ctx, req, eventMetadataDelete, key, nil)
+			return deletionJobResponse(job), nil
 		}
 
-		// Get an encrypted key storage object
-		wrapper, err := b.getKeyEncryptor(ctx, req.Storage)
-		if err != nil {
+		if err := b.deleteKey(ctx, req.Storage, meta); err != nil {
 			return nil, err
 		}
+		b.sendEvent(ctx, req, eventMetadataDelete, key, nil)
 
-		es := wrapper.Wrap(req.Storage)
+		return nil, nil
+	}
+}
 
-		// Use encrypted key storage to delete the key
-		err = es.Delete(ctx, key)
-		return nil, err
+// deleteKey permanently deletes the key described by meta and all of its
+// versions. The caller must hold the write lock of the key.
+func (b *versionedKVBackend) deleteKey(ctx context.Context, s logical.Storage, meta *KeyMetadata) error {
+	// Get an encrypted key storage object
+	wrapper, err := b.getKeyEncryptor(ctx, s)
+	if err != nil {
+		return err
 	}
+
+	// Mark the key as being deleted before purging it. Writers holding the
+	// lock after us see the marker and fail instead of resurrecting a
+	// partially deleted key, and an interrupted deletion can be resumed by
+	// deleting the key again.
+	if !meta.Deleting {
+		meta.Deleting = true
+		if err := b.writeKeyMetadata(ctx, s, meta); err != nil {
+			return err
+		}
+	}
+
+	// Delete each version.
+	ids := make([]uint64, 0, len(meta.Versions))
+	for id := range meta.Versions {
+		ids = append(ids, id)
+	}
+	if err := b.deleteVersions(ctx, s, meta.Key, ids); err != nil {
+		return err
+	}
+
+	es := wrapper.Wrap(s)
+
+	// Use encrypted key storage to delete the key
+	err = es.Delete(ctx, meta.Key)
+	b.invalidateKeyMetadata(s, meta.Key)
+	b.trackKeyBytes(s, meta.Key, nil)
+	b.trackKeyCount(s, meta.Key, nil)
+	return err
 }
 
+// versionDeleteWorkers is the number of versions deleteVersions deletes
+// concurrently.
+const versionDeleteWorkers = 16
+
+// deleteVersions removes the provided versions of key from storage. The
+// deletes are issued by a bounded number of workers so that keys with many
+// versions are deleted quickly on storage backends with a high latency.
+func (b *versionedKVBackend) deleteVersions(ctx context.Context, s logical.Storage, key string, ids []uint64) error {
+	workers := versionDeleteWorkers
+	if len(ids) < workers {
+		workers = len(ids)
+	}
+
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var errs *multierror.Error
+
+	versions := make(chan uint64)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for id := range versions {
+				versionKey, err := b.getVersionKey(ctx, key, id, s)
+				if err == nil {
+					err = s.Delete(ctx, versionKey)
+				}
+				if err != nil {
+					errLock.Lock()
+					errs = multierror.Append(errs, fmt.Errorf("failed to delete version %d: %w", id, err))
+					errLock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, id := range ids {
+		versions <- id
+	}
+	close(versions)
+	wg.Wait()
+
+	return errs.ErrorOrNil()
+}
+
+// errKeyDeleting is returned when writing to a key whose deletion is in
+// progress.
+var errKeyDeleting = errors.New("key is being deleted, write again once the deletion has completed")
+
 const metadataHelpSyn = `Allows interaction with key metadata and settings in the KV store.`
 const metadataHelpDesc = `
 This endpoint allows for reading, information about a key in the key-value
 store, writing key settings, and permanently deleting a key and all versions. 
+
+The versions of a key with more than 100 versions are deleted by a background
+job whose ID is returned in "id" and whose progress can be read at
+"jobs/<id>". The key cannot be written until the deletion completes.
+
+When destroys must be confirmed, the key is only deleted once an entity other
+than the one that first deleted it deletes it again within the
+destroy_confirmation_window. Until then the pending deletion is returned.
 `
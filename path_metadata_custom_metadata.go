@@ -0,0 +1,97 @@
+package kv
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathMetadataCustomMetadata returns the path configuration for deleting a
+// single custom_metadata key of a secret. It must be registered before
+// pathMetadata so that it takes precedence.
+func pathMetadataCustomMetadata(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "metadata/" + framework.MatchAllRegex("path") + "/custom-metadata/(?P<name>.+)$",
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the secret.",
+			},
+			"name": {
+				Type:        framework.TypeString,
+				Description: "The custom_metadata key to delete.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.DeleteOperation: b.upgradeCheck(b.reservedPrefixCheck(b.pathMetadataCustomMetadataDelete())),
+		},
+
+		HelpSynopsis:    metadataCustomMetadataHelpSyn,
+		HelpDescription: metadataCustomMetadataHelpDesc,
+	}
+}
+
+// pathMetadataCustomMetadataDelete removes a key from the custom_metadata of a
+// secret, leaving the other keys untouched.
+func (b *versionedKVBackend) pathMetadataCustomMetadataDelete() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+		name := data.Get("name").(string)
+
+		config, err := b.keyConfig(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkPathAllowed(config, key); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
+		// The keys under the enrichment namespace are managed by the
+		// synchronization and cannot be removed by users
+		namespace, err := b.enrichmentNamespace(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if namespace != "" && strings.HasPrefix(name, namespace) {
+			return logical.ErrorResponse("custom_metadata key %q is under the reserved namespace %q", name, namespace), nil
+		}
+
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+
+		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, nil
+		}
+		if meta.Deleting {
+			return logical.ErrorResponse(errKeyDeleting.Error()), logical.ErrInvalidRequest
+		}
+
+		if _, ok := meta.CustomMetadata[name]; !ok {
+			return nil, nil
+		}
+		delete(meta.CustomMetadata, name)
+
+		return nil, b.writeKeyMetadata(ctx, req.Storage, meta)
+	}
+}
+
+const metadataCustomMetadataHelpSyn = `Deletes a single custom_metadata key of a secret.`
+const metadataCustomMetadataHelpDesc = `
+Deleting "metadata/<path>/custom-metadata/<name>" removes the key <name> from
+the custom_metadata of the secret at <path> without modifying its other keys,
+so that clients do not have to read and rewrite the whole map. Deleting a key
+that is not set is not an error.
+
+Because this path takes precedence over "metadata/", a secret whose name
+contains "/custom-metadata/" cannot be deleted through "metadata/".
+`
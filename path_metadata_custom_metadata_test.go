@@ -0,0 +1,75 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Metadata_CustomMetadata_Delete(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo/bar",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{
+				"owner":     "platform",
+				"team/name": "kv",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for _, name := range []string{"team/name", "missing"} {
+		req = &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "metadata/foo/bar/custom-metadata/" + name,
+			Storage:   storage,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo/bar",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["custom_metadata"], map[string]string{"owner": "platform"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// Deleting a key of a missing secret does not create it
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/baz/custom-metadata/owner",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/baz",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("expected no metadata, err:%s resp:%#v\n", err, resp)
+	}
+}
@@ -0,0 +1,191 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// The sources a setting of a key can be resolved from.
+const (
+	settingSourceDefault = "default"
+	settingSourceBackend = "backend"
+	settingSourcePrefix  = "prefix"
+	settingSourceKey     = "key"
+)
+
+// pathMetadataEffective returns the path configuration for reading the
+// resolved settings of a key. It must be registered before pathMetadata so
+// that it takes precedence.
+func pathMetadataEffective(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "metadata/" + framework.MatchAllRegex("path") + "/effective$",
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the secret.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathMetadataEffectiveRead()),
+		},
+
+		HelpSynopsis:    metadataEffectiveHelpSyn,
+		HelpDescription: metadataEffectiveHelpDesc,
+	}
+}
+
+// effectiveSetting describes the resolved value of a setting and where it
+// comes from.
+func effectiveSetting(value interface{}, source, prefix string) map[string]interface{} {
+	setting := map[string]interface{}{
+		"value":  value,
+		"source": source,
+	}
+	if source == settingSourcePrefix {
+		setting["prefix"] = prefix
+	}
+	return setting
+}
+
+// mountSettingSource returns the source of a setting of the configuration
+// returned by keyConfig, depending on whether the prefix settings set it.
+func mountSettingSource(fromPrefix bool) string {
+	if fromPrefix {
+		return settingSourcePrefix
+	}
+	return settingSourceBackend
+}
+
+// shortestSetting resolves a duration the same way deletionTime does, using
+// the minimum non-zero value of the mount and the key.
+func shortestSetting(mount time.Duration, mountSource string, key time.Duration) (time.Duration, string) {
+	switch {
+	case mount == 0 && key == 0:
+		return 0, settingSourceDefault
+	case key != 0 && (mount == 0 || key < mount):
+		return key, settingSourceKey
+	default:
+		return mount, mountSource
+	}
+}
+
+// effectiveSettings resolves the settings applying to the key described by
+// meta. config must have been returned by keyConfig for the key.
+func effectiveSettings(config *Configuration, meta *KeyMetadata) map[string]interface{} {
+	prefix, pc := keyPathConfig(config, meta.Key)
+	if pc == nil {
+		pc = &PathConfig{}
+	}
+
+	settings := make(map[string]interface{})
+
+	kept := keptVersions(config, meta)
+	requested := max(config.MaxVersions, meta.MaxVersions)
+	if requested == 0 {
+		requested = defaultMaxVersions
+	}
+	switch {
+	case kept < requested:
+		settings["max_versions"] = effectiveSetting(kept, settingSourceBackend, prefix)
+	case meta.MaxVersions > 0 && meta.MaxVersions >= config.MaxVersions:
+		settings["max_versions"] = effectiveSetting(kept, settingSourceKey, prefix)
+	case config.MaxVersions > 0:
+		settings["max_versions"] = effectiveSetting(kept, mountSettingSource(pc.MaxVersions > 0), prefix)
+	default:
+		settings["max_versions"] = effectiveSetting(kept, settingSourceDefault, prefix)
+	}
+
+	switch {
+	case config.CasRequired:
+		settings["cas_required"] = effectiveSetting(true, mountSettingSource(pc.CasRequired), prefix)
+	case meta.CasRequired:
+		settings["cas_required"] = effectiveSetting(true, settingSourceKey, prefix)
+	case pc.CasOptional:
+		settings["cas_required"] = effectiveSetting(false, settingSourcePrefix, prefix)
+	default:
+		settings["cas_required"] = effectiveSetting(false, settingSourceDefault, prefix)
+	}
+
+	switch {
+	case config.DeleteMetadataWhenDestroyed:
+		settings["delete_metadata_when_destroyed"] = effectiveSetting(true, settingSourceBackend, prefix)
+	case meta.DeleteMetadataWhenDestroyed:
+		settings["delete_metadata_when_destroyed"] = effectiveSetting(true, settingSourceKey, prefix)
+	default:
+		settings["delete_metadata_when_destroyed"] = effectiveSetting(false, settingSourceDefault, prefix)
+	}
+
+	switch {
+	case meta.MinVersions > 0 && meta.MinVersions >= config.MinVersions:
+		settings["min_versions"] = effectiveSetting(meta.MinVersions, settingSourceKey, prefix)
+	case config.MinVersions > 0:
+		settings["min_versions"] = effectiveSetting(config.MinVersions, settingSourceBackend, prefix)
+	default:
+		settings["min_versions"] = effectiveSetting(uint32(0), settingSourceDefault, prefix)
+	}
+
+	if config.IsDeleteVersionAfterDisabled() {
+		settings["delete_version_after"] = effectiveSetting(time.Duration(0).String(), settingSourceBackend, prefix)
+	} else {
+		dva, source := shortestSetting(deleteVersionAfter(config), mountSettingSource(deleteVersionAfter(pc) > 0), deleteVersionAfter(meta))
+		settings["delete_version_after"] = effectiveSetting(dva.String(), source, prefix)
+	}
+
+	destroy, source := shortestSetting(destroyVersionAfter(config), mountSettingSource(destroyVersionAfter(pc) > 0), destroyVersionAfter(meta))
+	settings["destroy_version_after"] = effectiveSetting(destroy.String(), source, prefix)
+
+	age, source := shortestSetting(getMaxVersionAge(config), mountSettingSource(getMaxVersionAge(pc) > 0), getMaxVersionAge(meta))
+	settings["max_version_age"] = effectiveSetting(age.String(), source, prefix)
+
+	historyPrefix, historyBytes := prefixHistoryBytes(config, meta.Key)
+	switch {
+	case meta.MaxHistoryBytes > 0:
+		settings["max_history_bytes"] = effectiveSetting(meta.MaxHistoryBytes, settingSourceKey, historyPrefix)
+	case historyBytes > 0:
+		settings["max_history_bytes"] = effectiveSetting(historyBytes, settingSourcePrefix, historyPrefix)
+	default:
+		settings["max_history_bytes"] = effectiveSetting(uint64(0), settingSourceDefault, historyPrefix)
+	}
+
+	return settings
+}
+
+// pathMetadataEffectiveRead returns the settings applying to a key once the
+// settings of the backend, of the matching prefix and of the key are combined.
+func (b *versionedKVBackend) pathMetadataEffectiveRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+
+		config, err := b.keyConfig(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, nil
+		}
+
+		return &logical.Response{
+			Data: effectiveSettings(config, meta),
+		}, nil
+	}
+}
+
+const metadataEffectiveHelpSyn = `Reports the resolved settings of a key.`
+const metadataEffectiveHelpDesc = `
+Settings such as max_versions, cas_required or delete_version_after can be set
+on the backend, on a prefix with "config/path" and on the key itself. This
+endpoint returns, for each setting, the value applied to the key and its
+source: "backend", "prefix" (along with the matching prefix), "key" or
+"default" when nothing sets it.
+
+Because this path takes precedence over "metadata/", the metadata of a key
+whose name ends with "/effective" cannot be managed through it.
+`
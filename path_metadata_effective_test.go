@@ -0,0 +1,123 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Metadata_Effective(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"max_versions":         5,
+				"delete_version_after": "24h",
+				"max_history_bytes": map[string]interface{}{
+					"team/": "4096",
+				},
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config/path/team/",
+			Data: map[string]interface{}{
+				"cas_required":    true,
+				"max_version_age": "48h",
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/team/foo",
+			Data: map[string]interface{}{
+				"max_versions":         8,
+				"delete_version_after": "1h",
+			},
+		},
+	} {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/team/foo/effective",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected := map[string]interface{}{
+		"max_versions":                   map[string]interface{}{"value": uint32(8), "source": "key"},
+		"cas_required":                   map[string]interface{}{"value": true, "source": "prefix", "prefix": "team/"},
+		"min_versions":                   map[string]interface{}{"value": uint32(0), "source": "default"},
+		"delete_metadata_when_destroyed": map[string]interface{}{"value": false, "source": "default"},
+		"delete_version_after":           map[string]interface{}{"value": "1h0m0s", "source": "key"},
+		"destroy_version_after":          map[string]interface{}{"value": "0s", "source": "default"},
+		"max_version_age":                map[string]interface{}{"value": "48h0m0s", "source": "prefix", "prefix": "team/"},
+		"max_history_bytes":              map[string]interface{}{"value": uint64(4096), "source": "prefix", "prefix": "team/"},
+	}
+	if diff := deep.Equal(resp.Data, expected); len(diff) > 0 {
+		t.Fatal(diff)
+	}
+
+	// The metadata of the key itself is still available.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/team/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["max_versions"] != uint32(8) {
+		t.Fatalf("unexpected max_versions: %v", resp.Data["max_versions"])
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/bar/effective",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("expected no response for a missing key, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestEffectiveSettings(t *testing.T) {
+	config := &Configuration{
+		MaxVersions:      20,
+		MaxVersionsLimit: 15,
+		MinVersions:      2,
+	}
+	meta := &KeyMetadata{
+		Key:         "foo",
+		MinVersions: 1,
+	}
+
+	settings := effectiveSettings(config, meta)
+	if diff := deep.Equal(settings["max_versions"], map[string]interface{}{"value": uint32(15), "source": "backend"}); len(diff) > 0 {
+		t.Fatal(diff)
+	}
+	if diff := deep.Equal(settings["min_versions"], map[string]interface{}{"value": uint32(2), "source": "backend"}); len(diff) > 0 {
+		t.Fatal(diff)
+	}
+
+	config.DisableDeleteVersionAfter()
+	settings = effectiveSettings(config, meta)
+	if diff := deep.Equal(settings["delete_version_after"], map[string]interface{}{"value": "0s", "source": "backend"}); len(diff) > 0 {
+		t.Fatal(diff)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/go-test/deep"
 	"github.com/hashicorp/vault/sdk/logical"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -747,3 +749,622 @@ func TestVersionedKV_Metadata_Put_Merge_Behavior(t *testing.T) {
 		t.Fatal(diff)
 	}
 }
+
+func TestVersionedKV_Metadata_Delete_Interrupted(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Simulate a deletion that was marked but not purged
+	meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Deleting = true
+	if err := b.(*versionedKVBackend).writeKeyMetadata(context.Background(), storage, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []*logical.Request{
+		req,
+		{Operation: logical.PatchOperation, Path: "data/foo", Storage: storage, Data: map[string]interface{}{"data": map[string]interface{}{"bar": "qux"}}},
+		{Operation: logical.UpdateOperation, Path: "metadata/foo", Storage: storage, Data: map[string]interface{}{"max_versions": 2}},
+		{Operation: logical.UpdateOperation, Path: "undelete/foo", Storage: storage, Data: map[string]interface{}{"versions": "1"}},
+	} {
+		resp, err = b.HandleRequest(context.Background(), r)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("%s %s: expected error, err:%s resp:%#v\n", r.Operation, r.Path, err, resp)
+		}
+		if resp.Error().Error() != errKeyDeleting.Error() {
+			t.Fatalf("%s %s: unexpected error: %s", r.Operation, r.Path, resp.Error())
+		}
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	})
+	if err != nil || resp != nil {
+		t.Fatalf("expected no data, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["deleting"] != true {
+		t.Fatalf("expected the key to be marked as deleting, resp: %#v", resp)
+	}
+
+	// Deleting again resumes the deletion, after which the key can be
+	// written from scratch
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["version"] != uint64(1) {
+		t.Fatalf("expected version 1, resp: %#v", resp)
+	}
+}
+
+func TestVersionedKV_Metadata_DefaultCustomMetadata(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"default_custom_metadata": map[string]interface{}{
+				"owner":       "platform",
+				"cost-center": "42",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Keys created by a data write get the defaults
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// and the custom_metadata provided on creation overrides them
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/bar",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{
+				"owner": "security",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected := map[string]map[string]string{
+		"foo": {"owner": "platform", "cost-center": "42"},
+		"bar": {"owner": "security", "cost-center": "42"},
+	}
+	for key, customMetadata := range expected {
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if diff := deep.Equal(resp.Data["custom_metadata"], customMetadata); diff != nil {
+			t.Fatalf("%s: %v", key, diff)
+		}
+	}
+
+	// Existing keys are not modified
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{
+				"team": "kv",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["custom_metadata"], map[string]string{"team": "kv"}); diff != nil {
+		t.Fatal(diff)
+	}
+}
+
+func TestVersionedKV_Metadata_Put_CustomMetadataPatch(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{
+				"owner": "platform",
+				"tier":  "1",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata_patch": map[string]interface{}{
+				"tier":  nil,
+				"team":  "kv",
+				"owner": "security",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["custom_metadata"], map[string]string{"owner": "security", "team": "kv"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	for _, data := range []map[string]interface{}{
+		{
+			"custom_metadata":       map[string]interface{}{"owner": "platform"},
+			"custom_metadata_patch": map[string]interface{}{"team": "kv"},
+		},
+		{
+			"custom_metadata_patch": map[string]interface{}{"team": 42},
+		},
+		{
+			"custom_metadata_patch": map[string]interface{}{"team": ""},
+		},
+	} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "metadata/foo",
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error for %v, err:%s resp:%#v\n", data, err, resp)
+		}
+	}
+}
+
+func TestVersionedKV_Metadata_Put_CustomMetadataLimits(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata_max_keys":         2,
+			"custom_metadata_max_value_length": 4,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	for field, expected := range map[string]int{
+		"custom_metadata_max_keys":         2,
+		"custom_metadata_max_key_length":   maxCustomMetadataKeyLength,
+		"custom_metadata_max_value_length": 4,
+	} {
+		if resp.Data[field] != expected {
+			t.Fatalf("expected %s to be %d, got %v", field, expected, resp.Data[field])
+		}
+	}
+
+	for _, tc := range []struct {
+		customMetadata map[string]interface{}
+		valid          bool
+	}{
+		{map[string]interface{}{"a": "1", "b": "2"}, true},
+		{map[string]interface{}{"a": "1", "b": "2", "c": "3"}, false},
+		{map[string]interface{}{"a": "12345"}, false},
+	} {
+		req = &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"custom_metadata": tc.customMetadata,
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isError := resp != nil && resp.IsError(); isError == tc.valid {
+			t.Fatalf("unexpected result for %v: %#v", tc.customMetadata, resp)
+		}
+	}
+
+	// The default_custom_metadata must satisfy the limits
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"default_custom_metadata": map[string]interface{}{
+				"owner": "platform",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata_max_keys": -1,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Metadata_Put_CustomMetadataRules(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata_required_keys": "owner",
+			"custom_metadata_allowed_keys":  "owner,team/*",
+			"custom_metadata_value_patterns": map[string]interface{}{
+				"owner": `[^@]+@example\.com`,
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for _, tc := range []struct {
+		customMetadata map[string]interface{}
+		valid          bool
+	}{
+		{map[string]interface{}{"team/name": "kv"}, false},
+		{map[string]interface{}{"owner": "alice"}, false},
+		{map[string]interface{}{"owner": "alice@example.com", "tier": "1"}, false},
+		{map[string]interface{}{"owner": "alice@example.com", "team/name": "kv"}, true},
+	} {
+		req = &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"custom_metadata": tc.customMetadata,
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isError := resp != nil && resp.IsError(); isError == tc.valid {
+			t.Fatalf("unexpected result for %v: %#v", tc.customMetadata, resp)
+		}
+	}
+
+	// Required keys are only enforced when the secret is created
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{
+				"team/name": "kv",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Secrets created by writing data must get the required keys from the
+	// defaults
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/bar",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"foo": "bar",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata_value_patterns": map[string]interface{}{
+				"owner": "(",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Metadata_List_Recurse(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, key := range []string{"apps/foo", "apps/a/bar", "apps/a/b/baz", "other/qux"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"custom_metadata": map[string]interface{}{
+					"name": key,
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, tc := range []struct {
+		data     map[string]interface{}
+		expected []string
+	}{
+		{
+			map[string]interface{}{"recurse": true},
+			[]string{"apps/a/b/baz", "apps/a/bar", "apps/foo"},
+		},
+		{
+			map[string]interface{}{"recurse": true, "depth": 2},
+			[]string{"apps/a/b/", "apps/a/bar", "apps/foo"},
+		},
+		{
+			map[string]interface{}{"recurse": true, "filter": []interface{}{"name=apps/a/bar"}},
+			[]string{"apps/a/bar"},
+		},
+	} {
+		req := &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "metadata/apps/",
+			Storage:   storage,
+			Data:      tc.data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		keys := resp.Data["keys"].([]string)
+		sort.Strings(keys)
+		if diff := deep.Equal(keys, tc.expected); diff != nil {
+			t.Fatalf("%v: %v", tc.data, diff)
+		}
+	}
+}
+
+// concurrencyStorage records the largest number of concurrent deletes.
+type concurrencyStorage struct {
+	logical.Storage
+
+	l          sync.Mutex
+	inflight   int
+	concurrent int
+}
+
+func (s *concurrencyStorage) Delete(ctx context.Context, key string) error {
+	s.l.Lock()
+	s.inflight++
+	if s.inflight > s.concurrent {
+		s.concurrent = s.inflight
+	}
+	s.l.Unlock()
+
+	time.Sleep(time.Millisecond)
+	defer func() {
+		s.l.Lock()
+		s.inflight--
+		s.l.Unlock()
+	}()
+
+	return s.Storage.Delete(ctx, key)
+}
+
+func TestVersionedKV_DeleteVersions(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	ctx := context.Background()
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_versions": 50,
+		},
+	}
+	resp, err := b.HandleRequest(ctx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	ids := make([]uint64, 0, 50)
+	for i := 1; i <= 50; i++ {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": i,
+				},
+			},
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		ids = append(ids, uint64(i))
+	}
+
+	s := &concurrencyStorage{Storage: storage}
+	if err := kv.deleteVersions(ctx, s, "foo", ids); err != nil {
+		t.Fatal(err)
+	}
+	if s.concurrent < 2 || s.concurrent > versionDeleteWorkers {
+		t.Fatalf("unexpected number of concurrent deletes: %d", s.concurrent)
+	}
+
+	for _, id := range ids {
+		versionKey, err := kv.getVersionKey(ctx, "foo", id, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw, err := storage.Get(ctx, versionKey); err != nil || raw != nil {
+			t.Fatalf("expected version %d to be deleted, err:%s", id, err)
+		}
+	}
+}
+
+func TestVersionedKV_Metadata_Get_VersionSize(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": strings.Repeat("a", 1024),
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	size := resp.Data["versions"].(map[string]interface{})["1"].(map[string]interface{})["size"].(uint64)
+	if size < 1024 {
+		t.Fatalf("expected the size of the stored version to be at least 1024, got %d", size)
+	}
+}
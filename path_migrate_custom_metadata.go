@@ -0,0 +1,298 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathMigrateCustomMetadata returns the path configuration for the
+// migrate-custom-metadata endpoint
+func pathMigrateCustomMetadata(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "migrate-custom-metadata/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the secrets to migrate.",
+			},
+			"key_prefixes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The prefixes marking the data fields holding metadata. The prefix is removed from the custom_metadata key.",
+				Default:     []string{"__", "_"},
+			},
+			"remove_from_data": {
+				Type:        framework.TypeBool,
+				Description: "If true, the promoted fields are removed from the data by writing a new version.",
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Description: "If true, the secrets that would be migrated are returned and nothing is written.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathMigrateCustomMetadataWrite()),
+		},
+
+		HelpSynopsis:    migrateCustomMetadataHelpSyn,
+		HelpDescription: migrateCustomMetadataHelpDesc,
+	}
+}
+
+// pathMigrateCustomMetadataWrite promotes the pseudo-metadata fields of the
+// current version of every secret under a prefix to custom_metadata. Unless
+// dry_run is set the secrets are migrated by a background job whose ID is
+// returned.
+func (b *versionedKVBackend) pathMigrateCustomMetadataWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("path").(string)
+		keyPrefixes := data.Get("key_prefixes").([]string)
+		remove := data.Get("remove_from_data").(bool)
+		dryRun := data.Get("dry_run").(bool)
+
+		for _, p := range keyPrefixes {
+			if p == "" {
+				return logical.ErrorResponse("key_prefixes cannot contain an empty prefix"), logical.ErrInvalidRequest
+			}
+		}
+		if len(keyPrefixes) == 0 {
+			return logical.ErrorResponse("missing key_prefixes"), logical.ErrInvalidRequest
+		}
+
+		// Match the longest prefix first
+		sort.Slice(keyPrefixes, func(i, j int) bool {
+			return len(keyPrefixes[i]) > len(keyPrefixes[j])
+		})
+
+		keys, err := b.collectKeys(ctx, req.Storage, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		if dryRun {
+			updates := []string{}
+			var failed []string
+			for _, key := range keys {
+				updated, err := b.promoteCustomMetadata(ctx, req, key, keyPrefixes, remove, true)
+				switch {
+				case err != nil:
+					failed = append(failed, key+": "+err.Error())
+				case updated:
+					updates = append(updates, key)
+				}
+			}
+
+			resp := &logical.Response{
+				Data: map[string]interface{}{
+					"total": len(keys),
+					"keys":  updates,
+				},
+			}
+			for _, f := range failed {
+				resp.AddWarning(f)
+			}
+
+			return resp, nil
+		}
+
+		job, err := b.startJob(ctx, req.Storage, "migrate-custom-metadata", prefix, keys, func(ctx context.Context, key string) (bool, error) {
+			return b.promoteCustomMetadata(ctx, req, key, keyPrefixes, remove, false)
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: jobResponseData(job),
+		}, nil
+	}
+}
+
+// promoteCustomMetadata copies the fields of the current version of key whose
+// name starts with one of keyPrefixes to its custom_metadata, without the
+// prefix. Existing custom_metadata entries take precedence over the promoted
+// fields. If remove is true the promoted fields are removed from the data by
+// writing a new version. If dryRun is true nothing is written. It returns
+// true if the key is, or would be, updated.
+func (b *versionedKVBackend) promoteCustomMetadata(ctx context.Context, req *logical.Request, key string, keyPrefixes []string, remove, dryRun bool) (bool, error) {
+	s := req.Storage
+
+	config, err := b.keyConfig(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+
+	if err := checkPathAllowed(config, key); err != nil {
+		return false, err
+	}
+	if err := checkReservedPrefix(config, key); err != nil {
+		return false, err
+	}
+
+	namespace, err := b.enrichmentNamespace(ctx, s)
+	if err != nil {
+		return false, err
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+	if meta == nil {
+		return false, nil
+	}
+
+	// References have no data of their own
+	vm := meta.Versions[meta.CurrentVersion]
+	if vm == nil || vm.Destroyed || vm.Reference != "" {
+		return false, nil
+	}
+	if deleted, err := isDeleted(vm); err != nil || deleted {
+		return false, err
+	}
+
+	versionData, err := b.readVersionData(ctx, s, key, meta.CurrentVersion)
+	if err != nil {
+		return false, err
+	}
+
+	customMetadata := make(map[string]string, len(meta.CustomMetadata))
+	for k, v := range meta.CustomMetadata {
+		customMetadata[k] = v
+	}
+
+	var promoted []string
+	for field, value := range versionData {
+		name := trimKeyPrefix(field, keyPrefixes)
+		if name == "" {
+			continue
+		}
+		if namespace != "" && strings.HasPrefix(name, namespace) {
+			continue
+		}
+		if _, ok := customMetadata[name]; ok {
+			continue
+		}
+
+		customMetadataValue, err := customMetadataValue(value)
+		if err != nil {
+			return false, err
+		}
+
+		customMetadata[name] = customMetadataValue
+		promoted = append(promoted, field)
+	}
+
+	if len(promoted) == 0 {
+		return false, nil
+	}
+
+	if err := validateCustomMetadata(config, customMetadata); err != nil {
+		return false, err
+	}
+
+	var marshaledData []byte
+	if remove {
+		for _, field := range promoted {
+			delete(versionData, field)
+		}
+
+		marshaledData, err = json.Marshal(versionData)
+		if err != nil {
+			return false, err
+		}
+
+		if err := validateVersionData(config, meta, marshaledData); err != nil {
+			return false, err
+		}
+	}
+
+	if dryRun {
+		return true, nil
+	}
+
+	meta.CustomMetadata = customMetadata
+
+	if !remove {
+		return true, b.writeKeyMetadata(ctx, s, meta)
+	}
+
+	_, warning, err := b.writeVersion(ctx, s, config, meta, marshaledData, versionOptions{})
+	if err != nil {
+		return false, err
+	}
+	b.sendEvent(ctx, req, eventDataWrite, key, []uint64{meta.CurrentVersion})
+	if warning != "" {
+		b.Logger().Warn(warning, "key", key)
+	}
+
+	return true, nil
+}
+
+// trimKeyPrefix returns field without the first of keyPrefixes it starts
+// with, or an empty string if it starts with none of them.
+func trimKeyPrefix(field string, keyPrefixes []string) string {
+	for _, p := range keyPrefixes {
+		if strings.HasPrefix(field, p) {
+			return strings.TrimPrefix(field, p)
+		}
+	}
+
+	return ""
+}
+
+// customMetadataValue converts a data value to a custom_metadata value.
+// Strings are kept as is, lists of strings are joined with commas and other
+// values are JSON encoded.
+func customMetadataValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				break
+			}
+			values = append(values, s)
+		}
+		if len(values) == len(v) {
+			return strings.Join(values, ","), nil
+		}
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
+
+const migrateCustomMetadataHelpSyn = `Promotes pseudo-metadata data fields to custom_metadata.`
+const migrateCustomMetadataHelpDesc = `
+Before custom_metadata existed, metadata about a secret was often stored in its
+data using conventional field names such as "__owner" or "_tags". This endpoint
+copies the fields of the current version of every secret under the provided
+prefix whose name starts with one of "key_prefixes" to the custom_metadata of
+the secret, without the prefix. Existing custom_metadata entries are never
+overwritten. Strings are copied as is, lists of strings are joined with commas
+and other values are JSON encoded.
+
+If "remove_from_data" is set, the promoted fields are removed from the data by
+writing a new version.
+
+If "dry_run" is set, the secrets that would be migrated are returned and
+nothing is written. Otherwise the secrets are migrated by a background job
+whose progress can be read from the jobs/ endpoint using the returned ID.
+`
@@ -0,0 +1,123 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCustomMetadataValue(t *testing.T) {
+	tests := map[string]struct {
+		value interface{}
+		want  string
+	}{
+		"string":      {"team-a", "team-a"},
+		"string list": {[]interface{}{"a", "b"}, "a,b"},
+		"mixed list":  {[]interface{}{"a", 1.0}, `["a",1]`},
+		"number":      {42.0, "42"},
+		"object":      {map[string]interface{}{"a": "b"}, `{"a":"b"}`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := customMetadataValue(tc.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVersionedKV_MigrateCustomMetadata(t *testing.T) {
+	b, storage := getBackend(t)
+
+	secrets := map[string]map[string]interface{}{
+		"app/a": {"user": "a", "__owner": "team-a", "_tags": []interface{}{"db", "prod"}},
+		"app/b": {"user": "b", "_owner": "team-b"},
+		"app/c": {"user": "c"},
+	}
+	for key, data := range secrets {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": data,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	// Explicit custom_metadata takes precedence over the promoted fields
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/app/b",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{
+				"owner": "explicit",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "migrate-custom-metadata/app",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"dry_run": true,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["keys"], []string{"app/a"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	req.Data = map[string]interface{}{
+		"remove_from_data": true,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	job := waitForJob(t, b, storage, resp.Data["id"].(string))
+	if job["total"] != uint64(3) || job["updated"] != uint64(1) || job["failed"] != uint64(0) {
+		t.Fatalf("unexpected job progress: %#v", job)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/app/a",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["data"], map[string]interface{}{"user": "a"}); diff != nil {
+		t.Fatal(diff)
+	}
+	expected := map[string]string{"owner": "team-a", "tags": "db,prod"}
+	if diff := deep.Equal(resp.Data["metadata"].(map[string]interface{})["custom_metadata"], expected); diff != nil {
+		t.Fatal(diff)
+	}
+	if v := resp.Data["metadata"].(map[string]interface{})["version"]; v != uint64(2) {
+		t.Fatalf("expected version 2, got %v", v)
+	}
+}
@@ -0,0 +1,169 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathMigrateV1 returns the path configuration for the migrate-v1 endpoint.
+func pathMigrateV1(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "migrate-v1/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the KV v1 entries to migrate. Every entry is migrated if empty.",
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Description: "If true, the entries that would be migrated are returned and nothing is written.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathMigrateV1Write()),
+		},
+
+		HelpSynopsis:    migrateV1HelpSyn,
+		HelpDescription: migrateV1HelpDesc,
+	}
+}
+
+// pathMigrateV1Write converts the entries stored in the KV v1 layout under a
+// prefix into versioned secrets. Unless dry_run is set the entries are
+// migrated by a background job whose ID is returned.
+func (b *versionedKVBackend) pathMigrateV1Write() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("path").(string)
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		keys, err := b.collectV1Keys(ctx, req.Storage, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		if data.Get("dry_run").(bool) {
+			return &logical.Response{
+				Data: map[string]interface{}{
+					"total": len(keys),
+					"keys":  keys,
+				},
+			}, nil
+		}
+
+		job, err := b.startJob(ctx, req.Storage, "migrate-v1", prefix, keys, func(ctx context.Context, key string) (bool, error) {
+			return b.migrateV1Key(ctx, req, key)
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: jobResponseData(job),
+		}, nil
+	}
+}
+
+// collectV1Keys returns the keys of the entries stored in the KV v1 layout
+// under prefix, that is outside of the storage prefix of the versioned
+// backend.
+func (b *versionedKVBackend) collectV1Keys(ctx context.Context, s logical.Storage, prefix string) ([]string, error) {
+	all, err := logical.CollectKeysWithPrefix(ctx, s, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, key := range all {
+		if key == b.storagePrefix || strings.HasPrefix(key, b.storagePrefix+"/") {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// migrateV1Key writes the value of the KV v1 entry stored at key as the first
+// version of a new versioned secret, then removes the entry. Entries whose key
+// already holds a versioned secret or that would exceed a key quota are left
+// untouched and reported as errors.
+func (b *versionedKVBackend) migrateV1Key(ctx context.Context, req *logical.Request, key string) (bool, error) {
+	s := req.Storage
+
+	config, err := b.keyConfig(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+
+	unlock, err := b.lockKeyForWrite(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	raw, err := s.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if raw == nil {
+		return false, nil
+	}
+
+	// KV v1 stores the data as a JSON object
+	var value map[string]interface{}
+	if err := json.Unmarshal(raw.Value, &value); err != nil {
+		return false, fmt.Errorf("decoding KV v1 entry: %w", err)
+	}
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return false, err
+	}
+	if meta != nil {
+		return false, fmt.Errorf("a versioned secret already exists at this path")
+	}
+
+	release, quotaWarning, err := b.checkKeyQuotas(ctx, s, config, []string{key})
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	if quotaWarning != "" {
+		b.Logger().Warn(quotaWarning, "key", key)
+	}
+
+	meta = &KeyMetadata{
+		Key:            key,
+		Versions:       map[uint64]*VersionMetadata{},
+		CustomMetadata: defaultCustomMetadata(config, key, nil),
+	}
+	applyTemplate(config, meta)
+
+	if _, _, err := b.writeVersion(ctx, s, config, meta, raw.Value, versionOptions{}); err != nil {
+		return false, err
+	}
+	b.sendEvent(ctx, req, eventDataWrite, key, []uint64{meta.CurrentVersion})
+
+	return true, s.Delete(ctx, key)
+}
+
+const migrateV1HelpSyn = `Migrates KV v1 entries to versioned secrets.`
+const migrateV1HelpDesc = `
+Writing to "migrate-v1/<prefix>" converts the entries stored in the KV v1
+layout under the prefix, for instance restored from a KV v1 backup into the
+storage of the mount, into versioned secrets. The value of each entry becomes
+the first version of the secret and the entry is removed. Entries whose path
+already holds a versioned secret, or that would exceed the max_keys of a
+prefix, are left untouched and reported as errors.
+
+The entries are migrated by a background job whose ID is returned and whose
+progress can be read at "jobs/<id>". If "dry_run" is true, the entries that
+would be migrated are returned and nothing is written.
+`
@@ -0,0 +1,93 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_MigrateV1(t *testing.T) {
+	b, storage := getBackend(t)
+
+	// Entries written in the KV v1 layout, at the root of the storage of the
+	// mount
+	for key, value := range map[string]string{
+		"app/foo": `{"bar":"baz"}`,
+		"app/bar": `{"foo":"bar"}`,
+		"other":   `{"foo":"bar"}`,
+		"app/raw": `not json`,
+	} {
+		if err := storage.Put(context.Background(), &logical.StorageEntry{Key: key, Value: []byte(value)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A versioned secret already exists at app/bar
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/app/bar",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{"foo": "versioned"},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "migrate-v1/app",
+		Storage:   storage,
+		Data:      map[string]interface{}{"dry_run": true},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"app/bar", "app/foo", "app/raw"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+
+	req.Data = nil
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	job := waitForJob(t, b, storage, resp.Data["id"].(string))
+	if job["total"] != uint64(3) || job["updated"] != uint64(1) || job["failed"] != uint64(2) {
+		t.Fatalf("unexpected job: %#v", job)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/app/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["data"], map[string]interface{}{"bar": "baz"}) || resp.Data["metadata"].(map[string]interface{})["version"] != uint64(1) {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	// The migrated entry is removed, the others are left untouched
+	for key, exists := range map[string]bool{
+		"app/foo": false,
+		"app/bar": true,
+		"app/raw": true,
+		"other":   true,
+	} {
+		entry, err := storage.Get(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (entry != nil) != exists {
+			t.Fatalf("%s: expected the entry to exist: %t", key, exists)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// matchPathPattern reports whether key matches the provided pattern. Patterns
+// follow the syntax of Vault policies: a "+" segment matches any single path
+// segment and a trailing "*" matches any suffix, possibly spanning several
+// segments.
+func matchPathPattern(pattern, key string) bool {
+	glob := strings.HasSuffix(pattern, "*")
+	pattern = strings.TrimSuffix(pattern, "*")
+
+	patternSegments := strings.Split(pattern, "/")
+	keySegments := strings.Split(key, "/")
+
+	if len(keySegments) < len(patternSegments) {
+		return false
+	}
+	if !glob && len(keySegments) != len(patternSegments) {
+		return false
+	}
+
+	for i, segment := range patternSegments {
+		last := i == len(patternSegments)-1
+		switch {
+		case segment == "+":
+			continue
+		case last && glob:
+			if !strings.HasPrefix(keySegments[i], segment) {
+				return false
+			}
+		case keySegments[i] != segment:
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkPathAllowed returns an error if the allowed_paths and denied_paths
+// patterns of the config prevent writing to key. Denied patterns take
+// precedence over allowed ones and an empty allowed_paths list allows every
+// path.
+func checkPathAllowed(config *Configuration, key string) error {
+	for _, pattern := range config.DeniedPaths {
+		if matchPathPattern(pattern, key) {
+			return fmt.Errorf("writing to %q is denied by the backend configuration", key)
+		}
+	}
+
+	if len(config.AllowedPaths) == 0 {
+		return nil
+	}
+
+	for _, pattern := range config.AllowedPaths {
+		if matchPathPattern(pattern, key) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("writing to %q is not allowed by the backend configuration", key)
+}
+
+// checkReservedPrefix returns an error if key is under one of the prefixes
+// reserved for content managed by the backend.
+func checkReservedPrefix(config *Configuration, key string) error {
+	for _, prefix := range config.ReservedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return fmt.Errorf("%q is under the reserved prefix %q", key, prefix)
+		}
+	}
+
+	return nil
+}
+
+// reservedPrefixCheck wraps the handlers that modify or delete a key so that
+// keys under a reserved prefix cannot be altered by data operations.
+func (b *versionedKVBackend) reservedPrefixCheck(next framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkReservedPrefix(config, data.Get("path").(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
+		return next(ctx, req, data)
+	}
+}
+
+// nonNilStrings returns an empty slice instead of nil so that responses
+// contain an empty list rather than null.
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,170 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMatchPathPattern(t *testing.T) {
+	var tests = []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "foo/bar", false},
+		{"+", "foo", true},
+		{"+", "foo/bar", false},
+		{"teams/*", "teams/a", true},
+		{"teams/*", "teams/a/b", true},
+		{"teams/*", "teams", false},
+		{"teams/*", "other/a", false},
+		{"teams/+/db", "teams/a/db", true},
+		{"teams/+/db", "teams/a/web", false},
+		{"teams/+/db*", "teams/a/db/root", true},
+		{"team*", "teams/a", true},
+		{"*", "anything/at/all", true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("pattern=%s,key=%s", tt.pattern, tt.key), func(t *testing.T) {
+			if got := matchPathPattern(tt.pattern, tt.key); got != tt.want {
+				t.Fatalf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionedKV_Config_AllowedDeniedPaths(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"allowed_paths": "teams/*",
+			"denied_paths":  "teams/+/admin*",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	var tests = []struct {
+		path    string
+		allowed bool
+	}{
+		{"data/root", false},
+		{"data/other/foo", false},
+		{"data/teams/a/admin/foo", false},
+		{"metadata/teams/a/admin", false},
+		{"data/teams/a/foo", true},
+		{"metadata/teams/b/foo", true},
+	}
+	for _, tt := range tests {
+		data := map[string]interface{}{
+			"data": map[string]interface{}{
+				"foo": "bar",
+			},
+		}
+		if strings.HasPrefix(tt.path, "metadata/") {
+			data = map[string]interface{}{
+				"max_versions": 2,
+			}
+		}
+
+		req = &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      tt.path,
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if tt.allowed {
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("%s: err:%s resp:%#v\n", tt.path, err, resp)
+			}
+			continue
+		}
+		if err != logical.ErrPermissionDenied || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected permission denied, err:%s resp:%#v\n", tt.path, err, resp)
+		}
+	}
+}
+
+func TestVersionedKV_Config_ReservedPrefixes(t *testing.T) {
+	b, storage := getBackend(t)
+
+	// Write a secret before the prefix gets reserved
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/system/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"foo": "bar",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"reserved_prefixes": "system/,_templates/",
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	var tests = []struct {
+		operation logical.Operation
+		path      string
+		data      map[string]interface{}
+	}{
+		{logical.CreateOperation, "data/system/foo", map[string]interface{}{"data": map[string]interface{}{"foo": "baz"}}},
+		{logical.CreateOperation, "data/_templates/foo", map[string]interface{}{"data": map[string]interface{}{"foo": "baz"}}},
+		{logical.DeleteOperation, "data/system/foo", nil},
+		{logical.UpdateOperation, "delete/system/foo", map[string]interface{}{"versions": "1"}},
+		{logical.UpdateOperation, "destroy/system/foo", map[string]interface{}{"versions": "1"}},
+		{logical.CreateOperation, "metadata/system/foo", map[string]interface{}{"max_versions": 2}},
+		{logical.DeleteOperation, "metadata/system/foo", nil},
+	}
+	for _, tt := range tests {
+		req = &logical.Request{
+			Operation: tt.operation,
+			Path:      tt.path,
+			Storage:   storage,
+			Data:      tt.data,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != logical.ErrPermissionDenied || resp == nil || !resp.IsError() {
+			t.Fatalf("%s %s: expected permission denied, err:%s resp:%#v\n", tt.operation, tt.path, err, resp)
+		}
+	}
+
+	// Reads are still allowed
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/system/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data"].(map[string]interface{})["foo"] != "bar" {
+		t.Fatalf("unexpected data, resp: %#v", resp)
+	}
+}
@@ -0,0 +1,183 @@
+package kv
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathsPrefixKeys returns the path configuration for creating, reading and
+// rotating the key policies of the top-level prefixes with a key of their
+// own.
+func pathsPrefixKeys(b *versionedKVBackend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "prefix-keys/" + framework.GenericNameRegex("prefix") + "/rotate$",
+			Fields: map[string]*framework.FieldSchema{
+				"prefix": {
+					Type:        framework.TypeString,
+					Description: "Top-level prefix of the secrets protected by the key policy.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.upgradeCheck(b.pathPrefixKeysRotate()),
+			},
+
+			HelpSynopsis:    prefixKeysHelpSyn,
+			HelpDescription: prefixKeysHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "prefix-keys/?" + framework.OptionalParamRegex("prefix"),
+			Fields: map[string]*framework.FieldSchema{
+				"prefix": {
+					Type:        framework.TypeString,
+					Description: "Top-level prefix of the secrets protected by the key policy.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.upgradeCheck(b.pathPrefixKeysWrite()),
+				logical.ReadOperation:   b.upgradeCheck(b.pathPrefixKeysRead()),
+				logical.ListOperation:   b.upgradeCheck(b.pathPrefixKeysList()),
+			},
+
+			HelpSynopsis:    prefixKeysHelpSyn,
+			HelpDescription: prefixKeysHelpDesc,
+		},
+	}
+}
+
+// pathPrefixKeysWrite creates the key policy of a top-level prefix no secret
+// is stored under yet.
+func (b *versionedKVBackend) pathPrefixKeysWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("prefix").(string)
+		if prefix == "" || strings.Contains(prefix, "/") {
+			return logical.ErrorResponse("prefix must be a top-level prefix"), nil
+		}
+
+		layout, err := b.storageLayout(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if layout.DeterministicPaths {
+			return logical.ErrorResponse(errDeterministicPaths.Error()), nil
+		}
+
+		// The backend is unavailable while the key policies change so that
+		// no secret is written under the prefix meanwhile
+		if !atomic.CompareAndSwapUint32(b.rewrapping, rewrapIdle, rewrapPrefix) {
+			return logical.ErrorResponse("rewrap in progress"), nil
+		}
+		defer atomic.StoreUint32(b.rewrapping, rewrapIdle)
+
+		e, err := b.getKeyEncryptor(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := e.prefixes[prefix]; ok {
+			return logical.ErrorResponse("prefix %q already has a key policy", prefix), nil
+		}
+
+		entries, err := e.root.Wrap(req.Storage).List(ctx, prefix+"/")
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			return logical.ErrorResponse("secrets are already stored under %q, a key policy can only be created for an empty prefix", prefix), nil
+		}
+
+		return nil, b.createPrefixKey(ctx, req.Storage, prefix)
+	}
+}
+
+func (b *versionedKVBackend) pathPrefixKeysRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		e, err := b.getKeyEncryptor(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		policy, ok := e.prefixPolicies[data.Get("prefix").(string)]
+		if !ok {
+			return nil, nil
+		}
+
+		return &logical.Response{
+			Data: prefixKeyData(policy),
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathPrefixKeysList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		e, err := b.getKeyEncryptor(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		return logical.ListResponse(e.orderedPrefixes), nil
+	}
+}
+
+// pathPrefixKeysRotate rotates the key policy of a top-level prefix and
+// rewraps the metadata of the secrets under it.
+func (b *versionedKVBackend) pathPrefixKeysRotate() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		if !atomic.CompareAndSwapUint32(b.rewrapping, rewrapIdle, rewrapPrefix) {
+			return logical.ErrorResponse("rewrap in progress"), nil
+		}
+		defer atomic.StoreUint32(b.rewrapping, rewrapIdle)
+
+		prefix := data.Get("prefix").(string)
+		policy, err := b.rotatePrefixKey(ctx, req.Storage, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			return logical.ErrorResponse("prefix %q has no key policy", prefix), nil
+		}
+
+		return &logical.Response{
+			Data: prefixKeyData(policy),
+		}, nil
+	}
+}
+
+// prefixKeyData returns the description of the key policy of a prefix.
+func prefixKeyData(policy *keysutil.Policy) map[string]interface{} {
+	return map[string]interface{}{
+		"latest_version":         policy.LatestVersion,
+		"min_decryption_version": policy.MinDecryptionVersion,
+	}
+}
+
+const prefixKeysHelpSyn = `Manages the key policies of the prefixes with a key of their own.`
+const prefixKeysHelpDesc = `
+The paths the key metadata is stored under are encrypted with the key policy
+of the mount. Writing to "prefix-keys/<prefix>" gives the top-level prefix a
+key policy of its own: the metadata of the secrets under it is then stored
+apart from the rest of the mount and encrypted with that key, so that one
+team's key material can be rotated without rewrapping the whole mount. The key
+policy can only be created while no secret is stored under the prefix. Keys
+named after the prefix itself, rather than stored below it, keep using the key
+policy of the mount.
+
+Writing to "prefix-keys/<prefix>/rotate" rotates the key policy of the prefix,
+moves the metadata of the secrets under it to the paths encrypted with the new
+key and retires the previous versions of the key. The backend is unavailable
+while the metadata is moved. "rewrap" only rotates the key policy of the
+mount and leaves these prefixes untouched.
+
+The custom_metadata encrypted by encrypt_custom_metadata uses the key policy
+of the prefix of the secret. The version data is not encrypted with these key
+policies: it is stored under salted paths and protected by the barrier and
+seal wrapping.
+
+Reading "prefix-keys/<prefix>" returns the latest version of the key and the
+minimum version used for decryption, listing "prefix-keys/" returns the
+prefixes with a key policy of their own.
+`
@@ -0,0 +1,171 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_PrefixKeys(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"encrypt_custom_metadata": true,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "prefix-keys/team-a",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for _, key := range []string{"team-a/foo", "team-a/nested/bar", "other"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"key": key},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata/team-a/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"custom_metadata": map[string]interface{}{"owner": "team-a"},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The metadata of the prefix is not stored with the key policy of the
+	// mount
+	e, err := b.(*versionedKVBackend).getKeyEncryptor(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := e.root.Wrap(storage).Get(context.Background(), "team-a/foo")
+	if err != nil || entry != nil {
+		t.Fatalf("expected no metadata under the mount key, err:%s entry:%#v", err, entry)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "metadata/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"other", "team-a/"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+
+	// A key policy can only be created for an empty prefix
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "prefix-keys/other",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "prefix-keys/team-a",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "prefix-keys/team-a/rotate",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["latest_version"] != 2 || resp.Data["min_decryption_version"] != 2 {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	// The key policy of the mount is left untouched
+	policy, err := b.(*versionedKVBackend).policy(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.LatestVersion != 1 {
+		t.Fatalf("unexpected mount key policy version %d", policy.LatestVersion)
+	}
+
+	for _, key := range []string{"team-a/foo", "team-a/nested/bar", "other"} {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if resp.Data["data"].(map[string]interface{})["key"] != key {
+			t.Fatalf("bad response %#v", resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/team-a/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["custom_metadata"], map[string]string{"owner": "team-a"}) {
+		t.Fatalf("unexpected custom_metadata: %#v", resp.Data["custom_metadata"])
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "prefix-keys/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"other", "team-a"}) {
+		t.Fatalf("unexpected prefixes: %#v", resp.Data["keys"])
+	}
+}
@@ -0,0 +1,110 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathQuotas returns the path configuration for the endpoint reporting the
+// usage of the quotas of the mount and of its prefixes.
+func pathQuotas(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "quotas$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathQuotasRead()),
+		},
+
+		HelpSynopsis:    quotasHelpSyn,
+		HelpDescription: quotasHelpDesc,
+	}
+}
+
+// pathQuotasRead reports the limit and the usage of every quota configured.
+func (b *versionedKVBackend) pathQuotasRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		// The usage is computed by the periodic function
+		var unknown bool
+		usedBytes := func(prefix string) interface{} {
+			used, ok := b.usedBytes(prefix)
+			if !ok {
+				unknown = true
+				return nil
+			}
+			return used
+		}
+
+		var mount map[string]interface{}
+		if config.MaxBytes > 0 {
+			mount = map[string]interface{}{
+				"max_bytes":  config.MaxBytes,
+				"used_bytes": usedBytes(""),
+			}
+		}
+
+		prefixes := make(map[string]interface{})
+		for prefix, pc := range config.PathConfigs {
+			if pc.MaxBytes == 0 && pc.MaxKeys == 0 && pc.MaxKeysWarningThreshold == 0 {
+				continue
+			}
+
+			quota := map[string]interface{}{
+				"max_bytes":                  pc.MaxBytes,
+				"max_keys":                   pc.MaxKeys,
+				"max_keys_warning_threshold": pc.MaxKeysWarningThreshold,
+			}
+			if pc.MaxBytes > 0 {
+				quota["used_bytes"] = usedBytes(prefix)
+			}
+			if pc.MaxKeys > 0 || pc.MaxKeysWarningThreshold > 0 {
+				keys, err := b.countKeys(ctx, req.Storage, prefix)
+				if err != nil {
+					return nil, err
+				}
+				quota["keys"] = keys
+			}
+			prefixes[prefix] = quota
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"mount":    mount,
+				"prefixes": prefixes,
+			},
+		}
+		if unknown {
+			resp.AddWarning("The bytes used under some quotas have not been computed yet, their max_bytes is enforced once they are")
+		}
+
+		return resp, nil
+	}
+}
+
+const quotasHelpSyn = `Reports the usage of the quotas of the KV store.`
+const quotasHelpDesc = `
+This endpoint reports the quotas configured on the mount and on its prefixes
+along with their usage.
+
+"mount" holds the max_bytes of the mount and the bytes used by the versions
+stored in it, or is null if the mount has no max_bytes.
+
+"prefixes" maps each prefix with a quota configured in config/path to its
+max_bytes, max_keys and max_keys_warning_threshold, along with the bytes used
+by the versions stored under the prefix in "used_bytes" if it has a max_bytes
+and the number of keys under the prefix in "keys" if it has a key quota.
+
+The bytes used are the sizes of the versions that are not destroyed, as
+recorded when they were written. They are computed from the metadata of the
+secrets by the periodic maintenance of the active node, shortly after the
+mount is loaded or the max_bytes is set, and then kept up to date by the node
+serving the writes. The sizes of the versions written before they were
+recorded are read from storage and saved during this computation. Until the
+usage of a quota is computed, its "used_bytes" is null and its max_bytes is not
+enforced.
+`
@@ -0,0 +1,213 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathReadonlyMirror returns the path configuration for reading the secrets
+// of a prefix through one of the read-only mirrors of the configuration.
+func pathReadonlyMirror(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "readonly-mirror/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the secret in the mirror.",
+			},
+			"version": {
+				Type:        framework.TypeInt,
+				Description: "If provided during a read, the value at the version number will be returned",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathReadonlyMirrorRead()),
+			logical.ListOperation: b.upgradeCheck(b.pathReadonlyMirrorList()),
+		},
+
+		HelpSynopsis:    readonlyMirrorHelpSyn,
+		HelpDescription: readonlyMirrorHelpDesc,
+	}
+}
+
+// mirrorSource returns the key of the secret that a path of the read-only
+// mirrors points to, along with the fields exposed by the mirror. The mirror
+// with the longest matching prefix is used. found is false if the path is not
+// part of any mirror.
+func mirrorSource(config *Configuration, p string) (key string, fields []string, found bool) {
+	var mirror string
+	for prefix := range config.ReadonlyMirrors {
+		if strings.HasPrefix(p, prefix) && (!found || len(prefix) > len(mirror)) {
+			mirror, found = prefix, true
+		}
+	}
+	if !found {
+		return "", nil, false
+	}
+
+	if raw := config.ReadonlyMirrorFields[mirror]; raw != "" {
+		fields = strutil.ParseStringSlice(raw, ",")
+	}
+
+	return config.ReadonlyMirrors[mirror] + strings.TrimPrefix(p, mirror), fields, true
+}
+
+// validateReadonlyMirrors returns an error if fields are set for a mirror
+// that is not configured.
+func validateReadonlyMirrors(config *Configuration) error {
+	for mirror := range config.ReadonlyMirrorFields {
+		if _, ok := config.ReadonlyMirrors[mirror]; !ok {
+			return fmt.Errorf("readonly_mirror_fields set for the unknown mirror %q", mirror)
+		}
+	}
+	return nil
+}
+
+// pathReadonlyMirrorRead returns the data of the source secret, restricted to
+// the fields exposed by the mirror. The custom_metadata of the secret is not
+// returned.
+func (b *versionedKVBackend) pathReadonlyMirrorRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		key, fields, found := mirrorSource(config, data.Get("path").(string))
+		if !found {
+			return nil, nil
+		}
+
+		// The reads through a mirror share the limit of the source
+		if err := b.checkRateLimit(config, key, false); err != nil {
+			return nil, err
+		}
+
+		lock := locksutil.LockForKey(b.locks, key)
+		lock.RLock()
+		defer lock.RUnlock()
+
+		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil || meta.Deleting {
+			return nil, nil
+		}
+
+		verNum := meta.CurrentVersion
+		if verParam := data.Get("version").(int); verParam > 0 {
+			verNum = uint64(verParam)
+		}
+
+		vm := meta.Versions[verNum]
+		if vm == nil {
+			return nil, nil
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"data": nil,
+				"metadata": map[string]interface{}{
+					"version":       verNum,
+					"created_time":  ptypesTimestampToString(vm.CreatedTime),
+					"deletion_time": ptypesTimestampToString(vm.DeletionTime),
+					"destroyed":     vm.Destroyed,
+				},
+			},
+		}
+
+		expired, err := isExpired(meta)
+		if err != nil {
+			return nil, err
+		}
+		if expired || vm.Destroyed {
+			return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
+		}
+		if vm.DeletionTime != nil {
+			deletionTime, err := ptypes.Timestamp(vm.DeletionTime)
+			if err != nil {
+				return nil, err
+			}
+			switch now := time.Now(); {
+			case deletionTime.After(now):
+				resp.Data["metadata"].(map[string]interface{})["expires_in"] = expiresIn(deletionTime, now)
+			case !vm.DeletionDeferred:
+				return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
+			}
+		}
+
+		// The mirror does not bypass the response wrapping required by
+		// the source secret
+		if err := checkResponseWrapping(meta, requestWrapTTL(req)); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		vData, err := b.readVersionData(ctx, req.Storage, key, verNum)
+		if err != nil {
+			return nil, err
+		}
+
+		if fields != nil {
+			filtered := make(map[string]interface{}, len(fields))
+			for _, field := range fields {
+				if value, ok := vData[field]; ok {
+					filtered[field] = value
+				}
+			}
+			vData = filtered
+		}
+
+		resp.Data["data"] = vData
+
+		return resp, nil
+	}
+}
+
+// pathReadonlyMirrorList lists the secrets of the source prefix of a mirror.
+func (b *versionedKVBackend) pathReadonlyMirrorList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		key, _, found := mirrorSource(config, data.Get("path").(string))
+		if !found {
+			return logical.ListResponse(nil), nil
+		}
+
+		wrapper, err := b.getKeyEncryptor(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		es := wrapper.Wrap(req.Storage)
+
+		keys, err := es.List(ctx, key)
+		return logical.ListResponse(keys), err
+	}
+}
+
+const readonlyMirrorHelpSyn = `Reads secrets through a read-only mirror of another prefix.`
+const readonlyMirrorHelpDesc = `
+The "readonly_mirrors" configuration maps mirror prefixes to source prefixes.
+Reading "readonly-mirror/<mirror><path>" returns the secret at
+"<source><path>", and listing a mirror path lists the source prefix. The
+mirror with the longest matching prefix is used.
+
+Mirrors can only be read and listed, so broad read access can be granted on
+them while the source keeps tight write and metadata permissions. The
+custom_metadata of the secrets is not returned and, if
+"readonly_mirror_fields" is set for the mirror, only the listed data fields
+are.
+`
@@ -0,0 +1,138 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMirrorSource(t *testing.T) {
+	config := &Configuration{
+		ReadonlyMirrors: map[string]string{
+			"public/":     "team/prod/",
+			"public/db/":  "team/db/",
+			"everything/": "",
+		},
+		ReadonlyMirrorFields: map[string]string{
+			"public/db/": "host,port",
+		},
+	}
+
+	tests := map[string]struct {
+		key    string
+		fields []string
+		found  bool
+	}{
+		"public/foo":     {"team/prod/foo", nil, true},
+		"public/db/main": {"team/db/main", []string{"host", "port"}, true},
+		"everything/foo": {"foo", nil, true},
+		"private/foo":    {"", nil, false},
+	}
+
+	for p, tc := range tests {
+		key, fields, found := mirrorSource(config, p)
+		if diff := deep.Equal([]interface{}{key, fields, found}, []interface{}{tc.key, tc.fields, tc.found}); diff != nil {
+			t.Fatalf("%s: %v", p, diff)
+		}
+	}
+}
+
+func TestVersionedKV_ReadonlyMirror(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"readonly_mirror_fields": map[string]interface{}{
+				"public/": "host",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data["readonly_mirrors"] = map[string]interface{}{
+		"public/": "team/prod/",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/team/prod/db",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"host":     "db.example.com",
+				"password": "secret",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "readonly-mirror/public/db",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["data"], map[string]interface{}{"host": "db.example.com"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "readonly-mirror/public/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["keys"], []string{"db"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// Paths outside of the mirrors are not found
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "readonly-mirror/team/prod/db",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// and the mirror cannot be written to
+	for _, op := range []logical.Operation{logical.CreateOperation, logical.UpdateOperation, logical.DeleteOperation} {
+		req = &logical.Request{
+			Operation: op,
+			Path:      "readonly-mirror/public/db",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"host": "evil.example.com",
+				},
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err == nil {
+			t.Fatalf("%s: expected an error, got resp:%#v\n", op, resp)
+		}
+	}
+}
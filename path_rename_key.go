@@ -0,0 +1,184 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathRenameKey returns the path configuration for the rename-key endpoint
+func pathRenameKey(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "rename-key/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the secret.",
+			},
+			"from": {
+				Type:        framework.TypeString,
+				Description: "The name of the field to rename in the current version's data.",
+			},
+			"to": {
+				Type:        framework.TypeString,
+				Description: "The new name of the field. It must not already exist in the current version's data.",
+			},
+			"options": {
+				Type: framework.TypeMap,
+				Description: `Options for renaming the field.
+
+Set the "cas" value to use a Check-And-Set operation. If set, the rename will
+only be allowed if the key’s current version matches the version specified in
+the cas parameter.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.rateLimit(true, b.reservedPrefixCheck(b.pathRenameKeyWrite()))),
+		},
+
+		HelpSynopsis:    renameKeyHelpSyn,
+		HelpDescription: renameKeyHelpDesc,
+	}
+}
+
+// pathRenameKeyWrite renames a field in the data of the current version of a
+// key and stores the result as a new version.
+func (b *versionedKVBackend) pathRenameKeyWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+		from := data.Get("from").(string)
+		to := data.Get("to").(string)
+
+		if from == "" || to == "" {
+			return logical.ErrorResponse("both \"from\" and \"to\" must be provided"), logical.ErrInvalidRequest
+		}
+		if from == to {
+			return logical.ErrorResponse("\"from\" and \"to\" must be different"), logical.ErrInvalidRequest
+		}
+
+		config, err := b.keyConfig(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkPathAllowed(config, key); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+
+		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		// Only existing keys are renamed, so the key quotas cannot be
+		// exceeded
+		if meta == nil {
+			return nil, nil
+		}
+		if meta.Deleting {
+			return logical.ErrorResponse(errKeyDeleting.Error()), logical.ErrInvalidRequest
+		}
+
+		err = validateCheckAndSetOption(data, config, meta)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		vm := meta.Versions[meta.CurrentVersion]
+		if vm == nil {
+			return nil, nil
+		}
+
+		// Like the patch handler, respond with the version metadata and a
+		// 404 when the current version has either been deleted or destroyed
+		notFoundResp := &logical.Response{
+			Data: map[string]interface{}{
+				"version":         meta.CurrentVersion,
+				"created_time":    ptypesTimestampToString(vm.CreatedTime),
+				"deletion_time":   ptypesTimestampToString(vm.DeletionTime),
+				"destroyed":       vm.Destroyed,
+				"custom_metadata": meta.CustomMetadata,
+			},
+		}
+
+		if deleted, err := isDeleted(vm); err != nil {
+			return nil, err
+		} else if deleted {
+			return logical.RespondWithStatusCode(notFoundResp, req, http.StatusNotFound)
+		}
+
+		if vm.Destroyed {
+			return logical.RespondWithStatusCode(notFoundResp, req, http.StatusNotFound)
+		}
+
+		versionData, err := b.readVersionData(ctx, req.Storage, key, meta.CurrentVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok := versionData[from]
+		if !ok {
+			return logical.ErrorResponse("field %q does not exist in the current version", from), logical.ErrInvalidRequest
+		}
+		if _, ok := versionData[to]; ok {
+			return logical.ErrorResponse("field %q already exists in the current version", to), logical.ErrInvalidRequest
+		}
+
+		delete(versionData, from)
+		versionData[to] = value
+
+		marshaledData, err := json.Marshal(versionData)
+		if err != nil {
+			return nil, err
+		}
+
+		err = validateVersionData(config, meta, marshaledData)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		newVersionMetadata, warning, err := b.writeVersion(ctx, req.Storage, config, meta, marshaledData, versionOptions{})
+		if errors.Is(err, errByteQuotaExceeded) {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.sendEvent(ctx, req, eventDataWrite, key, []uint64{meta.CurrentVersion})
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"version":         meta.CurrentVersion,
+				"created_time":    ptypesTimestampToString(newVersionMetadata.CreatedTime),
+				"deletion_time":   ptypesTimestampToString(newVersionMetadata.DeletionTime),
+				"destroyed":       newVersionMetadata.Destroyed,
+				"custom_metadata": meta.CustomMetadata,
+			},
+		}
+
+		if warning != "" {
+			// A failed attempt to clean up old versions will be retried on
+			// next write attempt, prefer a warning over an error resp
+			resp.AddWarning(warning)
+		}
+
+		return resp, nil
+	}
+}
+
+const renameKeyHelpSyn = `Renames a field in the data of a secret.`
+const renameKeyHelpDesc = `
+Renames a field in the data of the current version of the secret and stores the
+result as a new version. The value of the field is left untouched. Like a write,
+the operation honors the "cas" option and the check-and-set requirements of the
+secret and of the engine's config.
+`
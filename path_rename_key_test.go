@@ -0,0 +1,117 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_RenameKey(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"user": "admin",
+				"pass": "secret",
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	var tests = []struct {
+		from, to string
+		cas      interface{}
+	}{
+		{"missing", "other", nil},
+		{"user", "pass", nil},
+		{"user", "user", nil},
+		{"user", "username", 2},
+	}
+	for _, tt := range tests {
+		data := map[string]interface{}{
+			"from": tt.from,
+			"to":   tt.to,
+		}
+		if tt.cas != nil {
+			data["options"] = map[string]interface{}{
+				"cas": tt.cas,
+			}
+		}
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "rename-key/foo",
+			Storage:   storage,
+			Data:      data,
+		}
+
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+			t.Fatalf("from=%s,to=%s: expected error, err:%s resp:%#v\n", tt.from, tt.to, err, resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rename-key/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"from": "user",
+			"to":   "username",
+			"options": map[string]interface{}{
+				"cas": 1,
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["version"] != uint64(2) {
+		t.Fatalf("expected version 2, resp: %#v", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected := map[string]interface{}{
+		"username": "admin",
+		"pass":     "secret",
+	}
+	if diff := deep.Equal(resp.Data["data"], expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rename-key/bar",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"from": "user",
+			"to":   "username",
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("expected no response for missing secret, err:%s resp:%#v\n", err, resp)
+	}
+}
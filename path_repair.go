@@ -0,0 +1,185 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultRepairScanLimit is the number of versions looked up in storage when
+// rebuilding the metadata of a key if no scan_limit is provided.
+const defaultRepairScanLimit = 1000
+
+// pathRepair returns the path configuration for rebuilding the metadata of a
+// key from its versions.
+func pathRepair(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "repair/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the secret.",
+			},
+			"scan_limit": {
+				Type:        framework.TypeInt,
+				Default:     defaultRepairScanLimit,
+				Description: "The versions from 1 to scan_limit are looked up in storage.",
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Description: "If true, the rebuilt metadata is returned and nothing is written.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.reservedPrefixCheck(b.pathRepairWrite())),
+		},
+
+		HelpSynopsis:    repairHelpSyn,
+		HelpDescription: repairHelpDesc,
+	}
+}
+
+// pathRepairWrite rebuilds the metadata of a key whose metadata is missing or
+// cannot be decoded from the versions found in storage.
+func (b *versionedKVBackend) pathRepairWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+		dryRun := data.Get("dry_run").(bool)
+
+		scanLimit := data.Get("scan_limit").(int)
+		if scanLimit <= 0 {
+			return logical.ErrorResponse("scan_limit must be positive"), nil
+		}
+
+		config, err := b.keyConfig(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkPathAllowed(config, key); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+
+		// Metadata that cannot be decoded returns an error and is rebuilt
+		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+		if err == nil && meta != nil {
+			return logical.ErrorResponse("the metadata of %q is readable and does not need to be repaired", key), nil
+		}
+
+		meta, err = b.rebuildKeyMetadata(ctx, req.Storage, key, uint64(scanLimit))
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return logical.ErrorResponse("no versions of %q were found in storage", key), nil
+		}
+
+		meta.CustomMetadata = defaultCustomMetadata(config, key, nil)
+		applyTemplate(config, meta)
+
+		destroyed := []uint64{}
+		for id := meta.OldestVersion; id <= meta.CurrentVersion; id++ {
+			if meta.Versions[id].Destroyed {
+				destroyed = append(destroyed, id)
+			}
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"current_version": meta.CurrentVersion,
+				"oldest_version":  meta.OldestVersion,
+				"created_time":    ptypesTimestampToString(meta.CreatedTime),
+				"updated_time":    ptypesTimestampToString(meta.UpdatedTime),
+				"destroyed":       destroyed,
+			},
+		}
+		if dryRun {
+			return resp, nil
+		}
+
+		return resp, b.writeKeyMetadata(ctx, req.Storage, meta)
+	}
+}
+
+// rebuildKeyMetadata returns the metadata of a key built from its versions
+// numbered from 1 to scanLimit found in storage, or nil if none is found. The
+// versions missing between the oldest and the current version are marked as
+// destroyed.
+func (b *versionedKVBackend) rebuildKeyMetadata(ctx context.Context, s logical.Storage, key string, scanLimit uint64) (*KeyMetadata, error) {
+	meta := &KeyMetadata{
+		Key:      key,
+		Versions: map[uint64]*VersionMetadata{},
+	}
+
+	for id := uint64(1); id <= scanLimit; id++ {
+		versionKey, err := b.getVersionKey(ctx, key, id, s)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := s.Get(ctx, versionKey)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			continue
+		}
+
+		// A version that cannot be decoded is kept so that its number is
+		// not reused, but is unreadable and thus destroyed
+		vm := &VersionMetadata{Size: uint64(len(raw.Value))}
+		version := &Version{}
+		if err := proto.Unmarshal(raw.Value, version); err != nil {
+			vm.Destroyed = true
+		} else {
+			vm.CreatedTime = version.CreatedTime
+			vm.DeletionTime = version.DeletionTime
+			vm.DataVersion = version.DataVersion
+		}
+		meta.Versions[id] = vm
+
+		if meta.OldestVersion == 0 {
+			meta.OldestVersion = id
+			meta.CreatedTime = vm.CreatedTime
+		}
+		meta.CurrentVersion = id
+		if vm.CreatedTime != nil {
+			meta.UpdatedTime = vm.CreatedTime
+		}
+	}
+
+	if meta.CurrentVersion == 0 {
+		return nil, nil
+	}
+
+	for id := meta.OldestVersion; id <= meta.CurrentVersion; id++ {
+		if _, ok := meta.Versions[id]; !ok {
+			meta.Versions[id] = &VersionMetadata{Destroyed: true}
+		}
+	}
+
+	return meta, nil
+}
+
+const repairHelpSyn = `Rebuilds the metadata of a secret from its versions.`
+const repairHelpDesc = `
+When the metadata of a secret is missing or cannot be decoded, its versions
+can no longer be read. Writing to "repair/<path>" looks up the versions
+numbered from 1 to "scan_limit" in storage and rebuilds the metadata from
+them: the oldest and current versions are the first and last versions found,
+and the creation and deletion times come from the versions. The versions
+missing in between are marked as destroyed.
+
+The settings of the secret, such as max_versions or cas_required, cannot be
+recovered: the secret gets the settings of a new secret, including its
+template and default custom_metadata. Secrets whose metadata is readable are
+not modified. With "dry_run", the rebuilt metadata is returned and nothing is
+written.
+`
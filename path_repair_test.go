@@ -0,0 +1,106 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Repair(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	ctx := context.Background()
+
+	for i := 1; i <= 4; i++ {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"version": i,
+				},
+			},
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	versionKey, err := kv.getVersionKey(ctx, "foo", 2, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, versionKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate the metadata so that it cannot be decoded
+	wrapper, err := kv.getKeyEncryptor(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wrapper.Wrap(storage).Put(ctx, &logical.StorageEntry{Key: "foo", Value: []byte{0xff}}); err != nil {
+		t.Fatal(err)
+	}
+
+	repair := func(path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "repair/" + path,
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil || resp == nil {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	for _, data := range []map[string]interface{}{{"dry_run": true}, nil} {
+		resp := repair("foo", data)
+		if resp.IsError() {
+			t.Fatalf("unexpected error: %#v", resp)
+		}
+		if resp.Data["current_version"] != uint64(4) || resp.Data["oldest_version"] != uint64(1) {
+			t.Fatalf("unexpected versions: %#v", resp.Data)
+		}
+		if diff := deep.Equal(resp.Data["destroyed"], []uint64{2}); diff != nil {
+			t.Fatal(diff)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(ctx, req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["data"], map[string]interface{}{"version": float64(4)}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	meta, err := kv.getKeyMetadata(ctx, storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Versions[2].Destroyed || meta.Versions[3].Destroyed || meta.Versions[3].CreatedTime == nil {
+		t.Fatalf("unexpected versions: %#v", meta.Versions)
+	}
+
+	// Readable metadata and keys without versions are not repaired
+	for _, path := range []string{"foo", "bar"} {
+		if resp := repair(path, nil); !resp.IsError() {
+			t.Fatalf("expected an error for %q, got %#v", path, resp)
+		}
+	}
+}
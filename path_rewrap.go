@@ -0,0 +1,163 @@
+package kv
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathsRewrap returns the path configuration for rotating the key policy
+// protecting the key metadata and following the rewrap of the metadata.
+func pathsRewrap(b *versionedKVBackend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "rewrap$",
+			Fields: map[string]*framework.FieldSchema{
+				"rate_limit": {
+					Type:        framework.TypeInt,
+					Description: "Maximum number of keys rewrapped per second. Zero means no limit.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				// A stopped rewrap must be resumable while the key policy of
+				// a prefix changes so it is not gated by upgradeCheck
+				logical.UpdateOperation: b.pathRewrapWrite(),
+			},
+
+			HelpSynopsis:    rewrapHelpSyn,
+			HelpDescription: rewrapHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "rewrap/status$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathRewrapStatusRead(),
+			},
+
+			HelpSynopsis:    rewrapHelpSyn,
+			HelpDescription: rewrapHelpDesc,
+		},
+	}
+}
+
+// pathRewrapWrite rotates the key policy and starts the rewrap of the key
+// metadata, or resumes the rewrap stopped by an error.
+func (b *versionedKVBackend) pathRewrapWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		if atomic.LoadUint32(b.upgrading) == 1 {
+			return logical.ErrorResponse("Upgrading from non-versioned to versioned data. This backend will be unavailable for a brief period and will resume service shortly."), logical.ErrInvalidRequest
+		}
+		if b.perfSecondaryCheck() {
+			return nil, logical.ErrReadOnly
+		}
+
+		layout, err := b.storageLayout(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if layout.DeterministicPaths {
+			return logical.ErrorResponse(errDeterministicPaths.Error()), nil
+		}
+
+		rateLimit := data.Get("rate_limit").(int)
+		if rateLimit < 0 {
+			return logical.ErrorResponse("rate_limit cannot be negative"), nil
+		}
+
+		if atomic.CompareAndSwapUint32(b.rewrapping, rewrapStopped, rewrapRunning) {
+			rewrapInfo, err := b.getRewrapInfo(ctx, req.Storage)
+			if err != nil || rewrapInfo == nil {
+				atomic.StoreUint32(b.rewrapping, rewrapStopped)
+				return nil, err
+			}
+			if _, ok := data.GetOk("rate_limit"); ok {
+				rewrapInfo.RateLimit = uint64(rateLimit)
+			}
+			rewrapInfo.Errors = nil
+			resp := &logical.Response{
+				Data: rewrapStatusData(rewrapInfo, true),
+			}
+			b.runRewrap(req.Storage, rewrapInfo)
+
+			return resp, nil
+		}
+
+		if !atomic.CompareAndSwapUint32(b.rewrapping, rewrapIdle, rewrapRunning) {
+			return logical.ErrorResponse("rewrap already in progress"), nil
+		}
+
+		rewrapInfo, err := b.startRewrap(ctx, req.Storage, uint64(rateLimit))
+		if err != nil {
+			atomic.StoreUint32(b.rewrapping, rewrapIdle)
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: rewrapStatusData(rewrapInfo, true),
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathRewrapStatusRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		rewrapInfo, err := b.getRewrapInfo(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if rewrapInfo == nil {
+			return nil, nil
+		}
+
+		return &logical.Response{
+			Data: rewrapStatusData(rewrapInfo, atomic.LoadUint32(b.rewrapping) == rewrapRunning),
+		}, nil
+	}
+}
+
+// rewrapStatusData returns the description of the progress of a rewrap
+// returned by the rewrap endpoints.
+func rewrapStatusData(rewrapInfo *RewrapInfo, running bool) map[string]interface{} {
+	errs := rewrapInfo.Errors
+	if errs == nil {
+		errs = []string{}
+	}
+
+	return map[string]interface{}{
+		"running":        running,
+		"done":           rewrapInfo.Done,
+		"started_time":   ptypesTimestampToString(rewrapInfo.StartedTime),
+		"completed_time": ptypesTimestampToString(rewrapInfo.CompletedTime),
+		"from_version":   rewrapInfo.FromVersion,
+		"to_version":     rewrapInfo.ToVersion,
+		"rate_limit":     rewrapInfo.RateLimit,
+		"total":          rewrapInfo.Total,
+		"processed":      rewrapInfo.Processed,
+		"errors":         errs,
+	}
+}
+
+const rewrapHelpSyn = `Rotates the key protecting the key metadata and rewraps the metadata.`
+const rewrapHelpDesc = `
+The paths the key metadata is stored under are encrypted with a key policy
+kept by the backend. Writing to "rewrap" rotates this key policy and moves the
+metadata of every secret to the paths encrypted with the new key in the
+background, so that a compromised key can be retired without exporting and
+re-importing the secrets. Once every secret has been rewrapped, the previous
+versions of the key can no longer be used for decryption.
+
+The version data is not encrypted with this key policy: it is stored under
+salted paths and protected by the barrier and seal wrapping, so it is left
+untouched.
+
+The backend keeps serving requests during the rewrap: the metadata not
+rewrapped yet is found with the previous version of the key, and writing the
+metadata of a secret moves it under the new key. Each secret is locked while
+its metadata is moved. "rate_limit" caps the number of secrets rewrapped per
+second to throttle the load on the storage backend.
+
+"rewrap/status" reports whether the rewrap is running or done, the versions of
+the key, the number of secrets to rewrap and rewrapped so far and the errors
+that stopped it. A rewrap interrupted by a restart resumes where it stopped,
+one stopped by an error resumes when "rewrap" is written again.
+`
@@ -0,0 +1,253 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Rewrap(t *testing.T) {
+	b, storage := getBackend(t)
+
+	keys := []string{"foo", "app/bar", "app/nested/baz"}
+	for _, key := range keys {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"key": key},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rewrap",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"rate_limit": 2,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["from_version"] != int64(1) || resp.Data["to_version"] != int64(2) {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	// The backend is available while the metadata is rewrapped
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data"].(map[string]interface{})["key"] != "foo" {
+		t.Fatalf("bad response %#v", resp)
+	}
+
+	for _, key := range []string{"app/nested/baz", "app/new"} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"key": key},
+			},
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	keys = append(keys, "app/new")
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "metadata/app/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"bar", "nested/", "new"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rewrap",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	for atomic.LoadUint32(b.(*versionedKVBackend).rewrapping) != rewrapIdle {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "rewrap/status",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	// The metadata written during the rewrap may have been moved before the
+	// rewrap collected it
+	if resp.Data["done"] != true || resp.Data["running"] != false || resp.Data["total"] != resp.Data["processed"] {
+		t.Fatalf("unexpected status: %#v", resp.Data)
+	}
+
+	for _, key := range keys {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if resp.Data["data"].(map[string]interface{})["key"] != key {
+			t.Fatalf("bad response %#v", resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "metadata/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"app/", "foo"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+
+	// The previous version of the key is retired
+	policy, err := b.(*versionedKVBackend).policy(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.LatestVersion != 2 || policy.MinDecryptionVersion != 2 {
+		t.Fatalf("unexpected key policy versions: latest %d, min decryption %d", policy.LatestVersion, policy.MinDecryptionVersion)
+	}
+}
+
+func TestVersionedKV_Rewrap_NotStarted(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "rewrap/status",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("expected no status, err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rewrap",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"rate_limit": -1,
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Rewrap_Resume(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/app/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{"bar": "baz"},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// A rewrap interrupted right after the key policy was rotated
+	policy, err := b.(*versionedKVBackend).policy(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Rotate(context.Background(), storage, b.(*versionedKVBackend).GetRandomReader()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.(*versionedKVBackend).writeRewrapInfo(context.Background(), storage, &RewrapInfo{
+		FromVersion: 1,
+		ToVersion:   2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &logical.BackendConfig{
+		Logger:      logging.NewVaultLogger(log.Trace),
+		System:      &logical.StaticSystemView{},
+		StorageView: storage,
+		BackendUUID: "test",
+	}
+	b, err = VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for atomic.LoadUint32(b.(*versionedKVBackend).rewrapping) != rewrapIdle {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rewrapInfo, err := b.(*versionedKVBackend).getRewrapInfo(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rewrapInfo.Done || rewrapInfo.Processed != 1 {
+		t.Fatalf("unexpected rewrap info: %#v", rewrapInfo)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/app/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data"].(map[string]interface{})["bar"] != "baz" {
+		t.Fatalf("bad response %#v", resp)
+	}
+}
@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathRotationDue returns the path configuration for listing the keys due for
+// rotation under a prefix.
+func pathRotationDue(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "rotation-due/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the keys to check.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.upgradeCheck(b.pathRotationDueList()),
+		},
+
+		HelpSynopsis:    rotationDueHelpSyn,
+		HelpDescription: rotationDueHelpDesc,
+	}
+}
+
+// pathRotationDueList lists the keys under a prefix, descending into all the
+// sub-folders, whose current version is older than their rotation_period.
+func (b *versionedKVBackend) pathRotationDueList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		keys, err := b.collectKeys(ctx, req.Storage, data.Get("path").(string))
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		due := []string{}
+		keyInfo := map[string]interface{}{}
+		for _, key := range keys {
+			meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+			if err != nil {
+				return nil, err
+			}
+			if meta == nil {
+				continue
+			}
+
+			ok, dueTime := rotationDue(meta, now)
+			if !ok {
+				continue
+			}
+
+			due = append(due, key)
+			keyInfo[key] = map[string]interface{}{
+				"current_version": meta.CurrentVersion,
+				"created_time":    ptypesTimestampToString(meta.Versions[meta.CurrentVersion].CreatedTime),
+				"rotation_period": rotationPeriod(meta).String(),
+				"due_time":        dueTime.UTC().Format(time.RFC3339Nano),
+			}
+		}
+
+		return logical.ListResponseWithInfo(due, keyInfo), nil
+	}
+}
+
+const rotationDueHelpSyn = `Lists the secrets due for rotation.`
+const rotationDueHelpDesc = `
+Listing "rotation-due/<prefix>" returns the secrets under the prefix,
+descending into all the sub-folders, whose current version is older than the
+rotation_period set in their metadata. The key_info of each secret holds its
+current version, the creation time of that version, its rotation_period and
+the time its rotation became due.
+
+Data reads of a secret due for rotation also return a warning.
+`
@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultSearchLimit is the number of keys returned by a search when no limit
+// is provided.
+const defaultSearchLimit = 1000
+
+// pathSearch returns the path configuration for searching keys by name.
+func pathSearch(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "search$",
+		Fields: map[string]*framework.FieldSchema{
+			"prefix": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the keys to search. The whole mount is searched if empty.",
+			},
+			"substring": {
+				Type:        framework.TypeString,
+				Description: "A string the keys must contain.",
+			},
+			"regex": {
+				Type:        framework.TypeString,
+				Description: "A regular expression the keys must match.",
+			},
+			"limit": {
+				Type:        framework.TypeInt,
+				Default:     defaultSearchLimit,
+				Description: "The largest number of keys to return.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathSearchRead()),
+		},
+
+		HelpSynopsis:    searchHelpSyn,
+		HelpDescription: searchHelpDesc,
+	}
+}
+
+// pathSearchRead returns the fully qualified keys under the prefix whose name
+// contains the substring and matches the regular expression.
+func (b *versionedKVBackend) pathSearchRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		substring := data.Get("substring").(string)
+		pattern := data.Get("regex").(string)
+		if substring == "" && pattern == "" {
+			return logical.ErrorResponse("substring or regex must be provided"), nil
+		}
+
+		var re *regexp.Regexp
+		if pattern != "" {
+			var err error
+			re, err = regexp.Compile(pattern)
+			if err != nil {
+				return logical.ErrorResponse("invalid regex: %s", err), nil
+			}
+		}
+
+		limit := data.Get("limit").(int)
+		if limit <= 0 {
+			return logical.ErrorResponse("limit must be positive"), nil
+		}
+
+		keys, err := b.collectKeys(ctx, req.Storage, data.Get("prefix").(string))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(keys)
+
+		matches := []string{}
+		var truncated bool
+		for _, key := range keys {
+			if !strings.Contains(key, substring) || (re != nil && !re.MatchString(key)) {
+				continue
+			}
+			if len(matches) == limit {
+				truncated = true
+				break
+			}
+			matches = append(matches, key)
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"keys":      matches,
+				"truncated": truncated,
+			},
+		}, nil
+	}
+}
+
+const searchHelpSyn = `Searches the secrets by name.`
+const searchHelpDesc = `
+Reading "search" returns in "keys" the fully qualified names of the secrets
+under "prefix", or of every secret of the mount, that contain "substring" and
+match the regular expression "regex". At least one of them must be provided.
+
+At most "limit" keys are returned, 1000 by default; "truncated" is true when
+more keys match.
+`
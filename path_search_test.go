@@ -0,0 +1,74 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Search(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, key := range []string{"apps/stripe", "apps/payments/stripe-key", "apps/database", "other/Stripe"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"max_versions": 5,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	for _, tc := range []struct {
+		data      map[string]interface{}
+		expected  []string
+		truncated bool
+	}{
+		{map[string]interface{}{"substring": "stripe"}, []string{"apps/payments/stripe-key", "apps/stripe"}, false},
+		{map[string]interface{}{"regex": "(?i)stripe$"}, []string{"apps/stripe", "other/Stripe"}, false},
+		{map[string]interface{}{"substring": "stripe", "prefix": "apps/payments"}, []string{"apps/payments/stripe-key"}, false},
+		{map[string]interface{}{"substring": "apps/", "limit": 2}, []string{"apps/database", "apps/payments/stripe-key"}, true},
+		{map[string]interface{}{"substring": "missing"}, []string{}, false},
+	} {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "search",
+			Storage:   storage,
+			Data:      tc.data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if diff := deep.Equal(resp.Data["keys"], tc.expected); diff != nil {
+			t.Fatalf("%v: %v", tc.data, diff)
+		}
+		if resp.Data["truncated"] != tc.truncated {
+			t.Fatalf("%v: unexpected truncated %v", tc.data, resp.Data["truncated"])
+		}
+	}
+
+	for _, data := range []map[string]interface{}{
+		{},
+		{"regex": "("},
+		{"substring": "stripe", "limit": 0},
+	} {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "search",
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error for %v, err:%s resp:%#v\n", data, err, resp)
+		}
+	}
+}
@@ -0,0 +1,527 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// snapshotsPrefix is the prefix where the snapshots are stored.
+const snapshotsPrefix string = "snapshots/"
+
+// pathsSnapshot returns the path configuration for the endpoints creating,
+// listing, restoring and deleting snapshots of the keys under a prefix.
+func pathsSnapshot(b *versionedKVBackend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "snapshot/create$",
+			Fields: map[string]*framework.FieldSchema{
+				"path": {
+					Type:        framework.TypeString,
+					Description: "Prefix of the keys to capture. The whole mount is captured if empty.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.upgradeCheck(b.pathSnapshotCreate()),
+			},
+
+			HelpSynopsis:    snapshotHelpSyn,
+			HelpDescription: snapshotHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "snapshot/list/?$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.upgradeCheck(b.pathSnapshotList()),
+				logical.ListOperation: b.upgradeCheck(b.pathSnapshotList()),
+			},
+
+			HelpSynopsis:    snapshotHelpSyn,
+			HelpDescription: snapshotHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "snapshot/restore$",
+			Fields: map[string]*framework.FieldSchema{
+				"id": {
+					Type:        framework.TypeString,
+					Description: "The ID of the snapshot to restore.",
+				},
+				"dry_run": {
+					Type:        framework.TypeBool,
+					Description: "If true, the keys the restore would write are returned without being written.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.upgradeCheck(b.pathSnapshotRestore()),
+			},
+
+			HelpSynopsis:    snapshotHelpSyn,
+			HelpDescription: snapshotHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "snapshot/delete$",
+			Fields: map[string]*framework.FieldSchema{
+				"id": {
+					Type:        framework.TypeString,
+					Description: "The ID of the snapshot to delete.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.upgradeCheck(b.pathSnapshotDelete()),
+			},
+
+			HelpSynopsis:    snapshotHelpSyn,
+			HelpDescription: snapshotHelpDesc,
+		},
+	}
+}
+
+// pathSnapshotCreate captures the metadata and the current data of the keys
+// under a prefix into a new snapshot. Each key is stored in an entry of its
+// own so that the size of a snapshot is not bounded by the size of a storage
+// entry.
+func (b *versionedKVBackend) pathSnapshotCreate() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("path").(string)
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		keys, err := b.collectKeys(ctx, req.Storage, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		createdTime, err := ptypes.TimestampProto(time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		snapshot := &Snapshot{
+			Id:          id,
+			Prefix:      prefix,
+			CreatedTime: createdTime,
+		}
+
+		// The snapshot is only listed once its header is written, after all
+		// of its keys
+		if err := b.putSnapshotKeys(ctx, req.Storage, snapshot, keys); err != nil {
+			if derr := b.deleteSnapshot(ctx, req.Storage, id); derr != nil {
+				b.Logger().Error("failed to clean up the snapshot", "id", id, "error", derr)
+			}
+			return nil, err
+		}
+		if err := b.putSnapshot(ctx, req.Storage, snapshot); err != nil {
+			return nil, err
+		}
+
+		resp := &logical.Response{
+			Data: snapshotInfo(snapshot),
+		}
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		pruned, err := b.pruneSnapshots(ctx, req.Storage, config.MaxSnapshots)
+		if err != nil {
+			return nil, err
+		}
+		if len(pruned) > 0 {
+			resp.AddWarning(fmt.Sprintf("Deleted the snapshots beyond the max_snapshots of %d: %s", config.MaxSnapshots, strings.Join(pruned, ", ")))
+		}
+
+		return resp, nil
+	}
+}
+
+// putSnapshotKeys exports the keys and writes each of them to an entry below
+// the snapshot, counting them in snapshot.Keys. The keys that no longer
+// exist are skipped.
+func (b *versionedKVBackend) putSnapshotKeys(ctx context.Context, s logical.Storage, snapshot *Snapshot, keys []string) error {
+	for _, key := range keys {
+		ek, err := b.exportKey(ctx, s, key, exportVersionsCurrent)
+		if err != nil {
+			return err
+		}
+		if ek == nil {
+			continue
+		}
+
+		raw, err := json.Marshal(ek)
+		if err != nil {
+			return err
+		}
+		buf, err := proto.Marshal(&SnapshotKey{
+			Path: strings.TrimPrefix(key, snapshot.Prefix),
+			Key:  raw,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := s.Put(ctx, &logical.StorageEntry{
+			Key:   path.Join(b.storagePrefix, snapshotsPrefix, snapshot.Id, strconv.FormatUint(snapshot.Keys, 10)),
+			Value: buf,
+		}); err != nil {
+			return err
+		}
+		snapshot.Keys++
+	}
+
+	return nil
+}
+
+// pathSnapshotList lists the IDs of the snapshots along with their prefix,
+// creation time and number of keys.
+func (b *versionedKVBackend) pathSnapshotList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		snapshots, err := b.listSnapshots(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]string, 0, len(snapshots))
+		keyInfo := make(map[string]interface{}, len(snapshots))
+		for _, snapshot := range snapshots {
+			ids = append(ids, snapshot.Id)
+			keyInfo[snapshot.Id] = snapshotInfo(snapshot)
+		}
+		sort.Strings(ids)
+
+		return logical.ListResponseWithInfo(ids, keyInfo), nil
+	}
+}
+
+// pathSnapshotRestore writes the metadata and the data of the keys captured
+// by a snapshot back to the mount. The data is written as new versions so the
+// history of the keys is preserved.
+func (b *versionedKVBackend) pathSnapshotRestore() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		id := data.Get("id").(string)
+		if id == "" {
+			return logical.ErrorResponse("missing id"), nil
+		}
+
+		snapshot, err := b.getSnapshot(ctx, req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot == nil {
+			return logical.ErrorResponse("snapshot %q not found", id), nil
+		}
+
+		captured, err := b.getSnapshotKeys(ctx, req.Storage, snapshot)
+		if err != nil {
+			return nil, err
+		}
+
+		rels := make([]string, 0, len(captured))
+		for rel := range captured {
+			rels = append(rels, rel)
+		}
+		sort.Strings(rels)
+
+		// The snapshot is validated against the current configuration
+		// before any key is written
+		restored := make([]string, 0, len(rels))
+		created := []string{}
+		imports := make([]*importedKey, 0, len(rels))
+		for _, rel := range rels {
+			key := snapshot.Prefix + rel
+
+			config, err := b.keyConfig(ctx, req.Storage, key)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkPathAllowed(config, key); err != nil {
+				return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+			}
+			if err := checkReservedPrefix(config, key); err != nil {
+				return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+			}
+
+			imported, err := parseImportedKey(config, key, captured[rel])
+			if err != nil {
+				return logical.ErrorResponse("key %q: %s", key, err), nil
+			}
+
+			meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case meta == nil:
+				created = append(created, key)
+			case destroyConfirmationWindow(config) > 0:
+				// Restoring the settings of an existing key would bypass the
+				// confirmation of its destroys
+				return logical.ErrorResponse("key %q: %s", key, errDualControlOverwrite), logical.ErrPermissionDenied
+			}
+			restored = append(restored, key)
+			imports = append(imports, imported)
+		}
+
+		// The quotas are checked for the whole restore so that it is not
+		// interrupted halfway through
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		release, quotaWarning, err := b.checkKeyQuotas(ctx, req.Storage, config, created)
+		if errors.Is(err, errKeyQuotaExceeded) {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"restored": restored,
+				"dry_run":  data.Get("dry_run").(bool),
+			},
+		}
+		if quotaWarning != "" {
+			resp.AddWarning(quotaWarning)
+		}
+		if data.Get("dry_run").(bool) {
+			return resp, nil
+		}
+
+		for _, imported := range imports {
+			warning, err := b.importKey(ctx, req, imported, importConflictOverwrite)
+			if err != nil {
+				return nil, fmt.Errorf("restoring %q: %w", imported.key, err)
+			}
+			if warning != "" {
+				resp.AddWarning(warning)
+			}
+		}
+
+		return resp, nil
+	}
+}
+
+// pathSnapshotDelete deletes a snapshot along with the keys it captured.
+func (b *versionedKVBackend) pathSnapshotDelete() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		id := data.Get("id").(string)
+		if id == "" {
+			return logical.ErrorResponse("missing id"), nil
+		}
+
+		return nil, b.deleteSnapshot(ctx, req.Storage, id)
+	}
+}
+
+// snapshotInfo returns the description of a snapshot returned by the
+// snapshot endpoints.
+func snapshotInfo(snapshot *Snapshot) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           snapshot.Id,
+		"prefix":       snapshot.Prefix,
+		"created_time": ptypesTimestampToString(snapshot.CreatedTime),
+		"keys":         snapshot.Keys,
+	}
+}
+
+// getSnapshot returns the snapshot with the provided ID, or nil if it does
+// not exist.
+func (b *versionedKVBackend) getSnapshot(ctx context.Context, s logical.Storage, id string) (*Snapshot, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, snapshotsPrefix, id))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	snapshot := &Snapshot{}
+	if err := proto.Unmarshal(raw.Value, snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// getSnapshotKeys returns the keys captured by the snapshot, relative to its
+// prefix. The keys of the snapshots created before the keys were stored in
+// entries of their own are read from their bundle.
+func (b *versionedKVBackend) getSnapshotKeys(ctx context.Context, s logical.Storage, snapshot *Snapshot) (map[string]*exportedKey, error) {
+	if len(snapshot.Bundle) > 0 {
+		var bundle exportBundle
+		if err := json.Unmarshal(snapshot.Bundle, &bundle); err != nil {
+			return nil, fmt.Errorf("decoding snapshot %q: %w", snapshot.Id, err)
+		}
+		return bundle.Keys, nil
+	}
+
+	keysPath := path.Join(b.storagePrefix, snapshotsPrefix, snapshot.Id) + "/"
+	entries, err := s.List(ctx, keysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*exportedKey, len(entries))
+	for _, entry := range entries {
+		raw, err := s.Get(ctx, keysPath+entry)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			continue
+		}
+
+		sk := &SnapshotKey{}
+		if err := proto.Unmarshal(raw.Value, sk); err != nil {
+			return nil, fmt.Errorf("decoding snapshot %q: %w", snapshot.Id, err)
+		}
+		ek := &exportedKey{}
+		if err := json.Unmarshal(sk.Key, ek); err != nil {
+			return nil, fmt.Errorf("decoding key %q of snapshot %q: %w", sk.Path, snapshot.Id, err)
+		}
+		keys[sk.Path] = ek
+	}
+	if uint64(len(keys)) != snapshot.Keys {
+		return nil, fmt.Errorf("snapshot %q holds %d keys, expected %d", snapshot.Id, len(keys), snapshot.Keys)
+	}
+
+	return keys, nil
+}
+
+// listSnapshots returns the snapshots, ignoring the keys of the snapshots
+// whose creation did not complete.
+func (b *versionedKVBackend) listSnapshots(ctx context.Context, s logical.Storage) ([]*Snapshot, error) {
+	ids, err := s.List(ctx, path.Join(b.storagePrefix, snapshotsPrefix)+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*Snapshot, 0, len(ids))
+	for _, id := range ids {
+		if strings.HasSuffix(id, "/") {
+			continue
+		}
+		snapshot, err := b.getSnapshot(ctx, s, id)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot == nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, nil
+}
+
+// pruneSnapshots deletes the oldest snapshots beyond the max most recent
+// ones and returns their IDs. Nothing is deleted if max is zero.
+func (b *versionedKVBackend) pruneSnapshots(ctx context.Context, s logical.Storage, max uint32) ([]string, error) {
+	if max == 0 {
+		return nil, nil
+	}
+
+	snapshots, err := b.listSnapshots(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) <= int(max) {
+		return nil, nil
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		ti, tj := snapshots[i].CreatedTime, snapshots[j].CreatedTime
+		if ti.Seconds != tj.Seconds {
+			return ti.Seconds < tj.Seconds
+		}
+		if ti.Nanos != tj.Nanos {
+			return ti.Nanos < tj.Nanos
+		}
+		return snapshots[i].Id < snapshots[j].Id
+	})
+
+	var pruned []string
+	for _, snapshot := range snapshots[:len(snapshots)-int(max)] {
+		if err := b.deleteSnapshot(ctx, s, snapshot.Id); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, snapshot.Id)
+	}
+
+	return pruned, nil
+}
+
+// deleteSnapshot deletes the snapshot and the keys it captured. The header
+// is deleted first so that a partially deleted snapshot is no longer listed.
+func (b *versionedKVBackend) deleteSnapshot(ctx context.Context, s logical.Storage, id string) error {
+	if err := s.Delete(ctx, path.Join(b.storagePrefix, snapshotsPrefix, id)); err != nil {
+		return err
+	}
+
+	keysPath := path.Join(b.storagePrefix, snapshotsPrefix, id) + "/"
+	entries, err := s.List(ctx, keysPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := s.Delete(ctx, keysPath+entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putSnapshot writes the snapshot to storage.
+func (b *versionedKVBackend) putSnapshot(ctx context.Context, s logical.Storage, snapshot *Snapshot) error {
+	bytes, err := proto.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, snapshotsPrefix, snapshot.Id),
+		Value: bytes,
+	})
+}
+
+const snapshotHelpSyn = `Creates, lists, restores and deletes snapshots of the secrets under a prefix.`
+const snapshotHelpDesc = `
+Writing to "snapshot/create" captures the metadata, custom_metadata, settings
+and current data of the secrets under the "path" prefix into a snapshot kept
+by the backend, and returns its ID. The snapshots are stored in seal-wrapped
+storage, one entry per secret. If the max_snapshots of the mount is set, the
+oldest snapshots beyond it are deleted.
+
+"snapshot/list" lists the IDs of the snapshots, along with the prefix, the
+creation time and the number of secrets of each snapshot in key_info.
+
+Writing the ID of a snapshot to "snapshot/restore" restores the secrets it
+captured: their settings and custom_metadata are reset to the captured ones
+and their captured data is written as a new version, so their history is
+preserved. The secrets created since the snapshot are left untouched. The
+secrets under a reserved prefix, and the existing secrets whose destroys must
+be confirmed by another entity through the destroy_confirmation_window, cannot
+be restored. The restore is rejected if the secrets it would create exceed the
+max_keys of a prefix. If "dry_run" is true, the secrets that would be restored
+are returned without being written.
+
+Writing the ID of a snapshot to "snapshot/delete" deletes it.
+`
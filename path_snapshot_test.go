@@ -0,0 +1,213 @@
+package kv
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Snapshot(t *testing.T) {
+	b, storage := getBackend(t)
+
+	write := func(key string, data map[string]interface{}) {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      key,
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	write("data/app/foo", map[string]interface{}{"data": map[string]interface{}{"bar": "baz"}})
+	write("metadata/app/foo", map[string]interface{}{"custom_metadata": map[string]interface{}{"owner": "team"}})
+	write("data/other", map[string]interface{}{"data": map[string]interface{}{"bar": "baz"}})
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "snapshot/create",
+		Storage:   storage,
+		Data:      map[string]interface{}{"path": "app"},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	id := resp.Data["id"].(string)
+	if resp.Data["prefix"] != "app/" || resp.Data["keys"] != uint64(1) {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "snapshot/list/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{id}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+
+	// Change the key after the snapshot
+	write("data/app/foo", map[string]interface{}{"data": map[string]interface{}{"bar": "qux"}})
+	write("metadata/app/foo", map[string]interface{}{"custom_metadata": map[string]interface{}{"owner": "other"}})
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "snapshot/restore",
+		Storage:   storage,
+		Data:      map[string]interface{}{"id": id, "dry_run": true},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["restored"], []string{"app/foo"}) {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	req.Data = map[string]interface{}{"id": id}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/app/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data"].(map[string]interface{})["bar"] != "baz" {
+		t.Fatalf("unexpected data: %#v", resp.Data["data"])
+	}
+	metadata := resp.Data["metadata"].(map[string]interface{})
+	if metadata["version"] != uint64(3) || !reflect.DeepEqual(metadata["custom_metadata"], map[string]string{"owner": "team"}) {
+		t.Fatalf("unexpected metadata: %#v", metadata)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "snapshot/restore",
+		Storage:   storage,
+		Data:      map[string]interface{}{"id": "missing"},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Snapshot_RetentionAndDelete(t *testing.T) {
+	b, storage := getBackend(t)
+
+	handle := func(op logical.Operation, p string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      p,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	handle(logical.UpdateOperation, "config", map[string]interface{}{"max_snapshots": 2})
+	handle(logical.CreateOperation, "data/app/foo", map[string]interface{}{"data": map[string]interface{}{"bar": "baz"}})
+	handle(logical.CreateOperation, "data/app/bar", map[string]interface{}{"data": map[string]interface{}{"bar": "baz"}})
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		resp := handle(logical.UpdateOperation, "snapshot/create", map[string]interface{}{"path": "app"})
+		if resp.Data["keys"] != uint64(2) {
+			t.Fatalf("unexpected response: %#v", resp.Data)
+		}
+		ids = append(ids, resp.Data["id"].(string))
+	}
+
+	// Each key is stored in an entry of its own
+	kvb := b.(*versionedKVBackend)
+	entries, err := storage.List(context.Background(), path.Join(kvb.storagePrefix, snapshotsPrefix, ids[2])+"/")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("unexpected entries: %v, err:%s", entries, err)
+	}
+
+	// The oldest snapshot is deleted along with its keys
+	resp := handle(logical.ListOperation, "snapshot/list/", nil)
+	keys := resp.Data["keys"].([]string)
+	if len(keys) != 2 || strings.Contains(strings.Join(keys, ","), ids[0]) {
+		t.Fatalf("unexpected keys: %#v", keys)
+	}
+	entries, err = storage.List(context.Background(), path.Join(kvb.storagePrefix, snapshotsPrefix, ids[0])+"/")
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("unexpected entries: %v, err:%s", entries, err)
+	}
+
+	handle(logical.UpdateOperation, "snapshot/delete", map[string]interface{}{"id": ids[1]})
+	resp = handle(logical.ListOperation, "snapshot/list/", nil)
+	if !reflect.DeepEqual(resp.Data["keys"], []string{ids[2]}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+}
+
+func TestVersionedKV_Snapshot_RestoreChecks(t *testing.T) {
+	b, storage := getBackend(t)
+
+	handle := func(op logical.Operation, p string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      p,
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+	mustHandle := func(op logical.Operation, p string, data map[string]interface{}) *logical.Response {
+		resp, err := handle(op, p, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	mustHandle(logical.CreateOperation, "data/app/foo", map[string]interface{}{"data": map[string]interface{}{"bar": "baz"}})
+	mustHandle(logical.CreateOperation, "data/app/bar", map[string]interface{}{"data": map[string]interface{}{"bar": "baz"}})
+	id := mustHandle(logical.UpdateOperation, "snapshot/create", map[string]interface{}{"path": "app"}).Data["id"].(string)
+
+	mustHandle(logical.DeleteOperation, "metadata/app/foo", nil)
+	mustHandle(logical.DeleteOperation, "metadata/app/bar", nil)
+
+	// Restoring the keys would exceed the quota of the prefix
+	mustHandle(logical.UpdateOperation, "config/path/app/", map[string]interface{}{"max_keys": 1})
+	resp, err := handle(logical.UpdateOperation, "snapshot/restore", map[string]interface{}{"id": id})
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Error().Error(), "max_keys") {
+		t.Fatalf("expected the restore to be rejected by the quota, err:%s resp:%#v\n", err, resp)
+	}
+
+	// The keys under a reserved prefix cannot be restored
+	mustHandle(logical.UpdateOperation, "config/path/app/", map[string]interface{}{"max_keys": 0})
+	mustHandle(logical.UpdateOperation, "config", map[string]interface{}{"reserved_prefixes": []string{"app/"}})
+	resp, err = handle(logical.UpdateOperation, "snapshot/restore", map[string]interface{}{"id": id})
+	if err != logical.ErrPermissionDenied || resp == nil || !strings.Contains(resp.Error().Error(), "reserved prefix") {
+		t.Fatalf("expected the restore to be rejected by the reserved prefix, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp = mustHandle(logical.ListOperation, "metadata/app/", nil)
+	if keys, _ := resp.Data["keys"].([]string); len(keys) != 0 {
+		t.Fatalf("unexpected keys: %#v", resp.Data)
+	}
+}
@@ -0,0 +1,233 @@
+package kv
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathTemplates returns the path configuration for CRUD operations on the
+// templates applied to new keys.
+func pathTemplates(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "templates/" + framework.MatchAllRegex("prefix"),
+		Fields: map[string]*framework.FieldSchema{
+			"prefix": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the keys the template applies to.",
+			},
+			"cas_required": {
+				Type:        framework.TypeBool,
+				Description: "The cas_required of the new keys.",
+			},
+			"max_versions": {
+				Type:        framework.TypeInt,
+				Description: "The max_versions of the new keys.",
+			},
+			"delete_version_after": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+The delete_version_after of the new keys. Cannot be less than the backend's
+min_delete_version_after.`,
+			},
+			"custom_metadata": {
+				Type: framework.TypeKVPairs,
+				Description: `
+The custom_metadata of the new keys. It is merged over the backend's
+default_custom_metadata.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathTemplatesWrite()),
+			logical.ReadOperation:   b.upgradeCheck(b.pathTemplatesRead()),
+			logical.DeleteOperation: b.upgradeCheck(b.pathTemplatesDelete()),
+			logical.ListOperation:   b.upgradeCheck(b.pathTemplatesList()),
+		},
+
+		HelpSynopsis:    templatesHelpSyn,
+		HelpDescription: templatesHelpDesc,
+	}
+}
+
+// keyTemplate returns the template of the longest prefix matching key, or nil
+// if none does.
+func keyTemplate(config *Configuration, key string) *Template {
+	var template *Template
+	var longest string
+	for prefix, t := range config.Templates {
+		if strings.HasPrefix(key, prefix) && (template == nil || len(prefix) > len(longest)) {
+			template, longest = t, prefix
+		}
+	}
+	return template
+}
+
+// applyTemplate sets the settings of the template matching the key on the
+// metadata of a new key.
+func applyTemplate(config *Configuration, meta *KeyMetadata) {
+	template := keyTemplate(config, meta.Key)
+	if template == nil {
+		return
+	}
+
+	meta.CasRequired = template.CasRequired
+	meta.MaxVersions = template.MaxVersions
+	if dva := deleteVersionAfter(template); dva != 0 {
+		meta.DeleteVersionAfter = ptypes.DurationProto(dva)
+	}
+}
+
+func (b *versionedKVBackend) pathTemplatesRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		template, ok := config.Templates[data.Get("prefix").(string)]
+		if !ok {
+			return nil, nil
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"cas_required":         template.CasRequired,
+				"max_versions":         template.MaxVersions,
+				"delete_version_after": deleteVersionAfter(template).String(),
+				"custom_metadata":      template.CustomMetadata,
+			},
+		}, nil
+	}
+}
+
+func (b *versionedKVBackend) pathTemplatesWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("prefix").(string)
+		if prefix == "" {
+			return logical.ErrorResponse("missing prefix"), nil
+		}
+
+		b.configWriteLock.Lock()
+		defer b.configWriteLock.Unlock()
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		template, ok := config.Templates[prefix]
+		if !ok {
+			template = &Template{}
+		}
+
+		if casRaw, ok := data.GetOk("cas_required"); ok {
+			template.CasRequired = casRaw.(bool)
+		}
+		previousMaxVersions := template.MaxVersions
+		maxRaw, maxOk := data.GetOk("max_versions")
+		if maxOk {
+			if maxRaw.(int) < 0 {
+				return logical.ErrorResponse("max_versions cannot be negative"), nil
+			}
+			template.MaxVersions = uint32(maxRaw.(int))
+		}
+		if dvaRaw, ok := data.GetOk("delete_version_after"); ok {
+			dva := time.Duration(dvaRaw.(int)) * time.Second
+			switch min := minDeleteVersionAfter(config); {
+			case dva < 0:
+				return logical.ErrorResponse("delete_version_after cannot be negative"), nil
+			case dva == 0:
+				template.DeleteVersionAfter = nil
+			case dva < min:
+				return logical.ErrorResponse("delete_version_after %s is less than the min_delete_version_after %s configured on the backend", dva, min), nil
+			default:
+				template.DeleteVersionAfter = ptypes.DurationProto(dva)
+			}
+		}
+		if cmRaw, ok := data.GetOk("custom_metadata"); ok {
+			customMetadata := cmRaw.(map[string]string)
+			if err := validateCustomMetadata(config, customMetadata); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			template.CustomMetadata = customMetadata
+		}
+
+		if limit := config.MaxVersionsLimit; limit > 0 && template.MaxVersions > limit {
+			return logical.ErrorResponse("max_versions %d is greater than max_versions_limit %d", template.MaxVersions, limit), nil
+		}
+
+		// The new keys would erase their versions on their own, bypassing
+		// the confirmation of destroys
+		setting := erasingSetting(maxVersionsIf(maxOk, template.MaxVersions), previousMaxVersions, nil, nil, false)
+		if err := checkDualControlSetting(config, setting); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		if config.Templates == nil {
+			config.Templates = map[string]*Template{}
+		}
+		config.Templates[prefix] = template
+
+		return nil, b.storeConfig(ctx, req.Storage, config)
+	}
+}
+
+func (b *versionedKVBackend) pathTemplatesDelete() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		b.configWriteLock.Lock()
+		defer b.configWriteLock.Unlock()
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := data.Get("prefix").(string)
+		if _, ok := config.Templates[prefix]; !ok {
+			return nil, nil
+		}
+		delete(config.Templates, prefix)
+
+		return nil, b.storeConfig(ctx, req.Storage, config)
+	}
+}
+
+// pathTemplatesList lists the prefixes of the templates starting with the
+// provided prefix.
+func (b *versionedKVBackend) pathTemplatesList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := data.Get("prefix").(string)
+		prefixes := []string{}
+		for p := range config.Templates {
+			if strings.HasPrefix(p, prefix) {
+				prefixes = append(prefixes, p)
+			}
+		}
+		sort.Strings(prefixes)
+
+		return logical.ListResponse(prefixes), nil
+	}
+}
+
+const templatesHelpSyn = `Configures the settings new keys under a prefix start with.`
+const templatesHelpDesc = `
+A template defines the cas_required, max_versions, delete_version_after and
+custom_metadata of the keys created under its prefix. When a key is created,
+by writing its data or its metadata, the template with the longest prefix
+matching the key is applied. The custom_metadata of the template is merged over
+the default_custom_metadata of the backend and the settings provided when
+creating the key take precedence over the template.
+
+Templates only apply to new keys; updating or deleting a template does not
+modify the existing keys.
+`
@@ -0,0 +1,232 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Templates(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"default_custom_metadata": map[string]interface{}{
+				"owner": "platform",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	for prefix, data := range map[string]map[string]interface{}{
+		"team/": {
+			"max_versions": 3,
+			"custom_metadata": map[string]interface{}{
+				"owner": "team",
+				"tier":  "1",
+			},
+		},
+		"team/prod/": {
+			"cas_required":         true,
+			"delete_version_after": "1h",
+		},
+	} {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "templates/" + prefix,
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	// Negative settings are rejected
+	for field, value := range map[string]interface{}{
+		"max_versions":         -1,
+		"delete_version_after": "-1h",
+	} {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "templates/team/",
+			Storage:   storage,
+			Data:      map[string]interface{}{field: value},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected an error, err:%s resp:%#v\n", field, err, resp)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "templates/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if diff := deep.Equal(resp.Data["keys"], []string{"team/", "team/prod/"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "templates/team/prod/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["cas_required"] != true || resp.Data["delete_version_after"] != "1h0m0s" {
+		t.Fatalf("unexpected template %#v", resp.Data)
+	}
+
+	// The longest matching template applies to new keys, and cas_required
+	// already applies to their first write
+	write := func(key string, cas bool) {
+		t.Helper()
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		if cas {
+			req.Data["options"] = map[string]interface{}{
+				"cas": 0,
+			}
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	write("team/foo", false)
+	write("team/prod/foo", true)
+	write("other", false)
+
+	tests := map[string]map[string]interface{}{
+		"team/foo": {
+			"max_versions":         uint32(3),
+			"cas_required":         false,
+			"delete_version_after": "0s",
+			"custom_metadata":      map[string]string{"owner": "team", "tier": "1"},
+		},
+		"team/prod/foo": {
+			"max_versions":         uint32(0),
+			"cas_required":         true,
+			"delete_version_after": "1h0m0s",
+			"custom_metadata":      map[string]string{"owner": "platform"},
+		},
+		"other": {
+			"max_versions":         uint32(0),
+			"cas_required":         false,
+			"delete_version_after": "0s",
+			"custom_metadata":      map[string]string{"owner": "platform"},
+		},
+	}
+	for key, expected := range tests {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metadata/" + key,
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		for field, value := range expected {
+			if diff := deep.Equal(resp.Data[field], value); diff != nil {
+				t.Fatalf("%s: %s: %v", key, field, diff)
+			}
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "templates/team/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "templates/team/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_Templates_Concurrent(t *testing.T) {
+	b, s := getBackend(t)
+	storage := &slowPutStorage{Storage: s}
+
+	// The templates share the configuration with the settings of the
+	// prefixes, the concurrent changes of both must not overwrite each other
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("templates/app%d/", i)
+		if i%2 == 1 {
+			path = fmt.Sprintf("config/path/app%d/", i)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      path,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"max_versions": 2,
+				},
+			})
+			if err == nil && resp != nil && resp.IsError() {
+				err = resp.Error()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := b.(*versionedKVBackend).config(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Templates) != 10 || len(config.PathConfigs) != 10 {
+		t.Fatalf("expected 10 templates and 10 prefixes, got %d and %d", len(config.Templates), len(config.PathConfigs))
+	}
+}
@@ -0,0 +1,254 @@
+package kv
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultTidySafetyBuffer is how old an unreferenced version must be before
+// tidy removes it.
+const defaultTidySafetyBuffer = 72 * time.Hour
+
+// pathTidy returns the path configuration for the tidy endpoint
+func pathTidy(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy$",
+		Fields: map[string]*framework.FieldSchema{
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Default:     true,
+				Description: "If true, the inconsistencies are reported and nothing is modified.",
+			},
+			"safety_buffer": {
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultTidySafetyBuffer.Seconds()),
+				Description: "Only the unreferenced versions created longer ago than this duration are tidied, so that versions being written are left alone.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.upgradeCheck(b.pathTidyWrite()),
+		},
+
+		HelpSynopsis:    tidyHelpSyn,
+		HelpDescription: tidyHelpDesc,
+	}
+}
+
+// pathTidyWrite cross-checks the metadata and the versions in storage. It
+// reports the deletions that were interrupted, the versions that no metadata
+// references and the versions referenced by metadata that are missing from
+// storage, and fixes them unless dry_run is set.
+func (b *versionedKVBackend) pathTidyWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		dryRun := data.Get("dry_run").(bool)
+		safetyBuffer := time.Duration(data.Get("safety_buffer").(int)) * time.Second
+		if safetyBuffer < 0 {
+			return logical.ErrorResponse("safety_buffer cannot be negative"), nil
+		}
+
+		keys, err := b.collectKeys(ctx, req.Storage, "")
+		if err != nil {
+			return nil, err
+		}
+
+		// Find the version entries referenced by the metadata. The
+		// versions of the keys being deleted are referenced as they are
+		// removed when the deletion is resumed.
+		interrupted := []string{}
+		missing := map[string]interface{}{}
+		referenced := make(map[string]bool)
+		for _, key := range keys {
+			meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+			if err != nil {
+				return nil, err
+			}
+			if meta == nil {
+				continue
+			}
+			if meta.Deleting {
+				interrupted = append(interrupted, key)
+			}
+
+			var absent []uint64
+			for id, vm := range meta.Versions {
+				if vm.Destroyed {
+					continue
+				}
+				versionKey, err := b.getVersionKey(ctx, key, id, req.Storage)
+				if err != nil {
+					return nil, err
+				}
+				referenced[versionKey] = true
+
+				if meta.Deleting {
+					continue
+				}
+				raw, err := req.Storage.Get(ctx, versionKey)
+				if err != nil {
+					return nil, err
+				}
+				if raw == nil {
+					absent = append(absent, id)
+				}
+			}
+			if len(absent) > 0 {
+				sort.Slice(absent, func(i, j int) bool { return absent[i] < absent[j] })
+				missing[key] = absent
+			}
+		}
+
+		orphaned, err := b.orphanedVersions(ctx, req.Storage, referenced, time.Now().Add(-safetyBuffer))
+		if err != nil {
+			return nil, err
+		}
+
+		if !dryRun {
+			for _, key := range interrupted {
+				if err := b.resumeInterruptedDeletion(ctx, req.Storage, key); err != nil {
+					return nil, err
+				}
+			}
+			for key := range missing {
+				if err := b.destroyMissingVersions(ctx, req.Storage, key); err != nil {
+					return nil, err
+				}
+			}
+			for _, versionKey := range orphaned {
+				if err := req.Storage.Delete(ctx, versionKey); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"dry_run":               dryRun,
+				"interrupted_deletions": interrupted,
+				"missing_versions":      missing,
+				"orphaned_versions":     orphaned,
+			},
+		}, nil
+	}
+}
+
+// orphanedVersions returns the storage keys of the versions that are not
+// referenced and were created before the cutoff.
+func (b *versionedKVBackend) orphanedVersions(ctx context.Context, s logical.Storage, referenced map[string]bool, cutoff time.Time) ([]string, error) {
+	// The versions are nested one or two folders deep depending on the
+	// storage layout
+	dirs := []string{path.Join(b.storagePrefix, versionPrefix) + "/"}
+
+	orphaned := []string{}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		entries, err := s.List(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if strings.HasSuffix(entry, "/") {
+				dirs = append(dirs, dir+entry)
+				continue
+			}
+
+			versionKey := dir + entry
+			if referenced[versionKey] {
+				continue
+			}
+
+			raw, err := s.Get(ctx, versionKey)
+			if err != nil {
+				return nil, err
+			}
+			if raw == nil {
+				continue
+			}
+
+			// A version whose creation time cannot be read is not
+			// usable and is always tidied
+			version := &Version{}
+			if err := proto.Unmarshal(raw.Value, version); err == nil {
+				created, err := ptypes.Timestamp(version.CreatedTime)
+				if err == nil && created.After(cutoff) {
+					continue
+				}
+			}
+
+			orphaned = append(orphaned, versionKey)
+		}
+	}
+
+	sort.Strings(orphaned)
+	return orphaned, nil
+}
+
+// destroyMissingVersions marks the versions of a key whose data is missing
+// from storage as destroyed. The versions are checked again under the lock of
+// the key so that concurrent writes are not affected.
+func (b *versionedKVBackend) destroyMissingVersions(ctx context.Context, s logical.Storage, key string) error {
+	unlock, err := b.lockKeyForWrite(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil || meta.Deleting {
+		return nil
+	}
+
+	var modified bool
+	for id, vm := range meta.Versions {
+		if vm.Destroyed {
+			continue
+		}
+		versionKey, err := b.getVersionKey(ctx, key, id, s)
+		if err != nil {
+			return err
+		}
+		raw, err := s.Get(ctx, versionKey)
+		if err != nil {
+			return err
+		}
+		if raw == nil {
+			vm.Destroyed = true
+			modified = true
+		}
+	}
+	if !modified {
+		return nil
+	}
+
+	return b.writeKeyMetadata(ctx, s, meta)
+}
+
+const tidyHelpSyn = `Finds and removes the inconsistencies between the metadata and the versions.`
+const tidyHelpDesc = `
+An interrupted write, destroy or deletion can leave the storage of the backend
+inconsistent. Writing to this endpoint reports:
+
+  - "interrupted_deletions": the secrets whose deletion did not complete,
+  - "missing_versions": for each secret, the versions that are neither
+    destroyed nor present in storage,
+  - "orphaned_versions": the storage entries of the versions that no secret
+    references and that were created longer ago than "safety_buffer", 72 hours
+    by default.
+
+By default nothing is modified. When "dry_run" is false, the interrupted
+deletions are completed, the missing versions are marked as destroyed and the
+orphaned versions are removed from storage.
+`
@@ -0,0 +1,165 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Tidy(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	ctx := context.Background()
+
+	for _, key := range []string{"live", "missing", "missing", "interrupted"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"foo": "bar",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	// Simulate a destroy interrupted before removing the version
+	missingKey, err := kv.getVersionKey(ctx, "missing", 1, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete(ctx, missingKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate an interrupted deletion
+	meta, err := kv.getKeyMetadata(ctx, storage, "interrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Deleting = true
+	if err := kv.writeKeyMetadata(ctx, storage, meta); err != nil {
+		t.Fatal(err)
+	}
+	interruptedKey, err := kv.getVersionKey(ctx, "interrupted", 1, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write versions no metadata references
+	putVersion := func(key string, created time.Time) string {
+		t.Helper()
+
+		versionKey, err := kv.getVersionKey(ctx, key, 1, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := ptypes.TimestampProto(created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := proto.Marshal(&Version{Data: []byte(`{}`), CreatedTime: ts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, &logical.StorageEntry{Key: versionKey, Value: buf}); err != nil {
+			t.Fatal(err)
+		}
+		return versionKey
+	}
+	oldOrphan := putVersion("old", time.Now().Add(-100*time.Hour))
+	recentOrphan := putVersion("recent", time.Now())
+
+	tidy := func(data map[string]interface{}) map[string]interface{} {
+		t.Helper()
+
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "tidy",
+			Storage:   storage,
+			Data:      data,
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp.Data
+	}
+
+	expected := map[string]interface{}{
+		"dry_run":               true,
+		"interrupted_deletions": []string{"interrupted"},
+		"missing_versions": map[string]interface{}{
+			"missing": []uint64{1},
+		},
+		"orphaned_versions": []string{oldOrphan},
+	}
+	if diff := deep.Equal(tidy(nil), expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	// A dry run does not modify anything
+	if diff := deep.Equal(tidy(nil), expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	expected["dry_run"] = false
+	if diff := deep.Equal(tidy(map[string]interface{}{"dry_run": false}), expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	meta, err = kv.getKeyMetadata(ctx, storage, "interrupted")
+	if err != nil || meta != nil {
+		t.Fatalf("expected the interrupted deletion to be completed, err:%s meta:%#v", err, meta)
+	}
+	meta, err = kv.getKeyMetadata(ctx, storage, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Versions[1].Destroyed || meta.Versions[2].Destroyed {
+		t.Fatalf("unexpected versions: %#v", meta.Versions)
+	}
+	for versionKey, present := range map[string]bool{
+		oldOrphan:      false,
+		interruptedKey: false,
+		recentOrphan:   true,
+	} {
+		raw, err := storage.Get(ctx, versionKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (raw != nil) != present {
+			t.Fatalf("expected %q to be present: %t", versionKey, present)
+		}
+	}
+
+	expected = map[string]interface{}{
+		"dry_run":               false,
+		"interrupted_deletions": []string{},
+		"missing_versions":      map[string]interface{}{},
+		"orphaned_versions":     []string{recentOrphan},
+	}
+	if diff := deep.Equal(tidy(map[string]interface{}{"dry_run": false, "safety_buffer": 0}), expected); diff != nil {
+		t.Fatal(diff)
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/live",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(ctx, req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
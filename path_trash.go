@@ -0,0 +1,111 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathsTrash returns the path configuration for the endpoints listing and
+// restoring the keys moved to the trash by metadata deletes.
+func pathsTrash(b *versionedKVBackend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern: "trash/list/?$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.upgradeCheck(b.pathTrashList()),
+				logical.ListOperation: b.upgradeCheck(b.pathTrashList()),
+			},
+
+			HelpSynopsis:    trashHelpSyn,
+			HelpDescription: trashHelpDesc,
+		},
+		&framework.Path{
+			Pattern: "trash/restore/" + framework.MatchAllRegex("path"),
+			Fields: map[string]*framework.FieldSchema{
+				"path": {
+					Type:        framework.TypeString,
+					Description: "Location of the secret to restore.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.upgradeCheck(b.reservedPrefixCheck(b.pathTrashRestore())),
+			},
+
+			HelpSynopsis:    trashHelpSyn,
+			HelpDescription: trashHelpDesc,
+		},
+	}
+}
+
+// pathTrashList lists the paths of the keys in the trash along with the time
+// they were deleted at and the time they are purged at.
+func (b *versionedKVBackend) pathTrashList() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		trash, err := b.listTrash(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := make([]string, 0, len(trash))
+		keyInfo := make(map[string]interface{}, len(trash))
+		for _, trashed := range trash {
+			key := trashed.Metadata.GetKey()
+			keys = append(keys, key)
+			keyInfo[key] = map[string]interface{}{
+				"deleted_time":    ptypesTimestampToString(trashed.DeletedTime),
+				"purge_time":      ptypesTimestampToString(trashed.PurgeTime),
+				"current_version": trashed.Metadata.GetCurrentVersion(),
+			}
+		}
+		sort.Strings(keys)
+
+		return logical.ListResponseWithInfo(keys, keyInfo), nil
+	}
+}
+
+// pathTrashRestore moves a key and its versions back from the trash.
+func (b *versionedKVBackend) pathTrashRestore() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+
+		unlock, err := b.lockKeyForWrite(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+
+		meta, err := b.restoreTrashedKey(ctx, req.Storage, key)
+		switch {
+		case err == errKeyExists, errors.Is(err, errKeyQuotaExceeded):
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		case err != nil:
+			return nil, err
+		case meta == nil:
+			return logical.ErrorResponse("secret %q is not in the trash", key), nil
+		}
+		b.sendEvent(ctx, req, eventDataWrite, key, versionNumbers(allVersionNumbers(meta)))
+
+		return nil, nil
+	}
+}
+
+const trashHelpSyn = `Lists and restores the secrets moved to the trash.`
+const trashHelpDesc = `
+If trash_retention is configured, deleting the metadata of a secret moves the
+secret and all of its versions to the trash instead of destroying them. The
+trashed secrets are retained for trash_retention and then permanently purged
+in the background. Deleting the metadata of a secret again replaces the
+previously trashed secret with the same path.
+
+"trash/list" lists the paths of the trashed secrets, along with the time they
+were deleted at, the time they will be purged at and their current version in
+key_info.
+
+Writing to "trash/restore/<path>" moves the trashed secret back with its
+metadata and versions. It fails if a secret has been created at the same path
+since.
+`
@@ -0,0 +1,151 @@
+package kv
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Trash(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"trash_retention": "1h",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	write := func(value string) {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{"bar": value},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	read := func(version int) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"version": version,
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+	deleteMetadata := func() {
+		req := &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "metadata/foo",
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+	listTrash := func() *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "trash/list",
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+	restore := func() (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "trash/restore/foo",
+			Storage:   storage,
+		})
+	}
+
+	write("v1")
+	write("v2")
+	deleteMetadata()
+
+	if resp := read(0); resp != nil {
+		t.Fatalf("expected no data, got %#v", resp)
+	}
+
+	resp = listTrash()
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"foo"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+	info := resp.Data["key_info"].(map[string]interface{})["foo"].(map[string]interface{})
+	if info["current_version"] != uint64(2) {
+		t.Fatalf("unexpected key_info: %#v", info)
+	}
+
+	resp, err = restore()
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	for version, want := range map[int]string{1: "v1", 2: "v2"} {
+		resp := read(version)
+		if resp == nil || resp.Data["data"].(map[string]interface{})["bar"] != want {
+			t.Fatalf("bad response %#v", resp)
+		}
+	}
+	if keys, _ := listTrash().Data["keys"].([]string); len(keys) != 0 {
+		t.Fatalf("expected an empty trash, got %#v", keys)
+	}
+
+	// A trashed key cannot replace a key created since
+	deleteMetadata()
+	write("new")
+	resp, err = restore()
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	// The trashed keys are purged once their retention is over
+	backend := b.(*versionedKVBackend)
+	if err := backend.purgeTrash(context.Background(), storage, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := listTrash().Data["keys"].([]string); len(keys) != 1 {
+		t.Fatalf("expected the trashed key to be retained, got %#v", keys)
+	}
+	if err := backend.purgeTrash(context.Background(), storage, time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := listTrash().Data["keys"].([]string); len(keys) != 0 {
+		t.Fatalf("expected an empty trash, got %#v", keys)
+	}
+	versions, err := logical.CollectKeys(context.Background(), logical.NewStorageView(storage, path.Join(backend.storagePrefix, trashVersionsPrefix)+"/"))
+	if err != nil || len(versions) != 0 {
+		t.Fatalf("expected the trashed versions to be purged, err:%s versions:%#v", err, versions)
+	}
+
+	resp, err = restore()
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+}
@@ -0,0 +1,97 @@
+package kv
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathUpgradeStatus returns the path configuration for reporting the progress
+// of the upgrade from non-versioned to versioned data.
+func pathUpgradeStatus(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "upgrade/status$",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			// The status must be readable while the upgrade runs so it is
+			// not gated by upgradeCheck
+			logical.ReadOperation: b.pathUpgradeStatusRead(),
+		},
+
+		HelpSynopsis:    upgradeStatusHelpSyn,
+		HelpDescription: upgradeStatusHelpDesc,
+	}
+}
+
+func (b *versionedKVBackend) pathUpgradeStatusRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		upgradeInfo, err := b.getUpgradeInfo(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"upgrading": atomic.LoadUint32(b.upgrading) == 1,
+			},
+		}
+		if upgradeInfo == nil {
+			resp.Data["done"] = false
+			return resp, nil
+		}
+
+		errs := upgradeInfo.Errors
+		if errs == nil {
+			errs = []string{}
+		}
+		resp.Data["done"] = upgradeInfo.Done
+		resp.Data["started_time"] = ptypesTimestampToString(upgradeInfo.StartedTime)
+		resp.Data["resumed_time"] = ptypesTimestampToString(upgradeInfo.ResumedTime)
+		resp.Data["total"] = upgradeInfo.Total
+		resp.Data["processed"] = upgradeInfo.Processed
+		resp.Data["errors"] = errs
+
+		eta, err := upgradeETA(upgradeInfo, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		resp.Data["eta"] = eta.String()
+
+		return resp, nil
+	}
+}
+
+// upgradeETA estimates the time left before the upgrade completes from the
+// rate at which the current run of the upgrade has processed the keys. It is
+// zero if the upgrade is done or if no key was processed by the current run
+// yet.
+func upgradeETA(upgradeInfo *UpgradeInfo, now time.Time) (time.Duration, error) {
+	processed := upgradeInfo.Processed - upgradeInfo.ResumedProcessed
+	if upgradeInfo.Done || processed == 0 || upgradeInfo.ResumedTime == nil || upgradeInfo.Total <= upgradeInfo.Processed {
+		return 0, nil
+	}
+
+	resumed, err := ptypes.Timestamp(upgradeInfo.ResumedTime)
+	if err != nil {
+		return 0, err
+	}
+
+	perKey := now.Sub(resumed) / time.Duration(processed)
+	return (perKey * time.Duration(upgradeInfo.Total-upgradeInfo.Processed)).Round(time.Second), nil
+}
+
+const upgradeStatusHelpSyn = `Reports the progress of the upgrade to versioned data.`
+const upgradeStatusHelpDesc = `
+When a KV v1 mount is upgraded, its data is converted to the versioned format
+in the background and the other endpoints are unavailable until the upgrade
+completes. This endpoint reports whether the upgrade is running or done, the
+number of keys to upgrade and upgraded so far, the errors that stopped it and
+an estimate of the time left.
+
+An upgrade interrupted by a restart resumes where it stopped: "started_time"
+is when the upgrade first started and "resumed_time" when the current run
+started.
+`
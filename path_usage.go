@@ -0,0 +1,90 @@
+package kv
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// pathUsage returns the path configuration for reporting the storage used by
+// the keys under a prefix.
+func pathUsage(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "usage/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the keys to report the usage of.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathUsageRead()),
+		},
+
+		HelpSynopsis:    usageHelpSyn,
+		HelpDescription: usageHelpDesc,
+	}
+}
+
+// pathUsageRead returns the approximate number of bytes stored for the
+// metadata and the versions of the keys under a prefix. The size of the
+// versions is the one recorded when they were written, so the versions
+// themselves are not read.
+func (b *versionedKVBackend) pathUsageRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		keys, err := b.collectKeys(ctx, req.Storage, data.Get("path").(string))
+		if err != nil {
+			return nil, err
+		}
+
+		var metadataBytes, versionBytes uint64
+		var versions, unsized int
+		for _, key := range keys {
+			meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+			if err != nil {
+				return nil, err
+			}
+			if meta == nil {
+				continue
+			}
+
+			metadataBytes += uint64(proto.Size(meta))
+			for _, vm := range meta.Versions {
+				if vm.Destroyed {
+					continue
+				}
+				versions++
+				if vm.Size == 0 {
+					unsized++
+				}
+				versionBytes += vm.Size
+			}
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"keys":             len(keys),
+				"versions":         versions,
+				"metadata_bytes":   metadataBytes,
+				"version_bytes":    versionBytes,
+				"total_bytes":      metadataBytes + versionBytes,
+				"unsized_versions": unsized,
+			},
+		}, nil
+	}
+}
+
+const usageHelpSyn = `Reports the storage used by the secrets under a prefix.`
+const usageHelpDesc = `
+Reading "usage/<prefix>" returns the approximate number of bytes stored for
+the secrets under the prefix, descending into all the sub-folders:
+"metadata_bytes" for their metadata and "version_bytes" for their versions
+that are not destroyed.
+
+The size of a version is recorded in the metadata when it is written, so the
+versions are not read. "unsized_versions" counts the versions written before
+sizes were recorded, whose size is not included; their size is recorded by the
+backend when max_history_bytes applies to them.
+`
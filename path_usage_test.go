@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Usage(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, key := range []string{"apps/foo", "apps/foo", "apps/nested/bar", "other/baz"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"foo": "bar",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	readUsage := func(path string) map[string]interface{} {
+		t.Helper()
+
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "usage/" + path,
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp.Data
+	}
+
+	apps := readUsage("apps/")
+	if apps["keys"] != 2 || apps["versions"] != 3 || apps["unsized_versions"] != 0 {
+		t.Fatalf("unexpected usage: %#v", apps)
+	}
+	if apps["version_bytes"].(uint64) == 0 || apps["metadata_bytes"].(uint64) == 0 {
+		t.Fatalf("unexpected usage: %#v", apps)
+	}
+	if apps["total_bytes"] != apps["version_bytes"].(uint64)+apps["metadata_bytes"].(uint64) {
+		t.Fatalf("unexpected usage: %#v", apps)
+	}
+
+	all := readUsage("")
+	if all["keys"] != 3 || all["version_bytes"].(uint64) <= apps["version_bytes"].(uint64) {
+		t.Fatalf("unexpected usage: %#v", all)
+	}
+
+	// Destroyed versions are not counted
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "destroy/apps/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"versions": []int{1, 2},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	apps = readUsage("apps/")
+	if apps["versions"] != 1 {
+		t.Fatalf("unexpected usage: %#v", apps)
+	}
+}
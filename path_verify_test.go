@@ -0,0 +1,112 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Verify(t *testing.T) {
+	b, storage := getBackend(t)
+	kv := b.(*versionedKVBackend)
+	ctx := context.Background()
+
+	for _, key := range []string{"apps/live", "apps/missing", "apps/destroyed", "apps/destroyed", "apps/nested/corrupt", "other"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"foo": "bar",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	versionKey := func(key string, version uint64) string {
+		t.Helper()
+
+		versionKey, err := kv.getVersionKey(ctx, key, version, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return versionKey
+	}
+
+	if err := storage.Delete(ctx, versionKey("apps/missing", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := kv.getKeyMetadata(ctx, storage, "apps/destroyed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Versions[1].Destroyed = true
+	meta.Deleting = true
+	if err := kv.writeKeyMetadata(ctx, storage, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := proto.Marshal(&Version{Data: []byte("not json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: versionKey("apps/nested/corrupt", 1), Value: buf}); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(path string) map[string]interface{} {
+		t.Helper()
+
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "verify/" + path,
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp.Data
+	}
+
+	data := verify("apps")
+	if data["keys"] != 4 {
+		t.Fatalf("unexpected keys: %v", data["keys"])
+	}
+	problems := data["problems"].(map[string]interface{})
+	if len(problems) != 3 {
+		t.Fatalf("unexpected problems: %#v", problems)
+	}
+	if diff := deep.Equal(problems["apps/missing"], []string{"version 1 is missing from storage"}); diff != nil {
+		t.Fatal(diff)
+	}
+	if diff := deep.Equal(problems["apps/destroyed"], []string{
+		"the deletion of the key was interrupted",
+		"version 1 is destroyed but still stored",
+	}); diff != nil {
+		t.Fatal(diff)
+	}
+	if corrupt := problems["apps/nested/corrupt"].([]string); len(corrupt) != 1 {
+		t.Fatalf("unexpected problems: %#v", corrupt)
+	}
+
+	// Nothing was modified
+	raw, err := storage.Get(ctx, versionKey("apps/destroyed", 1))
+	if err != nil || raw == nil {
+		t.Fatalf("expected the destroyed version to still be stored, err:%s", err)
+	}
+
+	data = verify("")
+	if data["keys"] != 5 || len(data["problems"].(map[string]interface{})) != 3 {
+		t.Fatalf("unexpected verification: %#v", data)
+	}
+}
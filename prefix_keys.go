@@ -0,0 +1,450 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// prefixKeysPath is the path of the list of the top-level prefixes with
+	// a key policy of their own.
+	prefixKeysPath string = "prefix-keys"
+
+	// prefixesPrefix is the prefix where the key policy and the key metadata
+	// of the prefixes with a key policy of their own are stored, under the
+	// salted name of the prefix.
+	prefixesPrefix string = "prefixes/"
+)
+
+// keyEncryptor encrypts the paths of the key metadata. The metadata of the
+// secrets under a top-level prefix with a key policy of its own is stored
+// apart from the rest of the mount with that key policy, so that it can be
+// rotated without rewrapping the whole mount.
+type keyEncryptor struct {
+	// root encrypts the paths with the key policy of the mount, unless the
+	// storage layout has deterministic paths.
+	root       keyStorageWrapper
+	rootPolicy *keysutil.Policy
+
+	// archived encrypts the paths with the version of the key policy of the
+	// mount the metadata is rewrapped from, while a rewrap is in progress.
+	// The metadata not rewrapped yet is read from it and moved to root when
+	// written.
+	archived *keysutil.EncryptedKeyStorageWrapper
+
+	// prefixes maps the top-level prefixes with a key policy of their own to
+	// the wrapper encrypting the paths below them.
+	prefixes        map[string]*keysutil.EncryptedKeyStorageWrapper
+	prefixPolicies  map[string]*keysutil.Policy
+	orderedPrefixes []string
+}
+
+// keyStorageWrapper returns the storage the key metadata is stored in.
+type keyStorageWrapper interface {
+	Wrap(s logical.Storage) logical.Storage
+}
+
+// Wrap returns a storage encrypting the paths of the key metadata with the
+// key policy of their top-level prefix.
+func (e *keyEncryptor) Wrap(s logical.Storage) logical.Storage {
+	return &prefixKeyStorage{
+		e: e,
+		s: s,
+	}
+}
+
+// prefixOf returns the top-level prefix of key if it has a key policy of its
+// own, and the path of key below it.
+func (e *keyEncryptor) prefixOf(key string) (string, string, bool) {
+	i := strings.Index(key, "/")
+	if i < 0 {
+		return "", "", false
+	}
+	prefix := key[:i]
+	if _, ok := e.prefixes[prefix]; !ok {
+		return "", "", false
+	}
+	return prefix, key[i+1:], true
+}
+
+// policy returns the key policy protecting the metadata of key.
+func (e *keyEncryptor) policy(key string) *keysutil.Policy {
+	if prefix, _, ok := e.prefixOf(key); ok {
+		return e.prefixPolicies[prefix]
+	}
+	return e.rootPolicy
+}
+
+// prefixKeyStorage routes the operations on the key metadata to the wrapper
+// of their top-level prefix.
+type prefixKeyStorage struct {
+	e *keyEncryptor
+	s logical.Storage
+}
+
+// route returns the storage holding key and the path of key in it. The
+// archived storage is returned along with the root one while the metadata of
+// the mount is rewrapped, or nil.
+func (p *prefixKeyStorage) route(key string) (logical.Storage, logical.Storage, string) {
+	if prefix, rest, ok := p.e.prefixOf(key); ok {
+		return p.e.prefixes[prefix].Wrap(p.s), nil, rest
+	}
+	if p.e.archived != nil {
+		return p.e.root.Wrap(p.s), p.e.archived.Wrap(p.s), key
+	}
+	return p.e.root.Wrap(p.s), nil, key
+}
+
+func (p *prefixKeyStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	s, archived, rest := p.route(prefix)
+	keys, err := s.List(ctx, rest)
+	if err != nil {
+		return nil, err
+	}
+
+	// The folders encrypted with the previous version of the key policy are
+	// distinct from the ones encrypted with the new one
+	if archived != nil {
+		entries, err := archived.List(ctx, rest)
+		if err != nil {
+			return nil, err
+		}
+		keys = strutil.RemoveDuplicates(append(keys, entries...), false)
+	}
+
+	if prefix != "" && prefix != "/" {
+		return keys, nil
+	}
+
+	// The prefixes with a key policy of their own are not stored under the
+	// root of the metadata
+	for _, name := range p.e.orderedPrefixes {
+		entries, err := p.e.prefixes[name].Wrap(p.s).List(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 && !strutil.StrListContains(keys, name+"/") {
+			keys = append(keys, name+"/")
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (p *prefixKeyStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	s, archived, rest := p.route(key)
+	entry, err := s.Get(ctx, rest)
+	if err != nil || entry != nil || archived == nil {
+		return entry, err
+	}
+	return archived.Get(ctx, rest)
+}
+
+// Put writes the entry with the new version of the key policy. The entry
+// stored with the previous version is deleted so that writing the metadata
+// during a rewrap moves it, the caller holding the lock of the key.
+func (p *prefixKeyStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	s, archived, rest := p.route(entry.Key)
+	e := &logical.StorageEntry{}
+	*e = *entry
+	e.Key = rest
+	if err := s.Put(ctx, e); err != nil {
+		return err
+	}
+	if archived != nil {
+		return archived.Delete(ctx, rest)
+	}
+	return nil
+}
+
+func (p *prefixKeyStorage) Delete(ctx context.Context, key string) error {
+	s, archived, rest := p.route(key)
+	if err := s.Delete(ctx, rest); err != nil {
+		return err
+	}
+	if archived != nil {
+		return archived.Delete(ctx, rest)
+	}
+	return nil
+}
+
+// getPrefixKeys returns the top-level prefixes with a key policy of their
+// own.
+func (b *versionedKVBackend) getPrefixKeys(ctx context.Context, s logical.Storage) ([]string, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, prefixKeysPath))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	prefixKeys := &PrefixKeys{}
+	if err := proto.Unmarshal(raw.Value, prefixKeys); err != nil {
+		return nil, err
+	}
+
+	return prefixKeys.Prefixes, nil
+}
+
+// writePrefixKeys writes the top-level prefixes with a key policy of their
+// own to storage.
+func (b *versionedKVBackend) writePrefixKeys(ctx context.Context, s logical.Storage, prefixes []string) error {
+	buf, err := proto.Marshal(&PrefixKeys{
+		Prefixes: prefixes,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, prefixKeysPath),
+		Value: buf,
+	})
+}
+
+// prefixStoragePrefix returns the storage prefix of the key policy and of the
+// key metadata of prefix.
+func (b *versionedKVBackend) prefixStoragePrefix(ctx context.Context, s logical.Storage, prefix string) (string, error) {
+	salt, err := b.Salt(ctx, s)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(b.storagePrefix, prefixesPrefix, salt.SaltID(prefix)) + "/", nil
+}
+
+// newPrefixPolicy returns a new key policy for the metadata stored under
+// storagePrefix.
+func (b *versionedKVBackend) newPrefixPolicy(storagePrefix string) *keysutil.Policy {
+	return keysutil.NewPolicy(keysutil.PolicyConfig{
+		Name:                 "metadata",
+		Type:                 keysutil.KeyType_AES256_GCM96,
+		Derived:              true,
+		KDF:                  keysutil.Kdf_hkdf_sha256,
+		ConvergentEncryption: true,
+		StoragePrefix:        storagePrefix,
+		VersionTemplate:      keysutil.EncryptedKeyPolicyVersionTpl,
+	})
+}
+
+// loadPrefixPolicy loads the key policy of the prefix whose storage prefix
+// is storagePrefix.
+func (b *versionedKVBackend) loadPrefixPolicy(ctx context.Context, s logical.Storage, storagePrefix string) (*keysutil.Policy, error) {
+	policy, err := keysutil.LoadPolicy(ctx, s, path.Join(storagePrefix, "policy/metadata"))
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("key policy of %q not found", storagePrefix)
+	}
+
+	return policy, nil
+}
+
+// newPrefixWrapper returns the wrapper encrypting the paths of the metadata
+// stored under storagePrefix with policy.
+func newPrefixWrapper(storagePrefix string, policy *keysutil.Policy) (*keysutil.EncryptedKeyStorageWrapper, error) {
+	return keysutil.NewEncryptedKeyStorageWrapper(keysutil.EncryptedKeyStorageConfig{
+		Policy: policy,
+		Prefix: path.Join(storagePrefix, metadataPrefix),
+	})
+}
+
+// loadPrefixWrappers adds the wrappers of the prefixes with a key policy of
+// their own to e. The salt is given by the caller as it holds the backend
+// lock.
+func (b *versionedKVBackend) loadPrefixWrappers(ctx context.Context, s logical.Storage, salt *salt.Salt, e *keyEncryptor) error {
+	prefixes, err := b.getPrefixKeys(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	e.prefixes = make(map[string]*keysutil.EncryptedKeyStorageWrapper, len(prefixes))
+	e.prefixPolicies = make(map[string]*keysutil.Policy, len(prefixes))
+	for _, prefix := range prefixes {
+		storagePrefix := path.Join(b.storagePrefix, prefixesPrefix, salt.SaltID(prefix)) + "/"
+		policy, err := b.loadPrefixPolicy(ctx, s, storagePrefix)
+		if err != nil {
+			return err
+		}
+		wrapper, err := newPrefixWrapper(storagePrefix, policy)
+		if err != nil {
+			return err
+		}
+
+		e.prefixes[prefix] = wrapper
+		e.prefixPolicies[prefix] = policy
+		e.orderedPrefixes = append(e.orderedPrefixes, prefix)
+	}
+	sort.Strings(e.orderedPrefixes)
+
+	return nil
+}
+
+// resetKeyEncryptor drops the cached key encryptor and the metadata
+// decrypted with it.
+func (b *versionedKVBackend) resetKeyEncryptor() {
+	b.l.Lock()
+	b.keyEncryptedWrapper = nil
+	b.l.Unlock()
+	b.metadataCache.purge()
+}
+
+// createPrefixKey creates a key policy of its own for the top-level prefix.
+// The caller must make sure that no secret is stored under the prefix as the
+// existing metadata is not moved.
+func (b *versionedKVBackend) createPrefixKey(ctx context.Context, s logical.Storage, prefix string) error {
+	storagePrefix, err := b.prefixStoragePrefix(ctx, s, prefix)
+	if err != nil {
+		return err
+	}
+	if err := b.newPrefixPolicy(storagePrefix).Rotate(ctx, s, b.GetRandomReader()); err != nil {
+		return err
+	}
+
+	prefixes, err := b.getPrefixKeys(ctx, s)
+	if err != nil {
+		return err
+	}
+	if err := b.writePrefixKeys(ctx, s, append(prefixes, prefix)); err != nil {
+		return err
+	}
+
+	b.resetKeyEncryptor()
+	return nil
+}
+
+// rotatePrefixKey rotates the key policy of the top-level prefix and moves
+// the metadata stored under it to the paths derived from the new version of
+// the key, then retires the previous versions. The metadata of the rest of
+// the mount is left untouched.
+func (b *versionedKVBackend) rotatePrefixKey(ctx context.Context, s logical.Storage, prefix string) (*keysutil.Policy, error) {
+	e, err := b.getKeyEncryptor(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	oldWrapper, ok := e.prefixes[prefix]
+	if !ok {
+		return nil, nil
+	}
+
+	keys, err := collectWrappedKeys(ctx, oldWrapper.Wrap(s), "")
+	if err != nil {
+		return nil, err
+	}
+
+	storagePrefix, err := b.prefixStoragePrefix(ctx, s, prefix)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := b.loadPrefixPolicy(ctx, s, storagePrefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.RotateInMemory(b.GetRandomReader()); err != nil {
+		return nil, err
+	}
+	newWrapper, err := newPrefixWrapper(storagePrefix, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	fullKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fullKeys[i] = prefix + "/" + key
+	}
+	for _, lock := range locksutil.LocksForKeys(b.locks, fullKeys) {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	// The rotated key policy is persisted before the metadata is moved so
+	// that the metadata is never stored under a version of the key that was
+	// not persisted. The previous versions are only retired once every key
+	// has been moved.
+	if err := policy.Persist(ctx, s); err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		entry, err := oldWrapper.Wrap(s).Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+
+		value := entry.Value
+		meta := &KeyMetadata{}
+		if err := proto.Unmarshal(value, meta); err != nil {
+			return nil, fmt.Errorf("failed to decode key metadata from storage: %v", err)
+		}
+		if meta.EncryptedCustomMetadata != "" {
+			if err := b.decryptCustomMetadata(policy, meta); err != nil {
+				return nil, fmt.Errorf("%s: %w", fullKeys[i], err)
+			}
+			if err := b.encryptCustomMetadata(policy, meta); err != nil {
+				return nil, fmt.Errorf("%s: %w", fullKeys[i], err)
+			}
+			if value, err = proto.Marshal(meta); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := newWrapper.Wrap(s).Put(ctx, &logical.StorageEntry{
+			Key:      key,
+			Value:    value,
+			SealWrap: entry.SealWrap,
+		}); err != nil {
+			return nil, err
+		}
+		if err := oldWrapper.Wrap(s).Delete(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+
+	policy.MinDecryptionVersion = policy.LatestVersion
+	if err := policy.Persist(ctx, s); err != nil {
+		return nil, err
+	}
+
+	b.resetKeyEncryptor()
+	return policy, nil
+}
+
+// collectWrappedKeys returns the keys of every entry stored under prefix in
+// s, descending into all the sub-folders.
+func collectWrappedKeys(ctx context.Context, s logical.Storage, prefix string) ([]string, error) {
+	entries, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry, "/") {
+			keys = append(keys, prefix+entry)
+			continue
+		}
+
+		sub, err := collectWrappedKeys(ctx, s, prefix+entry)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, sub...)
+	}
+
+	return keys, nil
+}
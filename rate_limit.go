@@ -0,0 +1,129 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// rateLimiter is a token bucket allowing rate requests per second, in bursts
+// of up to one second of requests.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, now time.Time) *rateLimiter {
+	burst := math.Max(rate, 1)
+	return &rateLimiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// allow takes a token from the bucket at now. If none is available, false is
+// returned along with the time to wait for the next one.
+func (l *rateLimiter) allow(now time.Time) (bool, time.Duration) {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+elapsed.Seconds()*l.rate)
+		l.last = now
+	}
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// takeRateLimitToken takes a token from the limiter of the operation on the
+// keys under prefix, creating it if it does not exist or if its rate changed.
+func (b *versionedKVBackend) takeRateLimitToken(operation, prefix string, rate float64, now time.Time) (bool, time.Duration) {
+	b.rateLimitersLock.Lock()
+	defer b.rateLimitersLock.Unlock()
+
+	id := operation + "|" + prefix
+	limiter, ok := b.rateLimiters[id]
+	if !ok || limiter.rate != rate {
+		limiter = newRateLimiter(rate, now)
+		b.rateLimiters[id] = limiter
+	}
+
+	return limiter.allow(now)
+}
+
+// rateLimit rejects the requests over the read_rate_limit, or the
+// write_rate_limit if write is true, of the longest prefix configured for the
+// key of the request with a 429 error.
+func (b *versionedKVBackend) rateLimit(write bool, next framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := b.checkRateLimit(config, data.Get("path").(string), write); err != nil {
+			return nil, err
+		}
+
+		return next(ctx, req, data)
+	}
+}
+
+// checkRateLimit takes a token from the read_rate_limit, or the
+// write_rate_limit if write is true, of the longest prefix configured for key
+// and returns a 429 error if none is available.
+func (b *versionedKVBackend) checkRateLimit(config *Configuration, key string, write bool) error {
+	prefix, pc := keyPathConfig(config, key)
+	if pc == nil {
+		return nil
+	}
+
+	operation, rate := "read", pc.ReadRateLimit
+	if write {
+		operation, rate = "write", pc.WriteRateLimit
+	}
+	if rate <= 0 {
+		return nil
+	}
+
+	if ok, wait := b.takeRateLimitToken(operation, prefix, rate, time.Now()); !ok {
+		metrics.IncrCounterWithLabels([]string{"kv", "rate_limit", "exceeded"}, 1, []metrics.Label{
+			{Name: "operation", Value: operation},
+		})
+		return logical.CodedError(http.StatusTooManyRequests, fmt.Sprintf("the %s rate limit of %g per second of the keys under %q is exceeded, retry in %s", operation, rate, prefix, wait.Round(time.Millisecond)))
+	}
+
+	return nil
+}
+
+// checkRateLimitOnce applies checkRateLimit to key unless the request already
+// took a token from the limit of its prefix, as recorded in limited. A request
+// reading or writing several keys counts as one operation under each prefix
+// it reaches.
+func (b *versionedKVBackend) checkRateLimitOnce(config *Configuration, limited map[string]bool, key string, write bool) error {
+	prefix, _ := keyPathConfig(config, key)
+	if limited[prefix] {
+		return nil
+	}
+	limited[prefix] = true
+
+	return b.checkRateLimit(config, key, write)
+}
+
+// limitedPrefixes returns the record of the prefixes limited by a request that
+// already took a token for key, to be passed to checkRateLimitOnce.
+func limitedPrefixes(config *Configuration, key string) map[string]bool {
+	prefix, _ := keyPathConfig(config, key)
+	return map[string]bool{prefix: true}
+}
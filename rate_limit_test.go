@@ -0,0 +1,178 @@
+package kv
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(2, now)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.allow(now); !ok {
+			t.Fatalf("expected request %d of the burst to be allowed", i)
+		}
+	}
+
+	ok, wait := limiter.allow(now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected the request to wait 500ms, got allowed %t and wait %s", ok, wait)
+	}
+
+	if ok, _ := limiter.allow(now.Add(wait)); !ok {
+		t.Fatal("expected the request to be allowed once a token is available")
+	}
+}
+
+func TestVersionedKV_RateLimit(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/path/hot/",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"write_rate_limit": 0.001,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	write := func(path string) error {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		})
+		if err == nil && (resp == nil || resp.IsError()) {
+			t.Fatalf("unexpected response: %#v", resp)
+		}
+		return err
+	}
+
+	if err := write("data/hot/foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The limit is shared by the keys under the prefix
+	err = write("data/hot/bar")
+	codedErr, ok := err.(logical.HTTPCodedError)
+	if !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got %v", err)
+	}
+
+	if err := write("data/cold/foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads are limited separately
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/hot/foo",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data = map[string]interface{}{
+		"read_rate_limit": -1,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected a negative limit to be rejected, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_RateLimit_ResolvedKeys(t *testing.T) {
+	b, storage := getBackend(t)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/hot/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config/composite/apps/foo",
+			Data: map[string]interface{}{
+				"sources": "hot/foo",
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"readonly_mirrors": map[string]interface{}{
+					"public/": "hot/",
+				},
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config/path/hot/",
+			Data: map[string]interface{}{
+				"read_rate_limit":  0.001,
+				"write_rate_limit": 0.001,
+			},
+		},
+	}
+	for _, req := range requests {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) error {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err == nil && (resp == nil || resp.IsError()) {
+			t.Fatalf("unexpected response: %#v", resp)
+		}
+		return err
+	}
+
+	// The first read takes the only token of the prefix, the reads through
+	// the composite and the mirror share it
+	if err := handle(logical.ReadOperation, "composite/apps/foo", nil); err != nil {
+		t.Fatal(err)
+	}
+	err := handle(logical.ReadOperation, "readonly-mirror/public/foo", nil)
+	if codedErr, ok := err.(logical.HTTPCodedError); !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got %v", err)
+	}
+
+	if err := handle(logical.UpdateOperation, "rename-key/hot/foo", map[string]interface{}{"from": "bar", "to": "qux"}); err != nil {
+		t.Fatal(err)
+	}
+	err = handle(logical.UpdateOperation, "inject-field/hot/", map[string]interface{}{"key": "env", "value": "prod"})
+	if codedErr, ok := err.(logical.HTTPCodedError); !ok || codedErr.Code() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 error, got %v", err)
+	}
+}
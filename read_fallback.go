@@ -0,0 +1,47 @@
+package kv
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+)
+
+// readFallbackSchema is the field of the data endpoint overriding the
+// read_fallback of the configuration.
+var readFallbackSchema = &framework.FieldSchema{
+	Type:        framework.TypeBool,
+	Description: "If set during a read, overrides the read_fallback of the backend's configuration.",
+}
+
+// readFallback returns true if a read of a key whose current version is
+// deleted or destroyed must return its newest readable version instead.
+func readFallback(config *Configuration, data *framework.FieldData) bool {
+	if raw, ok := data.GetOk("read_fallback"); ok {
+		return raw.(bool)
+	}
+	return config.ReadFallback
+}
+
+// latestReadableVersion returns the number of the newest version of the key
+// older than its current version that is neither deleted nor destroyed at
+// now, or zero if there is none.
+func latestReadableVersion(meta *KeyMetadata, now time.Time) (uint64, error) {
+	var latest uint64
+	for verNum, vm := range meta.Versions {
+		if verNum >= meta.CurrentVersion || verNum <= latest || vm.Destroyed {
+			continue
+		}
+		if vm.DeletionTime != nil && !vm.DeletionDeferred {
+			deletionTime, err := ptypes.Timestamp(vm.DeletionTime)
+			if err != nil {
+				return 0, err
+			}
+			if deletionTime.Before(now) {
+				continue
+			}
+		}
+		latest = verNum
+	}
+	return latest, nil
+}
@@ -0,0 +1,114 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Data_Get_ReadFallback(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for _, value := range []string{"v1", "v2", "v3"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": value,
+				},
+			},
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "destroy/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"versions": "2",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	read := func(data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	// The fallback is opt-in
+	resp = read(nil)
+	if resp.Data["http_status_code"] != 404 {
+		t.Fatalf("expected a 404 without the fallback, resp: %#v", resp)
+	}
+
+	// The destroyed version 2 is skipped
+	resp = read(map[string]interface{}{"read_fallback": true})
+	if resp.Data["data"].(map[string]interface{})["bar"] != "v1" || len(resp.Warnings) != 1 {
+		t.Fatalf("expected version 1 with a warning, resp: %#v", resp)
+	}
+	if resp.Data["metadata"].(map[string]interface{})["version"] != uint64(1) {
+		t.Fatalf("expected the metadata of version 1, resp: %#v", resp)
+	}
+
+	// Explicit versions are not replaced
+	resp = read(map[string]interface{}{"read_fallback": true, "version": 3})
+	if resp.Data["http_status_code"] != 404 {
+		t.Fatalf("expected a 404 for an explicit version, resp: %#v", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"read_fallback": true,
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp = read(nil)
+	if resp.Data["data"].(map[string]interface{})["bar"] != "v1" {
+		t.Fatalf("expected version 1 with the configured fallback, resp: %#v", resp)
+	}
+
+	resp = read(map[string]interface{}{"read_fallback": false})
+	if resp.Data["http_status_code"] != 404 {
+		t.Fatalf("expected a 404 when the fallback is overridden, resp: %#v", resp)
+	}
+}
@@ -0,0 +1,156 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// trackReadsWarning is returned when track_reads is enabled.
+const trackReadsWarning = "track_reads is best-effort: the reads served by performance standbys and secondaries are not recorded, and the reads not recorded yet are lost when the node restarts or steps down"
+
+// pendingRead holds the data reads of a key that have not been written to its
+// metadata yet.
+type pendingRead struct {
+	lastReadTime time.Time
+
+	// readCounts maps the versions of the key to their number of reads.
+	readCounts map[uint64]uint64
+}
+
+// readCount returns the number of pending reads of version. It is safe to
+// call on a nil pendingRead.
+func (p *pendingRead) readCount(version uint64) uint64 {
+	if p == nil {
+		return 0
+	}
+	return p.readCounts[version]
+}
+
+// lastRead returns the time of the most recent data read of the key, the
+// pending read if any or the one recorded in meta. It is safe to call on a
+// nil pendingRead.
+func (p *pendingRead) lastRead(meta *KeyMetadata) string {
+	if p == nil {
+		return ptypesTimestampToString(meta.LastReadTime)
+	}
+	return p.lastReadTime.UTC().Format(time.RFC3339Nano)
+}
+
+// merge adds the reads of other to p.
+func (p *pendingRead) merge(other *pendingRead) {
+	if other.lastReadTime.After(p.lastReadTime) {
+		p.lastReadTime = other.lastReadTime
+	}
+	for version, count := range other.readCounts {
+		p.readCounts[version] += count
+	}
+}
+
+// recordRead notes a data read of a version of key when track_reads is set on
+// the mount. The reads are kept in memory and written to the key metadata by
+// flushReads, so that reads do not write to the storage.
+func (b *versionedKVBackend) recordRead(config *Configuration, key string, version uint64) {
+	// Performance secondaries and standbys cannot write the metadata
+	if !config.TrackReads || b.perfSecondaryCheck() {
+		return
+	}
+
+	b.readsLock.Lock()
+	defer b.readsLock.Unlock()
+
+	pending, ok := b.reads[key]
+	if !ok {
+		pending = &pendingRead{
+			readCounts: make(map[uint64]uint64),
+		}
+		b.reads[key] = pending
+	}
+	pending.lastReadTime = time.Now()
+	pending.readCounts[version]++
+}
+
+// pendingReadOf returns a copy of the reads of key not yet written to its
+// metadata, or nil if there are none.
+func (b *versionedKVBackend) pendingReadOf(key string) *pendingRead {
+	b.readsLock.Lock()
+	defer b.readsLock.Unlock()
+
+	pending, ok := b.reads[key]
+	if !ok {
+		return nil
+	}
+	copied := &pendingRead{
+		readCounts: make(map[uint64]uint64, len(pending.readCounts)),
+	}
+	copied.merge(pending)
+	return copied
+}
+
+// flushReads writes the pending reads to the metadata of the keys. The reads
+// of the keys that failed to be written are kept for the next run.
+func (b *versionedKVBackend) flushReads(ctx context.Context, s logical.Storage) error {
+	b.readsLock.Lock()
+	reads := b.reads
+	b.reads = make(map[string]*pendingRead)
+	b.readsLock.Unlock()
+
+	for key, pending := range reads {
+		if err := b.flushRead(ctx, s, key, pending); err != nil {
+			b.restoreReads(reads)
+			return err
+		}
+		delete(reads, key)
+	}
+
+	return nil
+}
+
+// flushRead writes the pending reads of key to its metadata.
+func (b *versionedKVBackend) flushRead(ctx context.Context, s logical.Storage, key string, pending *pendingRead) error {
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	// The key may have been deleted since it was read
+	if meta == nil || meta.Deleting {
+		return nil
+	}
+
+	lastReadTime, err := ptypes.TimestampProto(pending.lastReadTime)
+	if err != nil {
+		return err
+	}
+	meta.LastReadTime = lastReadTime
+
+	// The reads of the versions removed since are dropped
+	for version, count := range pending.readCounts {
+		if vm, ok := meta.Versions[version]; ok {
+			vm.ReadCount += count
+		}
+	}
+
+	return b.writeKeyMetadata(ctx, s, meta)
+}
+
+// restoreReads merges reads back into the pending reads.
+func (b *versionedKVBackend) restoreReads(reads map[string]*pendingRead) {
+	b.readsLock.Lock()
+	defer b.readsLock.Unlock()
+
+	for key, pending := range reads {
+		current, ok := b.reads[key]
+		if !ok {
+			b.reads[key] = pending
+			continue
+		}
+		current.merge(pending)
+	}
+}
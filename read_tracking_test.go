@@ -0,0 +1,154 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_TrackReads(t *testing.T) {
+	b, storage := getBackend(t)
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"track_reads": true,
+			},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		},
+	}
+	for _, req := range requests {
+		req.Storage = storage
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		// Enabling track_reads warns that the reads are best-effort
+		if req.Path == "config" && (resp == nil || len(resp.Warnings) == 0) {
+			t.Fatalf("expected a warning, resp:%#v\n", resp)
+		}
+	}
+
+	readMetadata := func() *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metadata/foo",
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp
+	}
+
+	if resp := readMetadata(); resp.Data["last_read_time"] != "" {
+		t.Fatalf("expected no last_read_time, got %#v", resp.Data["last_read_time"])
+	}
+
+	for i := 0; i < 2; i++ {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	readCount := func(resp *logical.Response) interface{} {
+		return resp.Data["versions"].(map[string]interface{})["1"].(map[string]interface{})["read_count"]
+	}
+
+	// The pending reads are returned before they are written to the metadata
+	resp := readMetadata()
+	pending := resp.Data["last_read_time"]
+	if pending == "" {
+		t.Fatal("expected a last_read_time")
+	}
+	if readCount(resp) != uint64(2) {
+		t.Fatalf("expected a read_count of 2, got %#v", readCount(resp))
+	}
+
+	meta, err := b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.LastReadTime != nil {
+		t.Fatalf("expected the read not to be written yet, got %s", meta.LastReadTime)
+	}
+
+	req := &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err = b.(*versionedKVBackend).getKeyMetadata(context.Background(), storage, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ptypesTimestampToString(meta.LastReadTime) != pending {
+		t.Fatalf("expected last_read_time %s, got %s", pending, ptypesTimestampToString(meta.LastReadTime))
+	}
+	if meta.Versions[1].ReadCount != 2 {
+		t.Fatalf("expected a read_count of 2, got %d", meta.Versions[1].ReadCount)
+	}
+
+	resp = readMetadata()
+	if resp.Data["last_read_time"] != pending {
+		t.Fatalf("expected last_read_time %s, got %#v", pending, resp.Data["last_read_time"])
+	}
+	if readCount(resp) != uint64(2) {
+		t.Fatalf("expected a read_count of 2, got %#v", readCount(resp))
+	}
+}
+
+func TestVersionedKV_TrackReads_Disabled(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if pending := b.(*versionedKVBackend).pendingReadOf("foo"); pending != nil {
+		t.Fatalf("expected no pending read, got %#v", pending)
+	}
+}
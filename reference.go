@@ -0,0 +1,168 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// errUnreadableSource is returned when a secret whose data is combined with
+// the data of others cannot be read by the request.
+var errUnreadableSource = errors.New("cannot read")
+
+// maxReferenceDepth is the largest number of references followed by a data
+// read before it fails.
+const maxReferenceDepth = 8
+
+// checkReferenceTarget returns an error if the secret at key cannot point to
+// target, because target is not a valid path or because it does not match
+// the reference_targets patterns of the mount.
+func checkReferenceTarget(config *Configuration, key, target string) error {
+	switch {
+	case target == "" || strings.HasSuffix(target, "/") || strings.HasPrefix(target, "/"):
+		return fmt.Errorf("invalid reference %q", target)
+	case target == key:
+		return fmt.Errorf("%q cannot reference itself", key)
+	}
+
+	for _, pattern := range config.ReferenceTargets {
+		if matchPathPattern(pattern, target) {
+			return nil
+		}
+	}
+	return fmt.Errorf("referencing %q is not allowed by the reference_targets of the backend configuration", target)
+}
+
+// resolveReference returns the data read of the secret the reference at key
+// points to, following the references it reaches until a secret that is not
+// a reference. The read fails if a reference is reached twice or if more than
+// maxReferenceDepth references are followed.
+func (b *versionedKVBackend) resolveReference(ctx context.Context, req *logical.Request, data *framework.FieldData, key, target string) (*logical.Response, error) {
+	if _, ok := data.GetOk("if_newer_than_version"); ok {
+		return logical.ErrorResponse("if_newer_than_version cannot be used when following a reference"), logical.ErrInvalidRequest
+	}
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// The data read of key already took a token from the limit of its prefix
+	limited := limitedPrefixes(config, key)
+
+	chain := []string{key}
+	for {
+		for _, k := range chain {
+			if k == target {
+				return logical.ErrorResponse("reference loop: %s", strings.Join(append(chain, target), " -> ")), logical.ErrInvalidRequest
+			}
+		}
+		if len(chain) > maxReferenceDepth {
+			return logical.ErrorResponse("more than %d references followed from %q", maxReferenceDepth, key), logical.ErrInvalidRequest
+		}
+
+		// The patterns are checked again in case they changed since the
+		// reference was written
+		if err := checkReferenceTarget(config, chain[len(chain)-1], target); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+		chain = append(chain, target)
+
+		if err := b.checkRateLimitOnce(config, limited, target, false); err != nil {
+			return nil, err
+		}
+
+		resp, next, err := b.readData(ctx, req, data, target, true)
+		if err != nil || next == "" {
+			if resp != nil && resp.Data["data"] != nil {
+				resp.Data["resolved_path"] = target
+			}
+			return resp, err
+		}
+		target = next
+	}
+}
+
+// resolvedCurrentVersionData returns the data and the number of the current
+// version of key, following the references it reaches. The data is nil if the
+// key has no readable current version. The keys reached take a token from the
+// read_rate_limit of their prefix, once per prefix recorded in limited.
+func (b *versionedKVBackend) resolvedCurrentVersionData(ctx context.Context, req *logical.Request, config *Configuration, limited map[string]bool, key string) (map[string]interface{}, uint64, error) {
+	chain := []string{key}
+	for {
+		if err := b.checkRateLimitOnce(config, limited, key, false); err != nil {
+			return nil, 0, err
+		}
+
+		vData, verNum, target, err := b.currentVersionData(ctx, req, config, key)
+		if err != nil || target == "" {
+			return vData, verNum, err
+		}
+
+		for _, k := range chain {
+			if k == target {
+				return nil, 0, fmt.Errorf("%w %q: reference loop: %s", errUnreadableSource, chain[0], strings.Join(append(chain, target), " -> "))
+			}
+		}
+		if len(chain) > maxReferenceDepth {
+			return nil, 0, fmt.Errorf("%w %q: more than %d references followed", errUnreadableSource, chain[0], maxReferenceDepth)
+		}
+		if err := checkReferenceTarget(config, key, target); err != nil {
+			return nil, 0, fmt.Errorf("%w %q: %s", errUnreadableSource, chain[0], err)
+		}
+		chain = append(chain, target)
+		key = target
+	}
+}
+
+// currentVersionData returns the data and the number of the current version
+// of key, or the path it points to if it is a reference. The data is nil if
+// the key has no readable current version.
+func (b *versionedKVBackend) currentVersionData(ctx context.Context, req *logical.Request, config *Configuration, key string) (map[string]interface{}, uint64, string, error) {
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if meta == nil || meta.Deleting {
+		return nil, 0, "", nil
+	}
+
+	expired, err := isExpired(meta)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	vm := meta.Versions[meta.CurrentVersion]
+	if expired || vm == nil || vm.Destroyed {
+		return nil, 0, "", nil
+	}
+	if deleted, err := isDeleted(vm); err != nil || deleted {
+		return nil, 0, "", err
+	}
+
+	if vm.Reference != "" {
+		return nil, 0, vm.Reference, nil
+	}
+
+	// The response wrapping required by the key is not bypassed
+	if err := checkResponseWrapping(meta, requestWrapTTL(req)); err != nil {
+		return nil, 0, "", fmt.Errorf("%w %q: %s", errUnreadableSource, key, err)
+	}
+
+	vData, err := b.readVersionData(ctx, req.Storage, key, meta.CurrentVersion)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	recordVersionSize("read", vm)
+	b.recordRead(config, key, meta.CurrentVersion)
+
+	return vData, meta.CurrentVersion, "", nil
+}
@@ -0,0 +1,192 @@
+package kv
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCheckReferenceTarget(t *testing.T) {
+	config := &Configuration{
+		ReferenceTargets: []string{"shared/*"},
+	}
+
+	for _, tc := range []struct {
+		key, target string
+		valid       bool
+	}{
+		{"apps/foo/db", "shared/db", true},
+		{"apps/foo/db", "other/db", false},
+		{"apps/foo/db", "shared/", false},
+		{"apps/foo/db", "", false},
+		{"shared/db", "shared/db", false},
+	} {
+		if err := checkReferenceTarget(config, tc.key, tc.target); (err == nil) != tc.valid {
+			t.Fatalf("unexpected result for %q -> %q: %v", tc.key, tc.target, err)
+		}
+	}
+
+	if err := checkReferenceTarget(&Configuration{}, "apps/foo/db", "shared/db"); err == nil {
+		t.Fatal("expected references to be disabled without reference_targets")
+	}
+}
+
+func TestVersionedKV_References(t *testing.T) {
+	b, storage := getBackend(t)
+
+	write := func(path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+	read := func(path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	resp, err := write("data/apps/foo/db", map[string]interface{}{"reference": "shared/db"})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected references to be disabled, err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = write("config", map[string]interface{}{"reference_targets": "shared/*,apps/*"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = write("data/shared/db", map[string]interface{}{
+		"data": map[string]interface{}{
+			"password": "hunter2",
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = write("data/apps/foo/db", map[string]interface{}{"reference": "shared/db"})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Reads follow the reference
+	resp, err = read("data/apps/foo/db", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data"].(map[string]interface{})["password"] != "hunter2" || resp.Data["resolved_path"] != "shared/db" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	// and the target can be updated without changing the reference
+	resp, err = write("data/shared/db", map[string]interface{}{
+		"data": map[string]interface{}{
+			"password": "correct horse",
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = read("data/apps/foo/db", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data"].(map[string]interface{})["password"] != "correct horse" {
+		t.Fatalf("unexpected data: %#v", resp.Data["data"])
+	}
+	if version := resp.Data["metadata"].(map[string]interface{})["version"]; version != uint64(2) {
+		t.Fatalf("expected the metadata of the target, got version %v", version)
+	}
+
+	// The reference itself can be read
+	resp, err = read("data/apps/foo/db", map[string]interface{}{"return_reference": true})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["data"] != nil || resp.Data["metadata"].(map[string]interface{})["reference"] != "shared/db" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	// A reference cannot be patched
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.PatchOperation,
+		Path:      "data/apps/foo/db",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"password": "foo",
+			},
+		},
+	})
+	if err != logical.ErrInvalidRequest || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the patch to be rejected, err:%s resp:%#v\n", err, resp)
+	}
+
+	// Exporting and importing the reference keeps it
+	resp, err = read("export/apps/foo", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	keys := map[string]interface{}{}
+	for k, v := range resp.Data["keys"].(map[string]*exportedKey) {
+		keys[k] = v
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "import/copy",
+		Storage:   storage,
+		Data:      map[string]interface{}{"keys": keys},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = read("data/copy/db", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["resolved_path"] != "shared/db" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+
+	// Loops are detected when reading
+	resp, err = write("data/apps/a", map[string]interface{}{"reference": "apps/b"})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = write("data/apps/b", map[string]interface{}{"reference": "apps/a"})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = read("data/apps/a", nil)
+	if err != logical.ErrInvalidRequest || resp == nil || !strings.Contains(resp.Error().Error(), "reference loop") {
+		t.Fatalf("expected a loop error, err:%s resp:%#v\n", err, resp)
+	}
+
+	// A dangling reference reads as a missing secret
+	resp, err = write("data/apps/dangling", map[string]interface{}{"reference": "shared/missing"})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = read("data/apps/dangling", nil)
+	if err != nil || resp != nil {
+		t.Fatalf("expected no response, err:%s resp:%#v\n", err, resp)
+	}
+
+	// Removing the target from the reference_targets prevents reading it
+	resp, err = write("config", map[string]interface{}{"reference_targets": "apps/*"})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	resp, err = read("data/apps/foo/db", nil)
+	if err != logical.ErrPermissionDenied || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the read to be denied, err:%s resp:%#v\n", err, resp)
+	}
+}
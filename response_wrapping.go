@@ -0,0 +1,58 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// errResponseWrappingRequired is returned when the data of a key requiring
+// response wrapping would be returned in a response not wrapped as required.
+var errResponseWrappingRequired = errors.New("response wrapping required")
+
+// responseWrappingTTL returns the maximum TTL of the wrapping token of the data
+// reads of the key, or zero if it is not limited.
+func responseWrappingTTL(meta *KeyMetadata) time.Duration {
+	if meta.GetResponseWrappingTtl() == nil {
+		return time.Duration(0)
+	}
+	ttl, err := ptypes.Duration(meta.GetResponseWrappingTtl())
+	if err != nil {
+		return time.Duration(0)
+	}
+	return ttl
+}
+
+// requestWrapTTL returns the TTL of the wrapping token requested by req, or
+// zero if the response is not to be wrapped.
+func requestWrapTTL(req *logical.Request) time.Duration {
+	if req.WrapInfo == nil {
+		return time.Duration(0)
+	}
+	return req.WrapInfo.TTL
+}
+
+// checkResponseWrapping returns an error if the data of the key cannot be
+// returned in a response wrapped with a token of wrapTTL, zero meaning the
+// response is not wrapped.
+func checkResponseWrapping(meta *KeyMetadata, wrapTTL time.Duration) error {
+	if !meta.RequireResponseWrapping {
+		return nil
+	}
+	return checkWrapTTL(meta.Key, responseWrappingTTL(meta), wrapTTL)
+}
+
+// checkWrapTTL returns an error if wrapTTL does not satisfy the
+// response_wrapping_ttl maxTTL of a key requiring response wrapping.
+func checkWrapTTL(key string, maxTTL, wrapTTL time.Duration) error {
+	if wrapTTL <= 0 {
+		return fmt.Errorf("%w: the data of %q can only be read in a wrapped response", errResponseWrappingRequired, key)
+	}
+	if maxTTL > 0 && wrapTTL > maxTTL {
+		return fmt.Errorf("%w: the wrapping TTL %s is greater than the response_wrapping_ttl %s of %q", errResponseWrappingRequired, wrapTTL, maxTTL, key)
+	}
+	return nil
+}
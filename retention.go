@@ -0,0 +1,139 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+type maxVersionAgeGetter interface {
+	GetMaxVersionAge() *duration.Duration
+}
+
+func getMaxVersionAge(v maxVersionAgeGetter) time.Duration {
+	if v.GetMaxVersionAge() == nil {
+		return time.Duration(0)
+	}
+	age, err := ptypes.Duration(v.GetMaxVersionAge())
+	if err != nil {
+		return time.Duration(0)
+	}
+	return age
+}
+
+// maxVersionAge returns the age after which versions are removed, based on
+// the minimum non-zero value of the mount and the key. Zero is returned if
+// versions are kept regardless of their age.
+func maxVersionAge(config *Configuration, meta *KeyMetadata) time.Duration {
+	mount, key := getMaxVersionAge(config), getMaxVersionAge(meta)
+	switch {
+	case mount == 0:
+		return key
+	case key == 0 || mount < key:
+		return mount
+	default:
+		return key
+	}
+}
+
+// PruneVersionsCreatedBefore removes the versions created before cutoff from
+// the key metadata and moves the oldest version accordingly. The current
+// version is never removed, nor are versions that would leave fewer than
+// minVersions retrievable versions. It returns the most recent version
+// removed, or zero if none was.
+func (k *KeyMetadata) PruneVersionsCreatedBefore(cutoff time.Time, minVersions uint32) (uint64, error) {
+	remaining, err := retrievableVersions(k)
+	if err != nil {
+		return 0, err
+	}
+
+	var versionToDelete uint64
+	for i := k.OldestVersion; i < k.CurrentVersion; i++ {
+		vm, ok := k.Versions[i]
+		if !ok || vm.CreatedTime == nil {
+			continue
+		}
+
+		retrievable, err := isRetrievable(vm)
+		if err != nil {
+			return 0, err
+		}
+		if retrievable && remaining <= minVersions {
+			break
+		}
+
+		created, err := ptypes.Timestamp(vm.CreatedTime)
+		if err != nil {
+			return 0, err
+		}
+
+		// Versions are created in order so the following ones are more
+		// recent
+		if !created.Before(cutoff) {
+			break
+		}
+		if retrievable {
+			remaining--
+		}
+		versionToDelete = i
+	}
+
+	if versionToDelete == 0 {
+		return 0, nil
+	}
+
+	for i := k.OldestVersion; i < versionToDelete+1; i++ {
+		delete(k.Versions, i)
+	}
+	k.OldestVersion = versionToDelete + 1
+
+	return versionToDelete, nil
+}
+
+// pruneOldVersions removes the versions of key that are older than the
+// max_version_age of the key or of the mount.
+func (b *versionedKVBackend) pruneOldVersions(ctx context.Context, s logical.Storage, key string) error {
+	config, err := b.keyConfig(ctx, s, key)
+	if err != nil {
+		return err
+	}
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+
+	age := maxVersionAge(config, meta)
+	if age == 0 {
+		return nil
+	}
+
+	versionToDelete, err := meta.PruneVersionsCreatedBefore(time.Now().Add(-age), minVersions(config, meta))
+	if err != nil || versionToDelete == 0 {
+		return err
+	}
+
+	if err := b.rehomeSharedData(ctx, s, meta); err != nil {
+		return err
+	}
+	if err := b.writeKeyMetadata(ctx, s, meta); err != nil {
+		return err
+	}
+
+	if warning := b.cleanupOldVersions(ctx, s, key, versionToDelete); warning != "" {
+		b.Logger().Warn(warning, "key", key)
+	}
+
+	return nil
+}
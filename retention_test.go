@@ -0,0 +1,147 @@
+package kv
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestKeyMetadata_PruneVersionsCreatedBefore(t *testing.T) {
+	now := time.Now()
+	meta := &KeyMetadata{
+		Versions:       map[uint64]*VersionMetadata{},
+		CurrentVersion: 4,
+		OldestVersion:  1,
+	}
+	for i, age := range []time.Duration{4 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour} {
+		created, err := ptypes.TimestampProto(now.Add(-age))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta.Versions[uint64(i+1)] = &VersionMetadata{CreatedTime: created}
+	}
+
+	// min_versions stops the pruning
+	v, err := meta.PruneVersionsCreatedBefore(now, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 1 || meta.OldestVersion != 2 || len(meta.Versions) != 3 {
+		t.Fatalf("unexpected result %d, meta: %#v", v, meta)
+	}
+
+	v, err = meta.PruneVersionsCreatedBefore(now.Add(-150*time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 || meta.OldestVersion != 3 || len(meta.Versions) != 2 {
+		t.Fatalf("unexpected result %d, meta: %#v", v, meta)
+	}
+
+	// The current version is always kept
+	v, err = meta.PruneVersionsCreatedBefore(now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 3 || meta.OldestVersion != 4 || len(meta.Versions) != 1 {
+		t.Fatalf("unexpected result %d, meta: %#v", v, meta)
+	}
+}
+
+func TestVersionedKV_MaxVersionAge(t *testing.T) {
+	b, storage := getBackend(t)
+
+	write := func() {
+		t.Helper()
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	versions := func() []string {
+		t.Helper()
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metadata/foo",
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		var versions []string
+		for i := resp.Data["oldest_version"].(uint64); i <= resp.Data["current_version"].(uint64); i++ {
+			if _, ok := resp.Data["versions"].(map[string]interface{})[strconv.FormatUint(i, 10)]; ok {
+				versions = append(versions, strconv.FormatUint(i, 10))
+			}
+		}
+		return versions
+	}
+
+	write()
+	write()
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_version_age": "1s",
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	// The old versions are removed on write
+	write()
+	if diff := deep.Equal(versions(), []string{"3"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	write()
+	time.Sleep(1100 * time.Millisecond)
+
+	// and by the periodic sweep
+	req = &logical.Request{
+		Operation: logical.RollbackOperation,
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(versions(), []string{"4"}); diff != nil {
+		t.Fatal(diff)
+	}
+
+	versionKey, err := b.(*versionedKVBackend).getVersionKey(context.Background(), "foo", 3, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := storage.Get(context.Background(), versionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Fatal("expected the data of version 3 to be removed")
+	}
+}
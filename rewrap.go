@@ -0,0 +1,356 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/helper/keysutil"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// rewrapInfoPath is the path of the progress of the rewrap of the key
+// metadata.
+const rewrapInfoPath string = "rewrap"
+
+// The states of the rewrap held by versionedKVBackend.rewrapping. While the
+// metadata of the mount is rewrapped, running or stopped, the metadata not
+// rewrapped yet is found under the previous version of the key policy. The
+// backend is unavailable while the key policy of a prefix changes as the
+// metadata under the prefix is moved within the request.
+const (
+	rewrapIdle uint32 = iota
+	rewrapRunning
+	rewrapStopped
+	rewrapPrefix
+)
+
+// errDeterministicPaths is returned when rewrapping key metadata stored
+// under deterministic paths, which are not encrypted.
+var errDeterministicPaths = errors.New("the paths of the key metadata are not encrypted with deterministic_paths")
+
+// getRewrapInfo returns the progress of the rewrap, or nil if no rewrap was
+// started.
+func (b *versionedKVBackend) getRewrapInfo(ctx context.Context, s logical.Storage) (*RewrapInfo, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, rewrapInfoPath))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	rewrapInfo := &RewrapInfo{}
+	if err := proto.Unmarshal(raw.Value, rewrapInfo); err != nil {
+		return nil, err
+	}
+
+	return rewrapInfo, nil
+}
+
+// writeRewrapInfo writes the progress of the rewrap to storage.
+func (b *versionedKVBackend) writeRewrapInfo(ctx context.Context, s logical.Storage, rewrapInfo *RewrapInfo) error {
+	buf, err := proto.Marshal(rewrapInfo)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, rewrapInfoPath),
+		Value: buf,
+	})
+}
+
+// startRewrap rotates the key policy and starts moving the key metadata
+// under the new key.
+func (b *versionedKVBackend) startRewrap(ctx context.Context, s logical.Storage, rateLimit uint64) (*RewrapInfo, error) {
+	// The progress is written before the key encryptor is reloaded so that
+	// the reloaded encryptor falls back to the previous version of the key
+	// policy
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	policy, err := b.policy(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	from := policy.LatestVersion
+	if err := policy.Rotate(ctx, s, b.GetRandomReader()); err != nil {
+		return nil, err
+	}
+
+	rewrapInfo := &RewrapInfo{
+		StartedTime: ptypes.TimestampNow(),
+		FromVersion: int64(from),
+		ToVersion:   int64(policy.LatestVersion),
+		RateLimit:   rateLimit,
+	}
+	err = b.writeRewrapInfo(ctx, s, rewrapInfo)
+	b.keyEncryptedWrapper = nil
+	if err != nil {
+		return nil, err
+	}
+
+	// The returned progress must not be shared with the goroutine updating
+	// it.
+	started := proto.Clone(rewrapInfo).(*RewrapInfo)
+	b.runRewrap(s, rewrapInfo)
+
+	return started, nil
+}
+
+// resumeRewrap resumes the rewrap interrupted by a restart. The rewrap is
+// left to the primary on performance secondaries and standbys.
+func (b *versionedKVBackend) resumeRewrap(ctx context.Context, s logical.Storage) error {
+	if b.perfSecondaryCheck() {
+		return nil
+	}
+
+	rewrapInfo, err := b.getRewrapInfo(ctx, s)
+	if err != nil || rewrapInfo == nil || rewrapInfo.Done {
+		return err
+	}
+
+	b.Logger().Info("resuming rewrap", "processed", rewrapInfo.Processed)
+	atomic.StoreUint32(b.rewrapping, rewrapRunning)
+	rewrapInfo.Errors = nil
+	b.runRewrap(s, rewrapInfo)
+
+	return nil
+}
+
+// runRewrap moves the key metadata stored under the previous version of the
+// key policy to the paths derived from the new version in a background
+// goroutine. The progress is regularly written to storage so that the rewrap
+// can be resumed, the first error stops it.
+func (b *versionedKVBackend) runRewrap(s logical.Storage, rewrapInfo *RewrapInfo) {
+	b.jobsWG.Add(1)
+	go func() {
+		defer b.jobsWG.Done()
+
+		ctx := b.jobsCtx
+		logger := b.Logger().With("operation", "rewrap")
+
+		if err := b.rewrap(ctx, s, rewrapInfo); err != nil {
+			logger.Error("rewrap resulted in an error", "error", err)
+			rewrapInfo.Errors = append(rewrapInfo.Errors, err.Error())
+			if err := b.writeRewrapInfo(ctx, s, rewrapInfo); err != nil {
+				logger.Error("writing rewrap progress resulted in an error", "error", err)
+			}
+			atomic.StoreUint32(b.rewrapping, rewrapStopped)
+			return
+		}
+
+		b.metadataCache.purge()
+		atomic.StoreUint32(b.rewrapping, rewrapIdle)
+		logger.Info("rewrap finished", "processed", rewrapInfo.Processed)
+	}()
+}
+
+// rewrap moves every key metadata entry still stored under the previous
+// version of the key policy, then retires that version.
+func (b *versionedKVBackend) rewrap(ctx context.Context, s logical.Storage, rewrapInfo *RewrapInfo) error {
+	// The prefixes with a key policy of their own are not rewrapped
+	e, err := b.getKeyEncryptor(ctx, s)
+	if err != nil {
+		return err
+	}
+	newWrapper, ok := e.root.(*keysutil.EncryptedKeyStorageWrapper)
+	if !ok {
+		return errDeterministicPaths
+	}
+	newPolicy := e.rootPolicy
+
+	// The previous version of the key policy is used to find the paths of
+	// the entries not rewrapped yet
+	oldWrapper, err := b.archivedKeyWrapper(ctx, s, rewrapInfo.FromVersion)
+	if err != nil {
+		return err
+	}
+
+	keys, err := b.collectRewrapKeys(ctx, s, newWrapper, oldWrapper)
+	if err != nil {
+		return err
+	}
+	rewrapInfo.Total = rewrapInfo.Processed + uint64(len(keys))
+	if err := b.writeRewrapInfo(ctx, s, rewrapInfo); err != nil {
+		return err
+	}
+
+	var interval time.Duration
+	if rewrapInfo.RateLimit > 0 {
+		interval = time.Second / time.Duration(rewrapInfo.RateLimit)
+	}
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := b.rewrapKey(ctx, s, newPolicy, newWrapper, oldWrapper, key); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		rewrapInfo.Processed++
+		if rewrapInfo.Processed%jobProgressInterval == 0 {
+			if err := b.writeRewrapInfo(ctx, s, rewrapInfo); err != nil {
+				return err
+			}
+		}
+
+		if interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	// The previous versions of the key policy can no longer decrypt the
+	// paths of the metadata. The rewrap is marked as done before the key
+	// encryptor is reloaded so that it no longer falls back to them.
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	policy, err := b.policy(ctx, s)
+	if err == nil && policy.MinDecryptionVersion < int(rewrapInfo.ToVersion) {
+		policy.MinDecryptionVersion = int(rewrapInfo.ToVersion)
+		err = policy.Persist(ctx, s)
+	}
+	if err != nil {
+		b.keyEncryptedWrapper = nil
+		return err
+	}
+
+	rewrapInfo.Done = true
+	rewrapInfo.CompletedTime = ptypes.TimestampNow()
+	err = b.writeRewrapInfo(ctx, s, rewrapInfo)
+	b.keyEncryptedWrapper = nil
+	return err
+}
+
+// archivedKeyWrapper returns the wrapper encrypting the paths of the key
+// metadata with the version of the key policy the metadata is rewrapped from.
+func (b *versionedKVBackend) archivedKeyWrapper(ctx context.Context, s logical.Storage, version int64) (*keysutil.EncryptedKeyStorageWrapper, error) {
+	policy, err := keysutil.LoadPolicy(ctx, s, path.Join(b.storagePrefix, "policy/metadata"))
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("key policy not found")
+	}
+	policy.LatestVersion = int(version)
+
+	return keysutil.NewEncryptedKeyStorageWrapper(keysutil.EncryptedKeyStorageConfig{
+		Policy: policy,
+		Prefix: path.Join(b.storagePrefix, metadataPrefix),
+	})
+}
+
+// collectRewrapKeys returns the keys whose metadata is stored under the
+// previous version of the key policy. The root of the metadata holds the
+// entries of both versions so it is listed with the new key policy, the
+// folders below it only hold entries of the version they were created with.
+func (b *versionedKVBackend) collectRewrapKeys(ctx context.Context, s logical.Storage, newWrapper, oldWrapper *keysutil.EncryptedKeyStorageWrapper) ([]string, error) {
+	oldStorage := oldWrapper.Wrap(s)
+
+	roots, err := newWrapper.Wrap(s).List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	var walk func(prefix string) error
+	walk = func(prefix string) error {
+		entries, err := oldStorage.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry, "/") {
+				if err := walk(prefix + entry); err != nil {
+					return err
+				}
+				continue
+			}
+			keys = append(keys, prefix+entry)
+		}
+		return nil
+	}
+
+	seen := make(map[string]bool, len(roots))
+	for _, root := range roots {
+		if seen[root] {
+			continue
+		}
+		seen[root] = true
+
+		if !strings.HasSuffix(root, "/") {
+			entry, err := oldStorage.Get(ctx, root)
+			if err != nil {
+				return nil, err
+			}
+			if entry != nil {
+				keys = append(keys, root)
+			}
+			continue
+		}
+
+		if err := walk(root); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// rewrapKey moves the metadata of key from its path derived from the
+// previous version of the key policy to the path derived from the new one.
+// The encrypted custom_metadata is re-encrypted with the new version as the
+// previous ones are retired once the rewrap completes.
+func (b *versionedKVBackend) rewrapKey(ctx context.Context, s logical.Storage, newPolicy *keysutil.Policy, newWrapper, oldWrapper *keysutil.EncryptedKeyStorageWrapper, key string) error {
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := oldWrapper.Wrap(s).Get(ctx, key)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	value := entry.Value
+	meta := &KeyMetadata{}
+	if err := proto.Unmarshal(value, meta); err != nil {
+		return fmt.Errorf("failed to decode key metadata from storage: %v", err)
+	}
+	if meta.EncryptedCustomMetadata != "" {
+		if err := b.decryptCustomMetadata(newPolicy, meta); err != nil {
+			return err
+		}
+		if err := b.encryptCustomMetadata(newPolicy, meta); err != nil {
+			return err
+		}
+		value, err = proto.Marshal(meta)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := newWrapper.Wrap(s).Put(ctx, &logical.StorageEntry{
+		Key:      key,
+		Value:    value,
+		SealWrap: entry.SealWrap,
+	}); err != nil {
+		return err
+	}
+
+	return oldWrapper.Wrap(s).Delete(ctx, key)
+}
@@ -0,0 +1,42 @@
+package kv
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+)
+
+// rotationPeriod returns the rotation_period of the key, or zero if rotation
+// is not tracked for the key.
+func rotationPeriod(meta *KeyMetadata) time.Duration {
+	if meta.GetRotationPeriod() == nil {
+		return time.Duration(0)
+	}
+	period, err := ptypes.Duration(meta.GetRotationPeriod())
+	if err != nil {
+		return time.Duration(0)
+	}
+	return period
+}
+
+// rotationDue returns true if the current version of the key is older than
+// its rotation_period at now, along with the time the rotation became due.
+// Keys without a rotation_period or a current version are never due.
+func rotationDue(meta *KeyMetadata, now time.Time) (bool, time.Time) {
+	period := rotationPeriod(meta)
+	if period == 0 {
+		return false, time.Time{}
+	}
+
+	vm, ok := meta.Versions[meta.CurrentVersion]
+	if !ok || vm.CreatedTime == nil {
+		return false, time.Time{}
+	}
+	created, err := ptypes.Timestamp(vm.CreatedTime)
+	if err != nil {
+		return false, time.Time{}
+	}
+
+	dueTime := created.Add(period)
+	return dueTime.Before(now), dueTime
+}
@@ -0,0 +1,168 @@
+package kv
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRotationDue(t *testing.T) {
+	now := time.Now()
+	created, err := ptypes.TimestampProto(now.Add(-2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := func(period time.Duration) *KeyMetadata {
+		m := &KeyMetadata{
+			CurrentVersion: 1,
+			Versions: map[uint64]*VersionMetadata{
+				1: {CreatedTime: created},
+			},
+		}
+		if period > 0 {
+			m.RotationPeriod = ptypes.DurationProto(period)
+		}
+		return m
+	}
+
+	tests := map[string]struct {
+		meta *KeyMetadata
+		want bool
+	}{
+		"no period":   {meta(0), false},
+		"not due":     {meta(3 * time.Hour), false},
+		"due":         {meta(time.Hour), true},
+		"no versions": {&KeyMetadata{RotationPeriod: ptypes.DurationProto(time.Hour)}, false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got, _ := rotationDue(tc.meta, now); got != tc.want {
+				t.Fatalf("expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVersionedKV_RotationDue(t *testing.T) {
+	b, storage := getBackend(t)
+
+	for key, period := range map[string]string{
+		"app/foo": "1s",
+		"app/bar": "1h",
+		"baz":     "1s",
+	} {
+		requests := []*logical.Request{
+			{
+				Operation: logical.CreateOperation,
+				Path:      "metadata/" + key,
+				Data: map[string]interface{}{
+					"rotation_period": period,
+				},
+			},
+			{
+				Operation: logical.CreateOperation,
+				Path:      "data/" + key,
+				Data: map[string]interface{}{
+					"data": map[string]interface{}{
+						"bar": "baz",
+					},
+				},
+			},
+		}
+		for _, req := range requests {
+			req.Storage = storage
+			resp, err := b.HandleRequest(context.Background(), req)
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("err:%s resp:%#v\n", err, resp)
+			}
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "metadata/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"rotation_period": -1,
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "rotation-due/app/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"app/foo"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+	info := resp.Data["key_info"].(map[string]interface{})["app/foo"].(map[string]interface{})
+	if info["current_version"] != uint64(1) || info["rotation_period"] != "1s" {
+		t.Fatalf("unexpected key_info: %#v", info)
+	}
+
+	for key, warnings := range map[string]int{
+		"app/foo": 1,
+		"app/bar": 0,
+	} {
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if len(resp.Warnings) != warnings {
+			t.Fatalf("%s: expected %d warnings, got %v", key, warnings, resp.Warnings)
+		}
+		if warnings > 0 && !strings.Contains(resp.Warnings[0], "due for rotation") {
+			t.Fatalf("unexpected warning: %s", resp.Warnings[0])
+		}
+	}
+
+	// Writing a new version rotates the secret
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/app/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "qux",
+			},
+		},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "rotation-due/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"baz"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+}
@@ -0,0 +1,229 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// storageLayoutPath is the location where the storage layout is stored
+	storageLayoutPath string = "layout"
+
+	// maxVersionShards is the largest number of shards the versions can be
+	// spread across.
+	maxVersionShards = 1 << 16
+
+	// flatVersionFanOut is the number of folders the versions are spread
+	// across by the first three characters of their salted ID.
+	flatVersionFanOut = 1 << 12
+)
+
+// parseVersionShards returns the number of version shards requested by the
+// version_shards mount option, or zero if it is not set.
+func parseVersionShards(conf map[string]string) (uint32, error) {
+	raw, ok := conf["version_shards"]
+	if !ok || raw == "" {
+		return 0, nil
+	}
+
+	shards, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid version_shards %q: %w", raw, err)
+	}
+	if shards == 1 {
+		return 0, fmt.Errorf("version_shards must be greater than 1 to spread the versions")
+	}
+	if shards > maxVersionShards {
+		return 0, fmt.Errorf("version_shards cannot be greater than %d", maxVersionShards)
+	}
+
+	return uint32(shards), nil
+}
+
+// parseDeterministicPaths returns whether the deterministic_paths mount
+// option requests the key metadata and the versions to be stored under
+// deterministic paths.
+func parseDeterministicPaths(conf map[string]string) (bool, error) {
+	raw, ok := conf["deterministic_paths"]
+	if !ok || raw == "" {
+		return false, nil
+	}
+
+	deterministic, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid deterministic_paths %q: %w", raw, err)
+	}
+
+	return deterministic, nil
+}
+
+// storageLayout loads the storage layout. Mounts whose layout has not been
+// written by initializeStorageLayout yet use the original layout, which is
+// derived in memory so that reads never write to the storage.
+func (b *versionedKVBackend) storageLayout(ctx context.Context, s logical.Storage) (*StorageLayout, error) {
+	b.l.RLock()
+	if b.layout != nil {
+		defer b.l.RUnlock()
+		return b.layout, nil
+	}
+	b.l.RUnlock()
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.layout != nil {
+		return b.layout, nil
+	}
+
+	layout, err := b.readStorageLayout(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if layout == nil {
+		layout = &StorageLayout{}
+	}
+
+	b.layout = layout
+	return layout, nil
+}
+
+// readStorageLayout returns the storage layout written to s, or nil if there
+// is none.
+func (b *versionedKVBackend) readStorageLayout(ctx context.Context, s logical.Storage) (*StorageLayout, error) {
+	raw, err := s.Get(ctx, path.Join(b.storagePrefix, storageLayoutPath))
+	if err != nil || raw == nil {
+		return nil, err
+	}
+
+	layout := &StorageLayout{}
+	if err := proto.Unmarshal(raw.Value, layout); err != nil {
+		return nil, err
+	}
+	if layout.VersionShards > 0 && !layout.NestedShards && layout.VersionShards < flatVersionFanOut {
+		b.Logger().Warn("the versions are spread across fewer shards than the default layout uses, listing them may be slower", "version_shards", layout.VersionShards)
+	}
+
+	return layout, nil
+}
+
+// initializeStorageLayout writes the storage layout when the mount does not
+// have one yet, using the version_shards and deterministic_paths mount
+// options. Mounts that already hold secrets keep the original layout so that
+// their data remains reachable. It is called once the backend is mounted, and
+// only writes on the nodes that can.
+func (b *versionedKVBackend) initializeStorageLayout(ctx context.Context, s logical.Storage) error {
+	if !b.WriteSafeReplicationState() {
+		return nil
+	}
+
+	layout, err := b.readStorageLayout(ctx, s)
+	if err != nil {
+		return err
+	}
+	if layout != nil {
+		if b.deterministicPaths != layout.DeterministicPaths {
+			b.Logger().Warn("the storage layout was chosen when the mount was created, ignoring the deterministic_paths mount option", "deterministic_paths", layout.DeterministicPaths)
+		}
+
+		b.l.Lock()
+		b.layout = layout
+		b.l.Unlock()
+		return nil
+	}
+
+	layout, err = b.newStorageLayout(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	buf, err := proto.Marshal(layout)
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, storageLayoutPath),
+		Value: buf,
+	}); err != nil {
+		return err
+	}
+
+	b.l.Lock()
+	b.layout = layout
+	b.l.Unlock()
+	return nil
+}
+
+// newStorageLayout returns the layout requested by the mount options, or the
+// original layout if the mount already holds secrets.
+func (b *versionedKVBackend) newStorageLayout(ctx context.Context, s logical.Storage) (*StorageLayout, error) {
+	existing, err := s.List(ctx, path.Join(b.storagePrefix, versionPrefix)+"/")
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		if b.versionShards > 0 || b.deterministicPaths {
+			b.Logger().Warn("secrets already exist, ignoring the version_shards and deterministic_paths mount options")
+		}
+		return &StorageLayout{}, nil
+	}
+
+	if !b.deterministicPaths {
+		return &StorageLayout{
+			VersionShards: b.versionShards,
+			NestedShards:  b.versionShards > 0,
+		}, nil
+	}
+
+	// The metadata may exist without any version
+	metadata, err := s.List(ctx, path.Join(b.storagePrefix, metadataPrefix)+"/")
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) > 0 {
+		b.Logger().Warn("secrets already exist, ignoring the deterministic_paths mount option")
+		return &StorageLayout{}, nil
+	}
+
+	return &StorageLayout{DeterministicPaths: true}, nil
+}
+
+// versionShard returns the sub-prefix a version whose salted ID is provided
+// is stored under. Nested shards are chosen from the characters following the
+// three the versions are first split by, so that each of these folders is
+// spread evenly.
+func versionShard(salted string, shards uint32, nested bool) (string, error) {
+	hashed := salted[:8]
+	if nested {
+		hashed = salted[3:11]
+	}
+
+	h, err := strconv.ParseUint(hashed, 16, 32)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatUint(h%uint64(shards), 16), nil
+}
+
+// deterministicVersionKey returns the storage path of a version of key when
+// the storage layout has deterministic paths. The slashes of the key are
+// escaped so that the versions of every key are stored in a folder of their
+// own.
+func (b *versionedKVBackend) deterministicVersionKey(key string, version uint64) string {
+	return path.Join(b.storagePrefix, versionPrefix, url.PathEscape(key), strconv.FormatUint(version, 10))
+}
+
+// plainKeyStorageWrapper stores the key metadata under its plaintext path
+// when the storage layout has deterministic paths.
+type plainKeyStorageWrapper struct {
+	prefix string
+}
+
+// Wrap returns a view of s under the prefix of the key metadata.
+func (w *plainKeyStorageWrapper) Wrap(s logical.Storage) logical.Storage {
+	return logical.NewStorageView(s, w.prefix)
+}
@@ -0,0 +1,400 @@
+package kv
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func getShardedBackend(t *testing.T, storage logical.Storage, shards string) logical.Backend {
+	t.Helper()
+
+	config := &logical.BackendConfig{
+		Logger:      logging.NewVaultLogger(log.Trace),
+		System:      &logical.StaticSystemView{},
+		StorageView: storage,
+		BackendUUID: "test",
+		Config: map[string]string{
+			"version_shards": shards,
+		},
+	}
+
+	b, err := VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatalf("unable to create backend: %v", err)
+	}
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: config.StorageView}); err != nil {
+		t.Fatalf("unable to initialize backend: %v", err)
+	}
+
+	// Wait for the upgrade to finish
+	time.Sleep(time.Second)
+
+	return b
+}
+
+func TestParseVersionShards(t *testing.T) {
+	for raw, ok := range map[string]bool{"": true, "1": false, "16": true, "65536": true, "65537": false, "-1": false, "a": false} {
+		_, err := parseVersionShards(map[string]string{"version_shards": raw})
+		if (err == nil) != ok {
+			t.Fatalf("%q: unexpected error %v", raw, err)
+		}
+	}
+}
+
+func TestVersionedKV_VersionShards(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := getShardedBackend(t, storage, "16")
+
+	for _, key := range []string{"foo", "bar", "baz/qux"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "data/" + key,
+			Storage:   storage,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() || resp.Data["data"] == nil {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	// The shards are nested under the folders of the default layout
+	dirs, err := storage.List(context.Background(), path.Join("test", versionPrefix)+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if len(strings.TrimSuffix(dir, "/")) != 3 {
+			t.Fatalf("unexpected folder %q", dir)
+		}
+
+		shards, err := storage.List(context.Background(), path.Join("test", versionPrefix, dir)+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, shard := range shards {
+			if len(strings.TrimSuffix(shard, "/")) != 1 {
+				t.Fatalf("unexpected shard %q", shard)
+			}
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if resp.Data["version_shards"] != uint32(16) {
+		t.Fatalf("unexpected version_shards %#v", resp.Data["version_shards"])
+	}
+
+	// The layout is kept when the mount is reloaded with another value
+	b = getShardedBackend(t, storage, "4")
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() || resp.Data["data"] == nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_VersionShards_ExistingVersions(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Mounts holding versions keep the original layout
+	if err := storage.Delete(context.Background(), path.Join("test", storageLayoutPath)); err != nil {
+		t.Fatal(err)
+	}
+	b = getShardedBackend(t, storage, "16")
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() || resp.Data["data"] == nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_VersionShards_Flat(t *testing.T) {
+	// Layouts written before the shards were nested keep their paths
+	storage := &logical.InmemStorage{}
+	buf, err := proto.Marshal(&StorageLayout{VersionShards: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(context.Background(), &logical.StorageEntry{
+		Key:   path.Join("test", storageLayoutPath),
+		Value: buf,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b := getShardedBackend(t, storage, "16")
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	shards, err := storage.List(context.Background(), path.Join("test", versionPrefix)+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 1 || len(strings.TrimSuffix(shards[0], "/")) != 1 {
+		t.Fatalf("unexpected shards %q", shards)
+	}
+}
+
+func TestParseDeterministicPaths(t *testing.T) {
+	for raw, ok := range map[string]bool{"": true, "true": true, "false": true, "a": false} {
+		_, err := parseDeterministicPaths(map[string]string{"deterministic_paths": raw})
+		if (err == nil) != ok {
+			t.Fatalf("%q: unexpected error %v", raw, err)
+		}
+	}
+}
+
+func TestVersionedKV_DeterministicPaths(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	config := &logical.BackendConfig{
+		Logger:      logging.NewVaultLogger(log.Trace),
+		System:      &logical.StaticSystemView{},
+		StorageView: storage,
+		BackendUUID: "test",
+		Config: map[string]string{
+			"deterministic_paths": "true",
+			"version_shards":      "16",
+		},
+	}
+	if _, err := VersionedKVFactory(context.Background(), config); err == nil {
+		t.Fatal("expected an error combining version_shards and deterministic_paths")
+	}
+
+	delete(config.Config, "version_shards")
+	b, err := VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the upgrade to finish
+	time.Sleep(time.Second)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/app/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The records of the secret are found from its path
+	for _, key := range []string{"test/metadata/app/foo", "test/versions/app%2Ffoo/1"} {
+		entry, err := storage.Get(context.Background(), key)
+		if err != nil || entry == nil {
+			t.Fatalf("%s: err:%s entry:%#v", key, err, entry)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/app/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() || resp.Data["data"].(map[string]interface{})["bar"] != "baz" {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "metadata/app/",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "foo" {
+		t.Fatalf("unexpected keys %#v", keys)
+	}
+
+	// The paths cannot be rewrapped as they are not encrypted
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rewrap",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestVersionedKV_StorageLayout_Initialize(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	config := &logical.BackendConfig{
+		Logger:      logging.NewVaultLogger(log.Trace),
+		System:      &logical.StaticSystemView{},
+		StorageView: storage,
+		BackendUUID: "test",
+		Config: map[string]string{
+			"version_shards": "16",
+		},
+	}
+	b, err := VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the upgrade to finish
+	time.Sleep(time.Second)
+
+	// Reads use the original layout without writing it
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := storage.Get(context.Background(), path.Join("test", storageLayoutPath))
+	if err != nil || entry != nil {
+		t.Fatalf("err:%s entry:%#v", err, entry)
+	}
+
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = storage.Get(context.Background(), path.Join("test", storageLayoutPath))
+	if err != nil || entry == nil {
+		t.Fatalf("err:%s entry:%#v", err, entry)
+	}
+	layout := &StorageLayout{}
+	if err := proto.Unmarshal(entry.Value, layout); err != nil {
+		t.Fatal(err)
+	}
+	if layout.VersionShards != 16 || !layout.NestedShards {
+		t.Fatalf("unexpected layout %#v", layout)
+	}
+}
+
+func TestVersionedKV_DeterministicPaths_Remount(t *testing.T) {
+	b, storage := getBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"data": map[string]interface{}{
+				"bar": "baz",
+			},
+		},
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The layout decided when the mount was created is kept
+	config := &logical.BackendConfig{
+		Logger:      logging.NewVaultLogger(log.Trace),
+		System:      &logical.StaticSystemView{},
+		StorageView: storage,
+		BackendUUID: "test",
+		Config: map[string]string{
+			"deterministic_paths": "true",
+		},
+	}
+	b, err = VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Initialize(context.Background(), &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the upgrade to finish
+	time.Sleep(time.Second)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() || resp.Data["data"] == nil {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	layout, err := b.(*versionedKVBackend).storageLayout(context.Background(), storage)
+	if err != nil || layout.DeterministicPaths {
+		t.Fatalf("err:%s layout:%#v", err, layout)
+	}
+}
@@ -0,0 +1,51 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// instrument wraps a handler of endpoint to publish the number and the
+// latency of its requests, labeled by operation and outcome, through the
+// Vault metrics sink.
+func (b *versionedKVBackend) instrument(endpoint string, next framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		start := time.Now()
+		resp, err := next(ctx, req, data)
+
+		status := "success"
+		if err != nil || (resp != nil && resp.IsError()) {
+			status = "error"
+		}
+		labels := []metrics.Label{
+			{Name: "endpoint", Value: endpoint},
+			{Name: "operation", Value: string(req.Operation)},
+			{Name: "status", Value: status},
+		}
+		metrics.IncrCounterWithLabels([]string{"kv", "request"}, 1, labels)
+		metrics.MeasureSinceWithLabels([]string{"kv", "request", "duration"}, start, labels)
+
+		return resp, err
+	}
+}
+
+// recordVersionSize publishes the stored size of a version read or written
+// by operation.
+func recordVersionSize(operation string, vm *VersionMetadata) {
+	if vm.Size == 0 {
+		return
+	}
+	metrics.AddSample([]string{"kv", "data", operation, "size"}, float32(vm.Size))
+}
+
+// recordCASFailure counts the writes rejected by the check-and-set
+// validation.
+func recordCASFailure(reason string) {
+	metrics.IncrCounterWithLabels([]string{"kv", "cas", "failure"}, 1, []metrics.Label{
+		{Name: "reason", Value: reason},
+	})
+}
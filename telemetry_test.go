@@ -0,0 +1,98 @@
+package kv
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestVersionedKV_Telemetry(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Hour, time.Hour)
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatal(err)
+	}
+
+	b, storage := getBackend(t)
+
+	write := func(cas int) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "data/foo",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"data": map[string]interface{}{
+					"bar": "baz",
+				},
+				"options": map[string]interface{}{
+					"cas": cas,
+				},
+			},
+		}
+		resp, _ := b.HandleRequest(context.Background(), req)
+		return resp
+	}
+
+	if resp := write(0); resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if resp := write(0); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a check-and-set failure: %#v", resp)
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/foo",
+		Storage:   storage,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	counters := map[string]int{}
+	samples := map[string]int{}
+	for _, interval := range sink.Data() {
+		interval.RLock()
+		for name, c := range interval.Counters {
+			counters[name] += c.Count
+		}
+		for name, s := range interval.Samples {
+			samples[name] += s.Count
+		}
+		interval.RUnlock()
+	}
+
+	expectedCounters := map[string]int{
+		"kv.request;endpoint=data;operation=create;status=success": 1,
+		"kv.request;endpoint=data;operation=create;status=error":   1,
+		"kv.request;endpoint=data;operation=read;status=success":   1,
+		"kv.cas.failure;reason=mismatch":                           1,
+	}
+	for name, count := range expectedCounters {
+		if counters[name] != count {
+			t.Fatalf("expected %d %q, got %d: %#v", count, name, counters[name], counters)
+		}
+	}
+
+	expectedSamples := map[string]int{
+		"kv.request.duration;endpoint=data;operation=create;status=success": 1,
+		"kv.data.write.size": 1,
+		"kv.data.read.size":  1,
+	}
+	for name, count := range expectedSamples {
+		if samples[name] != count {
+			var names []string
+			for name := range samples {
+				names = append(names, name)
+			}
+			t.Fatalf("expected %d %q, got %d: %s", count, name, samples[name], strings.Join(names, ", "))
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// trashPrefix is the prefix where the keys moved to the trash are
+	// stored, under the salted key.
+	trashPrefix string = "trash/"
+
+	// trashVersionsPrefix is the prefix where the versions of the keys moved
+	// to the trash are stored.
+	trashVersionsPrefix string = "trash-versions/"
+)
+
+// errKeyExists is returned when restoring a key from the trash while a key
+// with the same path has been created since.
+var errKeyExists = errors.New("a secret with this path exists, it must be deleted before the trashed one can be restored")
+
+// trashRetention returns how long the keys moved to the trash are retained,
+// or zero if the metadata deletes destroy the keys immediately.
+func trashRetention(c *Configuration) time.Duration {
+	if c.GetTrashRetention() == nil {
+		return time.Duration(0)
+	}
+	retention, err := ptypes.Duration(c.GetTrashRetention())
+	if err != nil {
+		return time.Duration(0)
+	}
+	return retention
+}
+
+// trashID returns the ID the key is stored under in the trash, so that the
+// paths of the trashed keys are not stored in plaintext.
+func (b *versionedKVBackend) trashID(ctx context.Context, s logical.Storage, key string) (string, error) {
+	salt, err := b.Salt(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	return salt.SaltID("trash|" + key), nil
+}
+
+func (b *versionedKVBackend) trashedKeyPath(id string) string {
+	return path.Join(b.storagePrefix, trashPrefix, id)
+}
+
+func (b *versionedKVBackend) trashedVersionPath(id string, version uint64) string {
+	return path.Join(b.storagePrefix, trashVersionsPrefix, id, strconv.FormatUint(version, 10))
+}
+
+// getTrashedKey returns the trashed key with the provided ID, or nil if it
+// does not exist.
+func (b *versionedKVBackend) getTrashedKey(ctx context.Context, s logical.Storage, id string) (*TrashedKey, error) {
+	raw, err := s.Get(ctx, b.trashedKeyPath(id))
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	trashed := &TrashedKey{}
+	if err := proto.Unmarshal(raw.Value, trashed); err != nil {
+		return nil, err
+	}
+
+	return trashed, nil
+}
+
+// trashKey moves the key described by meta and its versions to the trash,
+// where it is retained for retention. A key previously trashed under the same
+// path is purged. The caller must hold the write lock of the key.
+func (b *versionedKVBackend) trashKey(ctx context.Context, s logical.Storage, meta *KeyMetadata, retention time.Duration) error {
+	wrapper, err := b.getKeyEncryptor(ctx, s)
+	if err != nil {
+		return err
+	}
+	id, err := b.trashID(ctx, s, meta.Key)
+	if err != nil {
+		return err
+	}
+
+	previous, err := b.getTrashedKey(ctx, s, id)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		if err := b.purgeTrashedKey(ctx, s, id, previous); err != nil {
+			return err
+		}
+	}
+
+	// The trashed key is written first so that the versions copied before
+	// an interruption are purged with it
+	now := time.Now()
+	deletedTime, err := ptypes.TimestampProto(now)
+	if err != nil {
+		return err
+	}
+	purgeTime, err := ptypes.TimestampProto(now.Add(retention))
+	if err != nil {
+		return err
+	}
+	bytes, err := proto.Marshal(&TrashedKey{
+		Metadata:    meta,
+		DeletedTime: deletedTime,
+		PurgeTime:   purgeTime,
+	})
+	if err != nil {
+		return err
+	}
+	err = s.Put(ctx, &logical.StorageEntry{
+		Key:   b.trashedKeyPath(id),
+		Value: bytes,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The versions are copied as they are stored so that their encryption
+	// and seal wrapping are preserved. They are only removed once the key
+	// is deleted, so that an interrupted move leaves the key untouched.
+	var versionKeys []string
+	for verNum := range meta.Versions {
+		versionKey, err := b.getVersionKey(ctx, meta.Key, verNum, s)
+		if err != nil {
+			return err
+		}
+		entry, err := s.Get(ctx, versionKey)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+
+		err = s.Put(ctx, &logical.StorageEntry{
+			Key:      b.trashedVersionPath(id, verNum),
+			Value:    entry.Value,
+			SealWrap: entry.SealWrap,
+		})
+		if err != nil {
+			return err
+		}
+		versionKeys = append(versionKeys, versionKey)
+	}
+
+	err = wrapper.Wrap(s).Delete(ctx, meta.Key)
+	b.invalidateKeyMetadata(s, meta.Key)
+	b.trackKeyBytes(s, meta.Key, nil)
+	b.trackKeyCount(s, meta.Key, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, versionKey := range versionKeys {
+		if err := s.Delete(ctx, versionKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreTrashedKey moves key and its versions back from the trash. It returns
+// the metadata of the restored key, nil if the key is not in the trash, and an
+// error wrapping errKeyQuotaExceeded if restoring it would exceed a key quota.
+// The caller must hold the write lock of the key.
+func (b *versionedKVBackend) restoreTrashedKey(ctx context.Context, s logical.Storage, key string) (*KeyMetadata, error) {
+	id, err := b.trashID(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := b.getTrashedKey(ctx, s, id)
+	if err != nil {
+		return nil, err
+	}
+	if trashed == nil {
+		return nil, nil
+	}
+
+	existing, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errKeyExists
+	}
+
+	config, err := b.config(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	release, _, err := b.checkKeyQuotas(ctx, s, config, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// The trashed key is deleted last so that a restore interrupted before
+	// it completes can be resumed
+	meta := trashed.Metadata
+	for verNum := range meta.Versions {
+		entry, err := s.Get(ctx, b.trashedVersionPath(id, verNum))
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+
+		versionKey, err := b.getVersionKey(ctx, key, verNum, s)
+		if err != nil {
+			return nil, err
+		}
+		err = s.Put(ctx, &logical.StorageEntry{
+			Key:      versionKey,
+			Value:    entry.Value,
+			SealWrap: entry.SealWrap,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Delete(ctx, b.trashedVersionPath(id, verNum)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.writeKeyMetadata(ctx, s, meta); err != nil {
+		return nil, err
+	}
+	if err := s.Delete(ctx, b.trashedKeyPath(id)); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// purgeTrashedKey permanently deletes a trashed key and its versions.
+func (b *versionedKVBackend) purgeTrashedKey(ctx context.Context, s logical.Storage, id string, trashed *TrashedKey) error {
+	for verNum := range trashed.Metadata.GetVersions() {
+		if err := s.Delete(ctx, b.trashedVersionPath(id, verNum)); err != nil {
+			return err
+		}
+	}
+	return s.Delete(ctx, b.trashedKeyPath(id))
+}
+
+// listTrash returns the keys in the trash by their ID.
+func (b *versionedKVBackend) listTrash(ctx context.Context, s logical.Storage) (map[string]*TrashedKey, error) {
+	ids, err := s.List(ctx, path.Join(b.storagePrefix, trashPrefix)+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	trash := make(map[string]*TrashedKey, len(ids))
+	for _, id := range ids {
+		trashed, err := b.getTrashedKey(ctx, s, id)
+		if err != nil {
+			return nil, err
+		}
+		if trashed != nil {
+			trash[id] = trashed
+		}
+	}
+
+	return trash, nil
+}
+
+// purgeTrash permanently deletes the keys whose retention in the trash is
+// over at now.
+func (b *versionedKVBackend) purgeTrash(ctx context.Context, s logical.Storage, now time.Time) error {
+	trash, err := b.listTrash(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	for id, trashed := range trash {
+		purgeTime, err := ptypes.Timestamp(trashed.PurgeTime)
+		if err != nil {
+			return err
+		}
+		if now.Before(purgeTime) {
+			continue
+		}
+
+		// The trashed key is checked again under the lock of its key so
+		// that it is not purged while being restored
+		if err := b.purgeExpiredTrashedKey(ctx, s, id, trashed.Metadata.GetKey(), now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *versionedKVBackend) purgeExpiredTrashedKey(ctx context.Context, s logical.Storage, id, key string, now time.Time) error {
+	unlock, err := b.lockKeyForWrite(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	trashed, err := b.getTrashedKey(ctx, s, id)
+	if err != nil || trashed == nil {
+		return err
+	}
+	purgeTime, err := ptypes.Timestamp(trashed.PurgeTime)
+	if err != nil {
+		return err
+	}
+	if now.Before(purgeTime) {
+		return nil
+	}
+
+	return b.purgeTrashedKey(ctx, s, id, trashed)
+}
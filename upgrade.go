@@ -3,7 +3,6 @@ package kv
 import (
 	"context"
 	"errors"
-	"fmt"
 	"path"
 	"strings"
 	"sync/atomic"
@@ -18,6 +17,16 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
+const (
+	// upgradeInfoPath is the path of the progress of the upgrade from
+	// non-versioned to versioned data.
+	upgradeInfoPath string = "upgrading"
+
+	// upgradeProgressInterval is the number of keys upgraded between two
+	// writes of the upgrade progress to storage.
+	upgradeProgressInterval = 1000
+)
+
 func (b *versionedKVBackend) perfSecondaryCheck() bool {
 	replState := b.System().ReplicationState()
 	if (!b.System().LocalMount() && replState.HasState(consts.ReplicationPerformanceSecondary)) ||
@@ -45,25 +54,53 @@ func (b *versionedKVBackend) upgradeCheck(next framework.OperationFunc) framewor
 			}
 		}
 
+		if atomic.LoadUint32(b.rewrapping) == rewrapPrefix {
+			return logical.ErrorResponse("Changing the key policy of a prefix. This backend will be unavailable for a brief period and will resume service shortly."), logical.ErrInvalidRequest
+		}
+
 		return next(ctx, req, data)
 	}
 }
 
 func (b *versionedKVBackend) upgradeDone(ctx context.Context, s logical.Storage) (bool, error) {
-	upgradeEntry, err := s.Get(ctx, path.Join(b.storagePrefix, "upgrading"))
-	if err != nil {
+	upgradeInfo, err := b.getUpgradeInfo(ctx, s)
+	if err != nil || upgradeInfo == nil {
 		return false, err
 	}
 
-	var upgradeInfo UpgradeInfo
-	if upgradeEntry != nil {
-		err := proto.Unmarshal(upgradeEntry.Value, &upgradeInfo)
-		if err != nil {
-			return false, err
-		}
+	return upgradeInfo.Done, nil
+}
+
+// getUpgradeInfo returns the progress of the upgrade, or nil if no upgrade
+// was started.
+func (b *versionedKVBackend) getUpgradeInfo(ctx context.Context, s logical.Storage) (*UpgradeInfo, error) {
+	upgradeEntry, err := s.Get(ctx, path.Join(b.storagePrefix, upgradeInfoPath))
+	if err != nil {
+		return nil, err
+	}
+	if upgradeEntry == nil {
+		return nil, nil
 	}
 
-	return upgradeInfo.Done, nil
+	upgradeInfo := &UpgradeInfo{}
+	if err := proto.Unmarshal(upgradeEntry.Value, upgradeInfo); err != nil {
+		return nil, err
+	}
+
+	return upgradeInfo, nil
+}
+
+// writeUpgradeInfo writes the progress of the upgrade to storage.
+func (b *versionedKVBackend) writeUpgradeInfo(ctx context.Context, s logical.Storage, upgradeInfo *UpgradeInfo) error {
+	info, err := proto.Marshal(upgradeInfo)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   path.Join(b.storagePrefix, upgradeInfoPath),
+		Value: info,
+	})
 }
 
 func (b *versionedKVBackend) Upgrade(ctx context.Context, s logical.Storage) error {
@@ -118,19 +155,27 @@ func (b *versionedKVBackend) Upgrade(ctx context.Context, s logical.Storage) err
 		return nil
 	}
 
-	upgradeInfo := &UpgradeInfo{
-		StartedTime: ptypes.TimestampNow(),
-	}
-
-	// Encode the canary
-	info, err := proto.Marshal(upgradeInfo)
+	// Resume the upgrade interrupted by a restart, the keys already upgraded
+	// have been removed from the non-versioned data
+	upgradeInfo, err := b.getUpgradeInfo(ctx, s)
 	if err != nil {
 		return err
 	}
+	if upgradeInfo == nil {
+		upgradeInfo = &UpgradeInfo{
+			StartedTime: ptypes.TimestampNow(),
+		}
+	} else {
+		b.Logger().Info("resuming upgrade", "processed", upgradeInfo.Processed)
+		upgradeInfo.Errors = nil
+	}
+	upgradeInfo.ResumedTime = ptypes.TimestampNow()
+	upgradeInfo.ResumedProcessed = upgradeInfo.Processed
 
 	// Because this is a long running process we need a new context.
 	ctx = context.Background()
 
+	// upgradeKey upgrades key.
 	upgradeKey := func(key string) error {
 		if strings.HasPrefix(key, b.storagePrefix) {
 			return nil
@@ -174,7 +219,8 @@ func (b *versionedKVBackend) Upgrade(ctx context.Context, s logical.Storage) err
 		}
 
 		// Store the metadata
-		meta.AddVersion(version.CreatedTime, nil, 1)
+		vm, _ := meta.AddVersion(version.CreatedTime, nil, 1)
+		vm.Size = uint64(len(buf))
 		err = b.writeKeyMetadata(ctx, s, meta)
 		if err != nil {
 			return err
@@ -197,10 +243,7 @@ func (b *versionedKVBackend) Upgrade(ctx context.Context, s logical.Storage) err
 		// process has finished.
 	READONLY_LOOP:
 		for {
-			err := s.Put(ctx, &logical.StorageEntry{
-				Key:   path.Join(b.storagePrefix, "upgrading"),
-				Value: info,
-			})
+			err := b.writeUpgradeInfo(ctx, s, upgradeInfo)
 			switch {
 			case err == nil:
 				break READONLY_LOOP
@@ -219,17 +262,25 @@ func (b *versionedKVBackend) Upgrade(ctx context.Context, s logical.Storage) err
 			return
 		}
 
-		b.Logger().Info("done collecting keys", "num_keys", len(keys))
-		for i, key := range keys {
-			if b.Logger().IsDebug() && i%500 == 0 {
-				b.Logger().Debug("upgrading keys", "progress", fmt.Sprintf("%d/%d", i, len(keys)))
-			}
-			err := upgradeKey(key)
-			if err != nil {
-				b.Logger().Error("upgrading resulted in error", "error", err, "progress", fmt.Sprintf("%d/%d", i+1, len(keys)))
-				return
+		// The versioned data is not upgraded
+		nonVersioned := keys[:0]
+		for _, key := range keys {
+			if !strings.HasPrefix(key, b.storagePrefix) {
+				nonVersioned = append(nonVersioned, key)
 			}
 		}
+		keys = nonVersioned
+
+		b.Logger().Info("done collecting keys", "num_keys", len(keys))
+		upgradeInfo.Total = upgradeInfo.Processed + uint64(len(keys))
+		if err := b.writeUpgradeInfo(ctx, s, upgradeInfo); err != nil {
+			b.Logger().Error("writing upgrade progress resulted in an error", "error", err)
+		}
+
+		if err := b.upgradeKeys(ctx, s, keys, upgradeInfo, upgradeKey); err != nil {
+			b.Logger().Error("upgrading resulted in error", "error", err)
+			return
+		}
 
 		b.Logger().Info("upgrading keys finished")
 
@@ -243,15 +294,7 @@ func (b *versionedKVBackend) Upgrade(ctx context.Context, s logical.Storage) err
 
 		// Write upgrade done value
 		upgradeInfo.Done = true
-		info, err := proto.Marshal(upgradeInfo)
-		if err != nil {
-			b.Logger().Error("encoding upgrade info resulted in an error", "error", err)
-		}
-
-		err = s.Put(ctx, &logical.StorageEntry{
-			Key:   path.Join(b.storagePrefix, "upgrading"),
-			Value: info,
-		})
+		err = b.writeUpgradeInfo(ctx, s, upgradeInfo)
 		if err != nil {
 			b.Logger().Error("writing upgrade done resulted in an error", "error", err)
 		}
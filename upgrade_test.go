@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/ptypes"
 	log "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/helper/logging"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -93,3 +94,116 @@ func TestVersionedKV_Upgrade(t *testing.T) {
 		}
 	}
 }
+
+func TestVersionedKV_ResumeUpgrade(t *testing.T) {
+	storage := &logical.InmemStorage{}
+
+	// An upgrade interrupted after processing some keys
+	b := &versionedKVBackend{storagePrefix: "test"}
+	started, err := ptypes.TimestampProto(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.writeUpgradeInfo(context.Background(), storage, &UpgradeInfo{
+		StartedTime: started,
+		Total:       20,
+		Processed:   10,
+		Errors:      []string{"foo: interrupted"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The remaining non-versioned keys
+	for i := 0; i < 5; i++ {
+		if err := storage.Put(context.Background(), &logical.StorageEntry{
+			Key:   fmt.Sprintf("%d/foo", i),
+			Value: []byte(`{"bar":"baz"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &logical.BackendConfig{
+		Logger:      logging.NewVaultLogger(log.Trace),
+		System:      &logical.StaticSystemView{},
+		StorageView: storage,
+		BackendUUID: "test",
+	}
+	backend, err := VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readStatus := func() map[string]interface{} {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "upgrade/status",
+			Storage:   storage,
+		}
+		resp, err := backend.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		return resp.Data
+	}
+
+	var status map[string]interface{}
+	for i := 0; i < 50; i++ {
+		status = readStatus()
+		if status["done"] == true {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if status["done"] != true || status["upgrading"] != false {
+		t.Fatalf("expected the upgrade to be done: %#v", status)
+	}
+	if status["started_time"] != ptypesTimestampToString(started) || status["started_time"] == status["resumed_time"] {
+		t.Fatalf("expected the upgrade to be resumed: %#v", status)
+	}
+	if status["total"] != uint64(15) || status["processed"] != uint64(15) || len(status["errors"].([]string)) != 0 {
+		t.Fatalf("unexpected status: %#v", status)
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "data/3/foo",
+		Storage:   storage,
+	}
+	resp, err := backend.HandleRequest(context.Background(), req)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestUpgradeETA(t *testing.T) {
+	now := time.Now()
+	resumed, err := ptypes.TimestampProto(now.Add(-10 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		info *UpgradeInfo
+		want time.Duration
+	}{
+		"done":         {&UpgradeInfo{Done: true, ResumedTime: resumed, Total: 10, Processed: 5}, 0},
+		"not started":  {&UpgradeInfo{ResumedTime: resumed, Total: 10, Processed: 5, ResumedProcessed: 5}, 0},
+		"half way":     {&UpgradeInfo{ResumedTime: resumed, Total: 20, Processed: 10}, 10 * time.Second},
+		"resumed":      {&UpgradeInfo{ResumedTime: resumed, Total: 20, Processed: 15, ResumedProcessed: 10}, 10 * time.Second},
+		"all upgraded": {&UpgradeInfo{ResumedTime: resumed, Total: 20, Processed: 20}, 0},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := upgradeETA(tc.info, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
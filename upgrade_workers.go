@@ -0,0 +1,187 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// defaultUpgradeWorkers is the number of keys upgraded concurrently
+	// unless set by the upgrade_workers mount option.
+	defaultUpgradeWorkers = 8
+
+	// maxUpgradeWorkers is the largest upgrade_workers accepted.
+	maxUpgradeWorkers = 256
+
+	// defaultUpgradeBatchSize is the number of keys dispatched to a worker at
+	// once unless set by the upgrade_batch_size mount option.
+	defaultUpgradeBatchSize = 100
+
+	// maxUpgradeBatchSize is the largest upgrade_batch_size accepted.
+	maxUpgradeBatchSize = 10000
+)
+
+// upgradeOptions are the settings of the upgrade from non-versioned to
+// versioned data requested by the mount options.
+type upgradeOptions struct {
+	// workers is the number of batches upgraded concurrently.
+	workers int
+
+	// batchSize is the number of keys dispatched to a worker at once.
+	batchSize int
+
+	// rateLimit is the maximum number of keys upgraded per second, zero
+	// means no limit.
+	rateLimit int
+}
+
+// parseUpgradeOptions returns the upgrade settings requested by the
+// upgrade_workers, upgrade_batch_size and upgrade_rate_limit mount options,
+// or their defaults if they are not set.
+func parseUpgradeOptions(conf map[string]string) (upgradeOptions, error) {
+	opts := upgradeOptions{
+		workers:   defaultUpgradeWorkers,
+		batchSize: defaultUpgradeBatchSize,
+	}
+
+	for _, o := range []struct {
+		name string
+		dst  *int
+		min  uint64
+		max  uint64
+	}{
+		{"upgrade_workers", &opts.workers, 1, maxUpgradeWorkers},
+		{"upgrade_batch_size", &opts.batchSize, 1, maxUpgradeBatchSize},
+		{"upgrade_rate_limit", &opts.rateLimit, 0, 1 << 30},
+	} {
+		raw, ok := conf[o.name]
+		if !ok || raw == "" {
+			continue
+		}
+
+		value, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return upgradeOptions{}, fmt.Errorf("invalid %s %q: %w", o.name, raw, err)
+		}
+		if value < o.min || value > o.max {
+			return upgradeOptions{}, fmt.Errorf("%s must be between %d and %d", o.name, o.min, o.max)
+		}
+		*o.dst = int(value)
+	}
+
+	return opts, nil
+}
+
+// upgradeKeys upgrades keys using the worker pool set by the mount options.
+// The keys are split in batches dispatched to the workers, the progress is
+// recorded in upgradeInfo as the batches complete. The first
+// error stops the upgrade once the running batches are done.
+func (b *versionedKVBackend) upgradeKeys(ctx context.Context, s logical.Storage, keys []string, upgradeInfo *UpgradeInfo, upgradeKey func(string) error) error {
+	opts := b.upgradeOptions
+
+	// Create the key policy before the workers start so that they do not
+	// all wait on its creation
+	if _, err := b.getKeyEncryptor(ctx, s); err != nil {
+		return err
+	}
+
+	// stopCtx is cancelled on the first error to stop the dispatch of the
+	// batches
+	stopCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		l        sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	// done records the keys of a batch that were upgraded and the error
+	// that stopped it, if any
+	done := func(upgraded int, key string, err error) {
+		l.Lock()
+		defer l.Unlock()
+
+		before := upgradeInfo.Processed
+		upgradeInfo.Processed += uint64(upgraded)
+		if err != nil {
+			upgradeInfo.Errors = append(upgradeInfo.Errors, fmt.Sprintf("%s: %s", key, err))
+			if firstErr == nil {
+				firstErr = err
+			}
+			cancel()
+		}
+
+		// Write the progress every upgradeProgressInterval keys, or on error
+		// so that it is reported by the status endpoint
+		if err != nil || before/upgradeProgressInterval != upgradeInfo.Processed/upgradeProgressInterval {
+			if err := b.writeUpgradeInfo(ctx, s, upgradeInfo); err != nil {
+				b.Logger().Error("writing upgrade progress resulted in an error", "error", err)
+			}
+		}
+
+		if b.Logger().IsDebug() {
+			b.Logger().Debug("upgrading keys", "progress", fmt.Sprintf("%d/%d", upgradeInfo.Processed, upgradeInfo.Total))
+		}
+	}
+
+	batches := make(chan []string)
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for batch := range batches {
+				upgraded := 0
+				var failed string
+				var err error
+				for _, key := range batch {
+					if err = upgradeKey(key); err != nil {
+						failed = key
+						break
+					}
+					upgraded++
+				}
+				done(upgraded, failed, err)
+			}
+		}()
+	}
+
+	// Dispatch the batches, waiting between them to stay under the rate
+	// limit
+	next := time.Now()
+DISPATCH:
+	for start := 0; start < len(keys); start += opts.batchSize {
+		end := start + opts.batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := keys[start:end]
+
+		if opts.rateLimit > 0 {
+			select {
+			case <-time.After(time.Until(next)):
+			case <-stopCtx.Done():
+				break DISPATCH
+			}
+			next = time.Now().Add(time.Duration(len(batch)) * time.Second / time.Duration(opts.rateLimit))
+		}
+
+		select {
+		case batches <- batch:
+		case <-stopCtx.Done():
+			break DISPATCH
+		}
+	}
+	close(batches)
+	wg.Wait()
+
+	l.Lock()
+	defer l.Unlock()
+	return firstErr
+}
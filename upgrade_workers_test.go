@@ -0,0 +1,120 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/logging"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestParseUpgradeOptions(t *testing.T) {
+	tests := map[string]struct {
+		conf    map[string]string
+		want    upgradeOptions
+		wantErr bool
+	}{
+		"unset": {
+			conf: map[string]string{},
+			want: upgradeOptions{workers: defaultUpgradeWorkers, batchSize: defaultUpgradeBatchSize},
+		},
+		"set": {
+			conf: map[string]string{"upgrade_workers": "2", "upgrade_batch_size": "10", "upgrade_rate_limit": "500"},
+			want: upgradeOptions{workers: 2, batchSize: 10, rateLimit: 500},
+		},
+		"invalid":       {conf: map[string]string{"upgrade_workers": "foo"}, wantErr: true},
+		"no workers":    {conf: map[string]string{"upgrade_workers": "0"}, wantErr: true},
+		"too many":      {conf: map[string]string{"upgrade_workers": "257"}, wantErr: true},
+		"empty batches": {conf: map[string]string{"upgrade_batch_size": "0"}, wantErr: true},
+		"negative rate": {conf: map[string]string{"upgrade_rate_limit": "-1"}, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseUpgradeOptions(tc.conf)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %#v, got %#v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVersionedKV_UpgradeWorkers(t *testing.T) {
+	storage := &logical.InmemStorage{}
+
+	const numKeys = 95
+	for i := 0; i < numKeys; i++ {
+		if err := storage.Put(context.Background(), &logical.StorageEntry{
+			Key:   fmt.Sprintf("%d/foo", i),
+			Value: []byte(fmt.Sprintf(`{"bar":%d}`, i)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &logical.BackendConfig{
+		Logger:      logging.NewVaultLogger(log.Trace),
+		System:      &logical.StaticSystemView{},
+		StorageView: storage,
+		BackendUUID: "test",
+		Config: map[string]string{
+			"upgrade_workers":    "4",
+			"upgrade_batch_size": "10",
+			"upgrade_rate_limit": "200",
+		},
+	}
+
+	start := time.Now()
+	b, err := VersionedKVFactory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for atomic.LoadUint32(b.(*versionedKVBackend).upgrading) != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The 10 batches are dispatched at most 200 keys per second
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("expected the upgrade to be throttled, took %s", elapsed)
+	}
+
+	upgradeInfo, err := b.(*versionedKVBackend).getUpgradeInfo(context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upgradeInfo.Done || upgradeInfo.Processed != numKeys || upgradeInfo.Total != numKeys {
+		t.Fatalf("unexpected upgrade info: %#v", upgradeInfo)
+	}
+
+	for i := 0; i < numKeys; i++ {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      fmt.Sprintf("data/%d/foo", i),
+			Storage:   storage,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+		if resp.Data["data"].(map[string]interface{})["bar"] != float64(i) {
+			t.Fatalf("bad response %#v", resp)
+		}
+	}
+
+	keys, err := b.(*versionedKVBackend).collectV1Keys(context.Background(), storage, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("expected the non-versioned keys to be removed, got %v", keys)
+	}
+}
@@ -0,0 +1,111 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// versionDiff summarizes the changes between the data of two versions of a
+// secret. Only the names of the keys are recorded, never their values, so
+// that the summary can be handed to parties that are not allowed to read the
+// secret itself.
+type versionDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// diffVersionData computes the keys that were added, removed and modified
+// between the JSON encoded data of two versions. A nil previous version is
+// treated as empty so that every key of the first version is reported as
+// added.
+func diffVersionData(previous, current []byte) (*versionDiff, error) {
+	prev := map[string]interface{}{}
+	if len(previous) > 0 {
+		if err := json.Unmarshal(previous, &prev); err != nil {
+			return nil, err
+		}
+	}
+
+	cur := map[string]interface{}{}
+	if len(current) > 0 {
+		if err := json.Unmarshal(current, &cur); err != nil {
+			return nil, err
+		}
+	}
+
+	diff := &versionDiff{
+		Added:    []string{},
+		Removed:  []string{},
+		Modified: []string{},
+	}
+
+	for key, value := range cur {
+		prevValue, ok := prev[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, key)
+		case !reflect.DeepEqual(prevValue, value):
+			diff.Modified = append(diff.Modified, key)
+		}
+	}
+
+	for key := range prev {
+		if _, ok := cur[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+
+	return diff, nil
+}
+
+// previousVersionJSON returns the data of the current version of the key
+// described by meta, that a new version is about to replace, so that the keys
+// changed by the new version can be added to its event. Nil is returned if the
+// mount does not report the changed keys or if the current version holds no
+// data. The caller must hold the lock for the key.
+func (b *versionedKVBackend) previousVersionJSON(ctx context.Context, s logical.Storage, config *Configuration, meta *KeyMetadata) ([]byte, error) {
+	if !config.EventChangedKeys {
+		return nil, nil
+	}
+
+	vm := meta.Versions[meta.CurrentVersion]
+	if vm == nil || vm.Destroyed || vm.Reference != "" {
+		return nil, nil
+	}
+	if deleted, err := isDeleted(vm); err != nil || deleted {
+		return nil, err
+	}
+
+	return b.readVersionJSON(ctx, s, meta.Key, meta.CurrentVersion)
+}
+
+// changedKeysMetadata returns the event metadata listing the comma separated
+// names of the keys added, removed and modified between the data of the
+// previous and the current version, or nil if the mount does not report the
+// changed keys.
+func changedKeysMetadata(config *Configuration, previous, current []byte) (map[string]string, error) {
+	if !config.EventChangedKeys {
+		return nil, nil
+	}
+
+	diff, err := diffVersionData(previous, current)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"added_keys":    strings.Join(diff.Added, ","),
+		"removed_keys":  strings.Join(diff.Removed, ","),
+		"modified_keys": strings.Join(diff.Modified, ","),
+	}, nil
+}
@@ -0,0 +1,43 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestDiffVersionData(t *testing.T) {
+	var tests = map[string]struct {
+		previous, current string
+		want              *versionDiff
+	}{
+		"first version": {
+			"",
+			`{"b": "2", "a": "1"}`,
+			&versionDiff{Added: []string{"a", "b"}, Removed: []string{}, Modified: []string{}},
+		},
+		"no change": {
+			`{"a": "1", "nested": {"b": [1, 2]}}`,
+			`{"a": "1", "nested": {"b": [1, 2]}}`,
+			&versionDiff{Added: []string{}, Removed: []string{}, Modified: []string{}},
+		},
+		"all changes": {
+			`{"keep": "1", "modify": "1", "remove": "1", "nested": {"b": 1}}`,
+			`{"keep": "1", "modify": "2", "add": "1", "nested": {"b": 2}}`,
+			&versionDiff{Added: []string{"add"}, Removed: []string{"remove"}, Modified: []string{"modify", "nested"}},
+		},
+	}
+
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			got, err := diffVersionData([]byte(tt.previous), []byte(tt.current))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
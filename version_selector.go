@@ -0,0 +1,118 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// versionSelector selects the versions of a key by number or by age instead
+// of an explicit list of versions. The conditions that are set must all be
+// met for a version to be selected.
+type versionSelector struct {
+	// beforeVersion selects the versions lower than this number if not zero.
+	beforeVersion uint64
+
+	// before selects the versions created before this time if not zero.
+	before time.Time
+}
+
+// withVersionSelectorFields adds the fields of the version selectors to the
+// fields of an endpoint.
+func withVersionSelectorFields(fields map[string]*framework.FieldSchema) map[string]*framework.FieldSchema {
+	fields["before_version"] = &framework.FieldSchema{
+		Type:        framework.TypeInt,
+		Description: "If set, selects the versions lower than this version number instead of listing versions.",
+	}
+	fields["before_time"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "If set, selects the versions created before this RFC3339 timestamp instead of listing versions.",
+	}
+	fields["older_than"] = &framework.FieldSchema{
+		Type:        framework.TypeDurationSecond,
+		Description: "If set, selects the versions created longer ago than this duration instead of listing versions.",
+	}
+	return fields
+}
+
+// parseVersionSelector returns the version selector set in data relative to
+// now, or nil if none is set.
+func parseVersionSelector(data *framework.FieldData, now time.Time) (*versionSelector, error) {
+	beforeVersionRaw, bvOk := data.GetOk("before_version")
+	beforeTimeRaw, btOk := data.GetOk("before_time")
+	olderThanRaw, otOk := data.GetOk("older_than")
+	if !bvOk && !btOk && !otOk {
+		return nil, nil
+	}
+
+	selector := &versionSelector{}
+	if bvOk {
+		if beforeVersionRaw.(int) <= 0 {
+			return nil, errors.New("before_version must be a positive version number")
+		}
+		selector.beforeVersion = uint64(beforeVersionRaw.(int))
+	}
+
+	switch {
+	case btOk && otOk:
+		return nil, errors.New("before_time cannot be combined with older_than")
+	case btOk:
+		before, err := time.Parse(time.RFC3339, beforeTimeRaw.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid before_time: %w", err)
+		}
+		selector.before = before
+	case otOk:
+		if olderThanRaw.(int) <= 0 {
+			return nil, errors.New("older_than must be a positive duration")
+		}
+		selector.before = now.Add(-time.Duration(olderThanRaw.(int)) * time.Second)
+	}
+
+	return selector, nil
+}
+
+// versions returns the numbers of the versions of the key matched by the
+// selector that are not destroyed, in ascending order.
+func (s *versionSelector) versions(meta *KeyMetadata) ([]int, error) {
+	versions := make([]int, 0, len(meta.Versions))
+	for verNum, vm := range meta.Versions {
+		if vm.Destroyed {
+			continue
+		}
+		if s.beforeVersion > 0 && verNum >= s.beforeVersion {
+			continue
+		}
+		if !s.before.IsZero() {
+			created, err := ptypes.Timestamp(vm.CreatedTime)
+			if err != nil {
+				return nil, err
+			}
+			if !created.Before(s.before) {
+				continue
+			}
+		}
+		versions = append(versions, int(verNum))
+	}
+
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// selectedVersionsResponse returns the response listing the versions matched
+// by selector, or nil if the versions were listed by the request.
+func selectedVersionsResponse(selector *versionSelector, versions []int) *logical.Response {
+	if selector == nil {
+		return nil
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"versions": versions,
+		},
+	}
+}